@@ -0,0 +1,228 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/cipher"
+	"strconv"
+)
+
+// This file implements the ARIA block cipher of RFC 5794, which is used by
+// the ARIA cipher suites of RFC 6209.
+
+const ariaBlockSize = 16
+
+type ariaKeySizeError int
+
+func (k ariaKeySizeError) Error() string {
+	return "tls: invalid ARIA key size " + strconv.Itoa(int(k))
+}
+
+type ariaCipher struct {
+	rounds int
+	// enc and dec hold the rounds+1 round keys for each direction.
+	enc, dec [17][16]byte
+}
+
+// newARIACipher returns a cipher.Block for the given 16, 24 or 32 byte key.
+func newARIACipher(key []byte) (cipher.Block, error) {
+	c := new(ariaCipher)
+	var ck1, ck2, ck3 *[16]byte
+	switch len(key) {
+	case 16:
+		c.rounds = 12
+		ck1, ck2, ck3 = &ariaC[0], &ariaC[1], &ariaC[2]
+	case 24:
+		c.rounds = 14
+		ck1, ck2, ck3 = &ariaC[1], &ariaC[2], &ariaC[0]
+	case 32:
+		c.rounds = 16
+		ck1, ck2, ck3 = &ariaC[2], &ariaC[0], &ariaC[1]
+	default:
+		return nil, ariaKeySizeError(len(key))
+	}
+
+	var w0, kr, w1, w2, w3 [16]byte
+	copy(w0[:], key)
+	copy(kr[:], key[16:])
+	w1 = ariaFO(&w0, ck1)
+	xorBlock(&w1, &kr)
+	w2 = ariaFE(&w1, ck2)
+	xorBlock(&w2, &w0)
+	w3 = ariaFO(&w2, ck3)
+	xorBlock(&w3, &w1)
+
+	// RFC 5794, section 2.2: each round key is one W combined with another,
+	// rotated, W.
+	w := [4]*[16]byte{&w0, &w1, &w2, &w3}
+	rots := [5]int{-19, -31, 61, 31, 19}
+	for i := 0; i <= c.rounds; i++ {
+		a, b := w[i%4], w[(i+1)%4]
+		c.enc[i] = rotateBlock(b, rots[i/4])
+		xorBlock(&c.enc[i], a)
+	}
+
+	c.dec[0] = c.enc[c.rounds]
+	for i := 1; i < c.rounds; i++ {
+		c.dec[i] = ariaA(&c.enc[c.rounds-i])
+	}
+	c.dec[c.rounds] = c.enc[0]
+
+	return c, nil
+}
+
+func (c *ariaCipher) BlockSize() int { return ariaBlockSize }
+
+func (c *ariaCipher) Encrypt(dst, src []byte) { c.crypt(&c.enc, dst, src) }
+func (c *ariaCipher) Decrypt(dst, src []byte) { c.crypt(&c.dec, dst, src) }
+
+func (c *ariaCipher) crypt(rk *[17][16]byte, dst, src []byte) {
+	if len(src) < ariaBlockSize {
+		panic("tls: ARIA input not full block")
+	}
+	if len(dst) < ariaBlockSize {
+		panic("tls: ARIA output not full block")
+	}
+
+	var p [16]byte
+	copy(p[:], src)
+	for i := 0; i < c.rounds-1; i++ {
+		if i%2 == 0 {
+			p = ariaFO(&p, &rk[i])
+		} else {
+			p = ariaFE(&p, &rk[i])
+		}
+	}
+	xorBlock(&p, &rk[c.rounds-1])
+	ariaSL2(&p)
+	xorBlock(&p, &rk[c.rounds])
+	copy(dst, p[:])
+}
+
+// ariaFO and ariaFE are the odd and even round functions.
+func ariaFO(d, rk *[16]byte) [16]byte {
+	x := *d
+	xorBlock(&x, rk)
+	ariaSL1(&x)
+	return ariaA(&x)
+}
+
+func ariaFE(d, rk *[16]byte) [16]byte {
+	x := *d
+	xorBlock(&x, rk)
+	ariaSL2(&x)
+	return ariaA(&x)
+}
+
+func ariaSL1(x *[16]byte) {
+	for i := 0; i < 16; i += 4 {
+		x[i] = ariaSB1[x[i]]
+		x[i+1] = ariaSB2[x[i+1]]
+		x[i+2] = ariaSB3[x[i+2]]
+		x[i+3] = ariaSB4[x[i+3]]
+	}
+}
+
+func ariaSL2(x *[16]byte) {
+	for i := 0; i < 16; i += 4 {
+		x[i] = ariaSB3[x[i]]
+		x[i+1] = ariaSB4[x[i+1]]
+		x[i+2] = ariaSB1[x[i+2]]
+		x[i+3] = ariaSB2[x[i+3]]
+	}
+}
+
+// ariaA is the diffusion layer, an involution.
+func ariaA(x *[16]byte) (y [16]byte) {
+	y[0] = x[3] ^ x[4] ^ x[6] ^ x[8] ^ x[9] ^ x[13] ^ x[14]
+	y[1] = x[2] ^ x[5] ^ x[7] ^ x[8] ^ x[9] ^ x[12] ^ x[15]
+	y[2] = x[1] ^ x[4] ^ x[6] ^ x[10] ^ x[11] ^ x[12] ^ x[15]
+	y[3] = x[0] ^ x[5] ^ x[7] ^ x[10] ^ x[11] ^ x[13] ^ x[14]
+	y[4] = x[0] ^ x[2] ^ x[5] ^ x[8] ^ x[11] ^ x[14] ^ x[15]
+	y[5] = x[1] ^ x[3] ^ x[4] ^ x[9] ^ x[10] ^ x[14] ^ x[15]
+	y[6] = x[0] ^ x[2] ^ x[7] ^ x[9] ^ x[10] ^ x[12] ^ x[13]
+	y[7] = x[1] ^ x[3] ^ x[6] ^ x[8] ^ x[11] ^ x[12] ^ x[13]
+	y[8] = x[0] ^ x[1] ^ x[4] ^ x[7] ^ x[10] ^ x[13] ^ x[15]
+	y[9] = x[0] ^ x[1] ^ x[5] ^ x[6] ^ x[11] ^ x[12] ^ x[14]
+	y[10] = x[2] ^ x[3] ^ x[5] ^ x[6] ^ x[8] ^ x[13] ^ x[15]
+	y[11] = x[2] ^ x[3] ^ x[4] ^ x[7] ^ x[9] ^ x[12] ^ x[14]
+	y[12] = x[1] ^ x[2] ^ x[6] ^ x[7] ^ x[9] ^ x[11] ^ x[12]
+	y[13] = x[0] ^ x[3] ^ x[6] ^ x[7] ^ x[8] ^ x[10] ^ x[13]
+	y[14] = x[0] ^ x[3] ^ x[4] ^ x[5] ^ x[9] ^ x[11] ^ x[14]
+	y[15] = x[1] ^ x[2] ^ x[4] ^ x[5] ^ x[8] ^ x[10] ^ x[15]
+	return
+}
+
+func xorBlock(dst, src *[16]byte) {
+	for i := range dst {
+		dst[i] ^= src[i]
+	}
+}
+
+// rotateBlock returns the 128-bit value x rotated left by n bits, or right
+// if n is negative.
+func rotateBlock(x *[16]byte, n int) (y [16]byte) {
+	n = (n%128 + 128) % 128
+	bytes, bits := uint(n/8), uint(n%8)
+	for i := range y {
+		y[i] = x[(uint(i)+bytes)%16]<<bits | x[(uint(i)+bytes+1)%16]>>(8-bits)
+	}
+	return
+}
+
+var ariaC = [3][16]byte{
+	{0x51, 0x7c, 0xc1, 0xb7, 0x27, 0x22, 0x0a, 0x94, 0xfe, 0x13, 0xab, 0xe8, 0xfa, 0x9a, 0x6e, 0xe0},
+	{0x6d, 0xb1, 0x4a, 0xcc, 0x9e, 0x21, 0xc8, 0x20, 0xff, 0x28, 0xb1, 0xd5, 0xef, 0x5d, 0xe2, 0xb0},
+	{0xdb, 0x92, 0x37, 0x1d, 0x21, 0x26, 0xe9, 0x70, 0x03, 0x24, 0x97, 0x75, 0x04, 0xe8, 0xc9, 0x0e},
+}
+
+// ariaSB3 and ariaSB4 are the inverses of ariaSB1 and ariaSB2.
+var ariaSB3, ariaSB4 [256]byte
+
+func init() {
+	for i := range ariaSB1 {
+		ariaSB3[ariaSB1[i]] = byte(i)
+		ariaSB4[ariaSB2[i]] = byte(i)
+	}
+}
+
+// ariaSB1 is the AES S-box.
+var ariaSB1 = [256]byte{
+	0x63, 0x7c, 0x77, 0x7b, 0xf2, 0x6b, 0x6f, 0xc5, 0x30, 0x01, 0x67, 0x2b, 0xfe, 0xd7, 0xab, 0x76,
+	0xca, 0x82, 0xc9, 0x7d, 0xfa, 0x59, 0x47, 0xf0, 0xad, 0xd4, 0xa2, 0xaf, 0x9c, 0xa4, 0x72, 0xc0,
+	0xb7, 0xfd, 0x93, 0x26, 0x36, 0x3f, 0xf7, 0xcc, 0x34, 0xa5, 0xe5, 0xf1, 0x71, 0xd8, 0x31, 0x15,
+	0x04, 0xc7, 0x23, 0xc3, 0x18, 0x96, 0x05, 0x9a, 0x07, 0x12, 0x80, 0xe2, 0xeb, 0x27, 0xb2, 0x75,
+	0x09, 0x83, 0x2c, 0x1a, 0x1b, 0x6e, 0x5a, 0xa0, 0x52, 0x3b, 0xd6, 0xb3, 0x29, 0xe3, 0x2f, 0x84,
+	0x53, 0xd1, 0x00, 0xed, 0x20, 0xfc, 0xb1, 0x5b, 0x6a, 0xcb, 0xbe, 0x39, 0x4a, 0x4c, 0x58, 0xcf,
+	0xd0, 0xef, 0xaa, 0xfb, 0x43, 0x4d, 0x33, 0x85, 0x45, 0xf9, 0x02, 0x7f, 0x50, 0x3c, 0x9f, 0xa8,
+	0x51, 0xa3, 0x40, 0x8f, 0x92, 0x9d, 0x38, 0xf5, 0xbc, 0xb6, 0xda, 0x21, 0x10, 0xff, 0xf3, 0xd2,
+	0xcd, 0x0c, 0x13, 0xec, 0x5f, 0x97, 0x44, 0x17, 0xc4, 0xa7, 0x7e, 0x3d, 0x64, 0x5d, 0x19, 0x73,
+	0x60, 0x81, 0x4f, 0xdc, 0x22, 0x2a, 0x90, 0x88, 0x46, 0xee, 0xb8, 0x14, 0xde, 0x5e, 0x0b, 0xdb,
+	0xe0, 0x32, 0x3a, 0x0a, 0x49, 0x06, 0x24, 0x5c, 0xc2, 0xd3, 0xac, 0x62, 0x91, 0x95, 0xe4, 0x79,
+	0xe7, 0xc8, 0x37, 0x6d, 0x8d, 0xd5, 0x4e, 0xa9, 0x6c, 0x56, 0xf4, 0xea, 0x65, 0x7a, 0xae, 0x08,
+	0xba, 0x78, 0x25, 0x2e, 0x1c, 0xa6, 0xb4, 0xc6, 0xe8, 0xdd, 0x74, 0x1f, 0x4b, 0xbd, 0x8b, 0x8a,
+	0x70, 0x3e, 0xb5, 0x66, 0x48, 0x03, 0xf6, 0x0e, 0x61, 0x35, 0x57, 0xb9, 0x86, 0xc1, 0x1d, 0x9e,
+	0xe1, 0xf8, 0x98, 0x11, 0x69, 0xd9, 0x8e, 0x94, 0x9b, 0x1e, 0x87, 0xe9, 0xce, 0x55, 0x28, 0xdf,
+	0x8c, 0xa1, 0x89, 0x0d, 0xbf, 0xe6, 0x42, 0x68, 0x41, 0x99, 0x2d, 0x0f, 0xb0, 0x54, 0xbb, 0x16,
+}
+
+var ariaSB2 = [256]byte{
+	0xe2, 0x4e, 0x54, 0xfc, 0x94, 0xc2, 0x4a, 0xcc, 0x62, 0x0d, 0x6a, 0x46, 0x3c, 0x4d, 0x8b, 0xd1,
+	0x5e, 0xfa, 0x64, 0xcb, 0xb4, 0x97, 0xbe, 0x2b, 0xbc, 0x77, 0x2e, 0x03, 0xd3, 0x19, 0x59, 0xc1,
+	0x1d, 0x06, 0x41, 0x6b, 0x55, 0xf0, 0x99, 0x69, 0xea, 0x9c, 0x18, 0xae, 0x63, 0xdf, 0xe7, 0xbb,
+	0x00, 0x73, 0x66, 0xfb, 0x96, 0x4c, 0x85, 0xe4, 0x3a, 0x09, 0x45, 0xaa, 0x0f, 0xee, 0x10, 0xeb,
+	0x2d, 0x7f, 0xf4, 0x29, 0xac, 0xcf, 0xad, 0x91, 0x8d, 0x78, 0xc8, 0x95, 0xf9, 0x2f, 0xce, 0xcd,
+	0x08, 0x7a, 0x88, 0x38, 0x5c, 0x83, 0x2a, 0x28, 0x47, 0xdb, 0xb8, 0xc7, 0x93, 0xa4, 0x12, 0x53,
+	0xff, 0x87, 0x0e, 0x31, 0x36, 0x21, 0x58, 0x48, 0x01, 0x8e, 0x37, 0x74, 0x32, 0xca, 0xe9, 0xb1,
+	0xb7, 0xab, 0x0c, 0xd7, 0xc4, 0x56, 0x42, 0x26, 0x07, 0x98, 0x60, 0xd9, 0xb6, 0xb9, 0x11, 0x40,
+	0xec, 0x20, 0x8c, 0xbd, 0xa0, 0xc9, 0x84, 0x04, 0x49, 0x23, 0xf1, 0x4f, 0x50, 0x1f, 0x13, 0xdc,
+	0xd8, 0xc0, 0x9e, 0x57, 0xe3, 0xc3, 0x7b, 0x65, 0x3b, 0x02, 0x8f, 0x3e, 0xe8, 0x25, 0x92, 0xe5,
+	0x15, 0xdd, 0xfd, 0x17, 0xa9, 0xbf, 0xd4, 0x9a, 0x7e, 0xc5, 0x39, 0x67, 0xfe, 0x76, 0x9d, 0x43,
+	0xa7, 0xe1, 0xd0, 0xf5, 0x68, 0xf2, 0x1b, 0x34, 0x70, 0x05, 0xa3, 0x8a, 0xd5, 0x79, 0x86, 0xa8,
+	0x30, 0xc6, 0x51, 0x4b, 0x1e, 0xa6, 0x27, 0xf6, 0x35, 0xd2, 0x6e, 0x24, 0x16, 0x82, 0x5f, 0xda,
+	0xe6, 0x75, 0xa2, 0xef, 0x2c, 0xb2, 0x1c, 0x9f, 0x5d, 0x6f, 0x80, 0x0a, 0x72, 0x44, 0x9b, 0x6c,
+	0x90, 0x0b, 0x5b, 0x33, 0x7d, 0x5a, 0x52, 0xf3, 0x61, 0xa1, 0xf7, 0xb0, 0xd6, 0x3f, 0x7c, 0x6d,
+	0xed, 0x14, 0xe0, 0xa5, 0x3d, 0x22, 0xb3, 0xf8, 0x89, 0xde, 0x71, 0x1a, 0xaf, 0xba, 0xb5, 0x81,
+}
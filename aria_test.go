@@ -0,0 +1,49 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Test vectors from RFC 5794, appendix A.
+var ariaTests = []struct {
+	key, ciphertext string
+}{
+	{
+		"000102030405060708090a0b0c0d0e0f",
+		"d718fbd6ab644c739da95f3be6451778",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f1011121314151617",
+		"26449c1805dbe7aa25a468ce263a9e79",
+	},
+	{
+		"000102030405060708090a0b0c0d0e0f101112131415161718191a1b1c1d1e1f",
+		"f92bd7c79fb72e2f2b8f80c1972d24fc",
+	},
+}
+
+func TestARIAVectors(t *testing.T) {
+	plaintext := fromHex("00112233445566778899aabbccddeeff")
+	for i, test := range ariaTests {
+		block, err := newARIACipher(fromHex(test.key))
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		expected := fromHex(test.ciphertext)
+
+		out := make([]byte, ariaBlockSize)
+		block.Encrypt(out, plaintext)
+		if !bytes.Equal(out, expected) {
+			t.Errorf("#%d: got ciphertext %x, want %x", i, out, expected)
+		}
+		block.Decrypt(out, expected)
+		if !bytes.Equal(out, plaintext) {
+			t.Errorf("#%d: got plaintext %x, want %x", i, out, plaintext)
+		}
+	}
+}
@@ -0,0 +1,252 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/cipher"
+	"encoding/binary"
+	"strconv"
+)
+
+// This file implements the Camellia block cipher of RFC 3713, which is used
+// by the Camellia cipher suites of RFC 6367.
+
+const camelliaBlockSize = 16
+
+type camelliaKeySizeError int
+
+func (k camelliaKeySizeError) Error() string {
+	return "tls: invalid Camellia key size " + strconv.Itoa(int(k))
+}
+
+// camelliaSubkeys holds the whitening (kw), round (k) and FL/FL^-1 (ke)
+// subkeys in the order they are used.
+type camelliaSubkeys struct {
+	kw [4]uint64
+	k  [24]uint64
+	ke [6]uint64
+}
+
+type camelliaCipher struct {
+	// grandRounds is the number of six round blocks: 3 for 128-bit keys and
+	// 4 for 192 and 256-bit keys.
+	grandRounds int
+	enc, dec    camelliaSubkeys
+}
+
+// newCamelliaCipher returns a cipher.Block for the given 16, 24 or 32 byte
+// key.
+func newCamelliaCipher(key []byte) (cipher.Block, error) {
+	var kl, kr [2]uint64
+	switch len(key) {
+	case 16:
+	case 24:
+		kr[0] = binary.BigEndian.Uint64(key[16:])
+		kr[1] = ^kr[0]
+	case 32:
+		kr[0] = binary.BigEndian.Uint64(key[16:])
+		kr[1] = binary.BigEndian.Uint64(key[24:])
+	default:
+		return nil, camelliaKeySizeError(len(key))
+	}
+	kl[0] = binary.BigEndian.Uint64(key)
+	kl[1] = binary.BigEndian.Uint64(key[8:])
+
+	var ka, kb [2]uint64
+	d1, d2 := kl[0]^kr[0], kl[1]^kr[1]
+	d2 ^= camelliaF(d1, camelliaSigma[0])
+	d1 ^= camelliaF(d2, camelliaSigma[1])
+	d1 ^= kl[0]
+	d2 ^= kl[1]
+	d2 ^= camelliaF(d1, camelliaSigma[2])
+	d1 ^= camelliaF(d2, camelliaSigma[3])
+	ka[0], ka[1] = d1, d2
+	d1, d2 = ka[0]^kr[0], ka[1]^kr[1]
+	d2 ^= camelliaF(d1, camelliaSigma[4])
+	d1 ^= camelliaF(d2, camelliaSigma[5])
+	kb[0], kb[1] = d1, d2
+
+	c := new(camelliaCipher)
+	e := &c.enc
+	e.kw[0], e.kw[1] = kl[0], kl[1]
+	if len(key) == 16 {
+		c.grandRounds = 3
+		e.k[0], e.k[1] = ka[0], ka[1]
+		e.k[2], e.k[3] = rotl128(kl, 15)
+		e.k[4], e.k[5] = rotl128(ka, 15)
+		e.ke[0], e.ke[1] = rotl128(ka, 30)
+		e.k[6], e.k[7] = rotl128(kl, 45)
+		e.k[8], _ = rotl128(ka, 45)
+		_, e.k[9] = rotl128(kl, 60)
+		e.k[10], e.k[11] = rotl128(ka, 60)
+		e.ke[2], e.ke[3] = rotl128(kl, 77)
+		e.k[12], e.k[13] = rotl128(kl, 94)
+		e.k[14], e.k[15] = rotl128(ka, 94)
+		e.k[16], e.k[17] = rotl128(kl, 111)
+		e.kw[2], e.kw[3] = rotl128(ka, 111)
+	} else {
+		c.grandRounds = 4
+		e.k[0], e.k[1] = kb[0], kb[1]
+		e.k[2], e.k[3] = rotl128(kr, 15)
+		e.k[4], e.k[5] = rotl128(ka, 15)
+		e.ke[0], e.ke[1] = rotl128(kr, 30)
+		e.k[6], e.k[7] = rotl128(kb, 30)
+		e.k[8], e.k[9] = rotl128(kl, 45)
+		e.k[10], e.k[11] = rotl128(ka, 45)
+		e.ke[2], e.ke[3] = rotl128(kl, 60)
+		e.k[12], e.k[13] = rotl128(kr, 60)
+		e.k[14], e.k[15] = rotl128(kb, 60)
+		e.k[16], e.k[17] = rotl128(kl, 77)
+		e.ke[4], e.ke[5] = rotl128(ka, 77)
+		e.k[18], e.k[19] = rotl128(kr, 94)
+		e.k[20], e.k[21] = rotl128(ka, 94)
+		e.k[22], e.k[23] = rotl128(kl, 111)
+		e.kw[2], e.kw[3] = rotl128(kb, 111)
+	}
+
+	// Decryption is encryption with the subkeys in reverse order.
+	d := &c.dec
+	d.kw[0], d.kw[1], d.kw[2], d.kw[3] = e.kw[2], e.kw[3], e.kw[0], e.kw[1]
+	rounds := 6 * c.grandRounds
+	for i := 0; i < rounds; i++ {
+		d.k[i] = e.k[rounds-1-i]
+	}
+	fls := 2 * (c.grandRounds - 1)
+	for i := 0; i < fls; i++ {
+		d.ke[i] = e.ke[fls-1-i]
+	}
+
+	return c, nil
+}
+
+func (c *camelliaCipher) BlockSize() int { return camelliaBlockSize }
+
+func (c *camelliaCipher) Encrypt(dst, src []byte) { c.crypt(&c.enc, dst, src) }
+func (c *camelliaCipher) Decrypt(dst, src []byte) { c.crypt(&c.dec, dst, src) }
+
+func (c *camelliaCipher) crypt(s *camelliaSubkeys, dst, src []byte) {
+	if len(src) < camelliaBlockSize {
+		panic("tls: Camellia input not full block")
+	}
+	if len(dst) < camelliaBlockSize {
+		panic("tls: Camellia output not full block")
+	}
+
+	d1 := binary.BigEndian.Uint64(src) ^ s.kw[0]
+	d2 := binary.BigEndian.Uint64(src[8:]) ^ s.kw[1]
+	for g := 0; g < c.grandRounds; g++ {
+		if g > 0 {
+			d1 = camelliaFL(d1, s.ke[2*g-2])
+			d2 = camelliaFLInv(d2, s.ke[2*g-1])
+		}
+		k := s.k[6*g : 6*g+6]
+		d2 ^= camelliaF(d1, k[0])
+		d1 ^= camelliaF(d2, k[1])
+		d2 ^= camelliaF(d1, k[2])
+		d1 ^= camelliaF(d2, k[3])
+		d2 ^= camelliaF(d1, k[4])
+		d1 ^= camelliaF(d2, k[5])
+	}
+	d2 ^= s.kw[2]
+	d1 ^= s.kw[3]
+
+	binary.BigEndian.PutUint64(dst, d2)
+	binary.BigEndian.PutUint64(dst[8:], d1)
+}
+
+// rotl128 rotates the 128-bit value x left by n bits and returns the two
+// halves of the result.
+func rotl128(x [2]uint64, n uint) (hi, lo uint64) {
+	if n >= 64 {
+		x[0], x[1] = x[1], x[0]
+		n -= 64
+	}
+	if n == 0 {
+		return x[0], x[1]
+	}
+	return x[0]<<n | x[1]>>(64-n), x[1]<<n | x[0]>>(64-n)
+}
+
+func camelliaF(in, k uint64) uint64 {
+	x := in ^ k
+	t1 := camelliaSbox1[byte(x>>56)]
+	t2 := camelliaSbox2[byte(x>>48)]
+	t3 := camelliaSbox3[byte(x>>40)]
+	t4 := camelliaSbox4[byte(x>>32)]
+	t5 := camelliaSbox2[byte(x>>24)]
+	t6 := camelliaSbox3[byte(x>>16)]
+	t7 := camelliaSbox4[byte(x>>8)]
+	t8 := camelliaSbox1[byte(x)]
+
+	y1 := t1 ^ t3 ^ t4 ^ t6 ^ t7 ^ t8
+	y2 := t1 ^ t2 ^ t4 ^ t5 ^ t7 ^ t8
+	y3 := t1 ^ t2 ^ t3 ^ t5 ^ t6 ^ t8
+	y4 := t2 ^ t3 ^ t4 ^ t5 ^ t6 ^ t7
+	y5 := t1 ^ t2 ^ t6 ^ t7 ^ t8
+	y6 := t2 ^ t3 ^ t5 ^ t7 ^ t8
+	y7 := t3 ^ t4 ^ t5 ^ t6 ^ t8
+	y8 := t1 ^ t4 ^ t5 ^ t6 ^ t7
+
+	return uint64(y1)<<56 | uint64(y2)<<48 | uint64(y3)<<40 | uint64(y4)<<32 |
+		uint64(y5)<<24 | uint64(y6)<<16 | uint64(y7)<<8 | uint64(y8)
+}
+
+func camelliaFL(in, k uint64) uint64 {
+	x1, x2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(k>>32), uint32(k)
+	x2 ^= rotl32(x1&k1, 1)
+	x1 ^= x2 | k2
+	return uint64(x1)<<32 | uint64(x2)
+}
+
+func camelliaFLInv(in, k uint64) uint64 {
+	y1, y2 := uint32(in>>32), uint32(in)
+	k1, k2 := uint32(k>>32), uint32(k)
+	y1 ^= y2 | k2
+	y2 ^= rotl32(y1&k1, 1)
+	return uint64(y1)<<32 | uint64(y2)
+}
+
+func rotl32(x uint32, n uint) uint32 { return x<<n | x>>(32-n) }
+
+var camelliaSigma = [6]uint64{
+	0xa09e667f3bcc908b,
+	0xb67ae8584caa73b2,
+	0xc6ef372fe94f82be,
+	0x54ff53a5f1d36f1c,
+	0x10e527fade682d1d,
+	0xb05688c2b3e6c1fd,
+}
+
+// camelliaSbox2, camelliaSbox3 and camelliaSbox4 are derived from
+// camelliaSbox1 as described in RFC 3713, section 2.4.4.
+var camelliaSbox2, camelliaSbox3, camelliaSbox4 [256]byte
+
+func init() {
+	for i, s := range camelliaSbox1 {
+		camelliaSbox2[i] = s<<1 | s>>7
+		camelliaSbox3[i] = s<<7 | s>>1
+		camelliaSbox4[byte(i)>>1|byte(i)<<7] = s
+	}
+}
+
+var camelliaSbox1 = [256]byte{
+	0x70, 0x82, 0x2c, 0xec, 0xb3, 0x27, 0xc0, 0xe5, 0xe4, 0x85, 0x57, 0x35, 0xea, 0x0c, 0xae, 0x41,
+	0x23, 0xef, 0x6b, 0x93, 0x45, 0x19, 0xa5, 0x21, 0xed, 0x0e, 0x4f, 0x4e, 0x1d, 0x65, 0x92, 0xbd,
+	0x86, 0xb8, 0xaf, 0x8f, 0x7c, 0xeb, 0x1f, 0xce, 0x3e, 0x30, 0xdc, 0x5f, 0x5e, 0xc5, 0x0b, 0x1a,
+	0xa6, 0xe1, 0x39, 0xca, 0xd5, 0x47, 0x5d, 0x3d, 0xd9, 0x01, 0x5a, 0xd6, 0x51, 0x56, 0x6c, 0x4d,
+	0x8b, 0x0d, 0x9a, 0x66, 0xfb, 0xcc, 0xb0, 0x2d, 0x74, 0x12, 0x2b, 0x20, 0xf0, 0xb1, 0x84, 0x99,
+	0xdf, 0x4c, 0xcb, 0xc2, 0x34, 0x7e, 0x76, 0x05, 0x6d, 0xb7, 0xa9, 0x31, 0xd1, 0x17, 0x04, 0xd7,
+	0x14, 0x58, 0x3a, 0x61, 0xde, 0x1b, 0x11, 0x1c, 0x32, 0x0f, 0x9c, 0x16, 0x53, 0x18, 0xf2, 0x22,
+	0xfe, 0x44, 0xcf, 0xb2, 0xc3, 0xb5, 0x7a, 0x91, 0x24, 0x08, 0xe8, 0xa8, 0x60, 0xfc, 0x69, 0x50,
+	0xaa, 0xd0, 0xa0, 0x7d, 0xa1, 0x89, 0x62, 0x97, 0x54, 0x5b, 0x1e, 0x95, 0xe0, 0xff, 0x64, 0xd2,
+	0x10, 0xc4, 0x00, 0x48, 0xa3, 0xf7, 0x75, 0xdb, 0x8a, 0x03, 0xe6, 0xda, 0x09, 0x3f, 0xdd, 0x94,
+	0x87, 0x5c, 0x83, 0x02, 0xcd, 0x4a, 0x90, 0x33, 0x73, 0x67, 0xf6, 0xf3, 0x9d, 0x7f, 0xbf, 0xe2,
+	0x52, 0x9b, 0xd8, 0x26, 0xc8, 0x37, 0xc6, 0x3b, 0x81, 0x96, 0x6f, 0x4b, 0x13, 0xbe, 0x63, 0x2e,
+	0xe9, 0x79, 0xa7, 0x8c, 0x9f, 0x6e, 0xbc, 0x8e, 0x29, 0xf5, 0xf9, 0xb6, 0x2f, 0xfd, 0xb4, 0x59,
+	0x78, 0x98, 0x06, 0x6a, 0xe7, 0x46, 0x71, 0xba, 0xd4, 0x25, 0xab, 0x42, 0x88, 0xa2, 0x8d, 0xfa,
+	0x72, 0x07, 0xb9, 0x55, 0xf8, 0xee, 0xac, 0x0a, 0x36, 0x49, 0x2a, 0x68, 0x3c, 0x38, 0xf1, 0xa4,
+	0x40, 0x28, 0xd3, 0x7b, 0xbb, 0xc9, 0x43, 0xc1, 0x15, 0xe3, 0xad, 0xf4, 0x77, 0xc7, 0x80, 0x9e,
+}
@@ -0,0 +1,49 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+// Test vectors from RFC 3713, appendix A.
+var camelliaTests = []struct {
+	key, ciphertext string
+}{
+	{
+		"0123456789abcdeffedcba9876543210",
+		"67673138549669730857065648eabe43",
+	},
+	{
+		"0123456789abcdeffedcba98765432100011223344556677",
+		"b4993401b3e996f84ee5cee7d79b09b9",
+	},
+	{
+		"0123456789abcdeffedcba987654321000112233445566778899aabbccddeeff",
+		"9acc237dff16d76c20ef7c919e3a7509",
+	},
+}
+
+func TestCamelliaVectors(t *testing.T) {
+	plaintext := fromHex("0123456789abcdeffedcba9876543210")
+	for i, test := range camelliaTests {
+		block, err := newCamelliaCipher(fromHex(test.key))
+		if err != nil {
+			t.Fatalf("#%d: %s", i, err)
+		}
+		expected := fromHex(test.ciphertext)
+
+		out := make([]byte, camelliaBlockSize)
+		block.Encrypt(out, plaintext)
+		if !bytes.Equal(out, expected) {
+			t.Errorf("#%d: got ciphertext %x, want %x", i, out, expected)
+		}
+		block.Decrypt(out, expected)
+		if !bytes.Equal(out, plaintext) {
+			t.Errorf("#%d: got plaintext %x, want %x", i, out, plaintext)
+		}
+	}
+}
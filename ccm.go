@@ -0,0 +1,154 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/cipher"
+	"crypto/subtle"
+	"errors"
+)
+
+// ccm implements the CCM mode of NIST SP 800-38C with the parameters that
+// TLS uses: a 12 byte nonce, and so a 3 byte length field, and a tag of
+// either 16 (CCM) or 8 (CCM_8) bytes. See RFC 6655.
+type ccm struct {
+	b       cipher.Block
+	tagSize int
+}
+
+const (
+	ccmNonceSize = 12
+	// ccmLenSize is the size of the message length field, L in RFC 3610.
+	ccmLenSize = 15 - ccmNonceSize
+	ccmMaxLen  = 1<<(8*ccmLenSize) - 1
+)
+
+var errCCMOpen = errors.New("tls: CCM message authentication failed")
+
+// newCCM returns the CCM mode of b, which must have a 16 byte block size,
+// with the given tag size.
+func newCCM(b cipher.Block, tagSize int) cipher.AEAD {
+	if b.BlockSize() != 16 {
+		panic("tls: CCM requires a 128-bit block cipher")
+	}
+	if tagSize < 4 || tagSize > 16 || tagSize%2 != 0 {
+		panic("tls: invalid CCM tag size")
+	}
+	return &ccm{b: b, tagSize: tagSize}
+}
+
+func (c *ccm) NonceSize() int { return ccmNonceSize }
+func (c *ccm) Overhead() int  { return c.tagSize }
+
+// counter returns the counter block A_i for nonce, see RFC 3610 section 2.3.
+func (c *ccm) counter(nonce []byte, i int) []byte {
+	a := make([]byte, 16)
+	a[0] = ccmLenSize - 1
+	copy(a[1:], nonce)
+	a[13] = byte(i >> 16)
+	a[14] = byte(i >> 8)
+	a[15] = byte(i)
+	return a
+}
+
+// mac computes the unencrypted CBC-MAC, T, of plaintext and additionalData.
+func (c *ccm) mac(nonce, plaintext, additionalData []byte) []byte {
+	x := make([]byte, 16)
+	flags := byte((c.tagSize-2)/2<<3 | (ccmLenSize - 1))
+	if len(additionalData) > 0 {
+		flags |= 0x40
+	}
+	x[0] = flags
+	copy(x[1:], nonce)
+	x[13] = byte(len(plaintext) >> 16)
+	x[14] = byte(len(plaintext) >> 8)
+	x[15] = byte(len(plaintext))
+	c.b.Encrypt(x, x)
+
+	block := make([]byte, 16)
+	process := func(data []byte) {
+		for len(data) > 0 {
+			n := copy(block, data)
+			for i := n; i < 16; i++ {
+				block[i] = 0
+			}
+			for i := range x {
+				x[i] ^= block[i]
+			}
+			c.b.Encrypt(x, x)
+			data = data[n:]
+		}
+	}
+
+	if len(additionalData) > 0 {
+		var encoded []byte
+		if n := len(additionalData); n < 0xff00 {
+			encoded = []byte{byte(n >> 8), byte(n)}
+		} else {
+			encoded = []byte{0xff, 0xfe, byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}
+		}
+		process(append(encoded, additionalData...))
+	}
+	process(plaintext)
+
+	return x[:c.tagSize]
+}
+
+func (c *ccm) Seal(dst, nonce, plaintext, additionalData []byte) []byte {
+	if len(nonce) != ccmNonceSize {
+		panic("tls: incorrect nonce length given to CCM")
+	}
+	if len(plaintext) > ccmMaxLen {
+		panic("tls: message too large for CCM")
+	}
+
+	ret, out := sliceForAppend(dst, len(plaintext)+c.tagSize)
+
+	tag := c.mac(nonce, plaintext, additionalData)
+	cipher.NewCTR(c.b, c.counter(nonce, 1)).XORKeyStream(out, plaintext)
+	cipher.NewCTR(c.b, c.counter(nonce, 0)).XORKeyStream(out[len(plaintext):], tag)
+
+	return ret
+}
+
+func (c *ccm) Open(dst, nonce, ciphertext, additionalData []byte) ([]byte, error) {
+	if len(nonce) != ccmNonceSize {
+		panic("tls: incorrect nonce length given to CCM")
+	}
+	if len(ciphertext) < c.tagSize || len(ciphertext)-c.tagSize > ccmMaxLen {
+		return nil, errCCMOpen
+	}
+
+	tagOffset := len(ciphertext) - c.tagSize
+	tag := make([]byte, c.tagSize)
+	cipher.NewCTR(c.b, c.counter(nonce, 0)).XORKeyStream(tag, ciphertext[tagOffset:])
+
+	ret, out := sliceForAppend(dst, tagOffset)
+	cipher.NewCTR(c.b, c.counter(nonce, 1)).XORKeyStream(out, ciphertext[:tagOffset])
+
+	if subtle.ConstantTimeCompare(c.mac(nonce, out, additionalData), tag) != 1 {
+		for i := range out {
+			out[i] = 0
+		}
+		return nil, errCCMOpen
+	}
+
+	return ret, nil
+}
+
+// sliceForAppend takes a slice and a requested number of bytes. It returns a
+// slice with the contents of the given slice followed by that many bytes and a
+// second slice that aliases into it and contains only the extra bytes. If the
+// original slice has sufficient capacity then no allocation is performed.
+func sliceForAppend(in []byte, n int) (head, tail []byte) {
+	if total := len(in) + n; cap(in) >= total {
+		head = in[:total]
+	} else {
+		head = make([]byte, total)
+		copy(head, in)
+	}
+	tail = head[len(in):]
+	return
+}
@@ -0,0 +1,48 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/aes"
+	"testing"
+)
+
+// Example 3 from NIST SP 800-38C, appendix C, which uses the same nonce
+// length as TLS.
+func TestCCMVector(t *testing.T) {
+	key := fromHex("404142434445464748494a4b4c4d4e4f")
+	nonce := fromHex("101112131415161718191a1b")
+	additionalData := fromHex("000102030405060708090a0b0c0d0e0f10111213")
+	plaintext := fromHex("202122232425262728292a2b2c2d2e2f3031323334353637")
+	expected := fromHex("e3b201a9f5b71a7a9b1ceaeccd97e70b6176aad9a4428aa5484392fbc1b09951")
+
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	aead := newCCM(block, 8)
+
+	ciphertext := aead.Seal(nil, nonce, plaintext, additionalData)
+	if !bytes.Equal(ciphertext, expected) {
+		t.Fatalf("got ciphertext %x, want %x", ciphertext, expected)
+	}
+
+	opened, err := aead.Open(nil, nonce, ciphertext, additionalData)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(opened, plaintext) {
+		t.Fatalf("got plaintext %x, want %x", opened, plaintext)
+	}
+
+	for i := range ciphertext {
+		ciphertext[i] ^= 0x80
+		if _, err := aead.Open(nil, nonce, ciphertext, additionalData); err == nil {
+			t.Fatalf("Open succeeded with byte %d corrupted", i)
+		}
+		ciphertext[i] ^= 0x80
+	}
+}
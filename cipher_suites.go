@@ -5,6 +5,7 @@
 package tls
 
 import (
+	"crypto"
 	"crypto/aes"
 	"crypto/cipher"
 	"crypto/des"
@@ -37,6 +38,97 @@ type keyAgreement interface {
 	generateClientKeyExchange(*Config, *clientHelloMsg, *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error)
 }
 
+// A groupKeyAgreement is a keyAgreement that negotiates an elliptic curve or
+// finite field group. curveID returns the group in use, or zero if none has
+// been chosen yet.
+type groupKeyAgreement interface {
+	curveID() CurveID
+}
+
+// keyAgreementCurveID returns the group that ka negotiated, if any.
+func keyAgreementCurveID(ka keyAgreement) CurveID {
+	if gka, ok := ka.(groupKeyAgreement); ok {
+		return gka.curveID()
+	}
+	return 0
+}
+
+// A pskIdentityKeyAgreement is a keyAgreement that authenticates with a
+// pre-shared key. pskIdentity returns the identity of the key, or nil if
+// none has been agreed yet.
+type pskIdentityKeyAgreement interface {
+	pskIdentity() []byte
+}
+
+// keyAgreementPSKIdentity returns the identity of the PSK that ka used, if
+// any.
+func keyAgreementPSKIdentity(ka keyAgreement) []byte {
+	if pka, ok := ka.(pskIdentityKeyAgreement); ok {
+		return pka.pskIdentity()
+	}
+	return nil
+}
+
+// A pskCandidatesKeyAgreement is a keyAgreement whose server side may hold
+// several candidate keys for the PSK identity of the client. pskCandidates
+// returns them along with the pre-master secret that each of them yields.
+type pskCandidatesKeyAgreement interface {
+	pskCandidates() ([]PSKCandidate, [][]byte)
+}
+
+// keyAgreementPSKCandidates returns the candidate keys of the server of ka
+// and their pre-master secrets, if any.
+func keyAgreementPSKCandidates(ka keyAgreement) ([]PSKCandidate, [][]byte) {
+	if pka, ok := ka.(pskCandidatesKeyAgreement); ok {
+		return pka.pskCandidates()
+	}
+	return nil, nil
+}
+
+// A pskLookupErrorKeyAgreement is a keyAgreement whose server side may go on
+// with a dummy key for a PSK identity that it doesn't know, see
+// Config.PSKDummyKeySecret. pskLookupError returns the error of the failed
+// lookup, or nil if the key is real.
+type pskLookupErrorKeyAgreement interface {
+	pskLookupError() error
+}
+
+// keyAgreementPSKLookupError returns the error that a dummy PSK of ka stands
+// in for, if any.
+func keyAgreementPSKLookupError(ka keyAgreement) error {
+	if pka, ok := ka.(pskLookupErrorKeyAgreement); ok {
+		return pka.pskLookupError()
+	}
+	return nil
+}
+
+// An encryptionCertificateKeyAgreement is a keyAgreement whose client side
+// encrypts to a certificate of the server other than the first one, like
+// the ECC key exchange of TLCP.
+type encryptionCertificateKeyAgreement interface {
+	setEncryptionCertificate(cert *x509.Certificate)
+}
+
+// setKeyAgreementEncryptionCertificate hands cert to ka, if ka uses it.
+func setKeyAgreementEncryptionCertificate(ka keyAgreement, cert *x509.Certificate) {
+	if eka, ok := ka.(encryptionCertificateKeyAgreement); ok {
+		eka.setEncryptionCertificate(cert)
+	}
+}
+
+// A clientHelloInfoKeyAgreement is a keyAgreement whose server side passes
+// the ClientHelloInfo of the connection to the callbacks of the Config.
+type clientHelloInfoKeyAgreement interface {
+	setClientHelloInfo(info *ClientHelloInfo)
+}
+
+// setKeyAgreementClientHelloInfo hands info to ka, if ka uses it.
+func setKeyAgreementClientHelloInfo(ka keyAgreement, info *ClientHelloInfo) {
+	if cka, ok := ka.(clientHelloInfoKeyAgreement); ok {
+		cka.setClientHelloInfo(info)
+	}
+}
+
 const (
 	// suiteRSA indicates that the cipher suite involves an RSA
 	// signature and therefore may only be selected when the server's
@@ -67,6 +159,29 @@ const (
 	// suiteDefaultOff indicates that this cipher suite is not included by
 	// default.
 	suiteDefaultOff
+	// suiteSRP indicates that the cipher suite uses SRP key agreement. This
+	// means that a client only offers it if it has an SRP username and a
+	// server only selects it if the client sent one.
+	suiteSRP
+	// suiteDSS indicates that the cipher suite involves a DSA signature and
+	// therefore may only be selected when the server's certificate is DSA.
+	suiteDSS
+	// suiteNull indicates that the cipher suite doesn't encrypt records. It
+	// is only offered or accepted if Config.AllowNullCipherSuites is set.
+	suiteNull
+	// suiteSM2 indicates that the cipher suite involves an SM2 signature
+	// or encryption and therefore may only be selected when the server's
+	// certificate is SM2.
+	suiteSM2
+	// suiteSM3 indicates that the cipher suite uses SM3 as the handshake
+	// hash.
+	suiteSM3
+	// suiteNoDTLS indicates that the cipher suite uses a stream cipher,
+	// which DTLS forbids, see RFC 6347, section 4.1.2.2.
+	suiteNoDTLS
+	// suiteTLCP indicates that the cipher suite belongs to TLCP rather
+	// than TLS, so it is only offered and selected in TLCP connections.
+	suiteTLCP
 )
 
 // A cipherSuite is a specific combination of key agreement, cipher and MAC
@@ -94,6 +209,8 @@ var cipherSuites = []*cipherSuite{
 	{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12, nil, nil, aeadAESGCM},
 	{TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
 	{TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384, 32, 48, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
+	{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384, 32, 48, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
 	{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256, 16, 32, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, 16, 20, 16, ecdheRSAKA, suiteECDHE | suiteRSA, cipherAES, macSHA1, nil},
 	{TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256, 16, 32, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, cipherAES, macSHA256, nil},
@@ -117,31 +234,148 @@ var cipherSuites = []*cipherSuite{
 	{TLS_DHE_RSA_WITH_AES_128_CBC_SHA256, 16, 32, 16, dheRSAKA, suiteDHE | suiteRSA | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_DHE_RSA_WITH_AES_256_CBC_SHA, 32, 20, 16, dheRSAKA, suiteDHE | suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_DHE_RSA_WITH_AES_128_CBC_SHA, 16, 20, 16, dheRSAKA, suiteDHE | suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_DHE_DSS_WITH_AES_256_GCM_SHA384, 32, 0, 4, dheDSSKA, suiteDHE | suiteDSS | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadAESGCM},
+	{TLS_DHE_DSS_WITH_AES_128_GCM_SHA256, 16, 0, 4, dheDSSKA, suiteDHE | suiteDSS | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
+	{TLS_DHE_DSS_WITH_AES_256_CBC_SHA256, 32, 32, 16, dheDSSKA, suiteDHE | suiteDSS | suiteDefaultOff, cipherAES, macSHA256, nil},
+	{TLS_DHE_DSS_WITH_AES_128_CBC_SHA256, 16, 32, 16, dheDSSKA, suiteDHE | suiteDSS | suiteDefaultOff, cipherAES, macSHA256, nil},
+	{TLS_DHE_DSS_WITH_AES_256_CBC_SHA, 32, 20, 16, dheDSSKA, suiteDHE | suiteDSS | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_DHE_DSS_WITH_AES_128_CBC_SHA, 16, 20, 16, dheDSSKA, suiteDHE | suiteDSS | suiteDefaultOff, cipherAES, macSHA1, nil},
 
 	// PSK ciphersuites use preshared keys
 	{TLS_RSA_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_RSA_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_RSA_PSK_WITH_CHACHA20_POLY1305_SHA256, 32, 0, 12, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadChaCha20Poly1305},
+	{TLS_RSA_PSK_WITH_AES_256_CBC_SHA384, 32, 48, 16, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
 	{TLS_RSA_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, pskRSAKA, suiteRSA | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_RSA_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, pskRSAKA, suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_RSA_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, pskRSAKA, suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_DHE_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_DHE_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_DHE_PSK_WITH_CHACHA20_POLY1305_SHA256, 32, 0, 12, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadChaCha20Poly1305},
+	{TLS_DHE_PSK_WITH_AES_256_CBC_SHA384, 32, 48, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
 	{TLS_DHE_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_DHE_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_DHE_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
+	{TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256, 32, 0, 12, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadChaCha20Poly1305},
+	{TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA384, 32, 48, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
+	{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherAES, macSHA256, nil},
+	{TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_PSK_WITH_AES_256_GCM_SHA384, 32, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_PSK_WITH_AES_128_GCM_SHA256, 16, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
 	{TLS_PSK_WITH_CHACHA20_POLY1305_SHA256, 32, 0, 12, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadChaCha20Poly1305},
+	{TLS_PSK_WITH_AES_256_CBC_SHA384, 32, 48, 16, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherAES, macSHA384, nil},
 	{TLS_PSK_WITH_AES_128_CBC_SHA256, 16, 32, 16, pskKA, suiteNoCerts | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_PSK_WITH_AES_256_CBC_SHA, 32, 20, 16, pskKA, suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_PSK_WITH_AES_128_CBC_SHA, 16, 20, 16, pskKA, suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
 
+	// NULL ciphersuites only authenticate records and also need an explicit opt-in
+	{TLS_RSA_PSK_WITH_NULL_SHA384, 0, 48, 0, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteNull | suiteDefaultOff, cipherNull, macSHA384, nil},
+	{TLS_RSA_PSK_WITH_NULL_SHA256, 0, 32, 0, pskRSAKA, suiteRSA | suiteNull | suiteDefaultOff, cipherNull, macSHA256, nil},
+	{TLS_RSA_PSK_WITH_NULL_SHA, 0, 20, 0, pskRSAKA, suiteRSA | suiteNull | suiteDefaultOff, cipherNull, macSHA1, nil},
+	{TLS_DHE_PSK_WITH_NULL_SHA384, 0, 48, 0, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteNull | suiteDefaultOff, cipherNull, macSHA384, nil},
+	{TLS_DHE_PSK_WITH_NULL_SHA256, 0, 32, 0, dhePSKKA, suiteDHE | suiteNoCerts | suiteNull | suiteDefaultOff, cipherNull, macSHA256, nil},
+	{TLS_DHE_PSK_WITH_NULL_SHA, 0, 20, 0, dhePSKKA, suiteDHE | suiteNoCerts | suiteNull | suiteDefaultOff, cipherNull, macSHA1, nil},
+	{TLS_PSK_WITH_NULL_SHA384, 0, 48, 0, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteNull | suiteDefaultOff, cipherNull, macSHA384, nil},
+	{TLS_PSK_WITH_NULL_SHA256, 0, 32, 0, pskKA, suiteNoCerts | suiteNull | suiteDefaultOff, cipherNull, macSHA256, nil},
+	{TLS_PSK_WITH_NULL_SHA, 0, 20, 0, pskKA, suiteNoCerts | suiteNull | suiteDefaultOff, cipherNull, macSHA1, nil},
+
+	// AES-CCM ciphersuites are mostly used by constrained devices
+	{TLS_ECDHE_ECDSA_WITH_AES_256_CCM, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_ECDHE_ECDSA_WITH_AES_128_CCM, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_DHE_RSA_WITH_AES_256_CCM, 32, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_DHE_RSA_WITH_AES_128_CCM, 16, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_DHE_RSA_WITH_AES_256_CCM_8, 32, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_DHE_RSA_WITH_AES_128_CCM_8, 16, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_DHE_PSK_WITH_AES_256_CCM, 32, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_DHE_PSK_WITH_AES_128_CCM, 16, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_PSK_DHE_WITH_AES_256_CCM_8, 32, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_PSK_DHE_WITH_AES_128_CCM_8, 16, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_PSK_WITH_AES_256_CCM, 32, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_PSK_WITH_AES_128_CCM, 16, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM},
+	{TLS_PSK_WITH_AES_256_CCM_8, 32, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+	{TLS_PSK_WITH_AES_128_CCM_8, 16, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESCCM8},
+
+	// Camellia and ARIA ciphersuites are required by some national standards
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_ECDHE_RSA_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_RSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, rsaKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_RSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, rsaKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_RSA_WITH_CAMELLIA_256_CBC_SHA256, 32, 32, 16, rsaKA, suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_RSA_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, rsaKA, suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_DHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_DHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA256, 32, 32, 16, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_ECDHE_PSK_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_ECDHE_PSK_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_DHE_PSK_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_DHE_PSK_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_DHE_PSK_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_DHE_PSK_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_RSA_PSK_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_RSA_PSK_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_RSA_PSK_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_RSA_PSK_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_PSK_WITH_CAMELLIA_256_GCM_SHA384, 32, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_PSK_WITH_CAMELLIA_128_GCM_SHA256, 16, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadCamelliaGCM},
+	{TLS_PSK_WITH_CAMELLIA_256_CBC_SHA384, 32, 48, 16, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherCamellia, macSHA384, nil},
+	{TLS_PSK_WITH_CAMELLIA_128_CBC_SHA256, 16, 32, 16, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherCamellia, macSHA256, nil},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_ECDHE_ECDSA_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_ECDHE_RSA_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_ECDHE_RSA_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, ecdheRSAKA, suiteECDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, rsaKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, rsaKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_RSA_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, rsaKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_RSA_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, rsaKA, suiteRSA | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_DHE_RSA_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_DHE_RSA_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_DHE_RSA_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_DHE_RSA_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, dheRSAKA, suiteDHE | suiteRSA | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_ECDHE_PSK_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_ECDHE_PSK_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, ecdhePSKKA, suiteECDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_DHE_PSK_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_DHE_PSK_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_DHE_PSK_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_DHE_PSK_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, dhePSKKA, suiteDHE | suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_RSA_PSK_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_RSA_PSK_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_RSA_PSK_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, pskRSAKA, suiteRSA | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_RSA_PSK_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, pskRSAKA, suiteRSA | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+	{TLS_PSK_WITH_ARIA_256_GCM_SHA384, 32, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_PSK_WITH_ARIA_128_GCM_SHA256, 16, 0, 4, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, nil, nil, aeadARIAGCM},
+	{TLS_PSK_WITH_ARIA_256_CBC_SHA384, 32, 48, 16, pskKA, suiteNoCerts | suiteTLS12 | suiteSHA384 | suiteDefaultOff, cipherARIA, macSHA384, nil},
+	{TLS_PSK_WITH_ARIA_128_CBC_SHA256, 16, 32, 16, pskKA, suiteNoCerts | suiteTLS12 | suiteDefaultOff, cipherARIA, macSHA256, nil},
+
+	// TLCP ciphersuites are required in China, see GB/T 38636 and tlcp.go
+	{TLS_ECC_SM4_GCM_SM3, 16, 0, 4, sm2KA, suiteSM2 | suiteSM3 | suiteTLCP | suiteDefaultOff, nil, nil, aeadSM4GCM},
+	{TLS_ECC_SM4_CBC_SM3, 16, 32, 16, sm2KA, suiteSM2 | suiteSM3 | suiteTLCP | suiteDefaultOff, cipherSM4, macSM3, nil},
+
+	// SRP ciphersuites use a password verifier instead of a preshared key
+	{TLS_SRP_SHA_RSA_WITH_AES_256_CBC_SHA, 32, 20, 16, srpRSAKA, suiteSRP | suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_SRP_SHA_RSA_WITH_AES_128_CBC_SHA, 16, 20, 16, srpRSAKA, suiteSRP | suiteRSA | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_SRP_SHA_WITH_AES_256_CBC_SHA, 32, 20, 16, srpKA, suiteSRP | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_SRP_SHA_WITH_AES_128_CBC_SHA, 16, 20, 16, srpKA, suiteSRP | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+
 	// RC4-based cipher suites are disabled by default.
-	{TLS_RSA_WITH_RC4_128_SHA, 16, 20, 0, rsaKA, suiteRSA | suiteDefaultOff, cipherRC4, macSHA1, nil},
-	{TLS_ECDHE_RSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheRSAKA, suiteECDHE | suiteRSA | suiteDefaultOff, cipherRC4, macSHA1, nil},
-	{TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteDefaultOff, cipherRC4, macSHA1, nil},
+	{TLS_RSA_WITH_RC4_128_SHA, 16, 20, 0, rsaKA, suiteRSA | suiteDefaultOff | suiteNoDTLS, cipherRC4, macSHA1, nil},
+	{TLS_ECDHE_RSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheRSAKA, suiteECDHE | suiteRSA | suiteDefaultOff | suiteNoDTLS, cipherRC4, macSHA1, nil},
+	{TLS_ECDHE_ECDSA_WITH_RC4_128_SHA, 16, 20, 0, ecdheECDSAKA, suiteECDHE | suiteECDSA | suiteDefaultOff | suiteNoDTLS, cipherRC4, macSHA1, nil},
 
 	// DH_anon
 	{TLS_DH_anon_WITH_AES_256_GCM_SHA384, 32, 0, 4, dheKA, suiteDHE | suiteNoCerts | suiteSHA384 | suiteTLS12 | suiteDefaultOff, nil, nil, aeadAESGCM},
@@ -150,6 +384,21 @@ var cipherSuites = []*cipherSuite{
 	{TLS_DH_anon_WITH_AES_128_CBC_SHA256, 16, 32, 16, dheKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA256, nil},
 	{TLS_DH_anon_WITH_AES_256_CBC_SHA, 32, 20, 16, dheKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
 	{TLS_DH_anon_WITH_AES_128_CBC_SHA, 16, 20, 16, dheKA, suiteDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+
+	// ECDH_anon
+	{TLS_ECDH_anon_WITH_AES_256_CBC_SHA, 32, 20, 16, ecdhAnonKA, suiteECDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+	{TLS_ECDH_anon_WITH_AES_128_CBC_SHA, 16, 20, 16, ecdhAnonKA, suiteECDHE | suiteNoCerts | suiteDefaultOff, cipherAES, macSHA1, nil},
+}
+
+// nullStream is the NULL cipher: a cipher.Stream that leaves data as it is.
+type nullStream struct{}
+
+func (nullStream) XORKeyStream(dst, src []byte) {
+	copy(dst, src)
+}
+
+func cipherNull(key, iv []byte, isRead bool) interface{} {
+	return nullStream{}
 }
 
 func cipherRC4(key, iv []byte, isRead bool) interface{} {
@@ -173,6 +422,30 @@ func cipherAES(key, iv []byte, isRead bool) interface{} {
 	return cipher.NewCBCEncrypter(block, iv)
 }
 
+func cipherCamellia(key, iv []byte, isRead bool) interface{} {
+	block, _ := newCamelliaCipher(key)
+	if isRead {
+		return cipher.NewCBCDecrypter(block, iv)
+	}
+	return cipher.NewCBCEncrypter(block, iv)
+}
+
+func cipherARIA(key, iv []byte, isRead bool) interface{} {
+	block, _ := newARIACipher(key)
+	if isRead {
+		return cipher.NewCBCDecrypter(block, iv)
+	}
+	return cipher.NewCBCEncrypter(block, iv)
+}
+
+func cipherSM4(key, iv []byte, isRead bool) interface{} {
+	block, _ := newSM4Cipher(key)
+	if isRead {
+		return cipher.NewCBCDecrypter(block, iv)
+	}
+	return cipher.NewCBCEncrypter(block, iv)
+}
+
 // macSHA1 returns a macFunction for the given protocol version.
 func macSHA1(version uint16, key []byte) macFunction {
 	if version == VersionSSL30 {
@@ -192,6 +465,19 @@ func macSHA256(version uint16, key []byte) macFunction {
 	return tls10MAC{hmac.New(sha256.New, key)}
 }
 
+// macSHA384 returns a SHA-384 based MAC. These are only supported in TLS 1.2
+// so the given version is ignored. Unlike macSHA256 it uses a constant time
+// hash, since SHA-384 CBC suites are meant for peers that lack GCM.
+func macSHA384(version uint16, key []byte) macFunction {
+	return tls10MAC{hmac.New(newConstantTimeHash(newConstantTimeSHA384), key)}
+}
+
+// macSM3 returns an SM3 based MAC. These are only supported in TLCP so
+// the given version is ignored.
+func macSM3(version uint16, key []byte) macFunction {
+	return tls10MAC{hmac.New(newSM3, key)}
+}
+
 type macFunction interface {
 	Size() int
 	MAC(digestBuf, seq, header, data, extra []byte) []byte
@@ -278,6 +564,72 @@ func aeadAESGCM(key, fixedNonce []byte) cipher.AEAD {
 	return ret
 }
 
+func aeadCamelliaGCM(key, fixedNonce []byte) cipher.AEAD {
+	camellia, err := newCamelliaCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(camellia)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &fixedNonceAEAD{aead: aead}
+	copy(ret.nonce[:], fixedNonce)
+	return ret
+}
+
+func aeadARIAGCM(key, fixedNonce []byte) cipher.AEAD {
+	aria, err := newARIACipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(aria)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &fixedNonceAEAD{aead: aead}
+	copy(ret.nonce[:], fixedNonce)
+	return ret
+}
+
+func aeadSM4GCM(key, fixedNonce []byte) cipher.AEAD {
+	sm4, err := newSM4Cipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(sm4)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &fixedNonceAEAD{aead: aead}
+	copy(ret.nonce[:], fixedNonce)
+	return ret
+}
+
+// aeadAESCCM and aeadAESCCM8 build the AES-CCM AEADs of RFC 6655, which use
+// the same nonce construction as AES-GCM.
+func aeadAESCCM(key, fixedNonce []byte) cipher.AEAD {
+	return newAESCCM(key, fixedNonce, 16)
+}
+
+func aeadAESCCM8(key, fixedNonce []byte) cipher.AEAD {
+	return newAESCCM(key, fixedNonce, 8)
+}
+
+func newAESCCM(key, fixedNonce []byte, tagSize int) cipher.AEAD {
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &fixedNonceAEAD{aead: newCCM(aes, tagSize)}
+	copy(ret.nonce[:], fixedNonce)
+	return ret
+}
+
 func aeadChaCha20Poly1305(key, fixedNonce []byte) cipher.AEAD {
 	aead, err := chacha20poly1305.New(key)
 	if err != nil {
@@ -289,6 +641,44 @@ func aeadChaCha20Poly1305(key, fixedNonce []byte) cipher.AEAD {
 	return ret
 }
 
+// aeadAESGCMTLS13 builds the AES-GCM AEAD of TLS 1.3, which has no explicit
+// nonce. Instead the sequence number is XORed into the 12 byte IV.
+func aeadAESGCMTLS13(key, nonceMask []byte) cipher.AEAD {
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+	aead, err := cipher.NewGCM(aes)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: aead}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
+// aeadAESCCMTLS13 and aeadAESCCM8TLS13 are the AES-CCM equivalents of
+// aeadAESGCMTLS13.
+func aeadAESCCMTLS13(key, nonceMask []byte) cipher.AEAD {
+	return newAESCCMTLS13(key, nonceMask, 16)
+}
+
+func aeadAESCCM8TLS13(key, nonceMask []byte) cipher.AEAD {
+	return newAESCCMTLS13(key, nonceMask, 8)
+}
+
+func newAESCCMTLS13(key, nonceMask []byte, tagSize int) cipher.AEAD {
+	aes, err := aes.NewCipher(key)
+	if err != nil {
+		panic(err)
+	}
+
+	ret := &xorNonceAEAD{aead: newCCM(aes, tagSize)}
+	copy(ret.nonceMask[:], nonceMask)
+	return ret
+}
+
 // ssl30MAC implements the SSLv3 MAC function, as defined in
 // www.mozilla.org/projects/security/pki/nss/ssl/draft302.txt section 5.2.3.1
 type ssl30MAC struct {
@@ -397,6 +787,12 @@ func dheKA(version uint16) keyAgreement {
 	return &dheKeyAgreement{}
 }
 
+func ecdhAnonKA(version uint16) keyAgreement {
+	return &ecdhAnonKeyAgreement{
+		ecdheKeyAgreement: ecdheKeyAgreement{version: version},
+	}
+}
+
 // no dheECDSAKA because there's no implemented ciphersuite that uses DHE and ECDSA
 
 func dheRSAKA(version uint16) keyAgreement {
@@ -406,6 +802,13 @@ func dheRSAKA(version uint16) keyAgreement {
 	}
 }
 
+func dheDSSKA(version uint16) keyAgreement {
+	return &dheRsaKeyAgreement{
+		sigType: signatureDSA,
+		version: version,
+	}
+}
+
 func pskKA(version uint16) keyAgreement {
 	return &pskKeyAgreement{}
 }
@@ -418,6 +821,33 @@ func dhePSKKA(version uint16) keyAgreement {
 	return &dhePskKeyAgreement{}
 }
 
+func srpKA(version uint16) keyAgreement {
+	return &srpKeyAgreement{version: version}
+}
+
+func srpRSAKA(version uint16) keyAgreement {
+	return &srpKeyAgreement{
+		sigType: signatureRSA,
+		version: version,
+	}
+}
+
+func ecdhePSKKA(version uint16) keyAgreement {
+	return &ecdhePskKeyAgreement{
+		ecdheKeyAgreement: ecdheKeyAgreement{version: version},
+	}
+}
+
+func sm2KA(version uint16) keyAgreement {
+	return &sm2KeyAgreement{}
+}
+
+// usesCBC reports whether the suite protects records with a block cipher in
+// CBC mode, the only kind that encrypt-then-MAC applies to.
+func (s *cipherSuite) usesCBC() bool {
+	return s.cipher != nil && s.ivLen > 0
+}
+
 // mutualCipherSuite returns a cipherSuite given a list of supported
 // ciphersuites and the id requested by the peer.
 func mutualCipherSuite(have []uint16, want uint16) *cipherSuite {
@@ -434,65 +864,225 @@ func mutualCipherSuite(have []uint16, want uint16) *cipherSuite {
 	return nil
 }
 
+// A cipherSuiteTLS13 is a TLS 1.3 cipher suite. These only name the AEAD and
+// the hash used by the key schedule: key exchange and authentication are
+// negotiated separately.
+type cipherSuiteTLS13 struct {
+	id     uint16
+	keyLen int
+	aead   func(key, nonceMask []byte) cipher.AEAD
+	hash   crypto.Hash
+	// flags is a bitmask of the suite* values, above. Only suiteDefaultOff
+	// is meaningful.
+	flags int
+}
+
+var cipherSuitesTLS13 = []*cipherSuiteTLS13{
+	{TLS_AES_128_GCM_SHA256, 16, aeadAESGCMTLS13, crypto.SHA256, 0},
+	{TLS_CHACHA20_POLY1305_SHA256, 32, aeadChaCha20Poly1305, crypto.SHA256, 0},
+	{TLS_AES_256_GCM_SHA384, 32, aeadAESGCMTLS13, crypto.SHA384, 0},
+	{TLS_AES_128_CCM_SHA256, 16, aeadAESCCMTLS13, crypto.SHA256, suiteDefaultOff},
+	{TLS_AES_128_CCM_8_SHA256, 16, aeadAESCCM8TLS13, crypto.SHA256, suiteDefaultOff},
+}
+
+// cipherSuiteTLS13ByID returns the TLS 1.3 cipher suite with the given id, or
+// nil if there isn't one.
+func cipherSuiteTLS13ByID(id uint16) *cipherSuiteTLS13 {
+	for _, suite := range cipherSuitesTLS13 {
+		if suite.id == id {
+			return suite
+		}
+	}
+	return nil
+}
+
+// mutualCipherSuiteTLS13 is the TLS 1.3 version of mutualCipherSuite.
+func mutualCipherSuiteTLS13(have []uint16, want uint16) *cipherSuiteTLS13 {
+	for _, id := range have {
+		if id == want {
+			return cipherSuiteTLS13ByID(id)
+		}
+	}
+	return nil
+}
+
 // A list of cipher suite IDs that are, or have been, implemented by this
 // package.
 //
 // Taken from http://www.iana.org/assignments/tls-parameters/tls-parameters.xml
 const (
-	TLS_RSA_WITH_RC4_128_SHA                  uint16 = 0x0005
-	TLS_RSA_WITH_3DES_EDE_CBC_SHA             uint16 = 0x000a
-	TLS_RSA_WITH_AES_128_CBC_SHA              uint16 = 0x002f
-	TLS_DHE_RSA_WITH_AES_128_CBC_SHA          uint16 = 0x0033
-	TLS_DH_anon_WITH_AES_128_CBC_SHA          uint16 = 0x0034
-	TLS_RSA_WITH_AES_256_CBC_SHA              uint16 = 0x0035
-	TLS_DHE_RSA_WITH_AES_256_CBC_SHA          uint16 = 0x0039
-	TLS_DH_anon_WITH_AES_256_CBC_SHA          uint16 = 0x003a
-	TLS_RSA_WITH_AES_128_CBC_SHA256           uint16 = 0x003c
-	TLS_RSA_WITH_AES_256_CBC_SHA256           uint16 = 0x003d
-	TLS_DHE_RSA_WITH_AES_128_CBC_SHA256       uint16 = 0x0067
-	TLS_DHE_RSA_WITH_AES_256_CBC_SHA256       uint16 = 0x006b
-	TLS_DH_anon_WITH_AES_128_CBC_SHA256       uint16 = 0x006c
-	TLS_DH_anon_WITH_AES_256_CBC_SHA256       uint16 = 0x006d
-	TLS_PSK_WITH_AES_128_CBC_SHA              uint16 = 0x008C
-	TLS_PSK_WITH_AES_256_CBC_SHA              uint16 = 0x008D
-	TLS_DHE_PSK_WITH_AES_128_CBC_SHA          uint16 = 0x0090
-	TLS_DHE_PSK_WITH_AES_256_CBC_SHA          uint16 = 0x0091
-	TLS_RSA_PSK_WITH_AES_128_CBC_SHA          uint16 = 0x0094
-	TLS_RSA_PSK_WITH_AES_256_CBC_SHA          uint16 = 0x0095
-	TLS_RSA_WITH_AES_128_GCM_SHA256           uint16 = 0x009c
-	TLS_RSA_WITH_AES_256_GCM_SHA384           uint16 = 0x009d
-	TLS_DHE_RSA_WITH_AES_128_GCM_SHA256       uint16 = 0x009e
-	TLS_DHE_RSA_WITH_AES_256_GCM_SHA384       uint16 = 0x009f
-	TLS_DH_anon_WITH_AES_128_GCM_SHA256       uint16 = 0x00a6
-	TLS_DH_anon_WITH_AES_256_GCM_SHA384       uint16 = 0x00a7
-	TLS_PSK_WITH_AES_128_GCM_SHA256           uint16 = 0x00a8
-	TLS_PSK_WITH_AES_256_GCM_SHA384           uint16 = 0x00a9
-	TLS_DHE_PSK_WITH_AES_128_GCM_SHA256       uint16 = 0x00aa
-	TLS_DHE_PSK_WITH_AES_256_GCM_SHA384       uint16 = 0x00ab
-	TLS_RSA_PSK_WITH_AES_128_GCM_SHA256       uint16 = 0x00ac
-	TLS_RSA_PSK_WITH_AES_256_GCM_SHA384       uint16 = 0x00ad
-	TLS_PSK_WITH_AES_128_CBC_SHA256           uint16 = 0x00ae
-	TLS_DHE_PSK_WITH_AES_128_CBC_SHA256       uint16 = 0x00b2
-	TLS_RSA_PSK_WITH_AES_128_CBC_SHA256       uint16 = 0x00b6
-	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA          uint16 = 0xc007
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA      uint16 = 0xc009
-	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA      uint16 = 0xc00a
-	TLS_ECDHE_RSA_WITH_RC4_128_SHA            uint16 = 0xc011
-	TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA       uint16 = 0xc012
-	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA        uint16 = 0xc013
-	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA        uint16 = 0xc014
-	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256   uint16 = 0xc023
-	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256     uint16 = 0xc027
-	TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256     uint16 = 0xc02f
-	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256   uint16 = 0xc02b
-	TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384     uint16 = 0xc030
-	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384   uint16 = 0xc02c
-	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305      uint16 = 0xcca8
-	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305    uint16 = 0xcca9
-	TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256 uint16 = 0xccaa
-	TLS_PSK_WITH_CHACHA20_POLY1305_SHA256     uint16 = 0xccab
-	TLS_DHE_PSK_WITH_CHACHA20_POLY1305_SHA256 uint16 = 0xccad
-	TLS_RSA_PSK_WITH_CHACHA20_POLY1305_SHA256 uint16 = 0xccae
+	TLS_RSA_WITH_RC4_128_SHA                     uint16 = 0x0005
+	TLS_RSA_WITH_3DES_EDE_CBC_SHA                uint16 = 0x000a
+	TLS_PSK_WITH_NULL_SHA                        uint16 = 0x002c
+	TLS_DHE_PSK_WITH_NULL_SHA                    uint16 = 0x002d
+	TLS_RSA_PSK_WITH_NULL_SHA                    uint16 = 0x002e
+	TLS_RSA_WITH_AES_128_CBC_SHA                 uint16 = 0x002f
+	TLS_DHE_DSS_WITH_AES_128_CBC_SHA             uint16 = 0x0032
+	TLS_DHE_RSA_WITH_AES_128_CBC_SHA             uint16 = 0x0033
+	TLS_DH_anon_WITH_AES_128_CBC_SHA             uint16 = 0x0034
+	TLS_RSA_WITH_AES_256_CBC_SHA                 uint16 = 0x0035
+	TLS_DHE_DSS_WITH_AES_256_CBC_SHA             uint16 = 0x0038
+	TLS_DHE_RSA_WITH_AES_256_CBC_SHA             uint16 = 0x0039
+	TLS_DH_anon_WITH_AES_256_CBC_SHA             uint16 = 0x003a
+	TLS_RSA_WITH_AES_128_CBC_SHA256              uint16 = 0x003c
+	TLS_RSA_WITH_AES_256_CBC_SHA256              uint16 = 0x003d
+	TLS_DHE_DSS_WITH_AES_128_CBC_SHA256          uint16 = 0x0040
+	TLS_DHE_RSA_WITH_AES_128_CBC_SHA256          uint16 = 0x0067
+	TLS_DHE_DSS_WITH_AES_256_CBC_SHA256          uint16 = 0x006a
+	TLS_DHE_RSA_WITH_AES_256_CBC_SHA256          uint16 = 0x006b
+	TLS_DH_anon_WITH_AES_128_CBC_SHA256          uint16 = 0x006c
+	TLS_DH_anon_WITH_AES_256_CBC_SHA256          uint16 = 0x006d
+	TLS_PSK_WITH_AES_128_CBC_SHA                 uint16 = 0x008C
+	TLS_PSK_WITH_AES_256_CBC_SHA                 uint16 = 0x008D
+	TLS_DHE_PSK_WITH_AES_128_CBC_SHA             uint16 = 0x0090
+	TLS_DHE_PSK_WITH_AES_256_CBC_SHA             uint16 = 0x0091
+	TLS_RSA_PSK_WITH_AES_128_CBC_SHA             uint16 = 0x0094
+	TLS_RSA_PSK_WITH_AES_256_CBC_SHA             uint16 = 0x0095
+	TLS_RSA_WITH_AES_128_GCM_SHA256              uint16 = 0x009c
+	TLS_RSA_WITH_AES_256_GCM_SHA384              uint16 = 0x009d
+	TLS_DHE_RSA_WITH_AES_128_GCM_SHA256          uint16 = 0x009e
+	TLS_DHE_RSA_WITH_AES_256_GCM_SHA384          uint16 = 0x009f
+	TLS_DHE_DSS_WITH_AES_128_GCM_SHA256          uint16 = 0x00a2
+	TLS_DHE_DSS_WITH_AES_256_GCM_SHA384          uint16 = 0x00a3
+	TLS_DH_anon_WITH_AES_128_GCM_SHA256          uint16 = 0x00a6
+	TLS_DH_anon_WITH_AES_256_GCM_SHA384          uint16 = 0x00a7
+	TLS_PSK_WITH_AES_128_GCM_SHA256              uint16 = 0x00a8
+	TLS_PSK_WITH_AES_256_GCM_SHA384              uint16 = 0x00a9
+	TLS_DHE_PSK_WITH_AES_128_GCM_SHA256          uint16 = 0x00aa
+	TLS_DHE_PSK_WITH_AES_256_GCM_SHA384          uint16 = 0x00ab
+	TLS_RSA_PSK_WITH_AES_128_GCM_SHA256          uint16 = 0x00ac
+	TLS_RSA_PSK_WITH_AES_256_GCM_SHA384          uint16 = 0x00ad
+	TLS_PSK_WITH_AES_128_CBC_SHA256              uint16 = 0x00ae
+	TLS_PSK_WITH_AES_256_CBC_SHA384              uint16 = 0x00af
+	TLS_PSK_WITH_NULL_SHA256                     uint16 = 0x00b0
+	TLS_PSK_WITH_NULL_SHA384                     uint16 = 0x00b1
+	TLS_DHE_PSK_WITH_AES_128_CBC_SHA256          uint16 = 0x00b2
+	TLS_DHE_PSK_WITH_AES_256_CBC_SHA384          uint16 = 0x00b3
+	TLS_DHE_PSK_WITH_NULL_SHA256                 uint16 = 0x00b4
+	TLS_DHE_PSK_WITH_NULL_SHA384                 uint16 = 0x00b5
+	TLS_RSA_PSK_WITH_AES_128_CBC_SHA256          uint16 = 0x00b6
+	TLS_RSA_PSK_WITH_AES_256_CBC_SHA384          uint16 = 0x00b7
+	TLS_RSA_PSK_WITH_NULL_SHA256                 uint16 = 0x00b8
+	TLS_RSA_PSK_WITH_NULL_SHA384                 uint16 = 0x00b9
+	TLS_RSA_WITH_CAMELLIA_128_CBC_SHA256         uint16 = 0x00ba
+	TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA256     uint16 = 0x00be
+	TLS_RSA_WITH_CAMELLIA_256_CBC_SHA256         uint16 = 0x00c0
+	TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA256     uint16 = 0x00c4
+	TLS_ECDHE_ECDSA_WITH_RC4_128_SHA             uint16 = 0xc007
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA         uint16 = 0xc009
+	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA         uint16 = 0xc00a
+	TLS_ECDHE_RSA_WITH_RC4_128_SHA               uint16 = 0xc011
+	TLS_ECDHE_RSA_WITH_3DES_EDE_CBC_SHA          uint16 = 0xc012
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA           uint16 = 0xc013
+	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA           uint16 = 0xc014
+	TLS_ECDH_anon_WITH_AES_128_CBC_SHA           uint16 = 0xc018
+	TLS_ECDH_anon_WITH_AES_256_CBC_SHA           uint16 = 0xc019
+	TLS_SRP_SHA_WITH_AES_128_CBC_SHA             uint16 = 0xc01d
+	TLS_SRP_SHA_RSA_WITH_AES_128_CBC_SHA         uint16 = 0xc01e
+	TLS_SRP_SHA_WITH_AES_256_CBC_SHA             uint16 = 0xc020
+	TLS_SRP_SHA_RSA_WITH_AES_256_CBC_SHA         uint16 = 0xc021
+	TLS_ECDHE_ECDSA_WITH_AES_128_CBC_SHA256      uint16 = 0xc023
+	TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384      uint16 = 0xc024
+	TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA256        uint16 = 0xc027
+	TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384        uint16 = 0xc028
+	TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256        uint16 = 0xc02f
+	TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256      uint16 = 0xc02b
+	TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384        uint16 = 0xc030
+	TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384      uint16 = 0xc02c
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA           uint16 = 0xc035
+	TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA           uint16 = 0xc036
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256        uint16 = 0xc037
+	TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA384        uint16 = 0xc038
+	TLS_RSA_WITH_ARIA_128_CBC_SHA256             uint16 = 0xc03c
+	TLS_RSA_WITH_ARIA_256_CBC_SHA384             uint16 = 0xc03d
+	TLS_DHE_RSA_WITH_ARIA_128_CBC_SHA256         uint16 = 0xc044
+	TLS_DHE_RSA_WITH_ARIA_256_CBC_SHA384         uint16 = 0xc045
+	TLS_ECDHE_ECDSA_WITH_ARIA_128_CBC_SHA256     uint16 = 0xc048
+	TLS_ECDHE_ECDSA_WITH_ARIA_256_CBC_SHA384     uint16 = 0xc049
+	TLS_ECDHE_RSA_WITH_ARIA_128_CBC_SHA256       uint16 = 0xc04c
+	TLS_ECDHE_RSA_WITH_ARIA_256_CBC_SHA384       uint16 = 0xc04d
+	TLS_RSA_WITH_ARIA_128_GCM_SHA256             uint16 = 0xc050
+	TLS_RSA_WITH_ARIA_256_GCM_SHA384             uint16 = 0xc051
+	TLS_DHE_RSA_WITH_ARIA_128_GCM_SHA256         uint16 = 0xc052
+	TLS_DHE_RSA_WITH_ARIA_256_GCM_SHA384         uint16 = 0xc053
+	TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256     uint16 = 0xc05c
+	TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384     uint16 = 0xc05d
+	TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256       uint16 = 0xc060
+	TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384       uint16 = 0xc061
+	TLS_PSK_WITH_ARIA_128_CBC_SHA256             uint16 = 0xc064
+	TLS_PSK_WITH_ARIA_256_CBC_SHA384             uint16 = 0xc065
+	TLS_DHE_PSK_WITH_ARIA_128_CBC_SHA256         uint16 = 0xc066
+	TLS_DHE_PSK_WITH_ARIA_256_CBC_SHA384         uint16 = 0xc067
+	TLS_RSA_PSK_WITH_ARIA_128_CBC_SHA256         uint16 = 0xc068
+	TLS_RSA_PSK_WITH_ARIA_256_CBC_SHA384         uint16 = 0xc069
+	TLS_PSK_WITH_ARIA_128_GCM_SHA256             uint16 = 0xc06a
+	TLS_PSK_WITH_ARIA_256_GCM_SHA384             uint16 = 0xc06b
+	TLS_DHE_PSK_WITH_ARIA_128_GCM_SHA256         uint16 = 0xc06c
+	TLS_DHE_PSK_WITH_ARIA_256_GCM_SHA384         uint16 = 0xc06d
+	TLS_RSA_PSK_WITH_ARIA_128_GCM_SHA256         uint16 = 0xc06e
+	TLS_RSA_PSK_WITH_ARIA_256_GCM_SHA384         uint16 = 0xc06f
+	TLS_ECDHE_PSK_WITH_ARIA_128_CBC_SHA256       uint16 = 0xc070
+	TLS_ECDHE_PSK_WITH_ARIA_256_CBC_SHA384       uint16 = 0xc071
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_CBC_SHA256 uint16 = 0xc072
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_CBC_SHA384 uint16 = 0xc073
+	TLS_ECDHE_RSA_WITH_CAMELLIA_128_CBC_SHA256   uint16 = 0xc076
+	TLS_ECDHE_RSA_WITH_CAMELLIA_256_CBC_SHA384   uint16 = 0xc077
+	TLS_RSA_WITH_CAMELLIA_128_GCM_SHA256         uint16 = 0xc07a
+	TLS_RSA_WITH_CAMELLIA_256_GCM_SHA384         uint16 = 0xc07b
+	TLS_DHE_RSA_WITH_CAMELLIA_128_GCM_SHA256     uint16 = 0xc07c
+	TLS_DHE_RSA_WITH_CAMELLIA_256_GCM_SHA384     uint16 = 0xc07d
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256 uint16 = 0xc086
+	TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384 uint16 = 0xc087
+	TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256   uint16 = 0xc08a
+	TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384   uint16 = 0xc08b
+	TLS_PSK_WITH_CAMELLIA_128_GCM_SHA256         uint16 = 0xc08e
+	TLS_PSK_WITH_CAMELLIA_256_GCM_SHA384         uint16 = 0xc08f
+	TLS_DHE_PSK_WITH_CAMELLIA_128_GCM_SHA256     uint16 = 0xc090
+	TLS_DHE_PSK_WITH_CAMELLIA_256_GCM_SHA384     uint16 = 0xc091
+	TLS_RSA_PSK_WITH_CAMELLIA_128_GCM_SHA256     uint16 = 0xc092
+	TLS_RSA_PSK_WITH_CAMELLIA_256_GCM_SHA384     uint16 = 0xc093
+	TLS_PSK_WITH_CAMELLIA_128_CBC_SHA256         uint16 = 0xc094
+	TLS_PSK_WITH_CAMELLIA_256_CBC_SHA384         uint16 = 0xc095
+	TLS_DHE_PSK_WITH_CAMELLIA_128_CBC_SHA256     uint16 = 0xc096
+	TLS_DHE_PSK_WITH_CAMELLIA_256_CBC_SHA384     uint16 = 0xc097
+	TLS_RSA_PSK_WITH_CAMELLIA_128_CBC_SHA256     uint16 = 0xc098
+	TLS_RSA_PSK_WITH_CAMELLIA_256_CBC_SHA384     uint16 = 0xc099
+	TLS_ECDHE_PSK_WITH_CAMELLIA_128_CBC_SHA256   uint16 = 0xc09a
+	TLS_ECDHE_PSK_WITH_CAMELLIA_256_CBC_SHA384   uint16 = 0xc09b
+	TLS_DHE_RSA_WITH_AES_128_CCM                 uint16 = 0xc09e
+	TLS_DHE_RSA_WITH_AES_256_CCM                 uint16 = 0xc09f
+	TLS_DHE_RSA_WITH_AES_128_CCM_8               uint16 = 0xc0a2
+	TLS_DHE_RSA_WITH_AES_256_CCM_8               uint16 = 0xc0a3
+	TLS_PSK_WITH_AES_128_CCM                     uint16 = 0xc0a4
+	TLS_PSK_WITH_AES_256_CCM                     uint16 = 0xc0a5
+	TLS_DHE_PSK_WITH_AES_128_CCM                 uint16 = 0xc0a6
+	TLS_DHE_PSK_WITH_AES_256_CCM                 uint16 = 0xc0a7
+	TLS_PSK_WITH_AES_128_CCM_8                   uint16 = 0xc0a8
+	TLS_PSK_WITH_AES_256_CCM_8                   uint16 = 0xc0a9
+	TLS_PSK_DHE_WITH_AES_128_CCM_8               uint16 = 0xc0aa
+	TLS_PSK_DHE_WITH_AES_256_CCM_8               uint16 = 0xc0ab
+	TLS_ECDHE_ECDSA_WITH_AES_128_CCM             uint16 = 0xc0ac
+	TLS_ECDHE_ECDSA_WITH_AES_256_CCM             uint16 = 0xc0ad
+	TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8           uint16 = 0xc0ae
+	TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8           uint16 = 0xc0af
+	TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305         uint16 = 0xcca8
+	TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305       uint16 = 0xcca9
+	TLS_DHE_RSA_WITH_CHACHA20_POLY1305_SHA256    uint16 = 0xccaa
+	TLS_PSK_WITH_CHACHA20_POLY1305_SHA256        uint16 = 0xccab
+	TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256  uint16 = 0xccac
+	TLS_DHE_PSK_WITH_CHACHA20_POLY1305_SHA256    uint16 = 0xccad
+	TLS_RSA_PSK_WITH_CHACHA20_POLY1305_SHA256    uint16 = 0xccae
+	TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256        uint16 = 0xd001
+	TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384        uint16 = 0xd002
+	TLS_ECC_SM4_CBC_SM3                          uint16 = 0xe013
+	TLS_ECC_SM4_GCM_SM3                          uint16 = 0xe053
+
+	// TLS 1.3 cipher suites, see RFC 8446, section B.4.
+	TLS_AES_128_GCM_SHA256       uint16 = 0x1301
+	TLS_AES_256_GCM_SHA384       uint16 = 0x1302
+	TLS_CHACHA20_POLY1305_SHA256 uint16 = 0x1303
+	TLS_AES_128_CCM_SHA256       uint16 = 0x1304
+	TLS_AES_128_CCM_8_SHA256     uint16 = 0x1305
 
 	// TLS_FALLBACK_SCSV isn't a standard cipher suite but an indicator
 	// that the client is doing version fallback. See
@@ -5,12 +5,16 @@
 package tls
 
 import (
+	"bytes"
 	"container/list"
 	"crypto"
+	"crypto/hmac"
 	"crypto/internal/cipherhw"
 	"crypto/rand"
+	"crypto/sha256"
 	"crypto/sha512"
 	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"math/big"
@@ -25,16 +29,34 @@ const (
 	VersionTLS10 = 0x0301
 	VersionTLS11 = 0x0302
 	VersionTLS12 = 0x0303
+	VersionTLS13 = 0x0304
+
+	// VersionDTLS12 is DTLS 1.2, the datagram version of TLS 1.2, see RFC
+	// 6347. It is reported by ConnectionState, but Config.MinVersion and
+	// MaxVersion of DTLS connections are given as TLS versions.
+	VersionDTLS12 = 0xfefd
+
+	// VersionTLCP is TLCP, the Chinese protocol of GB/T 38636 that carries
+	// the SM cipher suites, see tlcp.go. A client speaks it, and nothing
+	// else, when Config.MaxVersion is VersionTLCP. A server accepts it
+	// from clients that ask for it when Config.TLCPSigningCertificate and
+	// TLCPEncryptionCertificate are set.
+	VersionTLCP = 0x0101
 )
 
 const (
-	maxPlaintext    = 16384        // maximum plaintext payload length
-	maxCiphertext   = 16384 + 2048 // maximum ciphertext payload length
-	recordHeaderLen = 5            // record header length
-	maxHandshake    = 65536        // maximum handshake we support (protocol max is 16 MB)
+	maxPlaintext       = 16384        // maximum plaintext payload length
+	maxCiphertext      = 16384 + 2048 // maximum ciphertext payload length
+	recordHeaderLen    = 5            // record header length
+	maxHandshake       = 65536        // maximum handshake we support (protocol max is 16 MB)
+	minRecordSizeLimit = 64           // smallest record_size_limit (RFC 8449, section 4)
 
 	minVersion = VersionTLS10
 	maxVersion = VersionTLS12
+
+	// maxSupportedVersion is the highest version that can be enabled with
+	// Config.MaxVersion. TLS 1.3 isn't used unless it is asked for.
+	maxSupportedVersion = VersionTLS13
 )
 
 // TLS record types.
@@ -49,19 +71,23 @@ const (
 
 // TLS handshake message types.
 const (
-	typeHelloRequest       uint8 = 0
-	typeClientHello        uint8 = 1
-	typeServerHello        uint8 = 2
-	typeNewSessionTicket   uint8 = 4
-	typeCertificate        uint8 = 11
-	typeServerKeyExchange  uint8 = 12
-	typeCertificateRequest uint8 = 13
-	typeServerHelloDone    uint8 = 14
-	typeCertificateVerify  uint8 = 15
-	typeClientKeyExchange  uint8 = 16
-	typeFinished           uint8 = 20
-	typeCertificateStatus  uint8 = 22
-	typeNextProtocol       uint8 = 67 // Not IANA assigned
+	typeHelloRequest        uint8 = 0
+	typeClientHello         uint8 = 1
+	typeServerHello         uint8 = 2
+	typeHelloVerifyRequest  uint8 = 3 // DTLS only
+	typeNewSessionTicket    uint8 = 4
+	typeEncryptedExtensions uint8 = 8
+	typeCertificate         uint8 = 11
+	typeServerKeyExchange   uint8 = 12
+	typeCertificateRequest  uint8 = 13
+	typeServerHelloDone     uint8 = 14
+	typeCertificateVerify   uint8 = 15
+	typeClientKeyExchange   uint8 = 16
+	typeFinished            uint8 = 20
+	typeCertificateStatus   uint8 = 22
+	typeKeyUpdate           uint8 = 24
+	typeNextProtocol        uint8 = 67  // Not IANA assigned
+	typeMessageHash         uint8 = 254 // synthetic message, see RFC 8446, section 4.4.1
 )
 
 // TLS compression types.
@@ -71,16 +97,29 @@ const (
 
 // TLS extension numbers
 const (
-	extensionServerName          uint16 = 0
-	extensionStatusRequest       uint16 = 5
-	extensionSupportedCurves     uint16 = 10
-	extensionSupportedPoints     uint16 = 11
-	extensionSignatureAlgorithms uint16 = 13
-	extensionALPN                uint16 = 16
-	extensionSCT                 uint16 = 18 // https://tools.ietf.org/html/rfc6962#section-6
-	extensionSessionTicket       uint16 = 35
-	extensionNextProtoNeg        uint16 = 13172 // not IANA assigned
-	extensionRenegotiationInfo   uint16 = 0xff01
+	extensionServerName            uint16 = 0
+	extensionMaxFragmentLength     uint16 = 1 // https://tools.ietf.org/html/rfc6066#section-4
+	extensionStatusRequest         uint16 = 5
+	extensionSupportedCurves       uint16 = 10
+	extensionSupportedPoints       uint16 = 11
+	extensionSRP                   uint16 = 12 // https://tools.ietf.org/html/rfc5054#section-2.8.1
+	extensionSignatureAlgorithms   uint16 = 13
+	extensionALPN                  uint16 = 16
+	extensionSCT                   uint16 = 18 // https://tools.ietf.org/html/rfc6962#section-6
+	extensionClientCertificateType uint16 = 19 // https://tools.ietf.org/html/rfc7250#section-3
+	extensionServerCertificateType uint16 = 20 // https://tools.ietf.org/html/rfc7250#section-3
+	extensionEncryptThenMAC        uint16 = 22 // https://tools.ietf.org/html/rfc7366#section-2
+	extensionExtendedMasterSecret  uint16 = 23 // https://tools.ietf.org/html/rfc7627#section-5.1
+	extensionRecordSizeLimit       uint16 = 28 // https://tools.ietf.org/html/rfc8449#section-4
+	extensionSessionTicket         uint16 = 35
+	extensionPreSharedKey          uint16 = 41 // https://tools.ietf.org/html/rfc8446#section-4.2.11
+	extensionSupportedVersions     uint16 = 43
+	extensionCookie                uint16 = 44
+	extensionPSKModes              uint16 = 45
+	extensionCertAuthorities       uint16 = 47
+	extensionKeyShare              uint16 = 51
+	extensionNextProtoNeg          uint16 = 13172 // not IANA assigned
+	extensionRenegotiationInfo     uint16 = 0xff01
 )
 
 // TLS signaling cipher suite values
@@ -88,6 +127,35 @@ const (
 	scsvRenegotiation uint16 = 0x00ff
 )
 
+// TLS 1.3 PSK key exchange modes, see RFC 8446, section 4.2.9.
+const (
+	pskModePlain uint8 = 0 // psk_ke
+	pskModeDHE   uint8 = 1 // psk_dhe_ke
+)
+
+// TLS 1.3 Key Update request values, see RFC 8446, section 4.6.3.
+const (
+	keyUpdateNotRequested uint8 = 0
+	keyUpdateRequested    uint8 = 1
+)
+
+var (
+	// helloRetryRequestRandom is the random value of a ServerHello that is
+	// really a HelloRetryRequest, see RFC 8446, section 4.1.3.
+	helloRetryRequestRandom = []byte{
+		0xCF, 0x21, 0xAD, 0x74, 0xE5, 0x9A, 0x61, 0x11,
+		0xBE, 0x1D, 0x8C, 0x02, 0x1E, 0x65, 0xB8, 0x91,
+		0xC2, 0xA2, 0x11, 0x16, 0x7A, 0xBB, 0x8C, 0x5E,
+		0x07, 0x9E, 0x09, 0xE2, 0xC8, 0xA8, 0x33, 0x9C,
+	}
+
+	// downgradeCanaryTLS12 and downgradeCanaryTLS11 end the random value of
+	// a ServerHello from a TLS 1.3 server that negotiated an older version,
+	// see RFC 8446, section 4.1.3.
+	downgradeCanaryTLS12 = []byte("DOWNGRD\x01")
+	downgradeCanaryTLS11 = []byte("DOWNGRD\x00")
+)
+
 // CurveID is the type of a TLS identifier for an elliptic curve. See
 // http://www.iana.org/assignments/tls-parameters/tls-parameters.xml#tls-parameters-8
 type CurveID uint16
@@ -97,6 +165,21 @@ const (
 	CurveP384 CurveID = 24
 	CurveP521 CurveID = 25
 	X25519    CurveID = 29
+
+	// Finite field Diffie-Hellman groups from RFC 7919. These share the
+	// supported groups extension with the elliptic curves above and are used
+	// by the DHE ciphersuites.
+	FFDHE2048 CurveID = 256
+	FFDHE3072 CurveID = 257
+	FFDHE4096 CurveID = 258
+	FFDHE6144 CurveID = 259
+	FFDHE8192 CurveID = 260
+
+	// X25519MLKEM768 is the hybrid of X25519 and the post-quantum ML-KEM-768
+	// of FIPS 203. This package only supports it for the ECDHE cipher suites
+	// of TLS 1.2, and only negotiates it when it's listed in
+	// CurvePreferences on both sides.
+	X25519MLKEM768 CurveID = 4588
 )
 
 // TLS Elliptic Curve Point Formats
@@ -134,15 +217,20 @@ const (
 
 // Hash functions for TLS 1.2 (See RFC 5246, section A.4.1)
 const (
-	hashSHA1   uint8 = 2
-	hashSHA256 uint8 = 4
-	hashSHA384 uint8 = 5
+	hashSHA1      uint8 = 2
+	hashSHA256    uint8 = 4
+	hashSHA384    uint8 = 5
+	hashSHA512    uint8 = 6
+	hashIntrinsic uint8 = 8 // RFC 8422, for algorithms that hash on their own
 )
 
 // Signature algorithms for TLS 1.2 (See RFC 5246, section A.4.1)
 const (
-	signatureRSA   uint8 = 1
-	signatureECDSA uint8 = 3
+	signatureRSA     uint8 = 1
+	signatureDSA     uint8 = 2
+	signatureECDSA   uint8 = 3
+	signatureEd25519 uint8 = 7 // RFC 8422, always with hashIntrinsic
+	signatureRSAPSS  uint8 = 9 // RFC 8446 RSASSA-PSS keys, a key type only
 )
 
 // signatureAndHash mirrors the TLS 1.2, SignatureAndHashAlgorithm struct. See
@@ -153,19 +241,52 @@ type signatureAndHash struct {
 
 // supportedSignatureAlgorithms contains the signature and hash algorithms that
 // the code advertises as supported in a TLS 1.2 ClientHello and in a TLS 1.2
-// CertificateRequest.
+// CertificateRequest, unless Config.SignatureSchemes replaces them. The RSA-PSS
+// values of RFC 8446 don't follow the TLS 1.2 hash and signature layout.
 var supportedSignatureAlgorithms = []signatureAndHash{
-	{hashSHA256, signatureRSA},
+	{uint8(PSSWithSHA256 >> 8), uint8(PSSWithSHA256 & 0xff)},
 	{hashSHA256, signatureECDSA},
+	{hashIntrinsic, signatureEd25519},
+	{uint8(PSSWithSHA384 >> 8), uint8(PSSWithSHA384 & 0xff)},
+	{uint8(PSSWithSHA512 >> 8), uint8(PSSWithSHA512 & 0xff)},
+	{uint8(PSSKeyWithSHA256 >> 8), uint8(PSSKeyWithSHA256 & 0xff)},
+	{uint8(PSSKeyWithSHA384 >> 8), uint8(PSSKeyWithSHA384 & 0xff)},
+	{uint8(PSSKeyWithSHA512 >> 8), uint8(PSSKeyWithSHA512 & 0xff)},
+	{hashSHA256, signatureRSA},
 	{hashSHA384, signatureRSA},
+	{hashSHA512, signatureRSA},
 	{hashSHA384, signatureECDSA},
+	{hashSHA512, signatureECDSA},
 	{hashSHA1, signatureRSA},
 	{hashSHA1, signatureECDSA},
 }
 
+// supportedDSASignatureAlgorithms contains the DSA signature and hash
+// algorithms that are advertised, in addition to the ones above, in a TLS 1.2
+// ClientHello that offers DHE_DSS cipher suites.
+var supportedDSASignatureAlgorithms = []signatureAndHash{
+	{hashSHA256, signatureDSA},
+	{hashSHA1, signatureDSA},
+}
+
+// supportedSignatureAlgorithmsTLS13 contains the signature algorithms that
+// are advertised, in addition to the ones above, in a ClientHello that offers
+// TLS 1.3, which signs with RSA-PSS rather than PKCS#1 v1.5. They're only
+// added if Config.SignatureSchemes left them out of the TLS 1.2 list.
+var supportedSignatureAlgorithmsTLS13 = []signatureAndHash{
+	{uint8(PSSWithSHA256 >> 8), uint8(PSSWithSHA256 & 0xff)},
+	{uint8(PSSWithSHA384 >> 8), uint8(PSSWithSHA384 & 0xff)},
+	{uint8(PSSWithSHA512 >> 8), uint8(PSSWithSHA512 & 0xff)},
+	{uint8(PSSKeyWithSHA256 >> 8), uint8(PSSKeyWithSHA256 & 0xff)},
+	{uint8(PSSKeyWithSHA384 >> 8), uint8(PSSKeyWithSHA384 & 0xff)},
+	{uint8(PSSKeyWithSHA512 >> 8), uint8(PSSKeyWithSHA512 & 0xff)},
+	{uint8(ECDSAWithP521AndSHA512 >> 8), uint8(ECDSAWithP521AndSHA512 & 0xff)},
+	{hashIntrinsic, signatureEd25519},
+}
+
 // ConnectionState records basic TLS details about the connection.
 type ConnectionState struct {
-	Version                     uint16                // TLS version used by the connection (e.g. VersionTLS12)
+	Version                     uint16                // TLS version used by the connection (e.g. VersionTLS12, VersionDTLS12 or VersionTLCP)
 	HandshakeComplete           bool                  // TLS handshake is complete
 	DidResume                   bool                  // connection resumes a previous TLS connection
 	CipherSuite                 uint16                // cipher suite in use (TLS_RSA_WITH_RC4_128_SHA, ...)
@@ -176,14 +297,40 @@ type ConnectionState struct {
 	VerifiedChains              [][]*x509.Certificate // verified chains built from PeerCertificates
 	SignedCertificateTimestamps [][]byte              // SCTs from the server, if any
 	OCSPResponse                []byte                // stapled OCSP response from server, if any
+	CurveID                     CurveID               // elliptic curve or RFC 7919 group used for key agreement, if any
 
 	// TLSUnique contains the "tls-unique" channel binding value (see RFC
 	// 5929, section 3). For resumed sessions this value will be nil
-	// because resumption does not include enough context (see
-	// https://secure-resumption.com/#channelbindings). This will change in
-	// future versions of Go once the TLS master-secret fix has been
-	// standardized and implemented.
+	// unless the session uses the extended master secret (RFC 7627),
+	// because resumption does not include enough context otherwise (see
+	// https://secure-resumption.com/#channelbindings).
 	TLSUnique []byte
+
+	// MaxFragmentLength is the limit on record plaintext agreed with the
+	// max_fragment_length extension (RFC 6066), if any. RecordSizeLimit is
+	// the limit on the plaintext of the records sent to the peer that it
+	// announced with the record_size_limit extension (RFC 8449), if both
+	// sides sent one.
+	MaxFragmentLength int
+	RecordSizeLimit   int
+
+	// PeerRawPublicKey is the DER-encoded SubjectPublicKeyInfo that the
+	// peer authenticated with instead of a certificate chain, if a raw
+	// public key was negotiated (RFC 7250), and PeerPublicKey is its
+	// parsed form. PeerCertificates is empty in that case.
+	PeerRawPublicKey []byte
+	PeerPublicKey    crypto.PublicKey
+
+	// PSKIdentity is the identity of the pre-shared key that authenticated
+	// the connection, if any. A resumed connection reports the identity of
+	// the session that it resumes and, for a TLS 1.2 PSK session, its
+	// CurveID too.
+	PSKIdentity string
+
+	// PSKKeyVersion is the Version of the PSKCandidate from
+	// GetPSKKeyCandidates that authenticated the connection, on a server.
+	// It is zero for resumed connections and without GetPSKKeyCandidates.
+	PSKKeyVersion int
 }
 
 // ClientAuthType declares the policy the server will follow for
@@ -198,15 +345,41 @@ const (
 	RequireAndVerifyClientCert
 )
 
+// CertificateType is the type of the credential that authenticates a peer,
+// negotiated with the client_certificate_type and server_certificate_type
+// extensions, see RFC 7250.
+type CertificateType uint8
+
+const (
+	// CertificateTypeX509 is an X.509 certificate chain.
+	CertificateTypeX509 CertificateType = 0
+	// CertificateTypeRawPublicKey is a bare SubjectPublicKeyInfo, which
+	// the peer has to know beforehand to trust.
+	CertificateTypeRawPublicKey CertificateType = 2
+)
+
 // ClientSessionState contains the state needed by clients to resume TLS
 // sessions.
 type ClientSessionState struct {
-	sessionTicket      []uint8               // Encrypted ticket used for session resumption with server
-	vers               uint16                // SSL/TLS version negotiated for the session
-	cipherSuite        uint16                // Ciphersuite negotiated for the session
-	masterSecret       []byte                // MasterSecret generated by client on a full handshake
-	serverCertificates []*x509.Certificate   // Certificate chain presented by the server
-	verifiedChains     [][]*x509.Certificate // Certificate chains we built for verification
+	sessionTicket        []uint8               // Encrypted ticket used for session resumption with server
+	vers                 uint16                // SSL/TLS version negotiated for the session
+	cipherSuite          uint16                // Ciphersuite negotiated for the session
+	masterSecret         []byte                // MasterSecret generated by client on a full handshake
+	serverCertificates   []*x509.Certificate   // Certificate chain presented by the server
+	verifiedChains       [][]*x509.Certificate // Certificate chains we built for verification
+	sessionId            []uint8               // Session ID issued by a server that didn't send a ticket
+	serverRawPublicKey   []byte                // Raw public key presented by the server instead of a chain
+	serverPublicKey      crypto.PublicKey      // Parsed form of serverRawPublicKey
+	encryptThenMAC       bool                  // Whether the session uses encrypt-then-MAC
+	extendedMasterSecret bool                  // Whether the session uses the extended master secret
+	pskIdentity          []byte                // Identity of the PSK that authenticated the session, if any
+	curveID              CurveID               // Group used for key agreement in a PSK session, if any
+
+	// In TLS 1.3 masterSecret holds the PSK derived from the resumption
+	// master secret and the ticket nonce, and these track the ticket age.
+	receivedAt time.Time // When the ticket was received from the server
+	useBy      time.Time // When the ticket lifetime runs out
+	ageAdd     uint32    // Obfuscates the ticket age, see RFC 8446, section 4.6.1
 }
 
 // ClientSessionCache is a cache of ClientSessionState objects that can be used
@@ -222,6 +395,56 @@ type ClientSessionCache interface {
 	Put(sessionKey string, cs *ClientSessionState)
 }
 
+// ServerSessionState contains the state needed by servers to resume TLS
+// sessions by session ID. A session can be resumed until the time returned by
+// Expires.
+type ServerSessionState struct {
+	session sessionState
+}
+
+// maxServerSessionLifetime is how long a session is resumed by its session
+// ID, the upper limit that RFC 5246, appendix F.1.4 suggests.
+const maxServerSessionLifetime = 24 * time.Hour
+
+// Expires returns when the session stops being resumed, after which it can
+// be dropped from the cache.
+func (ss *ServerSessionState) Expires() time.Time {
+	return time.Unix(int64(ss.session.createdAt), 0).Add(maxServerSessionLifetime)
+}
+
+// Marshal returns an opaque encoding of the session, for a ServerSessionCache
+// that keeps sessions outside of the process, such as one shared by several
+// servers. The encoding holds the master secret of the session, so it must be
+// kept confidential.
+func (ss *ServerSessionState) Marshal() []byte {
+	return ss.session.marshal()
+}
+
+// Unmarshal sets the session to one encoded by Marshal.
+func (ss *ServerSessionState) Unmarshal(data []byte) error {
+	var session sessionState
+	if !session.unmarshal(data) {
+		return errors.New("tls: invalid ServerSessionState encoding")
+	}
+	ss.session = session
+	return nil
+}
+
+// ServerSessionCache is a cache of ServerSessionState objects that can be
+// used by a server to resume a TLS session by the session ID that it issued.
+// Implementations that keep sessions outside of the process store them with
+// ServerSessionState.Marshal. ServerSessionCache implementations should
+// expect to be called concurrently from different goroutines.
+type ServerSessionCache interface {
+	// Get searches for a ServerSessionState associated with the given
+	// session ID. On return, ok is true if one was found.
+	Get(sessionId string) (session *ServerSessionState, ok bool)
+
+	// Put adds the ServerSessionState to the cache with the given session
+	// ID.
+	Put(sessionId string, ss *ServerSessionState)
+}
+
 // SignatureScheme identifies a signature algorithm supported by TLS. See
 // https://tools.ietf.org/html/draft-ietf-tls-tls13-18#section-4.2.3.
 type SignatureScheme uint16
@@ -232,13 +455,25 @@ const (
 	PKCS1WithSHA384 SignatureScheme = 0x0501
 	PKCS1WithSHA512 SignatureScheme = 0x0601
 
+	// RSASSA-PSS algorithms with public key OID rsaEncryption.
 	PSSWithSHA256 SignatureScheme = 0x0804
 	PSSWithSHA384 SignatureScheme = 0x0805
 	PSSWithSHA512 SignatureScheme = 0x0806
 
+	// RSASSA-PSS algorithms with public key OID RSASSA-PSS.
+	PSSKeyWithSHA256 SignatureScheme = 0x0809
+	PSSKeyWithSHA384 SignatureScheme = 0x080a
+	PSSKeyWithSHA512 SignatureScheme = 0x080b
+
 	ECDSAWithP256AndSHA256 SignatureScheme = 0x0403
 	ECDSAWithP384AndSHA384 SignatureScheme = 0x0503
 	ECDSAWithP521AndSHA512 SignatureScheme = 0x0603
+
+	// EdDSA algorithms.
+	Ed25519 SignatureScheme = 0x0807
+
+	// Legacy signature and hash algorithms for TLS 1.2.
+	ECDSAWithSHA1 SignatureScheme = 0x0203
 )
 
 // ClientHelloInfo contains information from a ClientHello message in order to
@@ -335,6 +570,26 @@ const (
 	RenegotiateFreelyAsClient
 )
 
+// ExtendedMasterSecretSupport enumerates the policies for the extended master
+// secret of RFC 7627, which binds the master secret of TLS 1.2 and earlier to
+// the handshake that established it. Without it, an attacker can make the
+// master secrets of two connections the same, which breaks channel bindings
+// such as tls-unique, see https://mitls.org/pages/attacks/3SHAKE.
+type ExtendedMasterSecretSupport int
+
+const (
+	// ExtendedMasterSecretOffered uses the extended master secret with
+	// peers that support it.
+	ExtendedMasterSecretOffered ExtendedMasterSecretSupport = iota
+
+	// ExtendedMasterSecretRequired fails handshakes with peers that
+	// don't support the extended master secret.
+	ExtendedMasterSecretRequired
+
+	// ExtendedMasterSecretNever disables the extended master secret.
+	ExtendedMasterSecretNever
+)
+
 // A Config structure is used to configure a TLS client or server.
 // After one has been passed to a TLS function it must not be
 // modified. A Config may be reused; the tls package will also not
@@ -374,6 +629,16 @@ type Config struct {
 	// first element of Certificates will be used.
 	GetCertificate func(*ClientHelloInfo) (*Certificate, error)
 
+	// TLCPSigningCertificate and TLCPEncryptionCertificate are the SM2
+	// certificate chains of a server that accepts TLCP connections. TLCP
+	// keeps the two uses of a key apart: the server signs the handshake
+	// with the first and the client encrypts the pre-master secret to the
+	// second. Both are sent to the client, and Certificates and
+	// GetCertificate aren't used. Unless both are set, clients asking for
+	// TLCP are refused.
+	TLCPSigningCertificate    *Certificate
+	TLCPEncryptionCertificate *Certificate
+
 	// GetClientCertificate, if not nil, is called when a server requests a
 	// certificate from a client. If set, the contents of Certificates will
 	// be ignored.
@@ -421,16 +686,98 @@ type Config struct {
 	// the verifiedChains argument will always be nil.
 	VerifyPeerCertificate func(rawCerts [][]byte, verifiedChains [][]*x509.Certificate) error
 
+	// ServerCertificateTypes and ClientCertificateTypes list, in order of
+	// preference, the types of credential that may authenticate the
+	// server and the client, negotiated with the extensions of RFC 7250.
+	// A client offers the types in ServerCertificateTypes and presents
+	// one of ClientCertificateTypes; a server presents one of
+	// ServerCertificateTypes and accepts ClientCertificateTypes. If nil,
+	// only X.509 certificates are used and the extensions are not sent.
+	ServerCertificateTypes []CertificateType
+	ClientCertificateTypes []CertificateType
+
+	// VerifyPeerRawPublicKey is called when the peer authenticates with a
+	// raw public key (RFC 7250) instead of a certificate chain. It
+	// receives the DER-encoded SubjectPublicKeyInfo and the parsed public
+	// key. If it returns a non-nil error, the handshake is aborted and
+	// that error results.
+	//
+	// A raw public key names no one, so it is only trusted through this
+	// callback: a client needs it unless InsecureSkipVerify is set, and a
+	// server needs it if ClientAuth is VerifyClientCertIfGiven or
+	// RequireAndVerifyClientCert.
+	VerifyPeerRawPublicKey func(rawPublicKey []byte, publicKey crypto.PublicKey) error
+
 	// PSK Server Function to provide a hint to the client about which identity
 	// the client should send. If the hint returned is nil, the ServerKeyExchange
 	// is skipped, as per RFC 4279 Section 2.
 	GetPSKIdentityHint func() ([]byte, error)
 	// PSK Client Function to choose the identity to send to the server
 	// RFC 4279 5.1 insists the identity is utf8
+	// In TLS 1.3 there is no hint and identityHint is always nil.
 	GetPSKIdentity     func(identityHint []byte) (string, error)
 	// PSK function used by the client and the server to get the PSK
+	// In TLS 1.3 a client with both GetPSKIdentity and GetPSKKey offers the
+	// PSK, with SHA-256 as its hash, and a server with GetPSKKey accepts it.
 	GetPSKKey          func(identity string) ([]byte, error)
 
+	// GetPSKIdentityHintForClient and GetPSKKeyForClient are server
+	// variants of GetPSKIdentityHint and GetPSKKey that also receive the
+	// ClientHelloInfo of the connection, so that the hint and the key can
+	// depend on the server name, the listener or the remote address. If
+	// set, they are used instead of GetPSKIdentityHint and GetPSKKey.
+	//
+	// If GetPSKKey or GetPSKKeyForClient returns ErrUnknownPSKIdentity, a
+	// TLS 1.2 server aborts the handshake with an unknown_psk_identity
+	// alert rather than a handshake_failure one. A TLS 1.3 server ignores
+	// the identity and carries on without it.
+	GetPSKIdentityHintForClient func(*ClientHelloInfo) ([]byte, error)
+	GetPSKKeyForClient          func(info *ClientHelloInfo, identity string) ([]byte, error)
+
+	// GetPSKKeyCandidates lets a server rotate the key of a PSK identity.
+	// It returns every key that the server accepts for identity, and the
+	// server works out which of them the client holds: in TLS 1.2 by
+	// trying the keys that each candidate yields on the client's Finished
+	// message, and in TLS 1.3 by checking the PSK binder against each
+	// candidate. If set, it is used instead of GetPSKKeyForClient and
+	// GetPSKKey, and may return ErrUnknownPSKIdentity as they do.
+	GetPSKKeyCandidates func(info *ClientHelloInfo, identity string) ([]PSKCandidate, error)
+
+	// PSKDummyKeySecret, if not nil, keeps a server from telling clients
+	// which PSK identities it knows. When the key callback fails for an
+	// identity, the handshake goes on with HMAC-SHA256(PSKDummyKeySecret,
+	// identity) as the key, and fails as it does for a known identity
+	// with the wrong key: in TLS 1.2 at the client's Finished message, and
+	// in TLS 1.3 at the PSK binder. A TLS 1.3 server still prefers any
+	// session ticket or known identity that the client offers along with
+	// an unknown one, and never conceals labels that start with the name
+	// of a current or recently replaced session ticket key, so that stale
+	// tickets fall back to a full handshake.
+	//
+	// ConcealedPSKError, if not nil, is called with the error of the key
+	// callback when such a handshake fails.
+	PSKDummyKeySecret []byte
+	ConcealedPSKError func(info *ClientHelloInfo, identity string, err error)
+
+	// SRP Server Function to look up the group, salt and password verifier
+	// for the username sent by the client, as per RFC 5054. See
+	// NewSRPVerifier. It returns a nil verifier for an unknown username,
+	// which makes the server send an unknown_psk_identity alert.
+	GetSRPVerifier func(username string) (*SRPVerifier, error)
+	// SRPDummySecret, if not nil, keeps a server from telling clients
+	// which SRP usernames it knows, as RFC 5054, section 2.5.1.3 suggests.
+	// For an unknown username, the handshake goes on with the 2048-bit
+	// group, a 16-byte salt derived from SRPDummySecret and the username,
+	// and a random verifier, and fails at the client's Finished message as
+	// it does for a wrong password. Verifiers of known usernames should
+	// use SRPGroup2048 and 16-byte salts too, or they stand out.
+	SRPDummySecret []byte
+	// SRP Client Function to supply the password for SRPUsername
+	GetSRPPassword func(username string) (string, error)
+	// SRPUsername is the username sent by a client in the SRP ClientHello
+	// extension when SRP ciphersuites are enabled.
+	SRPUsername string
+
 	// RootCAs defines the set of root certificate authorities
 	// that clients use when verifying server certificates.
 	// If RootCAs is nil, TLS uses the host's root CA set.
@@ -464,8 +811,16 @@ type Config struct {
 
 	// CipherSuites is a list of supported cipher suites. If CipherSuites
 	// is nil, TLS uses a list of suites supported by the implementation.
+	// TLS 1.3 cipher suites (TLS_AES_128_GCM_SHA256, ...) may be listed
+	// too; if none are, the default TLS 1.3 cipher suites are used.
 	CipherSuites []uint16
 
+	// AllowNullCipherSuites enables the PSK cipher suites with NULL
+	// encryption, which authenticate records but send them in the clear.
+	// Even when listed in CipherSuites, these suites are neither offered
+	// nor accepted unless AllowNullCipherSuites is true.
+	AllowNullCipherSuites bool
+
 	// PreferServerCipherSuites controls whether the server selects the
 	// client's most preferred ciphersuite, or the server's most preferred
 	// ciphersuite. If true then the server's preference, as expressed in
@@ -490,22 +845,42 @@ type Config struct {
 	// resumption.
 	ClientSessionCache ClientSessionCache
 
+	// ServerSessionCache is a cache of ServerSessionState entries for TLS
+	// session resumption by session ID. If set, a server that doesn't send
+	// a session ticket issues a session ID instead, so that clients without
+	// session ticket support can resume too. Sessions are looked up in it
+	// even if SessionTicketsDisabled is true.
+	ServerSessionCache ServerSessionCache
+
 	// MinVersion contains the minimum SSL/TLS version that is acceptable.
 	// If zero, then TLS 1.0 is taken as the minimum.
 	MinVersion uint16
 
 	// MaxVersion contains the maximum SSL/TLS version that is acceptable.
-	// If zero, then the maximum version supported by this package is used,
-	// which is currently TLS 1.2.
+	// If zero, then TLS 1.2 is used as the maximum. TLS 1.3 is supported
+	// but must be enabled by setting MaxVersion to VersionTLS13. A client
+	// with a MaxVersion of VersionTLCP speaks TLCP instead of TLS.
 	MaxVersion uint16
 
 	// CurvePreferences contains the elliptic curves that will be used in
 	// an ECDHE handshake, in preference order. If empty, the default will
-	// be used.
+	// be used. It may also contain RFC 7919 finite field groups
+	// (FFDHE2048, ...), which are used in the same way for DHE handshakes;
+	// if it contains none, all of them are supported.
 	CurvePreferences []CurveID
 
+	// SignatureSchemes contains the signature algorithms that may be used
+	// in TLS 1.2, in preference order. They're advertised in ClientHello
+	// and CertificateRequest messages, and signatures with other
+	// algorithms are refused. If empty, the default will be used, which
+	// includes RSA-PSS, Ed25519 and SHA-512. It doesn't apply to TLS 1.3
+	// or to the DSA signatures of their own cipher suites.
+	SignatureSchemes []SignatureScheme
+
 	// Diffie-Hellman parameters P and G
 	// Typically loaded from a dhparam.pem file with LoadDhParams()
+	// A server only uses them with clients that don't support RFC 7919
+	// groups; otherwise a mutually supported group is used.
 	DhParameters *DhParams
 
 	// DynamicRecordSizingDisabled disables adaptive sizing of TLS records.
@@ -514,10 +889,34 @@ type Config struct {
 	// improve latency.
 	DynamicRecordSizingDisabled bool
 
+	// MaxFragmentLength, if not zero, makes a client ask the server, with
+	// the max_fragment_length extension (RFC 6066), to limit the plaintext
+	// of the records of both sides to this many bytes. It must be 512,
+	// 1024, 2048 or 4096. Servers always grant the request.
+	MaxFragmentLength int
+
+	// RecordSizeLimit, if not zero, is the largest record plaintext, in
+	// bytes, that the peer may send, announced in the record_size_limit
+	// extension (RFC 8449). It must be between 64 and 16384. Clients only
+	// send the extension if it is set. Servers answer clients that send it
+	// in any case, with 16384 if it is zero, and then ignore their
+	// max_fragment_length extension.
+	RecordSizeLimit int
+
+	// EncryptThenMACDisabled may be set to true to stop negotiating
+	// encrypt-then-MAC (RFC 7366), which otherwise replaces the
+	// MAC-then-encrypt construction of TLS for CBC cipher suites.
+	EncryptThenMACDisabled bool
+
 	// Renegotiation controls what types of renegotiation are supported.
 	// The default, none, is correct for the vast majority of applications.
 	Renegotiation RenegotiationSupport
 
+	// ExtendedMasterSecret controls the use of the extended master secret
+	// in TLS 1.2 and earlier. The default offers it. TLS 1.3 always binds
+	// its secrets to the handshake.
+	ExtendedMasterSecret ExtendedMasterSecretSupport
+
 	// KeyLogWriter optionally specifies a destination for TLS master secrets
 	// in NSS key log format that can be used to allow external programs
 	// such as Wireshark to decrypt TLS connections.
@@ -528,13 +927,19 @@ type Config struct {
 
 	serverInitOnce sync.Once // guards calling (*Config).serverInit
 
-	// mutex protects sessionTicketKeys and originalConfig.
+	// mutex protects sessionTicketKeys, retiredTicketKeyNames and
+	// originalConfig.
 	mutex sync.RWMutex
 	// sessionTicketKeys contains zero or more ticket keys. If the length
 	// is zero, SessionTicketsDisabled must be true. The first key is used
 	// for new tickets and any subsequent keys can be used to decrypt old
 	// tickets.
 	sessionTicketKeys []ticketKey
+	// retiredTicketKeyNames holds the names of up to
+	// maxRetiredTicketKeyNames keys that SetSessionTicketKeys replaced,
+	// newest last, so that their tickets can still be told apart from
+	// PSK identities.
+	retiredTicketKeyNames [][ticketKeyNameLen]byte
 	// originalConfig is set to the Config that was passed to Server if
 	// this Config is returned by a GetConfigForClient callback. It's used
 	// by serverInit in order to copy session ticket keys if needed.
@@ -545,6 +950,10 @@ type Config struct {
 // an encrypted session ticket in order to identify the key used to encrypt it.
 const ticketKeyNameLen = 16
 
+// maxRetiredTicketKeyNames is the number of replaced session ticket key
+// names that a Config remembers.
+const maxRetiredTicketKeyNames = 16
+
 // ticketKey is the internal representation of a session ticket key.
 type ticketKey struct {
 	// keyName is an opaque byte string that serves to identify the session
@@ -573,8 +982,10 @@ func (c *Config) Clone() *Config {
 	c.serverInitOnce.Do(c.serverInit)
 
 	var sessionTicketKeys []ticketKey
+	var retiredTicketKeyNames [][ticketKeyNameLen]byte
 	c.mutex.RLock()
 	sessionTicketKeys = c.sessionTicketKeys
+	retiredTicketKeyNames = c.retiredTicketKeyNames
 	c.mutex.RUnlock()
 
 	return &Config{
@@ -583,12 +994,26 @@ func (c *Config) Clone() *Config {
 		Certificates:                c.Certificates,
 		NameToCertificate:           c.NameToCertificate,
 		GetCertificate:              c.GetCertificate,
+		TLCPSigningCertificate:      c.TLCPSigningCertificate,
+		TLCPEncryptionCertificate:   c.TLCPEncryptionCertificate,
 		GetClientCertificate:        c.GetClientCertificate,
 		GetConfigForClient:          c.GetConfigForClient,
 		VerifyPeerCertificate:       c.VerifyPeerCertificate,
+		ServerCertificateTypes:      c.ServerCertificateTypes,
+		ClientCertificateTypes:      c.ClientCertificateTypes,
+		VerifyPeerRawPublicKey:      c.VerifyPeerRawPublicKey,
 		GetPSKIdentityHint:          c.GetPSKIdentityHint,
 		GetPSKIdentity:              c.GetPSKIdentity,
 		GetPSKKey:                   c.GetPSKKey,
+		GetPSKIdentityHintForClient: c.GetPSKIdentityHintForClient,
+		GetPSKKeyForClient:          c.GetPSKKeyForClient,
+		GetPSKKeyCandidates:         c.GetPSKKeyCandidates,
+		PSKDummyKeySecret:           c.PSKDummyKeySecret,
+		ConcealedPSKError:           c.ConcealedPSKError,
+		GetSRPVerifier:              c.GetSRPVerifier,
+		SRPDummySecret:              c.SRPDummySecret,
+		GetSRPPassword:              c.GetSRPPassword,
+		SRPUsername:                 c.SRPUsername,
 		RootCAs:                     c.RootCAs,
 		NextProtos:                  c.NextProtos,
 		ServerName:                  c.ServerName,
@@ -596,18 +1021,26 @@ func (c *Config) Clone() *Config {
 		ClientCAs:                   c.ClientCAs,
 		InsecureSkipVerify:          c.InsecureSkipVerify,
 		CipherSuites:                c.CipherSuites,
+		AllowNullCipherSuites:       c.AllowNullCipherSuites,
 		PreferServerCipherSuites:    c.PreferServerCipherSuites,
 		SessionTicketsDisabled:      c.SessionTicketsDisabled,
 		SessionTicketKey:            c.SessionTicketKey,
 		ClientSessionCache:          c.ClientSessionCache,
+		ServerSessionCache:          c.ServerSessionCache,
 		MinVersion:                  c.MinVersion,
 		MaxVersion:                  c.MaxVersion,
 		CurvePreferences:            c.CurvePreferences,
+		SignatureSchemes:            c.SignatureSchemes,
 		DhParameters:                c.DhParameters,
 		DynamicRecordSizingDisabled: c.DynamicRecordSizingDisabled,
+		MaxFragmentLength:           c.MaxFragmentLength,
+		RecordSizeLimit:             c.RecordSizeLimit,
+		EncryptThenMACDisabled:      c.EncryptThenMACDisabled,
 		Renegotiation:               c.Renegotiation,
+		ExtendedMasterSecret:        c.ExtendedMasterSecret,
 		KeyLogWriter:                c.KeyLogWriter,
 		sessionTicketKeys:           sessionTicketKeys,
+		retiredTicketKeyNames:       retiredTicketKeyNames,
 		// originalConfig is deliberately not duplicated.
 	}
 }
@@ -642,6 +1075,7 @@ func (c *Config) serverInit() {
 	if originalConfig != nil {
 		originalConfig.mutex.RLock()
 		c.sessionTicketKeys = originalConfig.sessionTicketKeys
+		c.retiredTicketKeyNames = originalConfig.retiredTicketKeyNames
 		originalConfig.mutex.RUnlock()
 	} else {
 		c.sessionTicketKeys = []ticketKey{ticketKeyFromBytes(c.SessionTicketKey)}
@@ -657,6 +1091,24 @@ func (c *Config) ticketKeys() []ticketKey {
 	return ret
 }
 
+// isTicketKeyName reports whether name is the name of one of the session
+// ticket keys of c, or of a key that SetSessionTicketKeys replaced lately.
+func (c *Config) isTicketKeyName(name []byte) bool {
+	c.mutex.RLock()
+	defer c.mutex.RUnlock()
+	for _, key := range c.sessionTicketKeys {
+		if bytes.Equal(name, key.keyName[:]) {
+			return true
+		}
+	}
+	for _, retired := range c.retiredTicketKeyNames {
+		if bytes.Equal(name, retired[:]) {
+			return true
+		}
+	}
+	return false
+}
+
 // SetSessionTicketKeys updates the session ticket keys for a server. The first
 // key will be used when creating new tickets, while all keys can be used for
 // decrypting tickets. It is safe to call this function while the server is
@@ -673,6 +1125,26 @@ func (c *Config) SetSessionTicketKeys(keys [][32]byte) {
 	}
 
 	c.mutex.Lock()
+	// c.retiredTicketKeyNames is shared with clones, so it is replaced
+	// rather than appended to in place.
+	var retired [][ticketKeyNameLen]byte
+	retired = append(retired, c.retiredTicketKeyNames...)
+	for _, old := range c.sessionTicketKeys {
+		kept := false
+		for _, key := range newKeys {
+			if key.keyName == old.keyName {
+				kept = true
+				break
+			}
+		}
+		if !kept {
+			retired = append(retired, old.keyName)
+		}
+	}
+	if len(retired) > maxRetiredTicketKeyNames {
+		retired = retired[len(retired)-maxRetiredTicketKeyNames:]
+	}
+	c.retiredTicketKeyNames = retired
 	c.sessionTicketKeys = newKeys
 	c.mutex.Unlock()
 }
@@ -701,6 +1173,51 @@ func (c *Config) cipherSuites() []uint16 {
 	return s
 }
 
+// cipherSuitesTLS13 returns the TLS 1.3 cipher suites listed in CipherSuites,
+// or the default ones if there are none.
+func (c *Config) cipherSuitesTLS13() []uint16 {
+	var s []uint16
+	if c != nil {
+		for _, id := range c.CipherSuites {
+			if cipherSuiteTLS13ByID(id) != nil {
+				s = append(s, id)
+			}
+		}
+	}
+	if len(s) == 0 {
+		s = defaultCipherSuitesTLS13()
+	}
+	return s
+}
+
+// cipherSuitesTLCP returns the TLCP cipher suites listed in CipherSuites, or
+// all of them if there are none.
+func (c *Config) cipherSuitesTLCP() []uint16 {
+	var s []uint16
+	if c != nil {
+		for _, id := range c.CipherSuites {
+			for _, suite := range cipherSuites {
+				if suite.id == id && suite.flags&suiteTLCP != 0 {
+					s = append(s, id)
+				}
+			}
+		}
+	}
+	if len(s) == 0 {
+		for _, suite := range cipherSuites {
+			if suite.flags&suiteTLCP != 0 {
+				s = append(s, suite.id)
+			}
+		}
+	}
+	return s
+}
+
+// acceptsTLCP reports whether a server has the certificates that TLCP needs.
+func (c *Config) acceptsTLCP() bool {
+	return c.TLCPSigningCertificate != nil && c.TLCPEncryptionCertificate != nil
+}
+
 func (c *Config) minVersion() uint16 {
 	if c == nil || c.MinVersion == 0 {
 		return minVersion
@@ -717,18 +1234,222 @@ func (c *Config) maxVersion() uint16 {
 
 var defaultCurvePreferences = []CurveID{X25519, CurveP256, CurveP384, CurveP521}
 
+var defaultFFDHEPreferences = []CurveID{FFDHE2048, FFDHE3072, FFDHE4096, FFDHE6144, FFDHE8192}
+
+// A PSKCandidate is one of the keys that a server accepts for a PSK identity,
+// see Config.GetPSKKeyCandidates.
+type PSKCandidate struct {
+	Key []byte
+	// Version tells the candidates of an identity apart. It is reported
+	// in ConnectionState.PSKKeyVersion if the client used Key.
+	Version int
+}
+
+// ErrUnknownPSKIdentity can be returned by GetPSKKey, GetPSKKeyForClient and
+// GetPSKKeyCandidates for an identity they have no key for. It makes a TLS 1.2
+// server send an unknown_psk_identity alert, see RFC 4279, section 2.
+var ErrUnknownPSKIdentity = errors.New("tls: unknown PSK identity")
+
+// hasServerPSKKey reports whether c can look up PSKs for a server.
+func (c *Config) hasServerPSKKey() bool {
+	return c.GetPSKKeyCandidates != nil || c.GetPSKKeyForClient != nil || c.GetPSKKey != nil
+}
+
+// serverPSKIdentityHint returns the PSK identity hint of a server from
+// GetPSKIdentityHintForClient or GetPSKIdentityHint, or nil if neither is
+// set.
+func (c *Config) serverPSKIdentityHint(info *ClientHelloInfo) ([]byte, error) {
+	if c.GetPSKIdentityHintForClient != nil {
+		return c.GetPSKIdentityHintForClient(info)
+	}
+	if c.GetPSKIdentityHint != nil {
+		return c.GetPSKIdentityHint()
+	}
+	return nil, nil
+}
+
+// serverPSKCandidates returns the PSKs of a server for identity from
+// GetPSKKeyCandidates, or the single one from GetPSKKeyForClient or
+// GetPSKKey. It never returns an empty slice without an error.
+func (c *Config) serverPSKCandidates(info *ClientHelloInfo, identity string) ([]PSKCandidate, error) {
+	if c.GetPSKKeyCandidates != nil {
+		candidates, err := c.GetPSKKeyCandidates(info, identity)
+		if err != nil {
+			return nil, err
+		}
+		if len(candidates) == 0 {
+			return nil, ErrUnknownPSKIdentity
+		}
+		return candidates, nil
+	}
+
+	var key []byte
+	var err error
+	switch {
+	case c.GetPSKKeyForClient != nil:
+		key, err = c.GetPSKKeyForClient(info, identity)
+	case c.GetPSKKey != nil:
+		key, err = c.GetPSKKey(identity)
+	default:
+		return nil, errors.New("tls: missing PSK key function")
+	}
+	if err != nil {
+		return nil, err
+	}
+	return []PSKCandidate{{Key: key}}, nil
+}
+
+// concealedPSKCandidates is serverPSKCandidates for a server that may
+// conceal which identities it knows. If PSKDummyKeySecret is set, a failed
+// lookup yields a dummy candidate instead, and its error as lookupErr.
+func (c *Config) concealedPSKCandidates(info *ClientHelloInfo, identity string) (candidates []PSKCandidate, lookupErr, err error) {
+	candidates, err = c.serverPSKCandidates(info, identity)
+	if err == nil || c.PSKDummyKeySecret == nil {
+		return candidates, nil, err
+	}
+
+	h := hmac.New(sha256.New, c.PSKDummyKeySecret)
+	h.Write([]byte(identity))
+	return []PSKCandidate{{Key: h.Sum(nil)}}, err, nil
+}
+
+// reportConcealedPSKError passes lookupErr, the error that a dummy PSK stood
+// in for, to ConcealedPSKError.
+func (c *Config) reportConcealedPSKError(info *ClientHelloInfo, identity []byte, lookupErr error) {
+	if c.ConcealedPSKError != nil {
+		c.ConcealedPSKError(info, string(identity), lookupErr)
+	}
+}
+
+// knowsPSKIdentity reports whether the server still has a key for identity.
+func (c *Config) knowsPSKIdentity(info *ClientHelloInfo, identity []byte) bool {
+	_, err := c.serverPSKCandidates(info, string(identity))
+	return err == nil
+}
+
+// curvePreferences returns the elliptic curves from CurvePreferences.
 func (c *Config) curvePreferences() []CurveID {
-	if c == nil || len(c.CurvePreferences) == 0 {
+	if c == nil {
 		return defaultCurvePreferences
 	}
-	return c.CurvePreferences
+	var curves []CurveID
+	for _, id := range c.CurvePreferences {
+		if !isFFDHE(id) {
+			curves = append(curves, id)
+		}
+	}
+	if len(curves) == 0 {
+		return defaultCurvePreferences
+	}
+	return curves
+}
+
+// signatureAlgorithms returns the TLS 1.2 signature algorithms from
+// SignatureSchemes.
+func (c *Config) signatureAlgorithms() []signatureAndHash {
+	if c == nil || len(c.SignatureSchemes) == 0 {
+		return supportedSignatureAlgorithms
+	}
+	sigAndHashes := make([]signatureAndHash, 0, len(c.SignatureSchemes))
+	for _, scheme := range c.SignatureSchemes {
+		sigAndHashes = append(sigAndHashes, signatureAndHash{uint8(scheme >> 8), uint8(scheme)})
+	}
+	return sigAndHashes
+}
+
+// maxFragmentLengthCode returns the max_fragment_length code for
+// MaxFragmentLength, or zero if it isn't set, see RFC 6066, section 4.
+func (c *Config) maxFragmentLengthCode() (uint8, error) {
+	switch c.MaxFragmentLength {
+	case 0:
+		return 0, nil
+	case 512:
+		return 1, nil
+	case 1024:
+		return 2, nil
+	case 2048:
+		return 3, nil
+	case 4096:
+		return 4, nil
+	}
+	return 0, errors.New("tls: invalid MaxFragmentLength")
+}
+
+// recordSizeLimit returns the largest record plaintext that the peer may
+// send once both sides have sent the record_size_limit extension.
+func (c *Config) recordSizeLimit() (int, error) {
+	switch {
+	case c.RecordSizeLimit == 0:
+		return maxPlaintext, nil
+	case c.RecordSizeLimit < minRecordSizeLimit || c.RecordSizeLimit > maxPlaintext:
+		return 0, errors.New("tls: invalid RecordSizeLimit")
+	}
+	return c.RecordSizeLimit, nil
+}
+
+// ffdhePreferences returns the RFC 7919 groups from CurvePreferences.
+func (c *Config) ffdhePreferences() []CurveID {
+	if c == nil {
+		return defaultFFDHEPreferences
+	}
+	var groups []CurveID
+	for _, id := range c.CurvePreferences {
+		if isFFDHE(id) {
+			groups = append(groups, id)
+		}
+	}
+	if len(groups) == 0 {
+		return defaultFFDHEPreferences
+	}
+	return groups
+}
+
+// serverDhParams returns the Diffie-Hellman group that a server should use
+// with the client that sent clientHello, or nil if DHE isn't possible. A
+// client that offers RFC 7919 groups gets the most preferred mutual one, or
+// none at all if there isn't one. Other clients get DhParameters.
+func (c *Config) serverDhParams(clientHello *clientHelloMsg) (CurveID, *DhParams) {
+	offered := false
+	for _, id := range clientHello.supportedCurves {
+		if isFFDHE(id) {
+			offered = true
+			break
+		}
+	}
+	if !offered {
+		return 0, c.DhParameters
+	}
+
+	for _, candidate := range c.ffdhePreferences() {
+		for _, id := range clientHello.supportedCurves {
+			if candidate == id {
+				return id, ffdheGroups[id]
+			}
+		}
+	}
+	return 0, nil
+}
+
+// supportedVersions returns the enabled protocol versions, highest first.
+func (c *Config) supportedVersions() []uint16 {
+	var versions []uint16
+	for vers := c.maxVersion(); vers >= c.minVersion() && vers >= VersionSSL30; vers-- {
+		if vers <= maxSupportedVersion {
+			versions = append(versions, vers)
+		}
+	}
+	return versions
 }
 
 // mutualVersion returns the protocol version to use given the advertised
-// version of the peer.
+// version of the peer. This is the legacy version negotiation, which never
+// selects TLS 1.3.
 func (c *Config) mutualVersion(vers uint16) (uint16, bool) {
 	minVersion := c.minVersion()
 	maxVersion := c.maxVersion()
+	if maxVersion > VersionTLS12 {
+		maxVersion = VersionTLS12
+	}
 
 	if vers < minVersion {
 		return 0, false
@@ -739,6 +1460,19 @@ func (c *Config) mutualVersion(vers uint16) (uint16, bool) {
 	return vers, true
 }
 
+// mutualVersionTLS13 returns the highest protocol version that is both enabled
+// and in the list sent in the peer's supported_versions extension.
+func (c *Config) mutualVersionTLS13(peerVersions []uint16) (uint16, bool) {
+	for _, vers := range c.supportedVersions() {
+		for _, peerVers := range peerVersions {
+			if vers == peerVers {
+				return vers, true
+			}
+		}
+	}
+	return 0, false
+}
+
 // getCertificate returns the best certificate for the given ClientHelloInfo,
 // defaulting to the first element of c.Certificates.
 func (c *Config) getCertificate(clientHello *ClientHelloInfo) (*Certificate, error) {
@@ -803,14 +1537,24 @@ func (c *Config) BuildNameToCertificate() {
 	}
 }
 
-// writeKeyLog logs client random and master secret if logging was enabled by
-// setting c.KeyLogWriter.
-func (c *Config) writeKeyLog(clientRandom, masterSecret []byte) error {
+// Labels of the NSS key log format. Before TLS 1.3 only the master secret is
+// logged; in TLS 1.3 each traffic secret is.
+const (
+	keyLogLabelTLS12           = "CLIENT_RANDOM"
+	keyLogLabelClientHandshake = "CLIENT_HANDSHAKE_TRAFFIC_SECRET"
+	keyLogLabelServerHandshake = "SERVER_HANDSHAKE_TRAFFIC_SECRET"
+	keyLogLabelClientTraffic   = "CLIENT_TRAFFIC_SECRET_0"
+	keyLogLabelServerTraffic   = "SERVER_TRAFFIC_SECRET_0"
+)
+
+// writeKeyLog logs client random and a secret under label if logging was
+// enabled by setting c.KeyLogWriter.
+func (c *Config) writeKeyLog(label string, clientRandom, secret []byte) error {
 	if c.KeyLogWriter == nil {
 		return nil
 	}
 
-	logLine := []byte(fmt.Sprintf("CLIENT_RANDOM %x %x\n", clientRandom, masterSecret))
+	logLine := []byte(fmt.Sprintf("%s %x %x\n", label, clientRandom, secret))
 
 	writerMutex.Lock()
 	_, err := c.KeyLogWriter.Write(logLine)
@@ -843,6 +1587,11 @@ type Certificate struct {
 	// processing for TLS clients doing client authentication. If nil, the
 	// leaf certificate will be parsed as needed.
 	Leaf *x509.Certificate
+	// RawPublicKey is the DER-encoded SubjectPublicKeyInfo sent instead
+	// of the chain when a raw public key is negotiated (RFC 7250). If
+	// nil, the public key of PrivateKey is sent. Certificate may be
+	// empty if the Certificate is only used as a raw public key.
+	RawPublicKey []byte
 }
 
 type handshakeMessage interface {
@@ -921,6 +1670,77 @@ func (c *lruSessionCache) Get(sessionKey string) (*ClientSessionState, bool) {
 	return nil, false
 }
 
+// lruServerSessionCache is a ServerSessionCache implementation that uses an
+// LRU caching strategy.
+type lruServerSessionCache struct {
+	sync.Mutex
+
+	m        map[string]*list.Element
+	q        *list.List
+	capacity int
+}
+
+type lruServerSessionCacheEntry struct {
+	sessionId string
+	state     *ServerSessionState
+}
+
+// NewLRUServerSessionCache returns a ServerSessionCache with the given
+// capacity that uses an LRU strategy. If capacity is < 1, a default capacity
+// is used instead.
+func NewLRUServerSessionCache(capacity int) ServerSessionCache {
+	const defaultSessionCacheCapacity = 1024
+
+	if capacity < 1 {
+		capacity = defaultSessionCacheCapacity
+	}
+	return &lruServerSessionCache{
+		m:        make(map[string]*list.Element),
+		q:        list.New(),
+		capacity: capacity,
+	}
+}
+
+// Put adds the provided (sessionId, ss) pair to the cache.
+func (c *lruServerSessionCache) Put(sessionId string, ss *ServerSessionState) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.m[sessionId]; ok {
+		entry := elem.Value.(*lruServerSessionCacheEntry)
+		entry.state = ss
+		c.q.MoveToFront(elem)
+		return
+	}
+
+	if c.q.Len() < c.capacity {
+		entry := &lruServerSessionCacheEntry{sessionId, ss}
+		c.m[sessionId] = c.q.PushFront(entry)
+		return
+	}
+
+	elem := c.q.Back()
+	entry := elem.Value.(*lruServerSessionCacheEntry)
+	delete(c.m, entry.sessionId)
+	entry.sessionId = sessionId
+	entry.state = ss
+	c.q.MoveToFront(elem)
+	c.m[sessionId] = elem
+}
+
+// Get returns the ServerSessionState value associated with a given session
+// ID. It returns (nil, false) if no value is found.
+func (c *lruServerSessionCache) Get(sessionId string) (*ServerSessionState, bool) {
+	c.Lock()
+	defer c.Unlock()
+
+	if elem, ok := c.m[sessionId]; ok {
+		c.q.MoveToFront(elem)
+		return elem.Value.(*lruServerSessionCacheEntry).state, true
+	}
+	return nil, false
+}
+
 // TODO(jsing): Make these available to both crypto/x509 and crypto/tls.
 type dsaSignature struct {
 	R, S *big.Int
@@ -935,8 +1755,9 @@ func defaultConfig() *Config {
 }
 
 var (
-	once                   sync.Once
-	varDefaultCipherSuites []uint16
+	once                        sync.Once
+	varDefaultCipherSuites      []uint16
+	varDefaultCipherSuitesTLS13 []uint16
 )
 
 func defaultCipherSuites() []uint16 {
@@ -944,6 +1765,11 @@ func defaultCipherSuites() []uint16 {
 	return varDefaultCipherSuites
 }
 
+func defaultCipherSuitesTLS13() []uint16 {
+	once.Do(initDefaultCipherSuites)
+	return varDefaultCipherSuitesTLS13
+}
+
 func initDefaultCipherSuites() {
 	var topCipherSuites []uint16
 	if cipherhw.AESGCMSupport() {
@@ -957,6 +1783,11 @@ func initDefaultCipherSuites() {
 			TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
 			TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
 		}
+		varDefaultCipherSuitesTLS13 = []uint16{
+			TLS_AES_128_GCM_SHA256,
+			TLS_AES_256_GCM_SHA384,
+			TLS_CHACHA20_POLY1305_SHA256,
+		}
 	} else {
 		// Without AES-GCM hardware, we put the ChaCha20-Poly1305
 		// cipher suites first.
@@ -968,6 +1799,11 @@ func initDefaultCipherSuites() {
 			TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
 			TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
 		}
+		varDefaultCipherSuitesTLS13 = []uint16{
+			TLS_CHACHA20_POLY1305_SHA256,
+			TLS_AES_128_GCM_SHA256,
+			TLS_AES_256_GCM_SHA384,
+		}
 	}
 
 	varDefaultCipherSuites = make([]uint16, 0, len(cipherSuites))
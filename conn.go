@@ -8,6 +8,7 @@ package tls
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/cipher"
 	"crypto/subtle"
 	"crypto/x509"
@@ -46,12 +47,23 @@ type Conn struct {
 	handshakes       int
 	didResume        bool // whether this connection was a session resumption
 	cipherSuite      uint16
+	curveID          CurveID  // group used for key agreement, if any
+	pskIdentity      []byte   // identity of the PSK that authenticated the connection, if any
+	pskKeyVersion    int      // Version of the PSKCandidate in use, if any
 	ocspResponse     []byte   // stapled OCSP response
 	scts             [][]byte // signed certificate timestamps from server
 	peerCertificates []*x509.Certificate
 	// verifiedChains contains the certificate chains that we built, as
 	// opposed to the ones presented by the server.
 	verifiedChains [][]*x509.Certificate
+	// serverCertificateType and clientCertificateType are the types of
+	// credential that authenticate each side, see RFC 7250. A peer that
+	// authenticated with a raw public key has peerRawPublicKey and
+	// peerPublicKey set instead of peerCertificates.
+	serverCertificateType CertificateType
+	clientCertificateType CertificateType
+	peerRawPublicKey      []byte
+	peerPublicKey         crypto.PublicKey
 	// serverName contains the server name indicated by the client, if any.
 	serverName string
 	// secureRenegotiation is true if the server echoed the secure
@@ -65,6 +77,20 @@ type Conn struct {
 	// channel-binding value.
 	clientFinishedIsFirst bool
 
+	// extendedMasterSecret is true if the master secret of the most recent
+	// handshake is bound to that handshake, see RFC 7627. This makes
+	// tls-unique safe to use after a resumption.
+	extendedMasterSecret bool
+
+	// maxFragmentLength is the limit on the plaintext of all records
+	// agreed with the max_fragment_length extension (RFC 6066).
+	// recordSizeLimit and peerRecordSizeLimit are the limits on the
+	// plaintext of the records received and sent, announced with the
+	// record_size_limit extension (RFC 8449). Zero means no limit.
+	maxFragmentLength   int
+	recordSizeLimit     int
+	peerRecordSizeLimit int
+
 	// closeNotifyErr is any error from sending the alertCloseNotify record.
 	closeNotifyErr error
 	// closeNotifySent is true if the Conn attempted to send an
@@ -81,6 +107,19 @@ type Conn struct {
 	clientProtocol         string
 	clientProtocolFallback bool
 
+	// resumptionSecret is the TLS 1.3 resumption master secret, from which
+	// the PSKs of the session tickets sent after the handshake are derived.
+	resumptionSecret []byte
+
+	// dtls holds the record and handshake layer state of a DTLS connection
+	// and is nil for TLS.
+	dtls *dtlsState
+
+	// tlcp is true if the connection speaks TLCP, see tlcp.go. vers is
+	// then VersionTLS12, whose record layer and PRF TLCP shares, and only
+	// the records carry VersionTLCP.
+	tlcp bool
+
 	// input/output
 	in, out   halfConn     // in.Mutex < out.Mutex
 	rawInput  *block       // raw input, right off the wire
@@ -120,12 +159,18 @@ func (c *Conn) RemoteAddr() net.Addr {
 // A zero value for t means Read and Write will not time out.
 // After a Write has timed out, the TLS state is corrupt and all future writes will return the same error.
 func (c *Conn) SetDeadline(t time.Time) error {
+	if c.dtls != nil {
+		c.dtls.setReadDeadline(t)
+	}
 	return c.conn.SetDeadline(t)
 }
 
 // SetReadDeadline sets the read deadline on the underlying connection.
 // A zero value for t means Read will not time out.
 func (c *Conn) SetReadDeadline(t time.Time) error {
+	if c.dtls != nil {
+		c.dtls.setReadDeadline(t)
+	}
 	return c.conn.SetReadDeadline(t)
 }
 
@@ -152,10 +197,29 @@ type halfConn struct {
 	nextCipher interface{} // next encryption state
 	nextMac    macFunction // next MAC algorithm
 
+	encryptThenMAC     bool // MAC the ciphertext, see RFC 7366
+	nextEncryptThenMAC bool
+
+	// candidates are the possible cipher specs of a peer whose PSK the
+	// server isn't sure of, see Config.GetPSKKeyCandidates. The first
+	// record that decrypts under one of them settles which one is in use,
+	// and candidate is then its index.
+	candidates     []cipherSpec
+	nextCandidates []cipherSpec
+	candidate      int
+
+	trafficSecret []byte // current TLS 1.3 traffic secret
+
 	// used to save allocating a new buffer for each MAC.
 	inDigestBuf, outDigestBuf []byte
 }
 
+// A cipherSpec is the encryption and MAC state of a halfConn.
+type cipherSpec struct {
+	cipher interface{}
+	mac    macFunction
+}
+
 func (hc *halfConn) setErrorLocked(err error) error {
 	hc.err = err
 	return err
@@ -163,10 +227,19 @@ func (hc *halfConn) setErrorLocked(err error) error {
 
 // prepareCipherSpec sets the encryption and MAC states
 // that a subsequent changeCipherSpec will use.
-func (hc *halfConn) prepareCipherSpec(version uint16, cipher interface{}, mac macFunction) {
+func (hc *halfConn) prepareCipherSpec(version uint16, cipher interface{}, mac macFunction, encryptThenMAC bool) {
 	hc.version = version
 	hc.nextCipher = cipher
 	hc.nextMac = mac
+	hc.nextEncryptThenMAC = encryptThenMAC
+	hc.nextCandidates = nil
+}
+
+// prepareCandidateCipherSpecs sets the cipher specs that a subsequent
+// changeCipherSpec will try on the records that follow it, in place of the
+// one passed to prepareCipherSpec.
+func (hc *halfConn) prepareCandidateCipherSpecs(specs []cipherSpec) {
+	hc.nextCandidates = specs
 }
 
 // changeCipherSpec changes the encryption and MAC states
@@ -177,14 +250,34 @@ func (hc *halfConn) changeCipherSpec() error {
 	}
 	hc.cipher = hc.nextCipher
 	hc.mac = hc.nextMac
+	hc.encryptThenMAC = hc.nextEncryptThenMAC
 	hc.nextCipher = nil
 	hc.nextMac = nil
+	hc.nextEncryptThenMAC = false
+	hc.candidates = hc.nextCandidates
+	hc.nextCandidates = nil
+	hc.candidate = 0
 	for i := range hc.seq {
 		hc.seq[i] = 0
 	}
 	return nil
 }
 
+// setTrafficSecret installs the TLS 1.3 record protection keys derived from
+// secret. Unlike earlier versions, TLS 1.3 switches keys without a
+// ChangeCipherSpec message.
+func (hc *halfConn) setTrafficSecret(suite *cipherSuiteTLS13, secret []byte) {
+	hc.trafficSecret = secret
+	key, iv := suite.trafficKey(secret)
+	hc.version = VersionTLS13
+	hc.cipher = suite.aead(key, iv)
+	hc.mac = nil
+	hc.encryptThenMAC = false
+	for i := range hc.seq {
+		hc.seq[i] = 0
+	}
+}
+
 // incSeq increments the sequence number.
 func (hc *halfConn) incSeq() {
 	for i := 7; i >= 0; i-- {
@@ -268,6 +361,10 @@ type cbcMode interface {
 // success boolean, the number of bytes to skip from the start of the record in
 // order to get the application payload, and an optional alert value.
 func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert) {
+	if hc.candidates != nil {
+		return hc.decryptCandidates(b)
+	}
+
 	// pull out payload
 	payload := b.data[recordHeaderLen:]
 
@@ -280,6 +377,31 @@ func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert)
 	paddingLen := 0
 	explicitIVLen := 0
 
+	// TLS 1.3 hides the real content type inside the encrypted record.
+	if hc.version == VersionTLS13 && hc.cipher != nil && recordType(b.data[0]) != recordTypeApplicationData {
+		return false, 0, alertUnexpectedMessage
+	}
+
+	// With encrypt-then-MAC the MAC covers the ciphertext and is checked
+	// before decrypting, see RFC 7366, section 3.
+	if hc.encryptThenMAC {
+		if len(payload) < macSize {
+			return false, 0, alertBadRecordMAC
+		}
+		n := len(payload) - macSize
+		b.data[3] = byte(n >> 8)
+		b.data[4] = byte(n)
+		remoteMAC := payload[n:]
+		localMAC := hc.mac.MAC(hc.inDigestBuf, hc.seq[0:], b.data[:recordHeaderLen], payload[:n], nil)
+		if subtle.ConstantTimeCompare(localMAC, remoteMAC) != 1 {
+			return false, 0, alertBadRecordMAC
+		}
+		hc.inDigestBuf = localMAC
+
+		payload = payload[:n]
+		macSize = 0
+	}
+
 	// decrypt
 	if hc.cipher != nil {
 		switch c := hc.cipher.(type) {
@@ -297,16 +419,35 @@ func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert)
 				nonce = hc.seq[:]
 			}
 
-			copy(hc.additionalData[:], hc.seq[:])
-			copy(hc.additionalData[8:], b.data[:3])
-			n := len(payload) - c.Overhead()
-			hc.additionalData[11] = byte(n >> 8)
-			hc.additionalData[12] = byte(n)
+			var additionalData []byte
+			if hc.version == VersionTLS13 {
+				additionalData = b.data[:recordHeaderLen]
+			} else {
+				copy(hc.additionalData[:], hc.seq[:])
+				copy(hc.additionalData[8:], b.data[:3])
+				n := len(payload) - c.Overhead()
+				hc.additionalData[11] = byte(n >> 8)
+				hc.additionalData[12] = byte(n)
+				additionalData = hc.additionalData[:]
+			}
 			var err error
-			payload, err = c.Open(payload[:0], nonce, payload, hc.additionalData[:])
+			payload, err = c.Open(payload[:0], nonce, payload, additionalData)
 			if err != nil {
 				return false, 0, alertBadRecordMAC
 			}
+			if hc.version == VersionTLS13 {
+				// Strip the zero padding and recover the content
+				// type, see RFC 8446, section 5.4.
+				i := len(payload) - 1
+				for i >= 0 && payload[i] == 0 {
+					i--
+				}
+				if i < 0 {
+					return false, 0, alertUnexpectedMessage
+				}
+				b.data[0] = payload[i]
+				payload = payload[:i]
+			}
 			b.resize(recordHeaderLen + explicitIVLen + len(payload))
 		case cbcMode:
 			blockSize := c.BlockSize()
@@ -340,8 +481,17 @@ func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert)
 		}
 	}
 
-	// check, strip mac
-	if hc.mac != nil {
+	if hc.encryptThenMAC {
+		// The padding isn't secret once the MAC has been checked.
+		if paddingGood != 255 {
+			return false, 0, alertBadRecordMAC
+		}
+		n := len(payload) - paddingLen
+		b.data[3] = byte(n >> 8)
+		b.data[4] = byte(n)
+		b.resize(recordHeaderLen + explicitIVLen + n)
+	} else if hc.mac != nil {
+		// check, strip mac
 		if len(payload) < macSize {
 			return false, 0, alertBadRecordMAC
 		}
@@ -366,6 +516,49 @@ func (hc *halfConn) decrypt(b *block) (ok bool, prefixLen int, alertValue alert)
 	return true, recordHeaderLen + explicitIVLen, 0
 }
 
+// decryptCandidates decrypts b under each of hc.candidates in turn. The first
+// one that b authenticates under becomes the cipher spec of hc.
+func (hc *halfConn) decryptCandidates(b *block) (ok bool, prefixLen int, alertValue alert) {
+	candidates := hc.candidates
+	hc.candidates = nil
+
+	record := append([]byte(nil), b.data...)
+	for i, spec := range candidates {
+		b.resize(len(record))
+		copy(b.data, record)
+		hc.cipher, hc.mac = spec.cipher, spec.mac
+		if ok, prefixLen, alertValue = hc.decrypt(b); ok {
+			hc.candidate = i
+			return ok, prefixLen, alertValue
+		}
+	}
+
+	// A datagram that decrypts under none of them may be a stray one, so
+	// the next one gets to try them all again.
+	hc.candidates = candidates
+	return false, 0, alertBadRecordMAC
+}
+
+// explicitIVLen returns the length of the explicit IV or nonce that precedes
+// the payload of the records written with the current cipher, and whether it
+// is the sequence number rather than random.
+func (hc *halfConn) explicitIVLen() (n int, isSeq bool) {
+	if hc.version >= VersionTLS11 {
+		if cbc, ok := hc.cipher.(cbcMode); ok {
+			return cbc.BlockSize(), false
+		}
+	}
+	if c, ok := hc.cipher.(aead); ok {
+		// The AES-GCM construction in TLS has an explicit nonce so
+		// that the nonce can be random. However, the nonce is only 8
+		// bytes which is too small for a secure, random nonce.
+		// Therefore we use the sequence number as the nonce.
+		n = c.explicitNonceLen()
+		return n, n > 0
+	}
+	return 0, false
+}
+
 // padToBlockSize calculates the needed padding block, if any, for a payload.
 // On exit, prefix aliases payload and extends to the end of the last full
 // block of payload. finalBlock is a fresh slice which contains the contents of
@@ -386,7 +579,7 @@ func padToBlockSize(payload []byte, blockSize int) (prefix, finalBlock []byte) {
 // encrypt encrypts and macs the data in b.
 func (hc *halfConn) encrypt(b *block, explicitIVLen int) (bool, alert) {
 	// mac
-	if hc.mac != nil {
+	if hc.mac != nil && !hc.encryptThenMAC {
 		mac := hc.mac.MAC(hc.outDigestBuf, hc.seq[0:], b.data[:recordHeaderLen], b.data[recordHeaderLen+explicitIVLen:], nil)
 
 		n := len(b.data)
@@ -412,12 +605,23 @@ func (hc *halfConn) encrypt(b *block, explicitIVLen int) (bool, alert) {
 			payload := b.data[recordHeaderLen+explicitIVLen:]
 			payload = payload[:payloadLen]
 
-			copy(hc.additionalData[:], hc.seq[:])
-			copy(hc.additionalData[8:], b.data[:3])
-			hc.additionalData[11] = byte(payloadLen >> 8)
-			hc.additionalData[12] = byte(payloadLen)
+			var additionalData []byte
+			if hc.version == VersionTLS13 {
+				// The additional data is the record header, which
+				// already needs the final length.
+				n := payloadLen + c.Overhead()
+				b.data[3] = byte(n >> 8)
+				b.data[4] = byte(n)
+				additionalData = b.data[:recordHeaderLen]
+			} else {
+				copy(hc.additionalData[:], hc.seq[:])
+				copy(hc.additionalData[8:], b.data[:3])
+				hc.additionalData[11] = byte(payloadLen >> 8)
+				hc.additionalData[12] = byte(payloadLen)
+				additionalData = hc.additionalData[:]
+			}
 
-			c.Seal(payload[:0], nonce, payload, hc.additionalData[:])
+			c.Seal(payload[:0], nonce, payload, additionalData)
 		case cbcMode:
 			blockSize := c.BlockSize()
 			if explicitIVLen > 0 {
@@ -433,6 +637,20 @@ func (hc *halfConn) encrypt(b *block, explicitIVLen int) (bool, alert) {
 		}
 	}
 
+	// With encrypt-then-MAC the MAC covers the header, with the length of
+	// the ciphertext, and the ciphertext, see RFC 7366, section 3.
+	if hc.encryptThenMAC {
+		n := len(b.data) - recordHeaderLen
+		b.data[3] = byte(n >> 8)
+		b.data[4] = byte(n)
+		mac := hc.mac.MAC(hc.outDigestBuf, hc.seq[0:], b.data[:recordHeaderLen], b.data[recordHeaderLen:], nil)
+
+		n = len(b.data)
+		b.resize(n + len(mac))
+		copy(b.data[n:], mac)
+		hc.outDigestBuf = mac
+	}
+
 	// update length to include MAC and any block padding needed.
 	n := len(b.data) - recordHeaderLen
 	b.data[3] = byte(n >> 8)
@@ -569,7 +787,9 @@ func (c *Conn) readRecord(want recordType) error {
 		c.sendAlert(alertInternalError)
 		return c.in.setErrorLocked(errors.New("tls: unknown record type requested"))
 	case recordTypeHandshake, recordTypeChangeCipherSpec:
-		if c.handshakeComplete {
+		// TLS 1.3 sends NewSessionTicket and KeyUpdate messages after
+		// the handshake.
+		if c.handshakeComplete && !(want == recordTypeHandshake && c.vers == VersionTLS13) {
 			c.sendAlert(alertInternalError)
 			return c.in.setErrorLocked(errors.New("tls: handshake or ChangeCipherSpec requested while not in handshake"))
 		}
@@ -580,6 +800,10 @@ func (c *Conn) readRecord(want recordType) error {
 		}
 	}
 
+	if c.dtls != nil {
+		return c.readRecordDTLS(want)
+	}
+
 Again:
 	if c.rawInput == nil {
 		c.rawInput = c.in.newBlock()
@@ -612,9 +836,14 @@ Again:
 
 	vers := uint16(b.data[1])<<8 | uint16(b.data[2])
 	n := int(b.data[3])<<8 | int(b.data[4])
-	if c.haveVers && vers != c.vers {
+	wantVers := c.vers
+	if c.tlcp {
+		wantVers = VersionTLCP
+	}
+	// TLS 1.3 freezes the record version at TLS 1.2 and it is ignored.
+	if c.haveVers && c.vers != VersionTLS13 && vers != wantVers {
 		c.sendAlert(alertProtocolVersion)
-		msg := fmt.Sprintf("received record with version %x when expecting version %x", vers, c.vers)
+		msg := fmt.Sprintf("received record with version %x when expecting version %x", vers, wantVers)
 		return c.in.setErrorLocked(c.newRecordHeaderError(msg))
 	}
 	if n > maxCiphertext {
@@ -644,14 +873,27 @@ Again:
 
 	// Process message.
 	b, c.rawInput = c.in.splitBlock(b, recordHeaderLen+n)
+
+	// TLS 1.3 peers may send unencrypted ChangeCipherSpec records during
+	// the handshake for middlebox compatibility. They carry no meaning.
+	if c.vers == VersionTLS13 && typ == recordTypeChangeCipherSpec {
+		dummy := !c.handshakeComplete && n == 1 && b.data[recordHeaderLen] == 1
+		c.in.freeBlock(b)
+		if !dummy {
+			return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+		}
+		goto Again
+	}
+
 	ok, off, alertValue := c.in.decrypt(b)
 	if !ok {
 		c.in.freeBlock(b)
 		return c.in.setErrorLocked(c.sendAlert(alertValue))
 	}
 	b.off = off
+	typ = recordType(b.data[0])
 	data := b.data[b.off:]
-	if len(data) > maxPlaintext {
+	if len(data) > maxPlaintext || c.in.cipher != nil && len(data) > c.maxPlaintextIn() {
 		err := c.sendAlert(alertRecordOverflow)
 		c.in.freeBlock(b)
 		return c.in.setErrorLocked(err)
@@ -701,7 +943,7 @@ Again:
 
 	case recordTypeHandshake:
 		// TODO(rsc): Should at least pick off connection close.
-		if typ != want && !(c.isClient && c.config.Renegotiation != RenegotiateNever) {
+		if typ != want && c.vers != VersionTLS13 && !(c.isClient && c.config.Renegotiation != RenegotiateNever) {
 			return c.in.setErrorLocked(c.sendAlert(alertNoRenegotiation))
 		}
 		c.hand.Write(data)
@@ -755,8 +997,59 @@ const (
 	recordSizeBoostThreshold = 128 * 1024
 )
 
+// maxPlaintextOut returns the largest plaintext of the records sent to the
+// peer.
+func (c *Conn) maxPlaintextOut() int {
+	n := maxPlaintext
+	if c.maxFragmentLength != 0 && c.maxFragmentLength < n {
+		n = c.maxFragmentLength
+	}
+	if c.peerRecordSizeLimit != 0 && c.peerRecordSizeLimit < n {
+		n = c.peerRecordSizeLimit
+	}
+	return n
+}
+
+// maxPlaintextIn returns the largest plaintext of the records received
+// from the peer. It only applies to records protected by the negotiated
+// keys, since record_size_limit doesn't limit the others.
+func (c *Conn) maxPlaintextIn() int {
+	n := maxPlaintext
+	if c.maxFragmentLength != 0 && c.maxFragmentLength < n {
+		n = c.maxFragmentLength
+	}
+	if c.recordSizeLimit != 0 && c.recordSizeLimit < n {
+		n = c.recordSizeLimit
+	}
+	return n
+}
+
+// recordSizeLimitValue returns the record_size_limit value that allows
+// records with up to n bytes of plaintext. In TLS 1.3 the limit also counts
+// the content type, see RFC 8449, section 4.
+func recordSizeLimitValue(n int, vers uint16) uint16 {
+	if vers == VersionTLS13 {
+		n++
+	}
+	return uint16(n)
+}
+
+// recordSizeLimitPlaintext is the inverse of recordSizeLimitValue. Values
+// above the largest record of vers, which may have been meant for another
+// version, allow the largest records.
+func recordSizeLimitPlaintext(limit uint16, vers uint16) int {
+	n := int(limit)
+	if vers == VersionTLS13 {
+		n--
+	}
+	if n > maxPlaintext {
+		n = maxPlaintext
+	}
+	return n
+}
+
 // maxPayloadSizeForWrite returns the maximum TLS payload size to use for the
-// next application data record. There is the following trade-off:
+// next record. There is the following trade-off:
 //
 //   - For latency-sensitive applications, such as web browsing, each TLS
 //     record should fit in one TCP segment.
@@ -774,12 +1067,13 @@ const (
 //
 // c.out.Mutex <= L.
 func (c *Conn) maxPayloadSizeForWrite(typ recordType, explicitIVLen int) int {
+	maxPayload := c.maxPlaintextOut()
 	if c.config.DynamicRecordSizingDisabled || typ != recordTypeApplicationData {
-		return maxPlaintext
+		return maxPayload
 	}
 
 	if c.bytesSent >= recordSizeBoostThreshold {
-		return maxPlaintext
+		return maxPayload
 	}
 
 	// Subtract TLS overheads to get the maximum payload size.
@@ -797,12 +1091,18 @@ func (c *Conn) maxPayloadSizeForWrite(typ recordType, explicitIVLen int) int {
 			payloadBytes -= ciph.Overhead()
 		case cbcMode:
 			blockSize := ciph.BlockSize()
+			// With encrypt-then-MAC the MAC follows the ciphertext.
+			if c.out.encryptThenMAC {
+				payloadBytes -= macSize
+			}
 			// The payload must fit in a multiple of blockSize, with
 			// room for at least one padding byte.
 			payloadBytes = (payloadBytes & ^(blockSize - 1)) - 1
 			// The MAC is appended before padding so affects the
 			// payload size directly.
-			payloadBytes -= macSize
+			if !c.out.encryptThenMAC {
+				payloadBytes -= macSize
+			}
 		default:
 			panic("unknown cipher type")
 		}
@@ -812,12 +1112,12 @@ func (c *Conn) maxPayloadSizeForWrite(typ recordType, explicitIVLen int) int {
 	pkt := c.packetsSent
 	c.packetsSent++
 	if pkt > 1000 {
-		return maxPlaintext // avoid overflow in multiply below
+		return maxPayload // avoid overflow in multiply below
 	}
 
 	n := payloadBytes * int(pkt+1)
-	if n > maxPlaintext {
-		n = maxPlaintext
+	if n > maxPayload {
+		n = maxPayload
 	}
 	return n
 }
@@ -850,34 +1150,16 @@ func (c *Conn) flush() (int, error) {
 // connection and updates the record layer state.
 // c.out.Mutex <= L.
 func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
+	if c.dtls != nil {
+		return c.writeRecordDTLS(typ, data)
+	}
+
 	b := c.out.newBlock()
 	defer c.out.freeBlock(b)
 
 	var n int
 	for len(data) > 0 {
-		explicitIVLen := 0
-		explicitIVIsSeq := false
-
-		var cbc cbcMode
-		if c.out.version >= VersionTLS11 {
-			var ok bool
-			if cbc, ok = c.out.cipher.(cbcMode); ok {
-				explicitIVLen = cbc.BlockSize()
-			}
-		}
-		if explicitIVLen == 0 {
-			if c, ok := c.out.cipher.(aead); ok {
-				explicitIVLen = c.explicitNonceLen()
-
-				// The AES-GCM construction in TLS has an
-				// explicit nonce so that the nonce can be
-				// random. However, the nonce is only 8 bytes
-				// which is too small for a secure, random
-				// nonce. Therefore we use the sequence number
-				// as the nonce.
-				explicitIVIsSeq = explicitIVLen > 0
-			}
-		}
+		explicitIVLen, explicitIVIsSeq := c.out.explicitIVLen()
 		m := len(data)
 		if maxPayload := c.maxPayloadSizeForWrite(typ, explicitIVLen); m > maxPayload {
 			m = maxPayload
@@ -885,10 +1167,14 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		b.resize(recordHeaderLen + explicitIVLen + m)
 		b.data[0] = byte(typ)
 		vers := c.vers
-		if vers == 0 {
+		if c.tlcp {
+			vers = VersionTLCP
+		} else if vers == 0 {
 			// Some TLS servers fail if the record version is
 			// greater than TLS 1.0 for the initial ClientHello.
 			vers = VersionTLS10
+		} else if vers == VersionTLS13 {
+			vers = VersionTLS12
 		}
 		b.data[1] = byte(vers >> 8)
 		b.data[2] = byte(vers)
@@ -905,6 +1191,13 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 			}
 		}
 		copy(b.data[recordHeaderLen+explicitIVLen:], data)
+		if c.out.version == VersionTLS13 && c.out.cipher != nil {
+			// TLS 1.3 appends the real content type to the
+			// plaintext, see RFC 8446, section 5.2.
+			b.data[0] = byte(recordTypeApplicationData)
+			b.resize(len(b.data) + 1)
+			b.data[len(b.data)-1] = byte(typ)
+		}
 		c.out.encrypt(b, explicitIVLen)
 		if _, err := c.write(b.data); err != nil {
 			return n, err
@@ -913,7 +1206,9 @@ func (c *Conn) writeRecordLocked(typ recordType, data []byte) (int, error) {
 		data = data[m:]
 	}
 
-	if typ == recordTypeChangeCipherSpec {
+	// In TLS 1.3 a ChangeCipherSpec is only sent for middlebox
+	// compatibility and does not change the keys.
+	if typ == recordTypeChangeCipherSpec && c.vers != VersionTLS13 {
 		if err := c.out.changeCipherSpec(); err != nil {
 			return n, c.sendAlertLocked(err.(alert))
 		}
@@ -969,13 +1264,33 @@ func (c *Conn) readHandshake() (interface{}, error) {
 	case typeServerHello:
 		m = new(serverHelloMsg)
 	case typeNewSessionTicket:
-		m = new(newSessionTicketMsg)
+		if c.vers == VersionTLS13 {
+			m = new(newSessionTicketMsgTLS13)
+		} else {
+			m = new(newSessionTicketMsg)
+		}
+	case typeEncryptedExtensions:
+		m = new(encryptedExtensionsMsg)
 	case typeCertificate:
-		m = new(certificateMsg)
+		if c.vers == VersionTLS13 {
+			m = new(certificateMsgTLS13)
+		} else {
+			peerCertificateType := c.clientCertificateType
+			if c.isClient {
+				peerCertificateType = c.serverCertificateType
+			}
+			m = &certificateMsg{rawPublicKey: peerCertificateType == CertificateTypeRawPublicKey}
+		}
 	case typeCertificateRequest:
-		m = &certificateRequestMsg{
-			hasSignatureAndHash: c.vers >= VersionTLS12,
+		if c.vers == VersionTLS13 {
+			m = new(certificateRequestMsgTLS13)
+		} else {
+			m = &certificateRequestMsg{
+				hasSignatureAndHash: c.vers >= VersionTLS12 && !c.tlcp,
+			}
 		}
+	case typeKeyUpdate:
+		m = new(keyUpdateMsg)
 	case typeCertificateStatus:
 		m = new(certificateStatusMsg)
 	case typeServerKeyExchange:
@@ -986,7 +1301,7 @@ func (c *Conn) readHandshake() (interface{}, error) {
 		m = new(clientKeyExchangeMsg)
 	case typeCertificateVerify:
 		m = &certificateVerifyMsg{
-			hasSignatureAndHash: c.vers >= VersionTLS12,
+			hasSignatureAndHash: c.vers >= VersionTLS12 && !c.tlcp,
 		}
 	case typeNextProtocol:
 		m = new(nextProtoMsg)
@@ -1087,6 +1402,11 @@ func (c *Conn) handleRenegotiation() error {
 		return c.sendAlert(alertNoRenegotiation)
 	}
 
+	// TLCP has no secure renegotiation extension.
+	if c.tlcp {
+		return c.sendAlert(alertNoRenegotiation)
+	}
+
 	switch c.config.Renegotiation {
 	case RenegotiateNever:
 		return c.sendAlert(alertNoRenegotiation)
@@ -1111,6 +1431,62 @@ func (c *Conn) handleRenegotiation() error {
 	return c.handshakeErr
 }
 
+// handlePostHandshakeMessage processes a handshake message arrived after the
+// handshake is complete.
+// c.in.Mutex <= L
+func (c *Conn) handlePostHandshakeMessage() error {
+	if c.vers != VersionTLS13 {
+		return c.handleRenegotiation()
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+
+	switch msg := msg.(type) {
+	case *newSessionTicketMsgTLS13:
+		return c.handleNewSessionTicket(msg)
+	case *keyUpdateMsg:
+		return c.handleKeyUpdate(msg)
+	default:
+		c.sendAlert(alertUnexpectedMessage)
+		return fmt.Errorf("tls: received unexpected handshake message of type %T", msg)
+	}
+}
+
+// handleKeyUpdate processes a TLS 1.3 KeyUpdate message, replying with one
+// of our own if the peer asks for it.
+// c.in.Mutex <= L
+func (c *Conn) handleKeyUpdate(keyUpdate *keyUpdateMsg) error {
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil {
+		return c.in.setErrorLocked(c.sendAlert(alertInternalError))
+	}
+
+	// A KeyUpdate must end on a record boundary, see RFC 8446, section 5.1.
+	if c.hand.Len() > 0 {
+		return c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+	}
+
+	c.in.setTrafficSecret(suite, suite.nextTrafficSecret(c.in.trafficSecret))
+
+	if keyUpdate.updateRequested {
+		c.out.Lock()
+		defer c.out.Unlock()
+
+		msg := &keyUpdateMsg{}
+		if _, err := c.writeRecordLocked(recordTypeHandshake, msg.marshal()); err != nil {
+			// Surface the error at the next write.
+			c.out.setErrorLocked(err)
+			return nil
+		}
+		c.out.setTrafficSecret(suite, suite.nextTrafficSecret(c.out.trafficSecret))
+	}
+
+	return nil
+}
+
 // Read can be made to time out and return a net.Error with Timeout() == true
 // after a fixed time limit; see SetDeadline and SetReadDeadline.
 func (c *Conn) Read(b []byte) (n int, err error) {
@@ -1137,8 +1513,9 @@ func (c *Conn) Read(b []byte) (n int, err error) {
 			}
 			if c.hand.Len() > 0 {
 				// We received handshake bytes, indicating the
-				// start of a renegotiation.
-				if err := c.handleRenegotiation(); err != nil {
+				// start of a renegotiation or a TLS 1.3
+				// post-handshake message.
+				if err := c.handlePostHandshakeMessage(); err != nil {
 					return 0, err
 				}
 			}
@@ -1310,6 +1687,9 @@ func (c *Conn) Handshake() error {
 	}
 	if c.handshakeErr == nil {
 		c.handshakes++
+		if c.dtls != nil {
+			c.dtls.handshakeDone()
+		}
 	} else {
 		// If an error occurred during the hadshake try to flush the
 		// alert that might be left in the buffer.
@@ -1339,15 +1719,28 @@ func (c *Conn) ConnectionState() ConnectionState {
 
 	if c.handshakeComplete {
 		state.Version = c.vers
+		if c.dtls != nil {
+			state.Version = dtlsVersion(c.vers)
+		} else if c.tlcp {
+			state.Version = VersionTLCP
+		}
 		state.NegotiatedProtocol = c.clientProtocol
 		state.DidResume = c.didResume
 		state.NegotiatedProtocolIsMutual = !c.clientProtocolFallback
 		state.CipherSuite = c.cipherSuite
+		state.CurveID = c.curveID
 		state.PeerCertificates = c.peerCertificates
 		state.VerifiedChains = c.verifiedChains
 		state.SignedCertificateTimestamps = c.scts
 		state.OCSPResponse = c.ocspResponse
-		if !c.didResume {
+		state.MaxFragmentLength = c.maxFragmentLength
+		state.RecordSizeLimit = c.peerRecordSizeLimit
+		state.PeerRawPublicKey = c.peerRawPublicKey
+		state.PeerPublicKey = c.peerPublicKey
+		state.PSKIdentity = string(c.pskIdentity)
+		state.PSKKeyVersion = c.pskKeyVersion
+		// tls-unique isn't defined for TLS 1.3.
+		if (!c.didResume || c.extendedMasterSecret) && c.vers != VersionTLS13 {
 			if c.clientFinishedIsFirst {
 				state.TLSUnique = c.clientFinished[:]
 			} else {
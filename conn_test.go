@@ -236,6 +236,13 @@ func TestDynamicRecordSizingWithCBC(t *testing.T) {
 	runDynamicRecordSizingTest(t, config)
 }
 
+func TestDynamicRecordSizingWithEncryptThenMAC(t *testing.T) {
+	config := testConfig.Clone()
+	config.CipherSuites = []uint16{TLS_RSA_WITH_AES_256_CBC_SHA}
+	config.EncryptThenMACDisabled = false
+	runDynamicRecordSizingTest(t, config)
+}
+
 func TestDynamicRecordSizingWithAEAD(t *testing.T) {
 	config := testConfig.Clone()
 	config.CipherSuites = []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256}
@@ -0,0 +1,1227 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"net"
+	"sync"
+	"time"
+)
+
+// This file contains the DTLS 1.2 record and handshake layers, see RFC 6347.
+// A DTLS Conn runs the TLS 1.2 handshake of handshake_client.go and
+// handshake_server.go. Underneath, records carry an explicit epoch and
+// sequence number, handshake messages are fragmented to fit in datagrams
+// and every flight of messages is retransmitted until the peer answers it.
+
+const (
+	dtlsRecordHeaderLen    = 13 // type, version, epoch, sequence number and length
+	dtlsHandshakeHeaderLen = 12 // type, length, message_seq, fragment_offset and fragment_length
+
+	// dtlsMaxFragmentLen bounds the handshake fragments that are sent, so
+	// that a record with its headers and cipher overhead fits in the 1280
+	// byte minimum MTU of IPv6.
+	dtlsMaxFragmentLen = 1024
+
+	// dtlsMaxBufferedMessages is how far ahead of the next expected one
+	// handshake messages that arrive out of order are buffered.
+	dtlsMaxBufferedMessages = 8
+	// dtlsMaxEarlyRecords is the number of records of the next epoch that
+	// are buffered when they overtake the ChangeCipherSpec.
+	dtlsMaxEarlyRecords = 8
+
+	// A flight is retransmitted if the peer doesn't answer within the
+	// timeout, which doubles every time up to the maximum, see RFC 6347,
+	// section 4.2.4.1.
+	dtlsInitialTimeout = 1 * time.Second
+	dtlsMaxTimeout     = 60 * time.Second
+
+	// dtlsListenerBacklog is the number of connections that wait for
+	// Accept, and dtlsPeerQueueLen the number of datagrams that wait to be
+	// read by each of them.
+	dtlsListenerBacklog = 16
+	dtlsPeerQueueLen    = 64
+	// dtlsPeerIdleTimeout is how long a dtlsListener keeps the Conn of a
+	// remote address from which no datagram arrives.
+	dtlsPeerIdleTimeout = 5 * time.Minute
+
+	versionDTLS10 = 0xfeff
+)
+
+// dtlsVersion returns the DTLS version on the wire for the TLS version vers.
+func dtlsVersion(vers uint16) uint16 {
+	if vers == VersionTLS11 {
+		return versionDTLS10
+	}
+	return VersionDTLS12
+}
+
+// tlsVersionOfDTLS returns the TLS version corresponding to the DTLS version
+// vers, or zero if there isn't one. DTLS version numbers count down, and
+// versions newer than DTLS 1.2 are treated as DTLS 1.2.
+func tlsVersionOfDTLS(vers uint16) uint16 {
+	switch {
+	case vers == versionDTLS10:
+		return VersionTLS11
+	case vers <= VersionDTLS12 && vers >= 0xfe00:
+		return VersionTLS12
+	}
+	return 0
+}
+
+// dtlsState is the record and handshake layer state of a DTLS connection.
+type dtlsState struct {
+	// Record layer. The epoch is incremented by every ChangeCipherSpec
+	// and each epoch restarts the record sequence numbers.
+	inEpoch  uint16
+	outEpoch uint16
+	outSeq   []uint64                // next record sequence number, by epoch
+	window   dtlsReplayWindow        // records received in inEpoch
+	early    [][]byte                // records of the epoch after inEpoch
+	buf      []byte                  // the last datagram read
+	records  []byte                  // unprocessed records of the last datagram
+	inSeq    uint64                  // sequence number of the last record read
+	messages map[uint16]*dtlsMessage // handshake messages being reassembled
+
+	// Handshake layer. Each side numbers its handshake messages.
+	started  bool   // the server received the first ClientHello
+	sendSeq  uint16 // message_seq of the next message to send
+	recvSeq  uint16 // message_seq of the next message to receive
+	cookie   []byte // sent by the server in a HelloVerifyRequest
+	lastSent dtlsTranscriptEntry
+	received []dtlsTranscriptEntry // passed on but not yet in the transcript
+
+	// Retransmission.
+	flight     []dtlsFlightMessage // the last flight sent
+	flightDone bool                // the peer has begun the next flight
+	peerFlight uint16              // message_seq that began the peer's last flight
+	timeout    time.Duration
+
+	deadlineMutex sync.Mutex
+	readDeadline  time.Time // set by the user, see Conn.SetReadDeadline
+
+	// onHandshakeDone, if not nil, is called when a handshake completes.
+	onHandshakeDone func()
+}
+
+func newDTLSState() *dtlsState {
+	return &dtlsState{
+		outSeq:   []uint64{0},
+		messages: make(map[uint16]*dtlsMessage),
+		timeout:  dtlsInitialTimeout,
+	}
+}
+
+// A dtlsTranscriptEntry holds a handshake message in the form that the
+// handshake code uses, with a TLS header, and in its DTLS form.
+type dtlsTranscriptEntry struct {
+	tls, wire []byte
+}
+
+// A dtlsFlightMessage is a handshake message, in its DTLS form, or a
+// ChangeCipherSpec that is kept for retransmission.
+type dtlsFlightMessage struct {
+	typ   recordType
+	epoch uint16
+	data  []byte
+}
+
+// A dtlsMessage is a handshake message being reassembled from fragments.
+type dtlsMessage struct {
+	typ     uint8
+	body    []byte
+	have    []bool // the bytes of body that were received
+	missing int
+}
+
+func (m *dtlsMessage) addFragment(offset int, fragment []byte) {
+	for i, b := range fragment {
+		if !m.have[offset+i] {
+			m.have[offset+i] = true
+			m.body[offset+i] = b
+			m.missing--
+		}
+	}
+}
+
+// dtlsReplayWindow is the sliding window of RFC 6347, section 4.1.2.6, that
+// detects duplicated records.
+type dtlsReplayWindow struct {
+	latest uint64 // highest sequence number accepted
+	bitmap uint64 // bit i is set if latest-i was accepted
+}
+
+// check reports whether a record with sequence number seq may be accepted.
+func (w *dtlsReplayWindow) check(seq uint64) bool {
+	if w.bitmap == 0 || seq > w.latest {
+		return true
+	}
+	d := w.latest - seq
+	return d < 64 && w.bitmap&(1<<d) == 0
+}
+
+// accept records that a record with sequence number seq was accepted.
+func (w *dtlsReplayWindow) accept(seq uint64) {
+	switch {
+	case w.bitmap == 0:
+		w.bitmap = 1
+		w.latest = seq
+	case seq > w.latest:
+		if d := seq - w.latest; d < 64 {
+			w.bitmap = w.bitmap<<d | 1
+		} else {
+			w.bitmap = 1
+		}
+		w.latest = seq
+	default:
+		w.bitmap |= 1 << (w.latest - seq)
+	}
+}
+
+func (d *dtlsState) setReadDeadline(t time.Time) {
+	d.deadlineMutex.Lock()
+	d.readDeadline = t
+	d.deadlineMutex.Unlock()
+}
+
+func (d *dtlsState) getReadDeadline() time.Time {
+	d.deadlineMutex.Lock()
+	defer d.deadlineMutex.Unlock()
+	return d.readDeadline
+}
+
+// handshakeDone is called when a handshake completes. Only the side that
+// sent the last flight keeps it, to retransmit it if the peer didn't get it.
+func (d *dtlsState) handshakeDone() {
+	if d.flightDone {
+		d.flight = nil
+	}
+	d.early = nil
+	d.messages = nil
+	d.received = nil
+	if d.onHandshakeDone != nil {
+		d.onHandshakeDone()
+	}
+}
+
+// startFlight discards the previous flight, which the peer answered.
+func (d *dtlsState) startFlight() {
+	d.flight = nil
+	d.flightDone = false
+	d.timeout = dtlsInitialTimeout
+}
+
+// splitClientHelloCookie returns the cookie of the body of a DTLS
+// ClientHello and the body without it, which is a TLS ClientHello.
+func splitClientHelloCookie(body []byte) (cookie, rest []byte, ok bool) {
+	// version, random, session_id and cookie
+	if len(body) < 2+32+1 {
+		return nil, nil, false
+	}
+	i := 2 + 32 + 1 + int(body[34])
+	if len(body) < i+1 || len(body) < i+1+int(body[i]) {
+		return nil, nil, false
+	}
+	cookie = body[i+1 : i+1+int(body[i])]
+	rest = make([]byte, 0, len(body)-1-len(cookie))
+	rest = append(rest, body[:i]...)
+	rest = append(rest, body[i+1+len(cookie):]...)
+	return cookie, rest, true
+}
+
+// wireMessage returns the DTLS form of msg, a handshake message with a TLS
+// header, as a single fragment with the given message_seq. The version of
+// hellos is a DTLS version and the ClientHello gains a cookie.
+func (d *dtlsState) wireMessage(msg []byte, seq uint16) []byte {
+	typ, body := msg[0], msg[4:]
+	if (typ == typeClientHello || typ == typeServerHello) && len(body) >= 2 {
+		vers := dtlsVersion(uint16(body[0])<<8 | uint16(body[1]))
+		if typ == typeClientHello && len(body) >= 2+32+1 {
+			i := 2 + 32 + 1 + int(body[34])
+			rest := body
+			body = make([]byte, 0, len(rest)+1+len(d.cookie))
+			body = append(body, rest[:i]...)
+			body = append(body, uint8(len(d.cookie)))
+			body = append(body, d.cookie...)
+			body = append(body, rest[i:]...)
+		} else {
+			body = append([]byte(nil), body...)
+		}
+		body[0] = byte(vers >> 8)
+		body[1] = byte(vers)
+	}
+
+	n := len(body)
+	out := make([]byte, dtlsHandshakeHeaderLen+n)
+	out[0] = typ
+	out[1] = byte(n >> 16)
+	out[2] = byte(n >> 8)
+	out[3] = byte(n)
+	out[4] = byte(seq >> 8)
+	out[5] = byte(seq)
+	out[9] = byte(n >> 16)
+	out[10] = byte(n >> 8)
+	out[11] = byte(n)
+	copy(out[dtlsHandshakeHeaderLen:], body)
+	return out
+}
+
+// transcriptMessage returns the DTLS form of msg, a handshake message that
+// is being added to the transcript. The DTLS transcript has the messages as
+// they were sent, with the message_seq, see RFC 6347, section 4.2.6.
+//
+// The handshake code adds received messages, in order, after reading them
+// and the messages it sends just before sending them, except for the client,
+// which adds its ClientHello after reading the ServerHello.
+func (d *dtlsState) transcriptMessage(msg []byte) []byte {
+	if len(d.received) > 0 && bytes.Equal(msg, d.received[0].tls) {
+		wire := d.received[0].wire
+		d.received = d.received[1:]
+		return wire
+	}
+	if bytes.Equal(msg, d.lastSent.tls) {
+		return d.lastSent.wire
+	}
+	return d.wireMessage(msg, d.sendSeq)
+}
+
+// writeRecordDTLS is the DTLS counterpart of writeRecordLocked. Handshake
+// messages and ChangeCipherSpecs are kept in the current flight, for
+// retransmission.
+func (c *Conn) writeRecordDTLS(typ recordType, data []byte) (int, error) {
+	d := c.dtls
+
+	switch typ {
+	case recordTypeHandshake:
+		if d.flightDone {
+			d.startFlight()
+		}
+		var n int
+		for len(data) > 0 {
+			if len(data) < 4 {
+				return n, alertInternalError
+			}
+			m := 4 + (int(data[1])<<16 | int(data[2])<<8 | int(data[3]))
+			if len(data) < m {
+				return n, alertInternalError
+			}
+			msg := data[:m]
+			wire := d.wireMessage(msg, d.sendSeq)
+			d.sendSeq++
+			d.lastSent = dtlsTranscriptEntry{msg, wire}
+			fm := dtlsFlightMessage{typ, d.outEpoch, wire}
+			d.flight = append(d.flight, fm)
+			if err := c.sendFlightMessage(fm); err != nil {
+				return n, err
+			}
+			n += m
+			data = data[m:]
+		}
+		return n, nil
+
+	case recordTypeChangeCipherSpec:
+		if d.flightDone {
+			d.startFlight()
+		}
+		fm := dtlsFlightMessage{typ, d.outEpoch, data}
+		d.flight = append(d.flight, fm)
+		if err := c.sendFlightMessage(fm); err != nil {
+			return 0, err
+		}
+		if err := c.out.changeCipherSpec(); err != nil {
+			return 0, c.sendAlertLocked(err.(alert))
+		}
+		d.outEpoch++
+		d.outSeq = append(d.outSeq, 0)
+		return len(data), nil
+	}
+
+	// Alerts and application data are sent at once, one record in each
+	// datagram.
+	var n int
+	for len(data) > 0 {
+		m := len(data)
+		if maxPayload := c.maxPlaintextOut(); m > maxPayload {
+			m = maxPayload
+		}
+		if err := c.sendRecordDTLS(typ, d.outEpoch, data[:m]); err != nil {
+			return n, err
+		}
+		n += m
+		data = data[m:]
+	}
+	return n, nil
+}
+
+// sendFlightMessage sends a message of the current flight, fragmenting
+// handshake messages so that each record fits in a datagram.
+func (c *Conn) sendFlightMessage(m dtlsFlightMessage) error {
+	if m.typ != recordTypeHandshake {
+		return c.sendRecordDTLS(m.typ, m.epoch, m.data)
+	}
+
+	maxFragmentLen := dtlsMaxFragmentLen
+	if n := c.maxPlaintextOut() - dtlsHandshakeHeaderLen; n < maxFragmentLen {
+		maxFragmentLen = n
+	}
+	body := m.data[dtlsHandshakeHeaderLen:]
+	for offset := 0; ; {
+		n := len(body) - offset
+		if n > maxFragmentLen {
+			n = maxFragmentLen
+		}
+		fragment := make([]byte, dtlsHandshakeHeaderLen+n)
+		copy(fragment, m.data[:6])
+		fragment[6] = byte(offset >> 16)
+		fragment[7] = byte(offset >> 8)
+		fragment[8] = byte(offset)
+		fragment[9] = byte(n >> 16)
+		fragment[10] = byte(n >> 8)
+		fragment[11] = byte(n)
+		copy(fragment[dtlsHandshakeHeaderLen:], body[offset:offset+n])
+		if err := c.sendRecordDTLS(m.typ, m.epoch, fragment); err != nil {
+			return err
+		}
+		offset += n
+		if offset >= len(body) {
+			return nil
+		}
+	}
+}
+
+// retransmitFlight sends the last flight again, with new record sequence
+// numbers.
+func (c *Conn) retransmitFlight() error {
+	c.out.Lock()
+	defer c.out.Unlock()
+
+	for _, m := range c.dtls.flight {
+		if err := c.sendFlightMessage(m); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sendRecordDTLS protects payload with the keys of the given epoch and
+// sends it in a datagram of its own.
+func (c *Conn) sendRecordDTLS(typ recordType, epoch uint16, payload []byte) error {
+	d := c.dtls
+	seq := d.outSeq[epoch]
+	if seq >= 1<<48 {
+		return errors.New("tls: DTLS record sequence numbers exhausted")
+	}
+	d.outSeq[epoch]++
+
+	vers := uint16(versionDTLS10)
+	if c.haveVers {
+		vers = dtlsVersion(c.vers)
+	}
+
+	fragment := payload
+	if epoch != 0 {
+		if epoch != d.outEpoch {
+			return errors.New("tls: internal error: DTLS record for a previous epoch")
+		}
+		b := c.out.newBlock()
+		defer c.out.freeBlock(b)
+
+		// The sequence number in MACs and nonces is the epoch followed
+		// by the record sequence number, see RFC 6347, section 4.1.2.1.
+		s := uint64(epoch)<<48 | seq
+		for i := range c.out.seq {
+			c.out.seq[i] = byte(s >> uint(56-8*i))
+		}
+
+		explicitIVLen, explicitIVIsSeq := c.out.explicitIVLen()
+		b.resize(recordHeaderLen + explicitIVLen + len(payload))
+		b.data[0] = byte(typ)
+		b.data[1] = byte(vers >> 8)
+		b.data[2] = byte(vers)
+		b.data[3] = byte(len(payload) >> 8)
+		b.data[4] = byte(len(payload))
+		if explicitIVLen > 0 {
+			explicitIV := b.data[recordHeaderLen : recordHeaderLen+explicitIVLen]
+			if explicitIVIsSeq {
+				copy(explicitIV, c.out.seq[:])
+			} else {
+				if _, err := io.ReadFull(c.config.rand(), explicitIV); err != nil {
+					return err
+				}
+			}
+		}
+		copy(b.data[recordHeaderLen+explicitIVLen:], payload)
+		c.out.encrypt(b, explicitIVLen)
+		fragment = b.data[recordHeaderLen:]
+	}
+
+	record := make([]byte, dtlsRecordHeaderLen+len(fragment))
+	record[0] = byte(typ)
+	record[1] = byte(vers >> 8)
+	record[2] = byte(vers)
+	record[3] = byte(epoch >> 8)
+	record[4] = byte(epoch)
+	for i := 0; i < 6; i++ {
+		record[5+i] = byte(seq >> uint(40-8*i))
+	}
+	record[11] = byte(len(fragment) >> 8)
+	record[12] = byte(len(fragment))
+	copy(record[dtlsRecordHeaderLen:], fragment)
+
+	n, err := c.conn.Write(record)
+	c.bytesSent += int64(n)
+	c.packetsSent++
+	return err
+}
+
+// readRecordDTLS is the DTLS counterpart of readRecord. It processes records
+// until one of the wanted type arrives. Records that can't be authenticated
+// or don't fit the state of the connection are silently discarded, since
+// datagrams may be lost, duplicated or reordered, see RFC 6347, section
+// 4.1.2.7.
+func (c *Conn) readRecordDTLS(want recordType) error {
+	d := c.dtls
+
+	for c.in.err == nil {
+		if want == recordTypeHandshake && c.hand.Len() > 0 ||
+			want == recordTypeApplicationData && c.input != nil {
+			return nil
+		}
+
+		b, err := c.nextRecordDTLS()
+		if err != nil {
+			return err
+		}
+		typ := recordType(b.data[0])
+		data := b.data[b.off:]
+		if len(data) > maxPlaintext || c.in.cipher != nil && len(data) > c.maxPlaintextIn() {
+			c.in.freeBlock(b)
+			return c.in.setErrorLocked(c.sendAlert(alertRecordOverflow))
+		}
+
+		switch typ {
+		case recordTypeAlert:
+			if len(data) != 2 {
+				c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+				break
+			}
+			if alert(data[1]) == alertCloseNotify {
+				c.in.setErrorLocked(io.EOF)
+				break
+			}
+			switch data[0] {
+			case alertLevelWarning:
+				// drop on the floor
+			case alertLevelError:
+				c.in.setErrorLocked(&net.OpError{Op: "remote error", Err: alert(data[1])})
+			default:
+				c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+			}
+
+		case recordTypeChangeCipherSpec:
+			// A ChangeCipherSpec that overtook the last handshake
+			// messages before it comes again with them.
+			if want != recordTypeChangeCipherSpec {
+				break
+			}
+			if len(data) != 1 || data[0] != 1 {
+				c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+				break
+			}
+			if err := c.in.changeCipherSpec(); err != nil {
+				c.in.setErrorLocked(c.sendAlert(err.(alert)))
+				break
+			}
+			d.inEpoch++
+			d.window = dtlsReplayWindow{}
+			var records []byte
+			for _, record := range d.early {
+				records = append(records, record...)
+			}
+			d.records = append(records, d.records...)
+			d.early = nil
+			c.in.freeBlock(b)
+			return nil
+
+		case recordTypeApplicationData:
+			if want != recordTypeApplicationData {
+				break
+			}
+			c.input = b
+			b = nil
+
+		case recordTypeHandshake:
+			c.handleHandshakeRecordDTLS(data, false)
+		}
+
+		if b != nil {
+			c.in.freeBlock(b)
+		}
+	}
+	return c.in.err
+}
+
+// nextRecordDTLS returns the next acceptable record of the current epoch,
+// with a TLS record header and its payload decrypted at b.off.
+func (c *Conn) nextRecordDTLS() (*block, error) {
+	d := c.dtls
+
+	for {
+		if len(d.records) < dtlsRecordHeaderLen {
+			datagram, err := c.readDatagramDTLS()
+			if err != nil {
+				return nil, err
+			}
+			d.records = datagram
+			continue
+		}
+		n := int(d.records[11])<<8 | int(d.records[12])
+		if len(d.records) < dtlsRecordHeaderLen+n {
+			d.records = nil
+			continue
+		}
+		record := d.records[:dtlsRecordHeaderLen+n]
+		d.records = d.records[dtlsRecordHeaderLen+n:]
+
+		typ := recordType(record[0])
+		epoch := uint16(record[3])<<8 | uint16(record[4])
+		var seq uint64
+		for _, b := range record[5:11] {
+			seq = seq<<8 | uint64(b)
+		}
+		if record[1] != 0xfe || n > maxCiphertext {
+			continue
+		}
+
+		switch {
+		case epoch == d.inEpoch:
+		case epoch == d.inEpoch+1:
+			if len(d.early) < dtlsMaxEarlyRecords {
+				d.early = append(d.early, append([]byte(nil), record...))
+			}
+			continue
+		case epoch == 0 && d.inEpoch == 1 && typ == recordTypeHandshake:
+			// A retransmission of the unencrypted part of the
+			// peer's last flight, which tells that ours was lost.
+			c.handleHandshakeRecordDTLS(record[dtlsRecordHeaderLen:], true)
+			continue
+		default:
+			continue
+		}
+		if !d.window.check(seq) {
+			continue
+		}
+
+		b := c.in.newBlock()
+		b.resize(recordHeaderLen + n)
+		b.data[0] = record[0]
+		b.data[1] = record[1]
+		b.data[2] = record[2]
+		b.data[3] = record[11]
+		b.data[4] = record[12]
+		copy(b.data[recordHeaderLen:], record[dtlsRecordHeaderLen:])
+
+		s := uint64(epoch)<<48 | seq
+		for i := range c.in.seq {
+			c.in.seq[i] = byte(s >> uint(56-8*i))
+		}
+		ok, off, _ := c.in.decrypt(b)
+		if !ok {
+			c.in.freeBlock(b)
+			continue
+		}
+		d.window.accept(seq)
+		d.inSeq = seq
+		b.off = off
+		return b, nil
+	}
+}
+
+// readDatagramDTLS reads the next datagram. During the handshake it
+// retransmits the last flight whenever the peer takes too long to answer.
+func (c *Conn) readDatagramDTLS() ([]byte, error) {
+	d := c.dtls
+	if d.buf == nil {
+		d.buf = make([]byte, 1<<16)
+	}
+
+	for {
+		deadline := d.getReadDeadline()
+		retransmit := false
+		if !c.handshakeComplete && len(d.flight) > 0 {
+			if t := time.Now().Add(d.timeout); deadline.IsZero() || t.Before(deadline) {
+				deadline, retransmit = t, true
+			}
+		}
+		if err := c.conn.SetReadDeadline(deadline); err != nil {
+			return nil, c.in.setErrorLocked(err)
+		}
+
+		n, err := c.conn.Read(d.buf)
+		if err == nil {
+			return d.buf[:n], nil
+		}
+		if e, ok := err.(net.Error); ok && e.Timeout() && retransmit {
+			if d.timeout >= dtlsMaxTimeout {
+				return nil, c.in.setErrorLocked(errors.New("tls: DTLS handshake timed out"))
+			}
+			if d.timeout *= 2; d.timeout > dtlsMaxTimeout {
+				d.timeout = dtlsMaxTimeout
+			}
+			if err := c.retransmitFlight(); err != nil {
+				return nil, c.in.setErrorLocked(err)
+			}
+			continue
+		}
+		if e, ok := err.(net.Error); !ok || !e.Temporary() {
+			c.in.setErrorLocked(err)
+		}
+		return nil, err
+	}
+}
+
+// handleHandshakeRecordDTLS reassembles the handshake fragments in data and
+// passes the messages on in order. A retransmission of the peer's last
+// flight means that it didn't get ours, which is then sent again. If
+// oldEpoch is set, data is from the previous epoch and can only be such a
+// retransmission.
+func (c *Conn) handleHandshakeRecordDTLS(data []byte, oldEpoch bool) {
+	d := c.dtls
+
+	retransmit := false
+	for len(data) >= dtlsHandshakeHeaderLen {
+		typ := data[0]
+		length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+		seq := uint16(data[4])<<8 | uint16(data[5])
+		offset := int(data[6])<<16 | int(data[7])<<8 | int(data[8])
+		n := int(data[9])<<16 | int(data[10])<<8 | int(data[11])
+		if len(data) < dtlsHandshakeHeaderLen+n || offset+n > length || length > maxHandshake {
+			return
+		}
+		fragment := data[dtlsHandshakeHeaderLen : dtlsHandshakeHeaderLen+n]
+		data = data[dtlsHandshakeHeaderLen+n:]
+
+		if !c.isClient && !d.started {
+			// The first ClientHello sets the message sequence
+			// numbers, which start at one after a
+			// HelloVerifyRequest. That had the record sequence
+			// number of the first ClientHello, so the records that
+			// follow continue from the second one's.
+			if typ != typeClientHello || oldEpoch {
+				continue
+			}
+			d.started = true
+			d.recvSeq = seq
+			d.sendSeq = seq
+			d.outSeq[0] = d.inSeq
+		}
+		if seq < d.recvSeq {
+			if seq == d.peerFlight && offset == 0 && len(d.flight) > 0 {
+				retransmit = true
+			}
+			continue
+		}
+		if oldEpoch || c.handshakeComplete || seq >= d.recvSeq+dtlsMaxBufferedMessages {
+			continue
+		}
+
+		m := d.messages[seq]
+		if m == nil {
+			m = &dtlsMessage{
+				typ:     typ,
+				body:    make([]byte, length),
+				have:    make([]bool, length),
+				missing: length,
+			}
+			d.messages[seq] = m
+		}
+		if m.typ != typ || len(m.body) != length {
+			continue
+		}
+		m.addFragment(offset, fragment)
+	}
+
+	for c.in.err == nil {
+		m := d.messages[d.recvSeq]
+		if m == nil || m.missing > 0 {
+			break
+		}
+		delete(d.messages, d.recvSeq)
+		d.recvSeq++
+		c.deliverHandshakeDTLS(d.recvSeq-1, m)
+	}
+
+	if retransmit && c.in.err == nil {
+		if err := c.retransmitFlight(); err != nil {
+			c.in.setErrorLocked(err)
+		}
+	}
+}
+
+// deliverHandshakeDTLS passes a reassembled handshake message on to
+// readHandshake in its TLS form. A client answers a HelloVerifyRequest here
+// by sending its ClientHello again, with the cookie.
+func (c *Conn) deliverHandshakeDTLS(seq uint16, m *dtlsMessage) {
+	d := c.dtls
+
+	if !d.flightDone {
+		d.flightDone = true
+		d.peerFlight = seq
+	}
+
+	n := len(m.body)
+	wire := make([]byte, dtlsHandshakeHeaderLen+n)
+	wire[0] = m.typ
+	wire[1] = byte(n >> 16)
+	wire[2] = byte(n >> 8)
+	wire[3] = byte(n)
+	wire[4] = byte(seq >> 8)
+	wire[5] = byte(seq)
+	wire[9] = byte(n >> 16)
+	wire[10] = byte(n >> 8)
+	wire[11] = byte(n)
+	copy(wire[dtlsHandshakeHeaderLen:], m.body)
+
+	body := m.body
+	switch m.typ {
+	case typeHelloVerifyRequest:
+		// Neither the first ClientHello nor the HelloVerifyRequest
+		// enter the transcript, see RFC 6347, section 4.2.6.
+		if !c.isClient || c.haveVers || d.cookie != nil {
+			c.in.setErrorLocked(c.sendAlert(alertUnexpectedMessage))
+			return
+		}
+		if len(body) < 3 || len(body) != 3+int(body[2]) || body[2] == 0 {
+			c.in.setErrorLocked(c.sendAlert(alertDecodeError))
+			return
+		}
+		d.cookie = append([]byte(nil), body[3:]...)
+		// A stateless server reuses record sequence numbers after a
+		// HelloVerifyRequest, which isn't authenticated anyway.
+		d.window = dtlsReplayWindow{}
+		c.out.Lock()
+		_, err := c.writeRecordDTLS(recordTypeHandshake, d.lastSent.tls)
+		c.out.Unlock()
+		if err != nil {
+			c.in.setErrorLocked(err)
+		}
+		return
+	case typeClientHello:
+		var ok bool
+		if _, body, ok = splitClientHelloCookie(body); !ok {
+			c.in.setErrorLocked(c.sendAlert(alertDecodeError))
+			return
+		}
+		fallthrough
+	case typeServerHello:
+		if len(body) >= 2 {
+			vers := tlsVersionOfDTLS(uint16(body[0])<<8 | uint16(body[1]))
+			body[0] = byte(vers >> 8)
+			body[1] = byte(vers)
+		}
+	}
+
+	n = len(body)
+	msg := make([]byte, 4+n)
+	msg[0] = m.typ
+	msg[1] = byte(n >> 16)
+	msg[2] = byte(n >> 8)
+	msg[3] = byte(n)
+	copy(msg[4:], body)
+	d.received = append(d.received, dtlsTranscriptEntry{msg, wire})
+	c.hand.Write(msg)
+}
+
+// A dtlsListener implements a network listener (net.Listener) for DTLS
+// connections. It reads the datagrams of all peers from one net.PacketConn
+// and passes them on to the Conn of their remote address. The Conns of
+// addresses that send nothing for dtlsPeerIdleTimeout are closed.
+type dtlsListener struct {
+	conn      net.PacketConn
+	config    *Config
+	cookieKey [32]byte
+
+	accept    chan *Conn
+	done      chan struct{}
+	closeOnce sync.Once
+
+	mutex sync.Mutex
+	peers map[string]*dtlsPeerConn
+	err   error // why the listener stopped
+
+	nextSweep time.Time // when to look for idle peers, used by readLoop
+}
+
+func newDTLSListener(conn net.PacketConn, config *Config) *dtlsListener {
+	l := &dtlsListener{
+		conn:   conn,
+		config: config,
+		accept: make(chan *Conn, dtlsListenerBacklog),
+		done:   make(chan struct{}),
+		peers:  make(map[string]*dtlsPeerConn),
+	}
+	if _, err := io.ReadFull(config.rand(), l.cookieKey[:]); err != nil {
+		l.stop(errors.New("tls: short read from Rand: " + err.Error()))
+		return l
+	}
+	go l.readLoop()
+	return l
+}
+
+// Accept waits for and returns the next incoming DTLS connection.
+// The returned connection is of type *Conn.
+func (l *dtlsListener) Accept() (net.Conn, error) {
+	select {
+	case c := <-l.accept:
+		return c, nil
+	case <-l.done:
+		l.mutex.Lock()
+		defer l.mutex.Unlock()
+		return nil, l.err
+	}
+}
+
+// Close stops the listener and closes the connections it accepted.
+func (l *dtlsListener) Close() error {
+	l.stop(errClosed)
+	return l.conn.Close()
+}
+
+// Addr returns the listener's network address.
+func (l *dtlsListener) Addr() net.Addr {
+	return l.conn.LocalAddr()
+}
+
+func (l *dtlsListener) stop(err error) {
+	l.closeOnce.Do(func() {
+		l.mutex.Lock()
+		l.err = err
+		l.mutex.Unlock()
+		close(l.done)
+	})
+}
+
+func (l *dtlsListener) readLoop() {
+	buf := make([]byte, 1<<16)
+	for {
+		n, addr, err := l.conn.ReadFrom(buf)
+		if err != nil {
+			if e, ok := err.(net.Error); ok && e.Temporary() {
+				continue
+			}
+			l.stop(err)
+			return
+		}
+		datagram := append([]byte(nil), buf[:n]...)
+		now := l.config.time()
+		l.closeIdlePeers(now)
+
+		l.mutex.Lock()
+		p := l.peers[addr.String()]
+		var prev *dtlsPeerConn
+		if p != nil {
+			prev = p.prev
+		}
+		l.mutex.Unlock()
+
+		_, body, ok := parseDTLSClientHello(datagram)
+		if p != nil && (!ok || bytes.Equal(body[2:34], p.random)) {
+			p.deliver(datagram, now)
+			// Until the handshake of p completes, the records of the
+			// connection it replaces go to both. Each Conn drops those
+			// that it can't decrypt.
+			if prev != nil && len(datagram) >= dtlsRecordHeaderLen && (datagram[3] != 0 || datagram[4] != 0) {
+				prev.deliver(datagram, now)
+			}
+			continue
+		}
+
+		// A ClientHello of a new connection. If it comes from the address
+		// of an existing one, the client may have lost the state of that
+		// one, see RFC 6347, section 4.2.8.
+		if !l.checkCookie(addr, datagram) {
+			continue
+		}
+		l.startConn(addr, datagram, body[2:34], p, now)
+	}
+}
+
+// startConn creates the Conn of a ClientHello with a valid cookie from addr
+// and queues it for Accept. If p isn't nil, it is the connection of addr that
+// the new one replaces once its handshake completes.
+func (l *dtlsListener) startConn(addr net.Addr, datagram, random []byte, p *dtlsPeerConn, now time.Time) {
+	np := &dtlsPeerConn{
+		l:               l,
+		addr:            addr,
+		random:          append([]byte(nil), random...),
+		queue:           make(chan []byte, dtlsPeerQueueLen),
+		closed:          make(chan struct{}),
+		deadlineChanged: make(chan struct{}),
+	}
+	np.deliver(datagram, now)
+
+	// If p itself is still waiting to replace a connection, the client has
+	// given up on p, and np replaces that connection instead.
+	var abandoned *dtlsPeerConn
+	l.mutex.Lock()
+	if p != nil && p.prev != nil {
+		abandoned, p = p, p.prev
+	}
+	np.prev = p
+	l.peers[addr.String()] = np
+	l.mutex.Unlock()
+	if abandoned != nil {
+		abandoned.Close()
+	}
+
+	c := DTLSServer(np, l.config)
+	c.dtls.onHandshakeDone = np.handshakeDone
+	select {
+	case l.accept <- c:
+	default:
+		// The backlog is full. The client will try again.
+		np.Close()
+	}
+}
+
+// closeIdlePeers closes the connections from which no datagram arrived for
+// dtlsPeerIdleTimeout. To not walk all of them for every datagram, it does
+// so at most twice per timeout.
+func (l *dtlsListener) closeIdlePeers(now time.Time) {
+	if now.Before(l.nextSweep) {
+		return
+	}
+	l.nextSweep = now.Add(dtlsPeerIdleTimeout / 2)
+
+	var idle []*dtlsPeerConn
+	l.mutex.Lock()
+	for _, p := range l.peers {
+		// Close p before the connection it replaces, so that the latter
+		// doesn't take its place again.
+		for ; p != nil; p = p.prev {
+			if now.Sub(p.lastActive) > dtlsPeerIdleTimeout {
+				idle = append(idle, p)
+			}
+		}
+	}
+	l.mutex.Unlock()
+	for _, p := range idle {
+		p.Close()
+	}
+}
+
+// parseDTLSClientHello returns the cookie and the rest of the body of the
+// ClientHello that datagram starts with, if it is in an epoch 0 record and
+// not fragmented. The body is at least 34 bytes long, so that it holds the
+// version and random.
+func parseDTLSClientHello(datagram []byte) (cookie, body []byte, ok bool) {
+	if len(datagram) < dtlsRecordHeaderLen+dtlsHandshakeHeaderLen ||
+		recordType(datagram[0]) != recordTypeHandshake || datagram[1] != 0xfe ||
+		datagram[3] != 0 || datagram[4] != 0 {
+		return nil, nil, false
+	}
+	n := int(datagram[11])<<8 | int(datagram[12])
+	if len(datagram) < dtlsRecordHeaderLen+n {
+		return nil, nil, false
+	}
+	record := datagram[dtlsRecordHeaderLen : dtlsRecordHeaderLen+n]
+	if len(record) < dtlsHandshakeHeaderLen || record[0] != typeClientHello {
+		return nil, nil, false
+	}
+	length := int(record[1])<<16 | int(record[2])<<8 | int(record[3])
+	if !bytes.Equal(record[6:9], []byte{0, 0, 0}) || !bytes.Equal(record[9:12], record[1:4]) ||
+		len(record) < dtlsHandshakeHeaderLen+length {
+		return nil, nil, false
+	}
+	return splitClientHelloCookie(record[dtlsHandshakeHeaderLen : dtlsHandshakeHeaderLen+length])
+}
+
+// checkCookie reports whether datagram, from addr, starts with a ClientHello
+// with a valid cookie. A ClientHello without one is answered with a
+// HelloVerifyRequest, see RFC 6347, section 4.2.1. Keeping no state until
+// the client proves that it receives datagrams sent to addr protects against
+// spoofed addresses. A first ClientHello that is fragmented isn't answered.
+func (l *dtlsListener) checkCookie(addr net.Addr, datagram []byte) bool {
+	cookie, body, ok := parseDTLSClientHello(datagram)
+	if !ok {
+		return false
+	}
+	record := datagram[dtlsRecordHeaderLen:]
+
+	mac := hmac.New(sha256.New, l.cookieKey[:])
+	mac.Write([]byte(addr.String()))
+	mac.Write(body)
+	expected := mac.Sum(nil)
+	if hmac.Equal(cookie, expected) {
+		return true
+	}
+
+	// The HelloVerifyRequest reuses the record and message sequence
+	// numbers of the ClientHello, and its version is DTLS 1.0 whatever
+	// the version that will be negotiated.
+	hvr := make([]byte, dtlsRecordHeaderLen+dtlsHandshakeHeaderLen+3+len(expected))
+	copy(hvr, datagram[:dtlsRecordHeaderLen])
+	hvr[1] = byte(versionDTLS10 >> 8)
+	hvr[2] = byte(versionDTLS10 & 0xff)
+	hvr[11] = 0
+	hvr[12] = byte(dtlsHandshakeHeaderLen + 3 + len(expected))
+	msg := hvr[dtlsRecordHeaderLen:]
+	msg[0] = typeHelloVerifyRequest
+	msg[3] = byte(3 + len(expected))
+	msg[4] = record[4]
+	msg[5] = record[5]
+	msg[11] = msg[3]
+	msg[12] = byte(versionDTLS10 >> 8)
+	msg[13] = byte(versionDTLS10 & 0xff)
+	msg[14] = byte(len(expected))
+	copy(msg[15:], expected)
+	l.conn.WriteTo(hvr, addr)
+	return false
+}
+
+// A dtlsPeerConn is the net.Conn of one remote address of a dtlsListener.
+type dtlsPeerConn struct {
+	l         *dtlsListener
+	addr      net.Addr
+	random    []byte // of the ClientHello that started the connection
+	queue     chan []byte
+	closed    chan struct{}
+	closeOnce sync.Once
+
+	lastActive time.Time     // when a datagram last arrived, used by readLoop
+	prev       *dtlsPeerConn // replaced when the handshake completes, guarded by l.mutex
+
+	mutex           sync.Mutex
+	deadline        time.Time
+	deadlineChanged chan struct{} // closed when the deadline changes
+}
+
+// deliver queues a datagram that arrived at now, or drops it if too many
+// are waiting.
+func (p *dtlsPeerConn) deliver(datagram []byte, now time.Time) {
+	p.lastActive = now
+	select {
+	case p.queue <- datagram:
+	default:
+	}
+}
+
+func (p *dtlsPeerConn) Read(b []byte) (int, error) {
+	for {
+		p.mutex.Lock()
+		deadline, changed := p.deadline, p.deadlineChanged
+		p.mutex.Unlock()
+
+		var timer *time.Timer
+		var expired <-chan time.Time
+		if !deadline.IsZero() {
+			d := time.Until(deadline)
+			if d <= 0 {
+				return 0, dtlsTimeoutError{}
+			}
+			timer = time.NewTimer(d)
+			expired = timer.C
+		}
+
+		var n int
+		var err error
+		select {
+		case datagram := <-p.queue:
+			n = copy(b, datagram)
+		case <-p.closed:
+			err = errClosed
+		case <-p.l.done:
+			err = errClosed
+		case <-expired:
+			err = dtlsTimeoutError{}
+		case <-changed:
+			// Wait again with the new deadline.
+			if timer != nil {
+				timer.Stop()
+			}
+			continue
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		return n, err
+	}
+}
+
+func (p *dtlsPeerConn) Write(b []byte) (int, error) {
+	return p.l.conn.WriteTo(b, p.addr)
+}
+
+func (p *dtlsPeerConn) Close() error {
+	p.closeOnce.Do(func() {
+		close(p.closed)
+		p.l.mutex.Lock()
+		if p.l.peers[p.addr.String()] == p {
+			// Unless its handshake completed, p hands the address
+			// back to the connection that it was to replace.
+			if p.prev != nil && !p.prev.isClosed() {
+				p.l.peers[p.addr.String()] = p.prev
+			} else {
+				delete(p.l.peers, p.addr.String())
+			}
+		}
+		p.prev = nil
+		p.l.mutex.Unlock()
+	})
+	return nil
+}
+
+func (p *dtlsPeerConn) isClosed() bool {
+	select {
+	case <-p.closed:
+		return true
+	default:
+		return false
+	}
+}
+
+// handshakeDone is called when a handshake of the Conn of p completes, and
+// retires the connection that p replaces.
+func (p *dtlsPeerConn) handshakeDone() {
+	p.l.mutex.Lock()
+	prev := p.prev
+	p.prev = nil
+	p.l.mutex.Unlock()
+	if prev != nil {
+		prev.Close()
+	}
+}
+
+func (p *dtlsPeerConn) LocalAddr() net.Addr  { return p.l.conn.LocalAddr() }
+func (p *dtlsPeerConn) RemoteAddr() net.Addr { return p.addr }
+
+func (p *dtlsPeerConn) SetDeadline(t time.Time) error {
+	return p.SetReadDeadline(t)
+}
+
+func (p *dtlsPeerConn) SetReadDeadline(t time.Time) error {
+	p.mutex.Lock()
+	p.deadline = t
+	close(p.deadlineChanged)
+	p.deadlineChanged = make(chan struct{})
+	p.mutex.Unlock()
+	return nil
+}
+
+// SetWriteDeadline does nothing: writes share the listener's net.PacketConn.
+func (p *dtlsPeerConn) SetWriteDeadline(t time.Time) error {
+	return nil
+}
+
+type dtlsTimeoutError struct{}
+
+func (dtlsTimeoutError) Error() string   { return "tls: i/o timeout" }
+func (dtlsTimeoutError) Timeout() bool   { return true }
+func (dtlsTimeoutError) Temporary() bool { return true }
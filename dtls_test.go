@@ -0,0 +1,475 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"errors"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testDTLSHandshake runs a DTLS handshake between DialUDP and a listener from
+// ListenUDP, after which the client sends "ping" and the server "pong".
+func testDTLSHandshake(t *testing.T, clientConfig, serverConfig *Config) (serverState, clientState ConnectionState, err error) {
+	ln, err := ListenUDP("udp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		cli, err := DialUDP("udp", ln.Addr().String(), clientConfig)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer cli.Close()
+		if _, err := cli.Write([]byte("ping")); err != nil {
+			errChan <- err
+			return
+		}
+		buf := make([]byte, 16)
+		n, err := cli.Read(buf)
+		if err == nil && string(buf[:n]) != "pong" {
+			err = errors.New("client read " + string(buf[:n]))
+		}
+		clientState = cli.ConnectionState()
+		errChan <- err
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	server := conn.(*Conn)
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err == nil && string(buf[:n]) != "ping" {
+		err = errors.New("server read " + string(buf[:n]))
+	}
+	if err == nil {
+		serverState = server.ConnectionState()
+		_, err = server.Write([]byte("pong"))
+	}
+	if clientErr := <-errChan; err == nil {
+		err = clientErr
+	}
+	server.Close()
+	return
+}
+
+func TestDTLSHandshake(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	getPSKIdentity := func(hint []byte) (string, error) { return "device", nil }
+	getPSKKey := func(identity string) ([]byte, error) {
+		if identity != "device" {
+			return nil, errors.New("unknown identity")
+		}
+		return psk, nil
+	}
+	ecdsaCert := Certificate{
+		Certificate: [][]byte{testECDSACertificate},
+		PrivateKey:  testECDSAPrivateKey,
+	}
+	// A chain too long for one datagram is sent in fragments.
+	longChain := Certificate{
+		Certificate: [][]byte{testRSACertificate, testRSACertificate, testRSACertificate},
+		PrivateKey:  testRSAPrivateKey,
+	}
+
+	tests := []struct {
+		suite uint16
+		cert  Certificate
+		psk   bool
+	}{
+		{TLS_PSK_WITH_AES_128_CCM_8, Certificate{}, true},
+		{TLS_PSK_WITH_AES_128_CBC_SHA256, Certificate{}, true},
+		{TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256, Certificate{}, true},
+		{TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256, Certificate{}, true},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8, ecdsaCert, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, ecdsaCert, false},
+		{TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384, testConfig.Certificates[0], false},
+		{TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305, longChain, false},
+		{TLS_ECDHE_RSA_WITH_AES_128_CBC_SHA, longChain, false},
+	}
+	for _, test := range tests {
+		serverConfig := &Config{
+			CipherSuites: []uint16{test.suite},
+			MaxVersion:   VersionTLS13,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{test.suite},
+			InsecureSkipVerify: true,
+		}
+		if test.psk {
+			serverConfig.GetPSKKey = getPSKKey
+			clientConfig.GetPSKIdentity = getPSKIdentity
+			clientConfig.GetPSKKey = getPSKKey
+		} else {
+			serverConfig.Certificates = []Certificate{test.cert}
+		}
+
+		serverState, clientState, err := testDTLSHandshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Errorf("%x: %s", test.suite, err)
+			continue
+		}
+		for _, state := range []ConnectionState{serverState, clientState} {
+			if state.Version != VersionDTLS12 {
+				t.Errorf("%x: got version %x", test.suite, state.Version)
+			}
+			if state.CipherSuite != test.suite {
+				t.Errorf("%x: got cipher suite %x", test.suite, state.CipherSuite)
+			}
+		}
+	}
+}
+
+func TestDTLSResumption(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+	}
+
+	_, clientState, err := testDTLSHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientState.DidResume {
+		t.Fatal("first handshake resumed")
+	}
+	serverState, clientState, err := testDTLSHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !clientState.DidResume || !serverState.DidResume {
+		t.Fatal("second handshake didn't resume")
+	}
+}
+
+func TestDTLSRejectsStreamCiphers(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		CipherSuites: []uint16{TLS_RSA_WITH_RC4_128_SHA, TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		CipherSuites:       []uint16{TLS_RSA_WITH_RC4_128_SHA, TLS_RSA_WITH_AES_128_CBC_SHA},
+	}
+	_, clientState, err := testDTLSHandshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if clientState.CipherSuite != TLS_RSA_WITH_AES_128_CBC_SHA {
+		t.Fatalf("got cipher suite %x", clientState.CipherSuite)
+	}
+}
+
+// lossyConn is a net.Conn that sends the datagrams written to it to a fixed
+// peer, dropping those that drop returns true for and sending the others
+// twice if duplicate is set.
+type lossyConn struct {
+	*net.UDPConn
+	peer      net.Addr
+	drop      func(datagram []byte) bool
+	duplicate bool
+}
+
+func (c *lossyConn) Write(b []byte) (int, error) {
+	if c.drop != nil && c.drop(b) {
+		return len(b), nil
+	}
+	if c.duplicate {
+		c.WriteTo(b, c.peer)
+	}
+	return c.WriteTo(b, c.peer)
+}
+
+func (c *lossyConn) RemoteAddr() net.Addr {
+	return c.peer
+}
+
+// dropOnce returns a drop function for lossyConn that drops the first
+// datagram that matches.
+func dropOnce(match func(datagram []byte) bool) func([]byte) bool {
+	dropped := false
+	return func(datagram []byte) bool {
+		if !dropped && match(datagram) {
+			dropped = true
+			return true
+		}
+		return false
+	}
+}
+
+func newLossyPipe(t *testing.T) (client, server *lossyConn) {
+	a, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		a.Close()
+		t.Fatal(err)
+	}
+	return &lossyConn{UDPConn: a, peer: b.LocalAddr()}, &lossyConn{UDPConn: b, peer: a.LocalAddr()}
+}
+
+func testDTLSPipe(t *testing.T, clientConn, serverConn net.Conn) error {
+	clientConfig := &Config{InsecureSkipVerify: true}
+	serverConfig := &Config{Certificates: testConfig.Certificates}
+
+	errChan := make(chan error, 1)
+	go func() {
+		cli := DTLSClient(clientConn, clientConfig)
+		defer cli.Close()
+		_, err := cli.Write([]byte("ping"))
+		errChan <- err
+	}()
+
+	server := DTLSServer(serverConn, serverConfig)
+	defer server.Close()
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err == nil && string(buf[:n]) != "ping" {
+		err = errors.New("server read " + string(buf[:n]))
+	}
+	if clientErr := <-errChan; err == nil {
+		err = clientErr
+	}
+	return err
+}
+
+func TestDTLSRetransmission(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in short mode: waits for retransmission timers")
+	}
+
+	clientConn, serverConn := newLossyPipe(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	// The ClientHello is lost, and so is the server's Finished, the first
+	// record of epoch one. The client sends its last flight again when the
+	// timer expires, which tells the server to do the same.
+	clientConn.drop = dropOnce(func(datagram []byte) bool {
+		return datagram[0] == byte(recordTypeHandshake) && datagram[dtlsRecordHeaderLen] == typeClientHello
+	})
+	serverConn.drop = dropOnce(func(datagram []byte) bool {
+		return datagram[3] == 0 && datagram[4] == 1
+	})
+	if err := testDTLSPipe(t, clientConn, serverConn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDTLSDuplicatedDatagrams(t *testing.T) {
+	clientConn, serverConn := newLossyPipe(t)
+	defer clientConn.Close()
+	defer serverConn.Close()
+
+	clientConn.duplicate = true
+	serverConn.duplicate = true
+	if err := testDTLSPipe(t, clientConn, serverConn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDTLSReplayWindow(t *testing.T) {
+	var w dtlsReplayWindow
+	steps := []struct {
+		seq uint64
+		ok  bool
+	}{
+		{5, true},
+		{5, false},
+		{3, true},
+		{3, false},
+		{70, true},
+		{6, false}, // too old
+		{7, true},
+		{7, false},
+		{69, true},
+		{1000, true},
+		{70, false},
+		{999, true},
+	}
+	for i, step := range steps {
+		if ok := w.check(step.seq); ok != step.ok {
+			t.Fatalf("#%d: check(%d) = %v, want %v", i, step.seq, ok, step.ok)
+		}
+		if step.ok {
+			w.accept(step.seq)
+		}
+	}
+}
+
+// dtlsPing connects to ln from laddr, or an ephemeral port if it is nil,
+// and sends "ping". It returns the Conn of each side and the client's socket.
+func dtlsPing(t *testing.T, ln net.Listener, clientConfig *Config, laddr *net.UDPAddr) (server, client *Conn, raw *net.UDPConn) {
+	raw, err := net.DialUDP("udp", laddr, ln.Addr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	client = DTLSClient(raw, clientConfig)
+	client.SetDeadline(time.Now().Add(10 * time.Second))
+	errChan := make(chan error, 1)
+	go func() {
+		_, err := client.Write([]byte("ping"))
+		errChan <- err
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	server = conn.(*Conn)
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("server read %q", buf[:n])
+	}
+	if err := <-errChan; err != nil {
+		t.Fatal(err)
+	}
+	return server, client, raw
+}
+
+// expectDTLSClosed checks that the listener closed the Conn of the server.
+func expectDTLSClosed(t *testing.T, server *Conn) {
+	server.SetReadDeadline(time.Now().Add(10 * time.Second))
+	_, err := server.Read(make([]byte, 16))
+	if err != errClosed {
+		t.Fatalf("got %v, want the connection to be closed", err)
+	}
+}
+
+func TestDTLSReconnect(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+	}
+	ln, err := ListenUDP("udp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	oldServer, _, raw := dtlsPing(t, ln, clientConfig, nil)
+	defer oldServer.Close()
+	// The client loses its state without closing the connection, and
+	// connects again from the same address.
+	laddr := raw.LocalAddr().(*net.UDPAddr)
+	raw.Close()
+
+	server, client, _ := dtlsPing(t, ln, clientConfig, laddr)
+	defer server.Close()
+	defer client.Close()
+	expectDTLSClosed(t, oldServer)
+
+	if _, err := server.Write([]byte("pong")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "pong" {
+		t.Fatalf("client read %q", buf[:n])
+	}
+}
+
+func TestDTLSIdlePeers(t *testing.T) {
+	var mutex sync.Mutex
+	now := time.Now()
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		Time: func() time.Time {
+			mutex.Lock()
+			defer mutex.Unlock()
+			return now
+		},
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+	}
+	ln, err := ListenUDP("udp", "127.0.0.1:0", serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	idleServer, idleClient, _ := dtlsPing(t, ln, clientConfig, nil)
+	defer idleServer.Close()
+	defer idleClient.Close()
+
+	mutex.Lock()
+	now = now.Add(dtlsPeerIdleTimeout + time.Second)
+	mutex.Unlock()
+
+	// The next datagram that arrives, from any address, makes the listener
+	// look for idle peers.
+	server, client, _ := dtlsPing(t, ln, clientConfig, nil)
+	defer server.Close()
+	defer client.Close()
+	expectDTLSClosed(t, idleServer)
+
+	if _, err := client.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, 16)
+	n, err := server.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(buf[:n]) != "ping" {
+		t.Fatalf("server read %q", buf[:n])
+	}
+}
+
+func TestDTLSClientHelloCookie(t *testing.T) {
+	d := newDTLSState()
+	d.cookie = []byte("cookie")
+	hello := &clientHelloMsg{
+		vers:               VersionTLS12,
+		random:             make([]byte, 32),
+		sessionId:          []byte{1, 2, 3},
+		cipherSuites:       []uint16{TLS_PSK_WITH_AES_128_CCM_8},
+		compressionMethods: []uint8{compressionNone},
+	}
+	msg := hello.marshal()
+	wire := d.wireMessage(msg, 1)
+	if wire[4] != 0 || wire[5] != 1 {
+		t.Fatalf("bad message_seq in %x", wire[:dtlsHandshakeHeaderLen])
+	}
+	body := wire[dtlsHandshakeHeaderLen:]
+	if vers := uint16(body[0])<<8 | uint16(body[1]); vers != VersionDTLS12 {
+		t.Fatalf("got version %x", vers)
+	}
+	cookie, rest, ok := splitClientHelloCookie(body)
+	if !ok || string(cookie) != "cookie" {
+		t.Fatalf("got cookie %q, ok %v", cookie, ok)
+	}
+	rest[0], rest[1] = msg[4], msg[5]
+	if !bytes.Equal(rest, msg[4:]) {
+		t.Fatal("ClientHello changed by the cookie")
+	}
+}
@@ -7,6 +7,7 @@ package tls
 import (
 	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/subtle"
@@ -17,6 +18,8 @@ import (
 	"net"
 	"strconv"
 	"strings"
+
+	"golang_org/x/crypto/ed25519"
 )
 
 type clientHandshakeState struct {
@@ -38,11 +41,16 @@ func (c *Conn) clientHandshake() error {
 	// This may be a renegotiation handshake, in which case some fields
 	// need to be reset.
 	c.didResume = false
+	c.extendedMasterSecret = false
 
 	if len(c.config.ServerName) == 0 && !c.config.InsecureSkipVerify {
 		return errors.New("tls: either ServerName or InsecureSkipVerify must be specified in the tls.Config")
 	}
 
+	if c.config.MaxVersion == VersionTLCP {
+		return c.tlcpClientHandshake()
+	}
+
 	nextProtosLength := 0
 	for _, proto := range c.config.NextProtos {
 		if l := len(proto); l == 0 || l > 255 {
@@ -55,6 +63,19 @@ func (c *Conn) clientHandshake() error {
 		return errors.New("tls: NextProtos values too large")
 	}
 
+	if len(c.config.SRPUsername) > 255 {
+		return errors.New("tls: SRPUsername too long")
+	}
+
+	maxFragmentLength, err := c.config.maxFragmentLengthCode()
+	if err != nil {
+		return err
+	}
+	recordSizeLimit, err := c.config.recordSizeLimit()
+	if err != nil {
+		return err
+	}
+
 	hello := &clientHelloMsg{
 		vers:                         c.config.maxVersion(),
 		compressionMethods:           []uint8{compressionNone},
@@ -67,14 +88,26 @@ func (c *Conn) clientHandshake() error {
 		nextProtoNeg:                 len(c.config.NextProtos) > 0,
 		secureRenegotiationSupported: true,
 		alpnProtocols:                c.config.NextProtos,
+		extendedMasterSecret:         c.config.ExtendedMasterSecret != ExtendedMasterSecretNever,
+		maxFragmentLength:            maxFragmentLength,
+		clientCertificateTypes:       c.config.ClientCertificateTypes,
+		serverCertificateTypes:       c.config.ServerCertificateTypes,
 	}
 
 	if c.handshakes > 0 {
 		hello.secureRenegotiation = c.clientFinished[:]
 	}
 
+	// DTLS 1.2 is the DTLS version of TLS 1.2, and there is no DTLS 1.3 yet.
+	if c.dtls != nil && hello.vers > VersionTLS12 {
+		hello.vers = VersionTLS12
+	}
+
 	possibleCipherSuites := c.config.cipherSuites()
 	hello.cipherSuites = make([]uint16, 0, len(possibleCipherSuites))
+	offerFFDHE := false
+	offerDSA := false
+	offerCBC := false
 
 NextCipherSuite:
 	for _, suiteId := range possibleCipherSuites {
@@ -87,19 +120,86 @@ NextCipherSuite:
 			if hello.vers < VersionTLS12 && suite.flags&suiteTLS12 != 0 {
 				continue
 			}
+			// TLCP cipher suites are offered by tlcpClientHandshake.
+			if suite.flags&suiteTLCP != 0 {
+				continue
+			}
+			// Stream ciphers can't be used over datagrams.
+			if c.dtls != nil && suite.flags&suiteNoDTLS != 0 {
+				continue
+			}
+			// NULL cipher suites need an explicit opt-in.
+			if suite.flags&suiteNull != 0 && !c.config.AllowNullCipherSuites {
+				continue
+			}
+			// SRP cipher suites need a username in the SRP extension.
+			if suite.flags&suiteSRP != 0 {
+				if len(c.config.SRPUsername) == 0 {
+					continue
+				}
+				hello.srpUsername = c.config.SRPUsername
+			}
+			if suite.flags&suiteDHE != 0 {
+				offerFFDHE = true
+			}
+			if suite.flags&suiteDSS != 0 {
+				offerDSA = true
+			}
+			if suite.usesCBC() {
+				offerCBC = true
+			}
 			hello.cipherSuites = append(hello.cipherSuites, suiteId)
 			continue NextCipherSuite
 		}
 	}
 
-	_, err := io.ReadFull(c.config.rand(), hello.random)
+	// RFC 7366: encrypt-then-MAC only changes CBC cipher suites.
+	hello.encryptThenMAC = offerCBC && !c.config.EncryptThenMACDisabled
+
+	// RFC 7919: clients offering DHE advertise the finite field groups they
+	// support alongside the elliptic curves.
+	if offerFFDHE {
+		curves := make([]CurveID, 0, len(hello.supportedCurves)+len(c.config.ffdhePreferences()))
+		curves = append(curves, hello.supportedCurves...)
+		hello.supportedCurves = append(curves, c.config.ffdhePreferences()...)
+	}
+
+	_, err = io.ReadFull(c.config.rand(), hello.random)
 	if err != nil {
 		c.sendAlert(alertInternalError)
 		return errors.New("tls: short read from Rand: " + err.Error())
 	}
 
 	if hello.vers >= VersionTLS12 {
-		hello.signatureAndHashes = supportedSignatureAlgorithms
+		hello.signatureAndHashes = c.config.signatureAlgorithms()
+		// DSA is only advertised when it might be used.
+		if offerDSA {
+			sigAndHashes := make([]signatureAndHash, 0, len(hello.signatureAndHashes)+len(supportedDSASignatureAlgorithms))
+			sigAndHashes = append(sigAndHashes, hello.signatureAndHashes...)
+			hello.signatureAndHashes = append(sigAndHashes, supportedDSASignatureAlgorithms...)
+		}
+	}
+
+	// TLS 1.3 is offered alongside the earlier versions, but it can't be
+	// renegotiated.
+	offerTLS13 := c.config.maxVersion() >= VersionTLS13 && c.handshakes == 0 && c.dtls == nil
+
+	// A limit meant for TLS 1.3 also counts the content type, which a TLS
+	// 1.2 server takes as one more byte of plaintext.
+	if c.config.RecordSizeLimit != 0 {
+		vers := hello.vers
+		if offerTLS13 {
+			vers = VersionTLS13
+		}
+		hello.recordSizeLimit = recordSizeLimitValue(recordSizeLimit, vers)
+	}
+
+	var hs13 *clientHandshakeStateTLS13
+	if offerTLS13 {
+		hs13 = &clientHandshakeStateTLS13{c: c, hello: hello}
+		if err := hs13.prepareHello(); err != nil {
+			return err
+		}
 	}
 
 	var session *ClientSessionState
@@ -134,21 +234,41 @@ NextCipherSuite:
 
 			versOk := candidateSession.vers >= c.config.minVersion() &&
 				candidateSession.vers <= c.config.maxVersion()
-			if versOk && cipherSuiteOk {
+
+			// A server won't resume a session with a different kind
+			// of master secret, see RFC 7627, section 5.3.
+			emsOk := candidateSession.vers >= VersionTLS13 ||
+				candidateSession.extendedMasterSecret == hello.extendedMasterSecret
+			if versOk && cipherSuiteOk && emsOk {
 				session = candidateSession
 			}
 		}
 	}
 
-	if session != nil {
+	if session != nil && session.vers < VersionTLS13 {
 		hello.sessionTicket = session.sessionTicket
 		// A random session ID is used to detect when the
 		// server accepted the ticket and is resuming a session
-		// (see RFC 5077).
-		hello.sessionId = make([]byte, 16)
-		if _, err := io.ReadFull(c.config.rand(), hello.sessionId); err != nil {
-			c.sendAlert(alertInternalError)
-			return errors.New("tls: short read from Rand: " + err.Error())
+		// (see RFC 5077). A TLS 1.3 ClientHello already has one.
+		// A session without a ticket is offered by the session ID
+		// that the server issued for it.
+		if session.sessionId != nil {
+			hello.sessionId = session.sessionId
+		} else if hello.sessionId == nil {
+			hello.sessionId = make([]byte, 16)
+			if _, err := io.ReadFull(c.config.rand(), hello.sessionId); err != nil {
+				c.sendAlert(alertInternalError)
+				return errors.New("tls: short read from Rand: " + err.Error())
+			}
+		}
+	}
+
+	if hs13 != nil {
+		if session != nil && session.vers == VersionTLS13 {
+			hs13.session = session
+		}
+		if err := hs13.offerPSKs(); err != nil {
+			return err
 		}
 	}
 
@@ -166,15 +286,35 @@ NextCipherSuite:
 		return unexpectedMessageError(serverHello, msg)
 	}
 
+	if serverHello.supportedVersion != 0 {
+		if hs13 == nil {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unsolicited supported_versions extension")
+		}
+		hs13.serverHello = serverHello
+		return hs13.handshake()
+	}
+
 	vers, ok := c.config.mutualVersion(serverHello.vers)
-	if !ok || vers < VersionTLS10 {
-		// TLS 1.0 is the minimum version supported as a client.
+	if !ok || vers < VersionTLS10 || c.dtls != nil && vers < VersionTLS12 {
+		// TLS 1.0 is the minimum version supported as a client, and
+		// DTLS 1.2 the minimum DTLS version.
 		c.sendAlert(alertProtocolVersion)
 		return fmt.Errorf("tls: server selected unsupported protocol version %x", serverHello.vers)
 	}
 	c.vers = vers
 	c.haveVers = true
 
+	// A TLS 1.3 server that negotiates an earlier version marks its random
+	// to thwart downgrade attacks, see RFC 8446, section 4.1.3.
+	if hs13 != nil {
+		canary := serverHello.random[24:]
+		if bytes.Equal(canary, downgradeCanaryTLS12) || bytes.Equal(canary, downgradeCanaryTLS11) {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: downgrade attempt detected, possibly due to a MitM attack or a broken middlebox")
+		}
+	}
+
 	suite := mutualCipherSuite(hello.cipherSuites, serverHello.cipherSuite)
 	if suite == nil {
 		c.sendAlert(alertHandshakeFailure)
@@ -189,6 +329,7 @@ NextCipherSuite:
 		finishedHash: newFinishedHash(c.vers, suite),
 		session:      session,
 	}
+	hs.finishedHash.dtls = c.dtls
 
 	isResume, err := hs.processServerHello()
 	if err != nil {
@@ -241,6 +382,12 @@ NextCipherSuite:
 		if err := hs.readSessionTicket(); err != nil {
 			return err
 		}
+		// A server that sent no ticket may still resume the session
+		// by the session ID that it issued.
+		if !hs.serverHello.ticketSupported && len(hs.serverHello.sessionId) > 0 {
+			hs.session = hs.newSession()
+			hs.session.sessionId = hs.serverHello.sessionId
+		}
 		if err := hs.readFinished(c.serverFinished[:]); err != nil {
 			return err
 		}
@@ -280,53 +427,9 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		if c.handshakes == 0 {
 			// If this is the first handshake on a connection, process and
 			// (optionally) verify the server's certificates.
-			certs := make([]*x509.Certificate, len(certMsg.certificates))
-			for i, asn1Data := range certMsg.certificates {
-				cert, err := x509.ParseCertificate(asn1Data)
-				if err != nil {
-					c.sendAlert(alertBadCertificate)
-					return errors.New("tls: failed to parse certificate from server: " + err.Error())
-				}
-				certs[i] = cert
-			}
-
-			if !c.config.InsecureSkipVerify {
-				opts := x509.VerifyOptions{
-					Roots:         c.config.RootCAs,
-					CurrentTime:   c.config.time(),
-					DNSName:       c.config.ServerName,
-					Intermediates: x509.NewCertPool(),
-				}
-
-				for i, cert := range certs {
-					if i == 0 {
-						continue
-					}
-					opts.Intermediates.AddCert(cert)
-				}
-				c.verifiedChains, err = certs[0].Verify(opts)
-				if err != nil {
-					c.sendAlert(alertBadCertificate)
-					return err
-				}
-			}
-
-			if c.config.VerifyPeerCertificate != nil {
-				if err := c.config.VerifyPeerCertificate(certMsg.certificates, c.verifiedChains); err != nil {
-					c.sendAlert(alertBadCertificate)
-					return err
-				}
-			}
-
-			switch certs[0].PublicKey.(type) {
-			case *rsa.PublicKey, *ecdsa.PublicKey:
-				break
-			default:
-				c.sendAlert(alertUnsupportedCertificate)
-				return fmt.Errorf("tls: server's certificate contains an unsupported type of public key: %T", certs[0].PublicKey)
+			if err := c.verifyServerCertificate(certMsg.certificates); err != nil {
+				return err
 			}
-
-			c.peerCertificates = certs
 		} else {
 			// This is a renegotiation handshake. We require that the
 			// server's identity (i.e. leaf certificate) is unchanged and
@@ -334,7 +437,11 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			//
 			// See https://mitls.org/pages/attacks/3SHAKE for the
 			// motivation behind this requirement.
-			if !bytes.Equal(c.peerCertificates[0].Raw, certMsg.certificates[0]) {
+			identity := c.peerRawPublicKey
+			if len(c.peerCertificates) > 0 {
+				identity = c.peerCertificates[0].Raw
+			}
+			if !bytes.Equal(identity, certMsg.certificates[0]) {
 				c.sendAlert(alertBadCertificate)
 				return errors.New("tls: server's identity changed during renegotiation")
 			}
@@ -348,6 +455,10 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	var firstPeerCert *x509.Certificate
 	if len(c.peerCertificates) > 0 {
 		firstPeerCert = c.peerCertificates[0]
+	} else if c.peerPublicKey != nil {
+		// The key agreement only looks at the public key of a raw
+		// public key.
+		firstPeerCert = &x509.Certificate{PublicKey: c.peerPublicKey}
 	}
 
 	if hs.serverHello.ocspStapling {
@@ -368,6 +479,9 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	}
 
 	keyAgreement := hs.suite.ka(c.vers)
+	if c.tlcp {
+		setKeyAgreementEncryptionCertificate(keyAgreement, c.peerCertificates[1])
+	}
 
 	skx, ok := msg.(*serverKeyExchangeMsg)
 	if ok {
@@ -391,7 +505,11 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		certRequested = true
 		hs.finishedHash.Write(certReq.marshal())
 
-		if chainToSend, err = hs.getCertificate(certReq); err != nil {
+		if c.tlcp {
+			// TLCP clients don't authenticate, so the Certificate
+			// message below stays empty.
+			chainToSend = new(Certificate)
+		} else if chainToSend, err = c.getClientCertificate(certReq); err != nil {
 			c.sendAlert(alertInternalError)
 			return err
 		}
@@ -414,7 +532,12 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 	// certificate to send.
 	if certRequested {
 		certMsg = new(certificateMsg)
-		certMsg.certificates = chainToSend.Certificate
+		certMsg.certificates, err = chainToSend.certificatesOfType(c.clientCertificateType)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		certMsg.rawPublicKey = c.clientCertificateType == CertificateTypeRawPublicKey
 		hs.finishedHash.Write(certMsg.marshal())
 		if _, err := c.writeRecord(recordTypeHandshake, certMsg.marshal()); err != nil {
 			return err
@@ -427,6 +550,8 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		c.sendAlert(alertInternalError)
 		return err
 	}
+	c.curveID = keyAgreementCurveID(keyAgreement)
+	c.pskIdentity = keyAgreementPSKIdentity(keyAgreement)
 	if ckx != nil {
 		hs.finishedHash.Write(ckx.marshal())
 		if _, err := c.writeRecord(recordTypeHandshake, ckx.marshal()); err != nil {
@@ -434,7 +559,17 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		}
 	}
 
-	if chainToSend != nil && len(chainToSend.Certificate) > 0 {
+	if c.extendedMasterSecret {
+		hs.masterSecret = extendedMasterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret, hs.finishedHash.Sum())
+	} else {
+		hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret, hs.hello.random, hs.serverHello.random)
+	}
+	if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.hello.random, hs.masterSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+
+	if certRequested && len(certMsg.certificates) > 0 {
 		certVerify := &certificateVerifyMsg{
 			hasSignatureAndHash: c.vers >= VersionTLS12,
 		}
@@ -445,18 +580,24 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			return fmt.Errorf("tls: client certificate private key of type %T does not implement crypto.Signer", chainToSend.PrivateKey)
 		}
 
-		var signatureType uint8
 		switch key.Public().(type) {
-		case *ecdsa.PublicKey:
-			signatureType = signatureECDSA
-		case *rsa.PublicKey:
-			signatureType = signatureRSA
+		case *ecdsa.PublicKey, *rsa.PublicKey:
+		case ed25519.PublicKey:
+			if c.vers < VersionTLS12 {
+				c.sendAlert(alertInternalError)
+				return errors.New("tls: Ed25519 client certificates require TLS 1.2")
+			}
+		case *rsaPSSPublicKey:
+			if c.vers < VersionTLS12 {
+				c.sendAlert(alertInternalError)
+				return errors.New("tls: RSASSA-PSS client certificates require TLS 1.2")
+			}
 		default:
 			c.sendAlert(alertInternalError)
 			return fmt.Errorf("tls: failed to sign handshake with client certificate: unknown client certificate key type: %T", key)
 		}
 
-		certVerify.signatureAndHash, err = hs.finishedHash.selectClientCertSignatureAlgorithm(certReq.signatureAndHashes, signatureType)
+		certVerify.signatureAndHash, err = hs.finishedHash.selectClientCertSignatureAlgorithm(c.config, key.Public(), certReq.signatureAndHashes)
 		if err != nil {
 			c.sendAlert(alertInternalError)
 			return err
@@ -466,7 +607,7 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 			c.sendAlert(alertInternalError)
 			return err
 		}
-		certVerify.signature, err = key.Sign(c.config.rand(), digest, hashFunc)
+		certVerify.signature, err = signHandshake(c.config.rand(), key, certVerify.signatureAndHash, hashFunc, digest)
 		if err != nil {
 			c.sendAlert(alertInternalError)
 			return err
@@ -478,14 +619,92 @@ func (hs *clientHandshakeState) doFullHandshake() error {
 		}
 	}
 
-	hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret, hs.hello.random, hs.serverHello.random)
-	if err := c.config.writeKeyLog(hs.hello.random, hs.masterSecret); err != nil {
-		c.sendAlert(alertInternalError)
-		return errors.New("tls: failed to write to key log: " + err.Error())
+	hs.finishedHash.discardHandshakeBuffer()
+
+	return nil
+}
+
+// verifyServerCertificate parses and, unless InsecureSkipVerify is set,
+// verifies the server's certificate chain, and sets c.peerCertificates. If
+// the server authenticates with a raw public key, certificates holds that key
+// instead, and c.peerRawPublicKey is set.
+func (c *Conn) verifyServerCertificate(certificates [][]byte) error {
+	if c.serverCertificateType == CertificateTypeRawPublicKey {
+		_, err := c.processRawPublicKey(certificates[0], !c.config.InsecureSkipVerify)
+		return err
 	}
 
-	hs.finishedHash.discardHandshakeBuffer()
+	// A TLCP server sends its signing and its encryption certificate
+	// first, followed by the rest of the signing chain.
+	if c.tlcp && len(certificates) < 2 {
+		c.sendAlert(alertBadCertificate)
+		return errors.New("tls: server didn't send a TLCP encryption certificate")
+	}
+
+	certs := make([]*x509.Certificate, len(certificates))
+	for i, asn1Data := range certificates {
+		cert, err := parseCertificate(asn1Data)
+		if err != nil {
+			c.sendAlert(alertBadCertificate)
+			return errors.New("tls: failed to parse certificate from server: " + err.Error())
+		}
+		certs[i] = cert
+	}
+
+	if !c.config.InsecureSkipVerify {
+		opts := x509.VerifyOptions{
+			Roots:         c.config.RootCAs,
+			CurrentTime:   c.config.time(),
+			DNSName:       c.config.ServerName,
+			Intermediates: x509.NewCertPool(),
+		}
+
+		for i, cert := range certs {
+			if i == 0 || c.tlcp && i == 1 {
+				continue
+			}
+			opts.Intermediates.AddCert(cert)
+		}
+		var err error
+		c.verifiedChains, err = certs[0].Verify(opts)
+		if err != nil {
+			c.sendAlert(alertBadCertificate)
+			return err
+		}
+		if c.tlcp {
+			// The encryption certificate needn't name the server,
+			// it is bound to it by the ServerKeyExchange signature.
+			opts.DNSName = ""
+			if _, err := certs[1].Verify(opts); err != nil {
+				c.sendAlert(alertBadCertificate)
+				return err
+			}
+		}
+	}
 
+	if c.config.VerifyPeerCertificate != nil {
+		if err := c.config.VerifyPeerCertificate(certificates, c.verifiedChains); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return err
+		}
+	}
+
+	switch certs[0].PublicKey.(type) {
+	case *rsa.PublicKey, *rsaPSSPublicKey, *ecdsa.PublicKey, ed25519.PublicKey, *dsa.PublicKey:
+		break
+	case *sm2PublicKey:
+		if c.tlcp {
+			if _, ok := certs[1].PublicKey.(*sm2PublicKey); !ok {
+				c.sendAlert(alertUnsupportedCertificate)
+				return fmt.Errorf("tls: server's TLCP encryption certificate contains an unsupported type of public key: %T", certs[1].PublicKey)
+			}
+		}
+	default:
+		c.sendAlert(alertUnsupportedCertificate)
+		return fmt.Errorf("tls: server's certificate contains an unsupported type of public key: %T", certs[0].PublicKey)
+	}
+
+	c.peerCertificates = certs
 	return nil
 }
 
@@ -506,8 +725,8 @@ func (hs *clientHandshakeState) establishKeys() error {
 		serverCipher = hs.suite.aead(serverKey, serverIV)
 	}
 
-	c.in.prepareCipherSpec(c.vers, serverCipher, serverHash)
-	c.out.prepareCipherSpec(c.vers, clientCipher, clientHash)
+	c.in.prepareCipherSpec(c.vers, serverCipher, serverHash, hs.serverHello.encryptThenMAC)
+	c.out.prepareCipherSpec(c.vers, clientCipher, clientHash, hs.serverHello.encryptThenMAC)
 	return nil
 }
 
@@ -534,6 +753,25 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		}
 	}
 
+	if hs.serverHello.extendedMasterSecret && !hs.hello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server sent an unrequested extended master secret extension")
+	}
+	if c.config.ExtendedMasterSecret == ExtendedMasterSecretRequired && !hs.serverHello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server doesn't support the extended master secret")
+	}
+	c.extendedMasterSecret = hs.serverHello.extendedMasterSecret
+
+	if err := c.clientRecordSizeLimits(hs.hello, hs.serverHello.maxFragmentLength, hs.serverHello.recordSizeLimit); err != nil {
+		return false, err
+	}
+
+	if err := c.clientCertificateTypes(hs.hello, hs.serverHello.clientCertificateTypePresent, hs.serverHello.clientCertificateType,
+		hs.serverHello.serverCertificateTypePresent, hs.serverHello.serverCertificateType); err != nil {
+		return false, err
+	}
+
 	if c.handshakes > 0 && c.secureRenegotiation {
 		var expectedSecureRenegotiation [24]byte
 		copy(expectedSecureRenegotiation[:], c.clientFinished[:])
@@ -570,6 +808,11 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 	}
 	c.scts = hs.serverHello.scts
 
+	if hs.serverHello.encryptThenMAC && (!hs.hello.encryptThenMAC || !hs.suite.usesCBC()) {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server selected encrypt-then-MAC for a cipher suite without CBC")
+	}
+
 	if !hs.serverResumedSession() {
 		return false, nil
 	}
@@ -584,10 +827,29 @@ func (hs *clientHandshakeState) processServerHello() (bool, error) {
 		return false, errors.New("tls: server resumed a session with a different cipher suite")
 	}
 
+	if hs.session.extendedMasterSecret != hs.serverHello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server resumed a session with a different kind of master secret")
+	}
+
+	if hs.session.encryptThenMAC != hs.serverHello.encryptThenMAC {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server resumed a session with a different encrypt-then-MAC setting")
+	}
+
+	if (hs.session.serverRawPublicKey != nil) != (c.serverCertificateType == CertificateTypeRawPublicKey) {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: server resumed a session with a different certificate type")
+	}
+
 	// Restore masterSecret and peerCerts from previous state
 	hs.masterSecret = hs.session.masterSecret
 	c.peerCertificates = hs.session.serverCertificates
 	c.verifiedChains = hs.session.verifiedChains
+	c.peerRawPublicKey = hs.session.serverRawPublicKey
+	c.peerPublicKey = hs.session.serverPublicKey
+	c.pskIdentity = hs.session.pskIdentity
+	c.curveID = hs.session.curveID
 	return true, nil
 }
 
@@ -637,18 +899,34 @@ func (hs *clientHandshakeState) readSessionTicket() error {
 	}
 	hs.finishedHash.Write(sessionTicketMsg.marshal())
 
-	hs.session = &ClientSessionState{
-		sessionTicket:      sessionTicketMsg.ticket,
-		vers:               c.vers,
-		cipherSuite:        hs.suite.id,
-		masterSecret:       hs.masterSecret,
-		serverCertificates: c.peerCertificates,
-		verifiedChains:     c.verifiedChains,
-	}
+	hs.session = hs.newSession()
+	hs.session.sessionTicket = sessionTicketMsg.ticket
 
 	return nil
 }
 
+// newSession returns the state of the session that was just established, to
+// be resumed later with a ticket or session ID.
+func (hs *clientHandshakeState) newSession() *ClientSessionState {
+	c := hs.c
+	session := &ClientSessionState{
+		vers:                 c.vers,
+		cipherSuite:          hs.suite.id,
+		masterSecret:         hs.masterSecret,
+		serverCertificates:   c.peerCertificates,
+		verifiedChains:       c.verifiedChains,
+		serverRawPublicKey:   c.peerRawPublicKey,
+		serverPublicKey:      c.peerPublicKey,
+		encryptThenMAC:       hs.serverHello.encryptThenMAC,
+		extendedMasterSecret: c.extendedMasterSecret,
+		pskIdentity:          c.pskIdentity,
+	}
+	if c.pskIdentity != nil {
+		session.curveID = c.curveID
+	}
+	return session
+}
+
 func (hs *clientHandshakeState) sendFinished(out []byte) error {
 	c := hs.c
 
@@ -691,9 +969,7 @@ const (
 	tls11SignatureSchemesNumRSA = 4
 )
 
-func (hs *clientHandshakeState) getCertificate(certReq *certificateRequestMsg) (*Certificate, error) {
-	c := hs.c
-
+func (c *Conn) getClientCertificate(certReq *certificateRequestMsg) (*Certificate, error) {
 	var rsaAvail, ecdsaAvail bool
 	for _, certType := range certReq.certificateTypes {
 		switch certType {
@@ -732,6 +1008,15 @@ func (hs *clientHandshakeState) getCertificate(certReq *certificateRequestMsg) (
 		})
 	}
 
+	// A raw public key has no issuer to match against the certificate
+	// authorities, so the first one is sent.
+	if c.clientCertificateType == CertificateTypeRawPublicKey {
+		if len(c.config.Certificates) > 0 {
+			return &c.config.Certificates[0], nil
+		}
+		return new(Certificate), nil
+	}
+
 	// RFC 4346 on the certificateAuthorities field: A list of the
 	// distinguished names of acceptable certificate authorities.
 	// These distinguished names may specify a desired
@@ -758,15 +1043,19 @@ findCert:
 			// node, or if chain.Leaf was nil
 			if j != 0 || x509Cert == nil {
 				var err error
-				if x509Cert, err = x509.ParseCertificate(cert); err != nil {
+				if x509Cert, err = parseCertificate(cert); err != nil {
 					c.sendAlert(alertInternalError)
 					return nil, errors.New("tls: failed to parse client certificate #" + strconv.Itoa(i) + ": " + err.Error())
 				}
 			}
 
+			_, isEd25519 := x509Cert.PublicKey.(ed25519.PublicKey)
+			_, isRSAPSS := x509Cert.PublicKey.(*rsaPSSPublicKey)
 			switch {
-			case rsaAvail && x509Cert.PublicKeyAlgorithm == x509.RSA:
+			case rsaAvail && isRSAPSS && certReq.hasSignatureAndHash:
+			case rsaAvail && x509Cert.PublicKeyAlgorithm == x509.RSA && !isRSAPSS:
 			case ecdsaAvail && x509Cert.PublicKeyAlgorithm == x509.ECDSA:
+			case ecdsaAvail && isEd25519 && certReq.hasSignatureAndHash:
 			default:
 				continue findCert
 			}
@@ -798,6 +1087,76 @@ func clientSessionCacheKey(serverAddr net.Addr, config *Config) string {
 	return serverAddr.String()
 }
 
+// clientRecordSizeLimits checks the server's answer to the
+// max_fragment_length and record_size_limit extensions of hello and sets the
+// record size limits of the connection.
+func (c *Conn) clientRecordSizeLimits(hello *clientHelloMsg, maxFragmentLength uint8, recordSizeLimit uint16) error {
+	c.maxFragmentLength = 0
+	c.recordSizeLimit = 0
+	c.peerRecordSizeLimit = 0
+
+	if recordSizeLimit != 0 {
+		if hello.recordSizeLimit == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unrequested record size limit")
+		}
+		if recordSizeLimit < minRecordSizeLimit {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server sent an invalid record size limit")
+		}
+		c.recordSizeLimit = recordSizeLimitPlaintext(hello.recordSizeLimit, c.vers)
+		c.peerRecordSizeLimit = recordSizeLimitPlaintext(recordSizeLimit, c.vers)
+	}
+
+	if maxFragmentLength != 0 {
+		if maxFragmentLength != hello.maxFragmentLength {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server sent a different max fragment length")
+		}
+		c.maxFragmentLength = 1 << (8 + maxFragmentLength)
+	}
+
+	return nil
+}
+
+// clientCertificateTypes checks the server's answer to the
+// client_certificate_type and server_certificate_type extensions of hello
+// and sets the certificate types of the connection. Without an answer,
+// X.509 certificates are used, see RFC 7250, section 4.2.
+func (c *Conn) clientCertificateTypes(hello *clientHelloMsg, clientTypePresent bool, clientType CertificateType, serverTypePresent bool, serverType CertificateType) error {
+	c.clientCertificateType = CertificateTypeX509
+	c.serverCertificateType = CertificateTypeX509
+
+	if clientTypePresent {
+		if len(hello.clientCertificateTypes) == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unrequested client certificate type")
+		}
+		if _, ok := mutualCertificateType([]CertificateType{clientType}, hello.clientCertificateTypes); !ok {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an unoffered client certificate type")
+		}
+		c.clientCertificateType = clientType
+	}
+
+	if serverTypePresent {
+		if len(hello.serverCertificateTypes) == 0 {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server sent an unrequested server certificate type")
+		}
+		if _, ok := mutualCertificateType([]CertificateType{serverType}, hello.serverCertificateTypes); !ok {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an unoffered server certificate type")
+		}
+		c.serverCertificateType = serverType
+	} else if _, ok := mutualCertificateType([]CertificateType{CertificateTypeX509}, certificateTypes(hello.serverCertificateTypes)); !ok {
+		c.sendAlert(alertUnsupportedCertificate)
+		return errors.New("tls: server doesn't support the offered server certificate types")
+	}
+
+	return nil
+}
+
 // mutualProtocol finds the mutual Next Protocol Negotiation or ALPN protocol
 // given list of possible protocols and a list of the preference order. The
 // first list must not be empty. It returns the resulting protocol and flag
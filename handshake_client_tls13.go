@@ -0,0 +1,740 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"io"
+	"time"
+)
+
+// clientHandshakeStateTLS13 contains details of a TLS 1.3 client handshake in
+// progress. clientHandshake prepares the ClientHello with it and hands over
+// to handshake if the server selects TLS 1.3.
+type clientHandshakeStateTLS13 struct {
+	c            *Conn
+	hello        *clientHelloMsg
+	serverHello  *serverHelloMsg
+	ecdhe        *ecdheKeyAgreement  // owns the key share in hello
+	session      *ClientSessionState // the session ticket offered, if any
+	psks         []offeredPSK        // the PSKs offered, in the order of hello
+	hrrPrefix    []byte              // the transcript of a HelloRetryRequest, if any
+	sentDummyCCS bool
+
+	suite           *cipherSuiteTLS13
+	transcript      hash.Hash
+	usingPSK        *offeredPSK
+	handshakeSecret []byte
+	masterSecret    []byte
+	trafficSecret   []byte // client handshake traffic secret
+	appSecret       []byte // client application traffic secret
+	certReq         *certificateRequestMsgTLS13
+}
+
+// An offeredPSK is a PSK that the client offers in its ClientHello, either a
+// session ticket or the external PSK.
+type offeredPSK struct {
+	identity     pskIdentity
+	key          []byte
+	suite        *cipherSuiteTLS13 // a suite with the hash of the PSK
+	isResumption bool
+}
+
+// prepareHello adds TLS 1.3 to hs.hello, which was built for TLS 1.2 and
+// earlier: the versions, cipher suites and signature algorithms, and a key
+// share for the most preferred curve.
+func (hs *clientHandshakeStateTLS13) prepareHello() error {
+	c := hs.c
+	hello := hs.hello
+
+	// TLS 1.3 is negotiated in supported_versions and the legacy version
+	// field stays at TLS 1.2, see RFC 8446, section 4.1.2.
+	hello.vers = VersionTLS12
+	hello.supportedVersions = c.config.supportedVersions()
+
+	suites := c.config.cipherSuitesTLS13()
+	cipherSuites := make([]uint16, 0, len(suites)+len(hello.cipherSuites))
+	cipherSuites = append(cipherSuites, suites...)
+	hello.cipherSuites = append(cipherSuites, hello.cipherSuites...)
+
+	sigAndHashes := make([]signatureAndHash, 0, len(hello.signatureAndHashes)+len(supportedSignatureAlgorithmsTLS13))
+	sigAndHashes = append(sigAndHashes, hello.signatureAndHashes...)
+	for _, sah := range supportedSignatureAlgorithmsTLS13 {
+		if !isSupportedSignatureAndHash(sah, sigAndHashes) {
+			sigAndHashes = append(sigAndHashes, sah)
+		}
+	}
+	hello.signatureAndHashes = sigAndHashes
+
+	for _, curve := range hello.supportedCurves {
+		if curveSupportedTLS13(curve) {
+			if err := hs.generateKeyShare(curve); err != nil {
+				c.sendAlert(alertInternalError)
+				return err
+			}
+			break
+		}
+	}
+	if hs.ecdhe == nil {
+		return errors.New("tls: CurvePreferences includes no curve supported in TLS 1.3")
+	}
+
+	hello.pskModes = []uint8{pskModeDHE, pskModePlain}
+
+	// A random legacy session ID makes the handshake look like a TLS 1.2
+	// resumption to middleboxes, see RFC 8446, appendix D.4.
+	hello.sessionId = make([]byte, 32)
+	if _, err := io.ReadFull(c.config.rand(), hello.sessionId); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: short read from Rand: " + err.Error())
+	}
+
+	return nil
+}
+
+// generateKeyShare replaces the key share in hs.hello with a fresh one for
+// curve.
+func (hs *clientHandshakeStateTLS13) generateKeyShare(curve CurveID) error {
+	ka := &ecdheKeyAgreement{curveid: curve}
+	params, err := ka.generateECDHParams(hs.c.config)
+	if err != nil {
+		return err
+	}
+	hs.ecdhe = ka
+	// Skip the curve_type, named_curve and length of the ServerECDHParams.
+	hs.hello.keyShares = []keyShare{{group: curve, data: params[4:]}}
+	return nil
+}
+
+// offerPSKs adds the session ticket in hs.session, if it is still valid, and
+// the external PSK from the GetPSKIdentity and GetPSKKey callbacks to the
+// ClientHello. As the binders cover the rest of the message, this must be
+// the last change to it.
+func (hs *clientHandshakeStateTLS13) offerPSKs() error {
+	c := hs.c
+
+	if session := hs.session; session != nil {
+		suite := cipherSuiteTLS13ByID(session.cipherSuite)
+		now := c.config.time()
+		if suite != nil && now.Before(session.useBy) {
+			age := uint32(now.Sub(session.receivedAt) / time.Millisecond)
+			hs.psks = append(hs.psks, offeredPSK{
+				identity: pskIdentity{
+					label:               session.sessionTicket,
+					obfuscatedTicketAge: age + session.ageAdd,
+				},
+				key:          session.masterSecret,
+				suite:        suite,
+				isResumption: true,
+			})
+		} else {
+			hs.session = nil
+		}
+	}
+
+	if c.config.GetPSKIdentity != nil && c.config.GetPSKKey != nil {
+		identity, err := c.config.GetPSKIdentity(nil)
+		if err != nil {
+			return err
+		}
+		key, err := c.config.GetPSKKey(identity)
+		if err != nil {
+			return err
+		}
+		if len(identity) == 0 || len(key) == 0 {
+			return errors.New("tls: empty PSK identity or key")
+		}
+		// External PSKs are assumed to use SHA-256, see RFC 8446,
+		// section 4.2.11.
+		hs.psks = append(hs.psks, offeredPSK{
+			identity: pskIdentity{label: []byte(identity)},
+			key:      key,
+			suite:    cipherSuiteTLS13ByID(TLS_AES_128_GCM_SHA256),
+		})
+	}
+
+	hs.writeBinders()
+	return nil
+}
+
+// writeBinders sets the PSK identities and binders of hs.hello from hs.psks.
+func (hs *clientHandshakeStateTLS13) writeBinders() {
+	hello := hs.hello
+	hello.raw = nil
+	hello.pskIdentities = nil
+	hello.pskBinders = nil
+	if len(hs.psks) == 0 {
+		return
+	}
+
+	// The binders are computed over the ClientHello without them, so
+	// marshal it first with placeholders of the right length.
+	binders := make([][]byte, len(hs.psks))
+	for _, psk := range hs.psks {
+		hello.pskIdentities = append(hello.pskIdentities, psk.identity)
+		hello.pskBinders = append(hello.pskBinders, make([]byte, psk.suite.hash.Size()))
+	}
+	partialHello := hello.marshalWithoutBinders()
+	for i, psk := range hs.psks {
+		transcript := psk.suite.hash.New()
+		transcript.Write(hs.hrrPrefix)
+		transcript.Write(partialHello)
+		binders[i] = psk.suite.binder(psk.key, psk.isResumption, transcript)
+	}
+	hello.updateBinders(binders)
+}
+
+// handshake completes a TLS 1.3 handshake once hs.serverHello, which may be
+// a HelloRetryRequest, has been received.
+func (hs *clientHandshakeStateTLS13) handshake() error {
+	c := hs.c
+
+	if err := hs.checkServerHelloOrHRR(); err != nil {
+		return err
+	}
+	c.vers = VersionTLS13
+	c.haveVers = true
+
+	if bytes.Equal(hs.serverHello.random, helloRetryRequestRandom) {
+		if err := hs.processHelloRetryRequest(); err != nil {
+			return err
+		}
+	}
+
+	if err := hs.processServerHello(); err != nil {
+		return err
+	}
+	if err := hs.readServerParameters(); err != nil {
+		return err
+	}
+	if err := hs.readServerCertificate(); err != nil {
+		return err
+	}
+	if err := hs.readServerFinished(); err != nil {
+		return err
+	}
+
+	c.buffering = true
+	if err := hs.sendDummyChangeCipherSpec(); err != nil {
+		return err
+	}
+	c.out.setTrafficSecret(hs.suite, hs.trafficSecret)
+	if err := hs.sendClientCertificate(); err != nil {
+		return err
+	}
+	if err := hs.sendClientFinished(); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+
+	c.handshakeComplete = true
+	return nil
+}
+
+// checkServerHelloOrHRR does the checks common to a ServerHello and a
+// HelloRetryRequest.
+func (hs *clientHandshakeStateTLS13) checkServerHelloOrHRR() error {
+	c := hs.c
+
+	if hs.serverHello.supportedVersion != VersionTLS13 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server selected an invalid version")
+	}
+
+	if hs.serverHello.vers != VersionTLS12 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent an incorrect legacy version")
+	}
+
+	if hs.serverHello.compressionMethod != compressionNone {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server selected unsupported compression format")
+	}
+
+	if !bytes.Equal(hs.hello.sessionId, hs.serverHello.sessionId) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server did not echo the legacy session ID")
+	}
+
+	if hs.serverHello.nextProtoNeg || hs.serverHello.ocspStapling || hs.serverHello.ticketSupported ||
+		len(hs.serverHello.alpnProtocol) > 0 || len(hs.serverHello.scts) > 0 {
+		c.sendAlert(alertUnsupportedExtension)
+		return errors.New("tls: server sent a ServerHello extension forbidden in TLS 1.3")
+	}
+
+	suite := mutualCipherSuiteTLS13(hs.hello.cipherSuites, hs.serverHello.cipherSuite)
+	if suite == nil {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server chose an unconfigured cipher suite")
+	}
+	if hs.suite != nil && suite != hs.suite {
+		// RFC 8446, section 4.1.4 requires the ServerHello to use the
+		// cipher suite of the HelloRetryRequest.
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server changed cipher suite after a HelloRetryRequest")
+	}
+	hs.suite = suite
+	c.cipherSuite = suite.id
+
+	return nil
+}
+
+// processHelloRetryRequest handles a HelloRetryRequest by sending a new
+// ClientHello and reading the ServerHello that follows it.
+func (hs *clientHandshakeStateTLS13) processHelloRetryRequest() error {
+	c := hs.c
+	hrr := hs.serverHello
+
+	if hrr.selectedGroup == 0 && len(hrr.cookie) == 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent an unnecessary HelloRetryRequest message")
+	}
+	if hrr.serverShare.group != 0 || hrr.selectedIdentityPresent {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent a key share or PSK in a HelloRetryRequest")
+	}
+
+	// The first ClientHello is replaced in the transcript by the
+	// synthetic message_hash message, see RFC 8446, section 4.4.1.
+	h := hs.suite.hash.New()
+	h.Write(hs.hello.marshal())
+	chHash := h.Sum(nil)
+	hs.hrrPrefix = append([]byte{typeMessageHash, 0, 0, uint8(len(chHash))}, chHash...)
+	hs.hrrPrefix = append(hs.hrrPrefix, hrr.marshal()...)
+
+	hs.hello.cookie = hrr.cookie
+
+	if curve := hrr.selectedGroup; curve != 0 {
+		curveOk := false
+		for _, id := range hs.hello.supportedCurves {
+			if id == curve && curveSupportedTLS13(id) {
+				curveOk = true
+				break
+			}
+		}
+		if !curveOk || curve == hs.ecdhe.curveid {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an unsupported or already offered group")
+		}
+		if err := hs.generateKeyShare(curve); err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+	}
+
+	// PSKs that can't be used with the selected cipher suite are dropped,
+	// and the binders of the others now cover the HelloRetryRequest.
+	psks := hs.psks[:0]
+	for _, psk := range hs.psks {
+		if psk.suite.hash == hs.suite.hash {
+			psks = append(psks, psk)
+		}
+	}
+	hs.psks = psks
+	hs.writeBinders()
+
+	if err := hs.sendDummyChangeCipherSpec(); err != nil {
+		return err
+	}
+	if _, err := c.writeRecord(recordTypeHandshake, hs.hello.marshal()); err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	serverHello, ok := msg.(*serverHelloMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(serverHello, msg)
+	}
+	if bytes.Equal(serverHello.random, helloRetryRequestRandom) {
+		c.sendAlert(alertUnexpectedMessage)
+		return errors.New("tls: server sent two HelloRetryRequest messages")
+	}
+	hs.serverHello = serverHello
+
+	return hs.checkServerHelloOrHRR()
+}
+
+// processServerHello checks the key exchange selected by the server and
+// installs the handshake traffic keys.
+func (hs *clientHandshakeStateTLS13) processServerHello() error {
+	c := hs.c
+	serverHello := hs.serverHello
+
+	if len(serverHello.cookie) != 0 || serverHello.selectedGroup != 0 {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: server sent a HelloRetryRequest extension in a ServerHello")
+	}
+
+	hs.transcript = hs.suite.hash.New()
+	hs.transcript.Write(hs.hrrPrefix)
+	hs.transcript.Write(hs.hello.marshal())
+	hs.transcript.Write(serverHello.marshal())
+
+	var psk []byte
+	if serverHello.selectedIdentityPresent {
+		if int(serverHello.selectedIdentity) >= len(hs.psks) {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an invalid PSK")
+		}
+		hs.usingPSK = &hs.psks[serverHello.selectedIdentity]
+		if hs.usingPSK.suite.hash != hs.suite.hash {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected an invalid PSK and cipher suite pair")
+		}
+		psk = hs.usingPSK.key
+		if !hs.usingPSK.isResumption {
+			c.pskIdentity = hs.usingPSK.identity.label
+		}
+	}
+
+	var sharedKey []byte
+	if serverHello.serverShare.group != 0 {
+		if serverHello.serverShare.group != hs.ecdhe.curveid {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: server selected unsupported group")
+		}
+		var err error
+		sharedKey, err = hs.ecdhe.sharedSecret(serverHello.serverShare.data)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid server key share")
+		}
+		c.curveID = hs.ecdhe.curveid
+	} else if hs.usingPSK == nil {
+		// Only psk_ke does without a key share.
+		c.sendAlert(alertMissingExtension)
+		return errors.New("tls: server sent neither a key share nor a PSK")
+	}
+
+	if hs.usingPSK != nil && hs.usingPSK.isResumption {
+		c.didResume = true
+		c.peerCertificates = hs.session.serverCertificates
+		c.verifiedChains = hs.session.verifiedChains
+		c.peerRawPublicKey = hs.session.serverRawPublicKey
+		c.peerPublicKey = hs.session.serverPublicKey
+		c.pskIdentity = hs.session.pskIdentity
+	}
+
+	earlySecret := hs.suite.earlySecret(psk)
+	hs.handshakeSecret = hs.suite.handshakeSecret(earlySecret, sharedKey)
+
+	clientSecret := hs.suite.deriveSecret(hs.handshakeSecret, clientHandshakeTrafficLabel, hs.transcript)
+	serverSecret := hs.suite.deriveSecret(hs.handshakeSecret, serverHandshakeTrafficLabel, hs.transcript)
+	hs.trafficSecret = clientSecret
+	c.in.setTrafficSecret(hs.suite, serverSecret)
+
+	if err := c.config.writeKeyLog(keyLogLabelClientHandshake, hs.hello.random, clientSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+	if err := c.config.writeKeyLog(keyLogLabelServerHandshake, hs.hello.random, serverSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+
+	hs.masterSecret = hs.suite.masterSecret(hs.handshakeSecret)
+
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) readServerParameters() error {
+	c := hs.c
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	encryptedExtensions, ok := msg.(*encryptedExtensionsMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(encryptedExtensions, msg)
+	}
+	hs.transcript.Write(encryptedExtensions.marshal())
+
+	if proto := encryptedExtensions.alpnProtocol; len(proto) > 0 {
+		if _, fallback := mutualProtocol(hs.hello.alpnProtocols, []string{proto}); len(hs.hello.alpnProtocols) == 0 || fallback {
+			c.sendAlert(alertUnsupportedExtension)
+			return errors.New("tls: server advertised unrequested ALPN protocol")
+		}
+		c.clientProtocol = proto
+		c.clientProtocolFallback = false
+	}
+
+	if err := c.clientRecordSizeLimits(hs.hello, encryptedExtensions.maxFragmentLength, encryptedExtensions.recordSizeLimit); err != nil {
+		return err
+	}
+
+	// Without certificates there are no certificate types to agree on.
+	if hs.usingPSK != nil {
+		return nil
+	}
+	return c.clientCertificateTypes(hs.hello, encryptedExtensions.clientCertificateTypePresent, encryptedExtensions.clientCertificateType,
+		encryptedExtensions.serverCertificateTypePresent, encryptedExtensions.serverCertificateType)
+}
+
+func (hs *clientHandshakeStateTLS13) readServerCertificate() error {
+	c := hs.c
+
+	// Either the PSK authenticates the server or its certificate does.
+	if hs.usingPSK != nil {
+		return nil
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+
+	certReq, ok := msg.(*certificateRequestMsgTLS13)
+	if ok {
+		hs.transcript.Write(certReq.marshal())
+		hs.certReq = certReq
+
+		msg, err = c.readHandshake()
+		if err != nil {
+			return err
+		}
+	}
+
+	certMsg, ok := msg.(*certificateMsgTLS13)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certMsg, msg)
+	}
+	if len(certMsg.certificates) == 0 {
+		c.sendAlert(alertDecodeError)
+		return errors.New("tls: received empty certificates message")
+	}
+	hs.transcript.Write(certMsg.marshal())
+
+	c.scts = certMsg.scts
+	c.ocspResponse = certMsg.ocspStaple
+
+	if err := c.verifyServerCertificate(certMsg.certificates); err != nil {
+		return err
+	}
+
+	msg, err = c.readHandshake()
+	if err != nil {
+		return err
+	}
+	certVerify, ok := msg.(*certificateVerifyMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certVerify, msg)
+	}
+
+	if !isSupportedSignatureAndHash(certVerify.signatureAndHash, hs.hello.signatureAndHashes) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: invalid signature algorithm in certificate verify")
+	}
+	pub := c.peerPublicKey
+	if len(c.peerCertificates) > 0 {
+		pub = c.peerCertificates[0].PublicKey
+	}
+	scheme := SignatureScheme(certVerify.signatureAndHash.hash)<<8 | SignatureScheme(certVerify.signatureAndHash.signature)
+	if err := verifyTLS13(pub, scheme, serverSignatureContext, hs.transcript, certVerify.signature); err != nil {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: invalid signature by the server certificate: " + err.Error())
+	}
+	hs.transcript.Write(certVerify.marshal())
+
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) readServerFinished() error {
+	c := hs.c
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	finished, ok := msg.(*finishedMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(finished, msg)
+	}
+
+	expectedMAC := hs.suite.finishedHash(c.in.trafficSecret, hs.transcript)
+	if !hmac.Equal(expectedMAC, finished.verifyData) {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: invalid server finished hash")
+	}
+	hs.transcript.Write(finished.marshal())
+
+	// The application traffic secrets cover the handshake up to the
+	// server Finished.
+	clientSecret := hs.suite.deriveSecret(hs.masterSecret, clientApplicationTrafficLabel, hs.transcript)
+	serverSecret := hs.suite.deriveSecret(hs.masterSecret, serverApplicationTrafficLabel, hs.transcript)
+	c.in.setTrafficSecret(hs.suite, serverSecret)
+
+	if err := c.config.writeKeyLog(keyLogLabelClientTraffic, hs.hello.random, clientSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+	if err := c.config.writeKeyLog(keyLogLabelServerTraffic, hs.hello.random, serverSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+
+	// The client keeps sending with the handshake traffic keys until its
+	// Finished.
+	hs.appSecret = clientSecret
+
+	return nil
+}
+
+// sendDummyChangeCipherSpec sends the ChangeCipherSpec record that TLS 1.3
+// clients send, once, for middlebox compatibility.
+func (hs *clientHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
+	if hs.sentDummyCCS {
+		return nil
+	}
+	hs.sentDummyCCS = true
+
+	_, err := hs.c.writeRecord(recordTypeChangeCipherSpec, []byte{1})
+	return err
+}
+
+func (hs *clientHandshakeStateTLS13) sendClientCertificate() error {
+	c := hs.c
+
+	if hs.certReq == nil {
+		return nil
+	}
+
+	// getClientCertificate only looks at the certificate types of a TLS
+	// 1.2 request, where TLS 1.3 relies on the signature algorithms.
+	chainToSend, err := c.getClientCertificate(&certificateRequestMsg{
+		hasSignatureAndHash:    true,
+		certificateTypes:       []byte{certTypeRSASign, certTypeECDSASign},
+		signatureAndHashes:     hs.certReq.signatureAndHashes,
+		certificateAuthorities: hs.certReq.certificateAuthorities,
+	})
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+
+	certificates, err := chainToSend.certificatesOfType(c.clientCertificateType)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	certMsg := &certificateMsgTLS13{certificates: certificates}
+	hs.transcript.Write(certMsg.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, certMsg.marshal()); err != nil {
+		return err
+	}
+
+	if len(certificates) == 0 {
+		return nil
+	}
+
+	key, ok := chainToSend.PrivateKey.(crypto.Signer)
+	if !ok {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: client certificate private key does not implement crypto.Signer")
+	}
+	scheme, err := pickSignatureSchemeTLS13(key.Public(), hs.certReq.signatureAndHashes)
+	if err != nil {
+		c.sendAlert(alertHandshakeFailure)
+		return err
+	}
+	signature, err := signTLS13(c.config.rand(), key, scheme, clientSignatureContext, hs.transcript)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to sign handshake: " + err.Error())
+	}
+
+	certVerify := &certificateVerifyMsg{
+		hasSignatureAndHash: true,
+		signatureAndHash:    signatureAndHash{hash: uint8(scheme >> 8), signature: uint8(scheme)},
+		signature:           signature,
+	}
+	hs.transcript.Write(certVerify.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, certVerify.marshal()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (hs *clientHandshakeStateTLS13) sendClientFinished() error {
+	c := hs.c
+
+	finished := &finishedMsg{
+		verifyData: hs.suite.finishedHash(c.out.trafficSecret, hs.transcript),
+	}
+	hs.transcript.Write(finished.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, finished.marshal()); err != nil {
+		return err
+	}
+
+	c.out.setTrafficSecret(hs.suite, hs.appSecret)
+	c.resumptionSecret = hs.suite.deriveSecret(hs.masterSecret, resumptionLabel, hs.transcript)
+
+	return nil
+}
+
+// handleNewSessionTicket stores a TLS 1.3 session ticket in the client
+// session cache.
+// c.in.Mutex <= L
+func (c *Conn) handleNewSessionTicket(msg *newSessionTicketMsgTLS13) error {
+	if !c.isClient {
+		c.sendAlert(alertUnexpectedMessage)
+		return errors.New("tls: received new session ticket from a client")
+	}
+
+	if c.config.SessionTicketsDisabled || c.config.ClientSessionCache == nil {
+		return nil
+	}
+
+	// See RFC 8446, section 4.6.1.
+	if msg.lifetime == 0 {
+		return nil
+	}
+	lifetime := time.Duration(msg.lifetime) * time.Second
+	if lifetime > maxSessionTicketLifetime {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: received a session ticket with invalid lifetime")
+	}
+
+	suite := cipherSuiteTLS13ByID(c.cipherSuite)
+	if suite == nil || c.resumptionSecret == nil {
+		return c.sendAlert(alertInternalError)
+	}
+
+	now := c.config.time()
+	session := &ClientSessionState{
+		sessionTicket:      msg.label,
+		vers:               c.vers,
+		cipherSuite:        c.cipherSuite,
+		masterSecret:       suite.resumptionPSK(c.resumptionSecret, msg.nonce),
+		serverCertificates: c.peerCertificates,
+		verifiedChains:     c.verifiedChains,
+		serverRawPublicKey: c.peerRawPublicKey,
+		serverPublicKey:    c.peerPublicKey,
+		pskIdentity:        c.pskIdentity,
+		receivedAt:         now,
+		useBy:              now.Add(lifetime),
+		ageAdd:             msg.ageAdd,
+	}
+
+	cacheKey := clientSessionCacheKey(c.conn.RemoteAddr(), c.config)
+	c.config.ClientSessionCache.Put(cacheKey, session)
+
+	return nil
+}
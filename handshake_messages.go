@@ -25,6 +25,31 @@ type clientHelloMsg struct {
 	secureRenegotiation          []byte
 	secureRenegotiationSupported bool
 	alpnProtocols                []string
+	srpUsername                  string
+	encryptThenMAC               bool
+	extendedMasterSecret         bool
+	maxFragmentLength            uint8
+	recordSizeLimit              uint16
+	clientCertificateTypes       []CertificateType
+	serverCertificateTypes       []CertificateType
+	supportedVersions            []uint16
+	keyShares                    []keyShare
+	pskModes                     []uint8
+	cookie                       []byte
+	pskIdentities                []pskIdentity
+	pskBinders                   [][]byte
+}
+
+// A keyShare is a KeyShareEntry, see RFC 8446, section 4.2.8.
+type keyShare struct {
+	group CurveID
+	data  []byte
+}
+
+// A pskIdentity is a PskIdentity, see RFC 8446, section 4.2.11.
+type pskIdentity struct {
+	label               []byte
+	obfuscatedTicketAge uint32
 }
 
 func (m *clientHelloMsg) equal(i interface{}) bool {
@@ -50,7 +75,20 @@ func (m *clientHelloMsg) equal(i interface{}) bool {
 		eqSignatureAndHashes(m.signatureAndHashes, m1.signatureAndHashes) &&
 		m.secureRenegotiationSupported == m1.secureRenegotiationSupported &&
 		bytes.Equal(m.secureRenegotiation, m1.secureRenegotiation) &&
-		eqStrings(m.alpnProtocols, m1.alpnProtocols)
+		eqStrings(m.alpnProtocols, m1.alpnProtocols) &&
+		m.srpUsername == m1.srpUsername &&
+		m.encryptThenMAC == m1.encryptThenMAC &&
+		m.extendedMasterSecret == m1.extendedMasterSecret &&
+		m.maxFragmentLength == m1.maxFragmentLength &&
+		m.recordSizeLimit == m1.recordSizeLimit &&
+		eqCertificateTypes(m.clientCertificateTypes, m1.clientCertificateTypes) &&
+		eqCertificateTypes(m.serverCertificateTypes, m1.serverCertificateTypes) &&
+		eqUint16s(m.supportedVersions, m1.supportedVersions) &&
+		eqKeyShares(m.keyShares, m1.keyShares) &&
+		bytes.Equal(m.pskModes, m1.pskModes) &&
+		bytes.Equal(m.cookie, m1.cookie) &&
+		eqPSKIdentities(m.pskIdentities, m1.pskIdentities) &&
+		eqByteSlices(m.pskBinders, m1.pskBinders)
 }
 
 func (m *clientHelloMsg) marshal() []byte {
@@ -106,6 +144,63 @@ func (m *clientHelloMsg) marshal() []byte {
 	if m.scts {
 		numExtensions++
 	}
+	if len(m.srpUsername) > 0 {
+		extensionsLength += 1 + len(m.srpUsername)
+		numExtensions++
+	}
+	if m.encryptThenMAC {
+		numExtensions++
+	}
+	if m.extendedMasterSecret {
+		numExtensions++
+	}
+	if m.maxFragmentLength != 0 {
+		extensionsLength += 1
+		numExtensions++
+	}
+	if m.recordSizeLimit != 0 {
+		extensionsLength += 2
+		numExtensions++
+	}
+	if len(m.clientCertificateTypes) > 0 {
+		extensionsLength += 1 + len(m.clientCertificateTypes)
+		numExtensions++
+	}
+	if len(m.serverCertificateTypes) > 0 {
+		extensionsLength += 1 + len(m.serverCertificateTypes)
+		numExtensions++
+	}
+	if len(m.supportedVersions) > 0 {
+		extensionsLength += 1 + 2*len(m.supportedVersions)
+		numExtensions++
+	}
+	keySharesLen := 0
+	if len(m.keyShares) > 0 {
+		for _, ks := range m.keyShares {
+			keySharesLen += 4 + len(ks.data)
+		}
+		extensionsLength += 2 + keySharesLen
+		numExtensions++
+	}
+	if len(m.pskModes) > 0 {
+		extensionsLength += 1 + len(m.pskModes)
+		numExtensions++
+	}
+	if len(m.cookie) > 0 {
+		extensionsLength += 2 + len(m.cookie)
+		numExtensions++
+	}
+	identitiesLen, bindersLen := 0, 0
+	if len(m.pskIdentities) > 0 {
+		for _, psk := range m.pskIdentities {
+			identitiesLen += 2 + len(psk.label) + 4
+		}
+		for _, binder := range m.pskBinders {
+			bindersLen += 1 + len(binder)
+		}
+		extensionsLength += 2 + identitiesLen + 2 + bindersLen
+		numExtensions++
+	}
 	if numExtensions > 0 {
 		extensionsLength += 4 * numExtensions
 		length += 2 + extensionsLength
@@ -288,12 +383,199 @@ func (m *clientHelloMsg) marshal() []byte {
 		// zero uint16 for the zero-length extension_data
 		z = z[4:]
 	}
+	if len(m.srpUsername) > 0 {
+		// https://tools.ietf.org/html/rfc5054#section-2.8.1
+		if len(m.srpUsername) > 255 {
+			panic("invalid SRP username")
+		}
+		z[0] = byte(extensionSRP >> 8)
+		z[1] = byte(extensionSRP)
+		l := 1 + len(m.srpUsername)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(len(m.srpUsername))
+		copy(z[5:], m.srpUsername)
+		z = z[4+l:]
+	}
+	if m.encryptThenMAC {
+		// https://tools.ietf.org/html/rfc7366#section-2
+		z[0] = byte(extensionEncryptThenMAC >> 8)
+		z[1] = byte(extensionEncryptThenMAC)
+		z = z[4:]
+	}
+	if m.extendedMasterSecret {
+		// https://tools.ietf.org/html/rfc7627#section-5.1
+		z[0] = byte(extensionExtendedMasterSecret >> 8)
+		z[1] = byte(extensionExtendedMasterSecret)
+		z = z[4:]
+	}
+	if m.maxFragmentLength != 0 {
+		// https://tools.ietf.org/html/rfc6066#section-4
+		z[0] = byte(extensionMaxFragmentLength >> 8)
+		z[1] = byte(extensionMaxFragmentLength)
+		z[3] = 1
+		z[4] = m.maxFragmentLength
+		z = z[5:]
+	}
+	if m.recordSizeLimit != 0 {
+		// https://tools.ietf.org/html/rfc8449#section-4
+		z[0] = byte(extensionRecordSizeLimit >> 8)
+		z[1] = byte(extensionRecordSizeLimit)
+		z[3] = 2
+		z[4] = byte(m.recordSizeLimit >> 8)
+		z[5] = byte(m.recordSizeLimit)
+		z = z[6:]
+	}
+	if len(m.clientCertificateTypes) > 0 {
+		// https://tools.ietf.org/html/rfc7250#section-3
+		z[0] = byte(extensionClientCertificateType >> 8)
+		z[1] = byte(extensionClientCertificateType)
+		l := 1 + len(m.clientCertificateTypes)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(l - 1)
+		z = z[5:]
+		for _, certType := range m.clientCertificateTypes {
+			z[0] = byte(certType)
+			z = z[1:]
+		}
+	}
+	if len(m.serverCertificateTypes) > 0 {
+		// https://tools.ietf.org/html/rfc7250#section-3
+		z[0] = byte(extensionServerCertificateType >> 8)
+		z[1] = byte(extensionServerCertificateType)
+		l := 1 + len(m.serverCertificateTypes)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(l - 1)
+		z = z[5:]
+		for _, certType := range m.serverCertificateTypes {
+			z[0] = byte(certType)
+			z = z[1:]
+		}
+	}
+	if len(m.supportedVersions) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.1
+		z[0] = byte(extensionSupportedVersions >> 8)
+		z[1] = byte(extensionSupportedVersions)
+		l := 1 + 2*len(m.supportedVersions)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(l - 1)
+		z = z[5:]
+		for _, vers := range m.supportedVersions {
+			z[0] = byte(vers >> 8)
+			z[1] = byte(vers)
+			z = z[2:]
+		}
+	}
+	if len(m.keyShares) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.8
+		z[0] = byte(extensionKeyShare >> 8)
+		z[1] = byte(extensionKeyShare)
+		l := 2 + keySharesLen
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(keySharesLen >> 8)
+		z[5] = byte(keySharesLen)
+		z = z[6:]
+		for _, ks := range m.keyShares {
+			z[0] = byte(ks.group >> 8)
+			z[1] = byte(ks.group)
+			z[2] = byte(len(ks.data) >> 8)
+			z[3] = byte(len(ks.data))
+			copy(z[4:], ks.data)
+			z = z[4+len(ks.data):]
+		}
+	}
+	if len(m.pskModes) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.9
+		z[0] = byte(extensionPSKModes >> 8)
+		z[1] = byte(extensionPSKModes)
+		l := 1 + len(m.pskModes)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(len(m.pskModes))
+		copy(z[5:], m.pskModes)
+		z = z[4+l:]
+	}
+	if len(m.cookie) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.2
+		z[0] = byte(extensionCookie >> 8)
+		z[1] = byte(extensionCookie)
+		l := 2 + len(m.cookie)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(len(m.cookie) >> 8)
+		z[5] = byte(len(m.cookie))
+		copy(z[6:], m.cookie)
+		z = z[4+l:]
+	}
+	if len(m.pskIdentities) > 0 {
+		// https://tools.ietf.org/html/rfc8446#section-4.2.11
+		// This must be the last extension.
+		z[0] = byte(extensionPreSharedKey >> 8)
+		z[1] = byte(extensionPreSharedKey)
+		l := 2 + identitiesLen + 2 + bindersLen
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(identitiesLen >> 8)
+		z[5] = byte(identitiesLen)
+		z = z[6:]
+		for _, psk := range m.pskIdentities {
+			z[0] = byte(len(psk.label) >> 8)
+			z[1] = byte(len(psk.label))
+			copy(z[2:], psk.label)
+			z = z[2+len(psk.label):]
+			z[0] = byte(psk.obfuscatedTicketAge >> 24)
+			z[1] = byte(psk.obfuscatedTicketAge >> 16)
+			z[2] = byte(psk.obfuscatedTicketAge >> 8)
+			z[3] = byte(psk.obfuscatedTicketAge)
+			z = z[4:]
+		}
+		z[0] = byte(bindersLen >> 8)
+		z[1] = byte(bindersLen)
+		z = z[2:]
+		for _, binder := range m.pskBinders {
+			z[0] = byte(len(binder))
+			copy(z[1:], binder)
+			z = z[1+len(binder):]
+		}
+	}
 
 	m.raw = x
 
 	return x
 }
 
+// marshalWithoutBinders returns the ClientHello up to, but not including, the
+// PSK binders. This is the partial transcript that the binders sign.
+func (m *clientHelloMsg) marshalWithoutBinders() []byte {
+	bindersLen := 2
+	for _, binder := range m.pskBinders {
+		bindersLen += 1 + len(binder)
+	}
+
+	full := m.marshal()
+	return full[:len(full)-bindersLen]
+}
+
+// updateBinders replaces the PSK binders, which must be of the same lengths
+// as the current ones, and re-marshals the message.
+func (m *clientHelloMsg) updateBinders(pskBinders [][]byte) {
+	if len(pskBinders) != len(m.pskBinders) {
+		panic("tls: internal error: pskBinders length mismatch")
+	}
+	for i := range m.pskBinders {
+		if len(pskBinders[i]) != len(m.pskBinders[i]) {
+			panic("tls: internal error: pskBinders length mismatch")
+		}
+	}
+	m.pskBinders = pskBinders
+	m.raw = nil
+	m.marshal()
+}
+
 func (m *clientHelloMsg) unmarshal(data []byte) bool {
 	if len(data) < 42 {
 		return false
@@ -344,6 +626,19 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 	m.signatureAndHashes = nil
 	m.alpnProtocols = nil
 	m.scts = false
+	m.srpUsername = ""
+	m.encryptThenMAC = false
+	m.extendedMasterSecret = false
+	m.maxFragmentLength = 0
+	m.recordSizeLimit = 0
+	m.clientCertificateTypes = nil
+	m.serverCertificateTypes = nil
+	m.supportedVersions = nil
+	m.keyShares = nil
+	m.pskModes = nil
+	m.cookie = nil
+	m.pskIdentities = nil
+	m.pskBinders = nil
 
 	if len(data) == 0 {
 		// ClientHello is optionally followed by extension data
@@ -488,6 +783,118 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 			if length != 0 {
 				return false
 			}
+		case extensionSRP:
+			// https://tools.ietf.org/html/rfc5054#section-2.8.1
+			if length < 2 {
+				return false
+			}
+			l := int(data[0])
+			if length != l+1 {
+				return false
+			}
+			m.srpUsername = string(data[1:length])
+		case extensionEncryptThenMAC:
+			if length != 0 {
+				return false
+			}
+			m.encryptThenMAC = true
+		case extensionExtendedMasterSecret:
+			if length != 0 {
+				return false
+			}
+			m.extendedMasterSecret = true
+		case extensionMaxFragmentLength:
+			if length != 1 {
+				return false
+			}
+			m.maxFragmentLength = data[0]
+		case extensionRecordSizeLimit:
+			if length != 2 {
+				return false
+			}
+			m.recordSizeLimit = uint16(data[0])<<8 | uint16(data[1])
+		case extensionClientCertificateType:
+			// https://tools.ietf.org/html/rfc7250#section-3
+			if length < 2 || int(data[0]) != length-1 {
+				return false
+			}
+			for _, certType := range data[1:length] {
+				m.clientCertificateTypes = append(m.clientCertificateTypes, CertificateType(certType))
+			}
+		case extensionServerCertificateType:
+			// https://tools.ietf.org/html/rfc7250#section-3
+			if length < 2 || int(data[0]) != length-1 {
+				return false
+			}
+			for _, certType := range data[1:length] {
+				m.serverCertificateTypes = append(m.serverCertificateTypes, CertificateType(certType))
+			}
+		case extensionSupportedVersions:
+			// https://tools.ietf.org/html/rfc8446#section-4.2.1
+			if length < 1 {
+				return false
+			}
+			l := int(data[0])
+			if l%2 == 1 || length != l+1 {
+				return false
+			}
+			d := data[1:length]
+			for len(d) > 0 {
+				m.supportedVersions = append(m.supportedVersions, uint16(d[0])<<8|uint16(d[1]))
+				d = d[2:]
+			}
+		case extensionKeyShare:
+			// https://tools.ietf.org/html/rfc8446#section-4.2.8
+			if length < 2 {
+				return false
+			}
+			l := int(data[0])<<8 | int(data[1])
+			if length != l+2 {
+				return false
+			}
+			d := data[2:length]
+			for len(d) > 0 {
+				if len(d) < 4 {
+					return false
+				}
+				group := CurveID(d[0])<<8 | CurveID(d[1])
+				dataLen := int(d[2])<<8 | int(d[3])
+				d = d[4:]
+				if dataLen == 0 || len(d) < dataLen {
+					return false
+				}
+				m.keyShares = append(m.keyShares, keyShare{group: group, data: d[:dataLen]})
+				d = d[dataLen:]
+			}
+		case extensionPSKModes:
+			// https://tools.ietf.org/html/rfc8446#section-4.2.9
+			if length < 1 {
+				return false
+			}
+			l := int(data[0])
+			if length != l+1 {
+				return false
+			}
+			m.pskModes = data[1:length]
+		case extensionCookie:
+			// https://tools.ietf.org/html/rfc8446#section-4.2.2
+			if length < 2 {
+				return false
+			}
+			l := int(data[0])<<8 | int(data[1])
+			if l == 0 || length != l+2 {
+				return false
+			}
+			m.cookie = data[2:length]
+		case extensionPreSharedKey:
+			// https://tools.ietf.org/html/rfc8446#section-4.2.11
+			if len(data) != length {
+				// pre_shared_key must be the last extension.
+				return false
+			}
+			if !m.unmarshalPreSharedKey(data) {
+				return false
+			}
 		}
 		data = data[length:]
 	}
@@ -495,6 +902,54 @@ func (m *clientHelloMsg) unmarshal(data []byte) bool {
 	return true
 }
 
+// unmarshalPreSharedKey parses the body of a pre_shared_key extension.
+func (m *clientHelloMsg) unmarshalPreSharedKey(data []byte) bool {
+	if len(data) < 2 {
+		return false
+	}
+	l := int(data[0])<<8 | int(data[1])
+	if l == 0 || len(data) < 2+l {
+		return false
+	}
+	d := data[2 : 2+l]
+	for len(d) > 0 {
+		if len(d) < 2 {
+			return false
+		}
+		labelLen := int(d[0])<<8 | int(d[1])
+		d = d[2:]
+		if labelLen == 0 || len(d) < labelLen+4 {
+			return false
+		}
+		m.pskIdentities = append(m.pskIdentities, pskIdentity{
+			label:               d[:labelLen],
+			obfuscatedTicketAge: uint32(d[labelLen])<<24 | uint32(d[labelLen+1])<<16 | uint32(d[labelLen+2])<<8 | uint32(d[labelLen+3]),
+		})
+		d = d[labelLen+4:]
+	}
+
+	data = data[2+l:]
+	if len(data) < 2 {
+		return false
+	}
+	l = int(data[0])<<8 | int(data[1])
+	if len(data) != 2+l {
+		return false
+	}
+	d = data[2:]
+	for len(d) > 0 {
+		binderLen := int(d[0])
+		d = d[1:]
+		if binderLen < 32 || len(d) < binderLen {
+			return false
+		}
+		m.pskBinders = append(m.pskBinders, d[:binderLen])
+		d = d[binderLen:]
+	}
+
+	return len(m.pskIdentities) == len(m.pskBinders)
+}
+
 type serverHelloMsg struct {
 	raw                          []byte
 	vers                         uint16
@@ -510,6 +965,24 @@ type serverHelloMsg struct {
 	secureRenegotiation          []byte
 	secureRenegotiationSupported bool
 	alpnProtocol                 string
+	encryptThenMAC               bool
+	extendedMasterSecret         bool
+	maxFragmentLength            uint8
+	recordSizeLimit              uint16
+	clientCertificateTypePresent bool
+	clientCertificateType        CertificateType
+	serverCertificateTypePresent bool
+	serverCertificateType        CertificateType
+
+	// TLS 1.3 extensions. A HelloRetryRequest, which is a ServerHello with
+	// helloRetryRequestRandom, may carry cookie and selectedGroup instead
+	// of serverShare.
+	supportedVersion        uint16
+	serverShare             keyShare
+	selectedIdentityPresent bool
+	selectedIdentity        uint16
+	cookie                  []byte
+	selectedGroup           CurveID
 }
 
 func (m *serverHelloMsg) equal(i interface{}) bool {
@@ -539,7 +1012,22 @@ func (m *serverHelloMsg) equal(i interface{}) bool {
 		m.ticketSupported == m1.ticketSupported &&
 		m.secureRenegotiationSupported == m1.secureRenegotiationSupported &&
 		bytes.Equal(m.secureRenegotiation, m1.secureRenegotiation) &&
-		m.alpnProtocol == m1.alpnProtocol
+		m.alpnProtocol == m1.alpnProtocol &&
+		m.encryptThenMAC == m1.encryptThenMAC &&
+		m.extendedMasterSecret == m1.extendedMasterSecret &&
+		m.maxFragmentLength == m1.maxFragmentLength &&
+		m.recordSizeLimit == m1.recordSizeLimit &&
+		m.clientCertificateTypePresent == m1.clientCertificateTypePresent &&
+		m.clientCertificateType == m1.clientCertificateType &&
+		m.serverCertificateTypePresent == m1.serverCertificateTypePresent &&
+		m.serverCertificateType == m1.serverCertificateType &&
+		m.supportedVersion == m1.supportedVersion &&
+		m.serverShare.group == m1.serverShare.group &&
+		bytes.Equal(m.serverShare.data, m1.serverShare.data) &&
+		m.selectedIdentityPresent == m1.selectedIdentityPresent &&
+		m.selectedIdentity == m1.selectedIdentity &&
+		bytes.Equal(m.cookie, m1.cookie) &&
+		m.selectedGroup == m1.selectedGroup
 }
 
 func (m *serverHelloMsg) marshal() []byte {
@@ -585,6 +1073,48 @@ func (m *serverHelloMsg) marshal() []byte {
 		extensionsLength += 2 + sctLen
 		numExtensions++
 	}
+	if m.encryptThenMAC {
+		numExtensions++
+	}
+	if m.extendedMasterSecret {
+		numExtensions++
+	}
+	if m.maxFragmentLength != 0 {
+		extensionsLength += 1
+		numExtensions++
+	}
+	if m.recordSizeLimit != 0 {
+		extensionsLength += 2
+		numExtensions++
+	}
+	if m.clientCertificateTypePresent {
+		extensionsLength += 1
+		numExtensions++
+	}
+	if m.serverCertificateTypePresent {
+		extensionsLength += 1
+		numExtensions++
+	}
+	if m.supportedVersion != 0 {
+		extensionsLength += 2
+		numExtensions++
+	}
+	if m.serverShare.group != 0 {
+		extensionsLength += 4 + len(m.serverShare.data)
+		numExtensions++
+	}
+	if m.selectedIdentityPresent {
+		extensionsLength += 2
+		numExtensions++
+	}
+	if len(m.cookie) > 0 {
+		extensionsLength += 2 + len(m.cookie)
+		numExtensions++
+	}
+	if m.selectedGroup != 0 {
+		extensionsLength += 2
+		numExtensions++
+	}
 
 	if numExtensions > 0 {
 		extensionsLength += 4 * numExtensions
@@ -680,6 +1210,93 @@ func (m *serverHelloMsg) marshal() []byte {
 			z = z[len(sct)+2:]
 		}
 	}
+	if m.encryptThenMAC {
+		z[0] = byte(extensionEncryptThenMAC >> 8)
+		z[1] = byte(extensionEncryptThenMAC)
+		z = z[4:]
+	}
+	if m.extendedMasterSecret {
+		z[0] = byte(extensionExtendedMasterSecret >> 8)
+		z[1] = byte(extensionExtendedMasterSecret)
+		z = z[4:]
+	}
+	if m.maxFragmentLength != 0 {
+		z[0] = byte(extensionMaxFragmentLength >> 8)
+		z[1] = byte(extensionMaxFragmentLength)
+		z[3] = 1
+		z[4] = m.maxFragmentLength
+		z = z[5:]
+	}
+	if m.recordSizeLimit != 0 {
+		z[0] = byte(extensionRecordSizeLimit >> 8)
+		z[1] = byte(extensionRecordSizeLimit)
+		z[3] = 2
+		z[4] = byte(m.recordSizeLimit >> 8)
+		z[5] = byte(m.recordSizeLimit)
+		z = z[6:]
+	}
+	if m.clientCertificateTypePresent {
+		z[0] = byte(extensionClientCertificateType >> 8)
+		z[1] = byte(extensionClientCertificateType)
+		z[3] = 1
+		z[4] = byte(m.clientCertificateType)
+		z = z[5:]
+	}
+	if m.serverCertificateTypePresent {
+		z[0] = byte(extensionServerCertificateType >> 8)
+		z[1] = byte(extensionServerCertificateType)
+		z[3] = 1
+		z[4] = byte(m.serverCertificateType)
+		z = z[5:]
+	}
+	if m.supportedVersion != 0 {
+		z[0] = byte(extensionSupportedVersions >> 8)
+		z[1] = byte(extensionSupportedVersions)
+		z[3] = 2
+		z[4] = byte(m.supportedVersion >> 8)
+		z[5] = byte(m.supportedVersion)
+		z = z[6:]
+	}
+	if m.serverShare.group != 0 {
+		z[0] = byte(extensionKeyShare >> 8)
+		z[1] = byte(extensionKeyShare)
+		l := 4 + len(m.serverShare.data)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(m.serverShare.group >> 8)
+		z[5] = byte(m.serverShare.group)
+		z[6] = byte(len(m.serverShare.data) >> 8)
+		z[7] = byte(len(m.serverShare.data))
+		copy(z[8:], m.serverShare.data)
+		z = z[4+l:]
+	}
+	if m.selectedIdentityPresent {
+		z[0] = byte(extensionPreSharedKey >> 8)
+		z[1] = byte(extensionPreSharedKey)
+		z[3] = 2
+		z[4] = byte(m.selectedIdentity >> 8)
+		z[5] = byte(m.selectedIdentity)
+		z = z[6:]
+	}
+	if len(m.cookie) > 0 {
+		z[0] = byte(extensionCookie >> 8)
+		z[1] = byte(extensionCookie)
+		l := 2 + len(m.cookie)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(len(m.cookie) >> 8)
+		z[5] = byte(len(m.cookie))
+		copy(z[6:], m.cookie)
+		z = z[4+l:]
+	}
+	if m.selectedGroup != 0 {
+		z[0] = byte(extensionKeyShare >> 8)
+		z[1] = byte(extensionKeyShare)
+		z[3] = 2
+		z[4] = byte(m.selectedGroup >> 8)
+		z[5] = byte(m.selectedGroup)
+		z = z[6:]
+	}
 
 	m.raw = x
 
@@ -712,6 +1329,20 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 	m.scts = nil
 	m.ticketSupported = false
 	m.alpnProtocol = ""
+	m.encryptThenMAC = false
+	m.extendedMasterSecret = false
+	m.maxFragmentLength = 0
+	m.recordSizeLimit = 0
+	m.clientCertificateTypePresent = false
+	m.clientCertificateType = 0
+	m.serverCertificateTypePresent = false
+	m.serverCertificateType = 0
+	m.supportedVersion = 0
+	m.serverShare = keyShare{}
+	m.selectedIdentityPresent = false
+	m.selectedIdentity = 0
+	m.cookie = nil
+	m.selectedGroup = 0
 
 	if len(data) == 0 {
 		// ServerHello is optionally followed by extension data
@@ -819,6 +1450,73 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 				m.scts = append(m.scts, d[:sctLen])
 				d = d[sctLen:]
 			}
+		case extensionEncryptThenMAC:
+			if length != 0 {
+				return false
+			}
+			m.encryptThenMAC = true
+		case extensionExtendedMasterSecret:
+			if length != 0 {
+				return false
+			}
+			m.extendedMasterSecret = true
+		case extensionMaxFragmentLength:
+			if length != 1 {
+				return false
+			}
+			m.maxFragmentLength = data[0]
+		case extensionRecordSizeLimit:
+			if length != 2 {
+				return false
+			}
+			m.recordSizeLimit = uint16(data[0])<<8 | uint16(data[1])
+		case extensionClientCertificateType:
+			if length != 1 {
+				return false
+			}
+			m.clientCertificateTypePresent = true
+			m.clientCertificateType = CertificateType(data[0])
+		case extensionServerCertificateType:
+			if length != 1 {
+				return false
+			}
+			m.serverCertificateTypePresent = true
+			m.serverCertificateType = CertificateType(data[0])
+		case extensionSupportedVersions:
+			if length != 2 {
+				return false
+			}
+			m.supportedVersion = uint16(data[0])<<8 | uint16(data[1])
+		case extensionKeyShare:
+			if length == 2 {
+				// A HelloRetryRequest only names the group.
+				m.selectedGroup = CurveID(data[0])<<8 | CurveID(data[1])
+				break
+			}
+			if length < 4 {
+				return false
+			}
+			m.serverShare.group = CurveID(data[0])<<8 | CurveID(data[1])
+			l := int(data[2])<<8 | int(data[3])
+			if l == 0 || length != l+4 {
+				return false
+			}
+			m.serverShare.data = data[4:length]
+		case extensionPreSharedKey:
+			if length != 2 {
+				return false
+			}
+			m.selectedIdentityPresent = true
+			m.selectedIdentity = uint16(data[0])<<8 | uint16(data[1])
+		case extensionCookie:
+			if length < 2 {
+				return false
+			}
+			l := int(data[0])<<8 | int(data[1])
+			if l == 0 || length != l+2 {
+				return false
+			}
+			m.cookie = data[2:length]
 		}
 		data = data[length:]
 	}
@@ -829,6 +1527,10 @@ func (m *serverHelloMsg) unmarshal(data []byte) bool {
 type certificateMsg struct {
 	raw          []byte
 	certificates [][]byte
+	// rawPublicKey is set if the message carries the SubjectPublicKeyInfo
+	// of a raw public key, see RFC 7250, section 3, in which case
+	// certificates holds that key, if any, instead of a chain.
+	rawPublicKey bool
 }
 
 func (m *certificateMsg) equal(i interface{}) bool {
@@ -838,7 +1540,8 @@ func (m *certificateMsg) equal(i interface{}) bool {
 	}
 
 	return bytes.Equal(m.raw, m1.raw) &&
-		eqByteSlices(m.certificates, m1.certificates)
+		eqByteSlices(m.certificates, m1.certificates) &&
+		m.rawPublicKey == m1.rawPublicKey
 }
 
 func (m *certificateMsg) marshal() (x []byte) {
@@ -846,6 +1549,26 @@ func (m *certificateMsg) marshal() (x []byte) {
 		return m.raw
 	}
 
+	if m.rawPublicKey {
+		var spki []byte
+		if len(m.certificates) > 0 {
+			spki = m.certificates[0]
+		}
+		length := 3 + len(spki)
+		x = make([]byte, 4+length)
+		x[0] = typeCertificate
+		x[1] = uint8(length >> 16)
+		x[2] = uint8(length >> 8)
+		x[3] = uint8(length)
+		x[4] = uint8(len(spki) >> 16)
+		x[5] = uint8(len(spki) >> 8)
+		x[6] = uint8(len(spki))
+		copy(x[7:], spki)
+
+		m.raw = x
+		return
+	}
+
 	var i int
 	for _, slice := range m.certificates {
 		i += len(slice)
@@ -887,6 +1610,14 @@ func (m *certificateMsg) unmarshal(data []byte) bool {
 		return false
 	}
 
+	if m.rawPublicKey {
+		m.certificates = nil
+		if certsLen > 0 {
+			m.certificates = [][]byte{data[7:]}
+		}
+		return true
+	}
+
 	numCerts := 0
 	d := data[7:]
 	for certsLen > 0 {
@@ -1486,7 +2217,697 @@ func (m *newSessionTicketMsg) unmarshal(data []byte) bool {
 	return true
 }
 
-type helloRequestMsg struct {
+// encryptedExtensionsMsg is the TLS 1.3 EncryptedExtensions message, which
+// carries the server extensions that aren't needed to establish keys.
+type encryptedExtensionsMsg struct {
+	raw                          []byte
+	alpnProtocol                 string
+	maxFragmentLength            uint8
+	recordSizeLimit              uint16
+	clientCertificateTypePresent bool
+	clientCertificateType        CertificateType
+	serverCertificateTypePresent bool
+	serverCertificateType        CertificateType
+}
+
+func (m *encryptedExtensionsMsg) equal(i interface{}) bool {
+	m1, ok := i.(*encryptedExtensionsMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.alpnProtocol == m1.alpnProtocol &&
+		m.maxFragmentLength == m1.maxFragmentLength &&
+		m.recordSizeLimit == m1.recordSizeLimit &&
+		m.clientCertificateTypePresent == m1.clientCertificateTypePresent &&
+		m.clientCertificateType == m1.clientCertificateType &&
+		m.serverCertificateTypePresent == m1.serverCertificateTypePresent &&
+		m.serverCertificateType == m1.serverCertificateType
+}
+
+func (m *encryptedExtensionsMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See https://tools.ietf.org/html/rfc8446#section-4.3.1
+	extensionsLength := 0
+	alpnLen := len(m.alpnProtocol)
+	if alpnLen > 0 {
+		if alpnLen >= 256 {
+			panic("invalid ALPN protocol")
+		}
+		extensionsLength += 4 + 2 + 1 + alpnLen
+	}
+	if m.maxFragmentLength != 0 {
+		extensionsLength += 4 + 1
+	}
+	if m.recordSizeLimit != 0 {
+		extensionsLength += 4 + 2
+	}
+	if m.clientCertificateTypePresent {
+		extensionsLength += 4 + 1
+	}
+	if m.serverCertificateTypePresent {
+		extensionsLength += 4 + 1
+	}
+
+	length := 2 + extensionsLength
+	x = make([]byte, 4+length)
+	x[0] = typeEncryptedExtensions
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(extensionsLength >> 8)
+	x[5] = uint8(extensionsLength)
+	z := x[6:]
+	if alpnLen > 0 {
+		z[0] = byte(extensionALPN >> 8)
+		z[1] = byte(extensionALPN)
+		l := 2 + 1 + alpnLen
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		l -= 2
+		z[4] = byte(l >> 8)
+		z[5] = byte(l)
+		z[6] = byte(alpnLen)
+		copy(z[7:], m.alpnProtocol)
+		z = z[7+alpnLen:]
+	}
+	if m.maxFragmentLength != 0 {
+		z[0] = byte(extensionMaxFragmentLength >> 8)
+		z[1] = byte(extensionMaxFragmentLength)
+		z[3] = 1
+		z[4] = m.maxFragmentLength
+		z = z[5:]
+	}
+	if m.recordSizeLimit != 0 {
+		z[0] = byte(extensionRecordSizeLimit >> 8)
+		z[1] = byte(extensionRecordSizeLimit)
+		z[3] = 2
+		z[4] = byte(m.recordSizeLimit >> 8)
+		z[5] = byte(m.recordSizeLimit)
+		z = z[6:]
+	}
+	if m.clientCertificateTypePresent {
+		z[0] = byte(extensionClientCertificateType >> 8)
+		z[1] = byte(extensionClientCertificateType)
+		z[3] = 1
+		z[4] = byte(m.clientCertificateType)
+		z = z[5:]
+	}
+	if m.serverCertificateTypePresent {
+		z[0] = byte(extensionServerCertificateType >> 8)
+		z[1] = byte(extensionServerCertificateType)
+		z[3] = 1
+		z[4] = byte(m.serverCertificateType)
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *encryptedExtensionsMsg) unmarshal(data []byte) bool {
+	m.raw = data
+	m.alpnProtocol = ""
+	m.maxFragmentLength = 0
+	m.recordSizeLimit = 0
+	m.clientCertificateTypePresent = false
+	m.clientCertificateType = 0
+	m.serverCertificateTypePresent = false
+	m.serverCertificateType = 0
+
+	if len(data) < 6 {
+		return false
+	}
+	extensionsLength := int(data[4])<<8 | int(data[5])
+	data = data[6:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		switch extension {
+		case extensionALPN:
+			d := data[:length]
+			if len(d) < 3 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			if l != len(d)-2 {
+				return false
+			}
+			d = d[2:]
+			l = int(d[0])
+			if l == 0 || l != len(d)-1 {
+				return false
+			}
+			m.alpnProtocol = string(d[1:])
+		case extensionMaxFragmentLength:
+			if length != 1 {
+				return false
+			}
+			m.maxFragmentLength = data[0]
+		case extensionRecordSizeLimit:
+			if length != 2 {
+				return false
+			}
+			m.recordSizeLimit = uint16(data[0])<<8 | uint16(data[1])
+		case extensionClientCertificateType:
+			if length != 1 {
+				return false
+			}
+			m.clientCertificateTypePresent = true
+			m.clientCertificateType = CertificateType(data[0])
+		case extensionServerCertificateType:
+			if length != 1 {
+				return false
+			}
+			m.serverCertificateTypePresent = true
+			m.serverCertificateType = CertificateType(data[0])
+		}
+		data = data[length:]
+	}
+
+	return true
+}
+
+// certificateMsgTLS13 is the TLS 1.3 Certificate message, in which each
+// certificate may carry extensions. Only the OCSP staple and SCTs of the
+// leaf certificate are supported.
+type certificateMsgTLS13 struct {
+	raw          []byte
+	certificates [][]byte
+	ocspStaple   []byte
+	scts         [][]byte
+}
+
+func (m *certificateMsgTLS13) equal(i interface{}) bool {
+	m1, ok := i.(*certificateMsgTLS13)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		eqByteSlices(m.certificates, m1.certificates) &&
+		bytes.Equal(m.ocspStaple, m1.ocspStaple) &&
+		eqByteSlices(m.scts, m1.scts)
+}
+
+func (m *certificateMsgTLS13) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See https://tools.ietf.org/html/rfc8446#section-4.4.2
+	leafExtensionsLength := 0
+	if len(m.ocspStaple) > 0 {
+		leafExtensionsLength += 4 + 1 + 3 + len(m.ocspStaple)
+	}
+	sctLen := 0
+	if len(m.scts) > 0 {
+		for _, sct := range m.scts {
+			sctLen += 2 + len(sct)
+		}
+		leafExtensionsLength += 4 + 2 + sctLen
+	}
+
+	certificatesLength := 0
+	for i, cert := range m.certificates {
+		certificatesLength += 3 + len(cert) + 2
+		if i == 0 {
+			certificatesLength += leafExtensionsLength
+		}
+	}
+
+	length := 1 + 3 + certificatesLength
+	x = make([]byte, 4+length)
+	x[0] = typeCertificate
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	// x[4] is the empty certificate_request_context.
+	x[5] = uint8(certificatesLength >> 16)
+	x[6] = uint8(certificatesLength >> 8)
+	x[7] = uint8(certificatesLength)
+
+	y := x[8:]
+	for i, cert := range m.certificates {
+		y[0] = uint8(len(cert) >> 16)
+		y[1] = uint8(len(cert) >> 8)
+		y[2] = uint8(len(cert))
+		copy(y[3:], cert)
+		y = y[3+len(cert):]
+		if i != 0 {
+			y = y[2:]
+			continue
+		}
+
+		y[0] = uint8(leafExtensionsLength >> 8)
+		y[1] = uint8(leafExtensionsLength)
+		y = y[2:]
+		if len(m.ocspStaple) > 0 {
+			l := 1 + 3 + len(m.ocspStaple)
+			y[0] = byte(extensionStatusRequest >> 8)
+			y[1] = byte(extensionStatusRequest)
+			y[2] = byte(l >> 8)
+			y[3] = byte(l)
+			y[4] = statusTypeOCSP
+			y[5] = byte(len(m.ocspStaple) >> 16)
+			y[6] = byte(len(m.ocspStaple) >> 8)
+			y[7] = byte(len(m.ocspStaple))
+			copy(y[8:], m.ocspStaple)
+			y = y[4+l:]
+		}
+		if len(m.scts) > 0 {
+			l := 2 + sctLen
+			y[0] = byte(extensionSCT >> 8)
+			y[1] = byte(extensionSCT)
+			y[2] = byte(l >> 8)
+			y[3] = byte(l)
+			y[4] = byte(sctLen >> 8)
+			y[5] = byte(sctLen)
+			y = y[6:]
+			for _, sct := range m.scts {
+				y[0] = byte(len(sct) >> 8)
+				y[1] = byte(len(sct))
+				copy(y[2:], sct)
+				y = y[2+len(sct):]
+			}
+		}
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *certificateMsgTLS13) unmarshal(data []byte) bool {
+	m.raw = data
+	m.certificates = nil
+	m.ocspStaple = nil
+	m.scts = nil
+
+	if len(data) < 8 {
+		return false
+	}
+	contextLen := int(data[4])
+	data = data[5:]
+	if len(data) < contextLen+3 {
+		return false
+	}
+	data = data[contextLen:]
+	certsLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+	data = data[3:]
+	if len(data) != certsLen {
+		return false
+	}
+
+	for len(data) > 0 {
+		if len(data) < 3 {
+			return false
+		}
+		certLen := int(data[0])<<16 | int(data[1])<<8 | int(data[2])
+		data = data[3:]
+		if certLen == 0 || len(data) < certLen+2 {
+			return false
+		}
+		m.certificates = append(m.certificates, data[:certLen])
+		data = data[certLen:]
+
+		extensionsLen := int(data[0])<<8 | int(data[1])
+		data = data[2:]
+		if len(data) < extensionsLen {
+			return false
+		}
+		extensions := data[:extensionsLen]
+		data = data[extensionsLen:]
+		if len(m.certificates) == 1 && !m.unmarshalLeafExtensions(extensions) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (m *certificateMsgTLS13) unmarshalLeafExtensions(data []byte) bool {
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		d := data[:length]
+		switch extension {
+		case extensionStatusRequest:
+			if len(d) < 4 || d[0] != statusTypeOCSP {
+				return false
+			}
+			l := int(d[1])<<16 | int(d[2])<<8 | int(d[3])
+			if l == 0 || len(d) != 4+l {
+				return false
+			}
+			m.ocspStaple = d[4:]
+		case extensionSCT:
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if len(d) != l || l == 0 {
+				return false
+			}
+			for len(d) != 0 {
+				if len(d) < 2 {
+					return false
+				}
+				sctLen := int(d[0])<<8 | int(d[1])
+				d = d[2:]
+				if sctLen == 0 || len(d) < sctLen {
+					return false
+				}
+				m.scts = append(m.scts, d[:sctLen])
+				d = d[sctLen:]
+			}
+		}
+		data = data[length:]
+	}
+
+	return true
+}
+
+// certificateRequestMsgTLS13 is the TLS 1.3 CertificateRequest message, which
+// moves its parameters into extensions.
+type certificateRequestMsgTLS13 struct {
+	raw                    []byte
+	signatureAndHashes     []signatureAndHash
+	certificateAuthorities [][]byte
+}
+
+func (m *certificateRequestMsgTLS13) equal(i interface{}) bool {
+	m1, ok := i.(*certificateRequestMsgTLS13)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		eqSignatureAndHashes(m.signatureAndHashes, m1.signatureAndHashes) &&
+		eqByteSlices(m.certificateAuthorities, m1.certificateAuthorities)
+}
+
+func (m *certificateRequestMsgTLS13) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See https://tools.ietf.org/html/rfc8446#section-4.3.2
+	extensionsLength := 4 + 2 + 2*len(m.signatureAndHashes)
+	casLength := 0
+	if len(m.certificateAuthorities) > 0 {
+		for _, ca := range m.certificateAuthorities {
+			casLength += 2 + len(ca)
+		}
+		extensionsLength += 4 + 2 + casLength
+	}
+
+	length := 1 + 2 + extensionsLength
+	x = make([]byte, 4+length)
+	x[0] = typeCertificateRequest
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	// x[4] is the empty certificate_request_context.
+	x[5] = uint8(extensionsLength >> 8)
+	x[6] = uint8(extensionsLength)
+
+	z := x[7:]
+	l := 2 + 2*len(m.signatureAndHashes)
+	z[0] = byte(extensionSignatureAlgorithms >> 8)
+	z[1] = byte(extensionSignatureAlgorithms)
+	z[2] = byte(l >> 8)
+	z[3] = byte(l)
+	l -= 2
+	z[4] = byte(l >> 8)
+	z[5] = byte(l)
+	z = z[6:]
+	for _, sigAndHash := range m.signatureAndHashes {
+		z[0] = sigAndHash.hash
+		z[1] = sigAndHash.signature
+		z = z[2:]
+	}
+
+	if len(m.certificateAuthorities) > 0 {
+		l := 2 + casLength
+		z[0] = byte(extensionCertAuthorities >> 8)
+		z[1] = byte(extensionCertAuthorities)
+		z[2] = byte(l >> 8)
+		z[3] = byte(l)
+		z[4] = byte(casLength >> 8)
+		z[5] = byte(casLength)
+		z = z[6:]
+		for _, ca := range m.certificateAuthorities {
+			z[0] = byte(len(ca) >> 8)
+			z[1] = byte(len(ca))
+			copy(z[2:], ca)
+			z = z[2+len(ca):]
+		}
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *certificateRequestMsgTLS13) unmarshal(data []byte) bool {
+	m.raw = data
+	m.signatureAndHashes = nil
+	m.certificateAuthorities = nil
+
+	if len(data) < 5 {
+		return false
+	}
+	contextLen := int(data[4])
+	data = data[5:]
+	if len(data) < contextLen+2 {
+		return false
+	}
+	data = data[contextLen:]
+	extensionsLength := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if len(data) != extensionsLength {
+		return false
+	}
+
+	for len(data) != 0 {
+		if len(data) < 4 {
+			return false
+		}
+		extension := uint16(data[0])<<8 | uint16(data[1])
+		length := int(data[2])<<8 | int(data[3])
+		data = data[4:]
+		if len(data) < length {
+			return false
+		}
+
+		d := data[:length]
+		switch extension {
+		case extensionSignatureAlgorithms:
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if l == 0 || l%2 == 1 || len(d) != l {
+				return false
+			}
+			m.signatureAndHashes = make([]signatureAndHash, l/2)
+			for i := range m.signatureAndHashes {
+				m.signatureAndHashes[i].hash = d[0]
+				m.signatureAndHashes[i].signature = d[1]
+				d = d[2:]
+			}
+		case extensionCertAuthorities:
+			if len(d) < 2 {
+				return false
+			}
+			l := int(d[0])<<8 | int(d[1])
+			d = d[2:]
+			if len(d) != l {
+				return false
+			}
+			for len(d) > 0 {
+				if len(d) < 2 {
+					return false
+				}
+				caLen := int(d[0])<<8 | int(d[1])
+				d = d[2:]
+				if len(d) < caLen {
+					return false
+				}
+				m.certificateAuthorities = append(m.certificateAuthorities, d[:caLen])
+				d = d[caLen:]
+			}
+		}
+		data = data[length:]
+	}
+
+	return len(m.signatureAndHashes) > 0
+}
+
+// newSessionTicketMsgTLS13 is the TLS 1.3 NewSessionTicket message, sent by
+// the server after the handshake.
+type newSessionTicketMsgTLS13 struct {
+	raw      []byte
+	lifetime uint32
+	ageAdd   uint32
+	nonce    []byte
+	label    []byte
+}
+
+func (m *newSessionTicketMsgTLS13) equal(i interface{}) bool {
+	m1, ok := i.(*newSessionTicketMsgTLS13)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.lifetime == m1.lifetime &&
+		m.ageAdd == m1.ageAdd &&
+		bytes.Equal(m.nonce, m1.nonce) &&
+		bytes.Equal(m.label, m1.label)
+}
+
+func (m *newSessionTicketMsgTLS13) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See https://tools.ietf.org/html/rfc8446#section-4.6.1
+	length := 4 + 4 + 1 + len(m.nonce) + 2 + len(m.label) + 2
+	x = make([]byte, 4+length)
+	x[0] = typeNewSessionTicket
+	x[1] = uint8(length >> 16)
+	x[2] = uint8(length >> 8)
+	x[3] = uint8(length)
+	x[4] = uint8(m.lifetime >> 24)
+	x[5] = uint8(m.lifetime >> 16)
+	x[6] = uint8(m.lifetime >> 8)
+	x[7] = uint8(m.lifetime)
+	x[8] = uint8(m.ageAdd >> 24)
+	x[9] = uint8(m.ageAdd >> 16)
+	x[10] = uint8(m.ageAdd >> 8)
+	x[11] = uint8(m.ageAdd)
+	x[12] = uint8(len(m.nonce))
+	y := x[13:]
+	copy(y, m.nonce)
+	y = y[len(m.nonce):]
+	y[0] = uint8(len(m.label) >> 8)
+	y[1] = uint8(len(m.label))
+	copy(y[2:], m.label)
+	// The extensions are empty.
+
+	m.raw = x
+	return
+}
+
+func (m *newSessionTicketMsgTLS13) unmarshal(data []byte) bool {
+	m.raw = data
+
+	if len(data) < 13 {
+		return false
+	}
+	length := int(data[1])<<16 | int(data[2])<<8 | int(data[3])
+	if len(data)-4 != length {
+		return false
+	}
+
+	m.lifetime = uint32(data[4])<<24 | uint32(data[5])<<16 | uint32(data[6])<<8 | uint32(data[7])
+	m.ageAdd = uint32(data[8])<<24 | uint32(data[9])<<16 | uint32(data[10])<<8 | uint32(data[11])
+	nonceLen := int(data[12])
+	data = data[13:]
+	if len(data) < nonceLen+2 {
+		return false
+	}
+	m.nonce = data[:nonceLen]
+	data = data[nonceLen:]
+
+	labelLen := int(data[0])<<8 | int(data[1])
+	data = data[2:]
+	if labelLen == 0 || len(data) < labelLen+2 {
+		return false
+	}
+	m.label = data[:labelLen]
+	data = data[labelLen:]
+
+	// Extensions, such as early_data, are ignored.
+	extensionsLen := int(data[0])<<8 | int(data[1])
+	return len(data) == 2+extensionsLen
+}
+
+// keyUpdateMsg is the TLS 1.3 KeyUpdate message.
+type keyUpdateMsg struct {
+	raw             []byte
+	updateRequested bool
+}
+
+func (m *keyUpdateMsg) equal(i interface{}) bool {
+	m1, ok := i.(*keyUpdateMsg)
+	if !ok {
+		return false
+	}
+
+	return bytes.Equal(m.raw, m1.raw) &&
+		m.updateRequested == m1.updateRequested
+}
+
+func (m *keyUpdateMsg) marshal() (x []byte) {
+	if m.raw != nil {
+		return m.raw
+	}
+
+	// See https://tools.ietf.org/html/rfc8446#section-4.6.3
+	x = []byte{typeKeyUpdate, 0, 0, 1, keyUpdateNotRequested}
+	if m.updateRequested {
+		x[4] = keyUpdateRequested
+	}
+
+	m.raw = x
+	return
+}
+
+func (m *keyUpdateMsg) unmarshal(data []byte) bool {
+	m.raw = data
+
+	if len(data) != 5 {
+		return false
+	}
+
+	switch data[4] {
+	case keyUpdateNotRequested:
+		m.updateRequested = false
+	case keyUpdateRequested:
+		m.updateRequested = true
+	default:
+		return false
+	}
+	return true
+}
+
+type helloRequestMsg struct {
 }
 
 func (*helloRequestMsg) marshal() []byte {
@@ -1509,6 +2930,18 @@ func eqUint16s(x, y []uint16) bool {
 	return true
 }
 
+func eqCertificateTypes(x, y []CertificateType) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if y[i] != v {
+			return false
+		}
+	}
+	return true
+}
+
 func eqCurveIDs(x, y []CurveID) bool {
 	if len(x) != len(y) {
 		return false
@@ -1557,3 +2990,27 @@ func eqSignatureAndHashes(x, y []signatureAndHash) bool {
 	}
 	return true
 }
+
+func eqKeyShares(x, y []keyShare) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if v.group != y[i].group || !bytes.Equal(v.data, y[i].data) {
+			return false
+		}
+	}
+	return true
+}
+
+func eqPSKIdentities(x, y []pskIdentity) bool {
+	if len(x) != len(y) {
+		return false
+	}
+	for i, v := range x {
+		if v.obfuscatedTicketAge != y[i].obfuscatedTicketAge || !bytes.Equal(v.label, y[i].label) {
+			return false
+		}
+	}
+	return true
+}
@@ -25,6 +25,11 @@ var tests = []interface{}{
 	&nextProtoMsg{},
 	&newSessionTicketMsg{},
 	&sessionState{},
+	&encryptedExtensionsMsg{},
+	&certificateMsgTLS13{},
+	&certificateRequestMsgTLS13{},
+	&newSessionTicketMsgTLS13{},
+	&keyUpdateMsg{},
 }
 
 type testMessage interface {
@@ -146,6 +151,61 @@ func (*clientHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 	if rand.Intn(10) > 5 {
 		m.scts = true
 	}
+	if rand.Intn(10) > 5 {
+		m.srpUsername = randomString(rand.Intn(255)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.encryptThenMAC = true
+	}
+	if rand.Intn(10) > 5 {
+		m.extendedMasterSecret = true
+	}
+	if rand.Intn(10) > 5 {
+		m.maxFragmentLength = uint8(rand.Intn(4) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(65535) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.clientCertificateTypes = make([]CertificateType, rand.Intn(5)+1)
+		for i := range m.clientCertificateTypes {
+			m.clientCertificateTypes[i] = CertificateType(rand.Intn(256))
+		}
+	}
+	if rand.Intn(10) > 5 {
+		m.serverCertificateTypes = make([]CertificateType, rand.Intn(5)+1)
+		for i := range m.serverCertificateTypes {
+			m.serverCertificateTypes[i] = CertificateType(rand.Intn(256))
+		}
+	}
+	if rand.Intn(10) > 5 {
+		m.supportedVersions = make([]uint16, rand.Intn(5)+1)
+		for i := range m.supportedVersions {
+			m.supportedVersions[i] = uint16(rand.Intn(65536))
+		}
+	}
+	if rand.Intn(10) > 5 {
+		m.keyShares = make([]keyShare, rand.Intn(3)+1)
+		for i := range m.keyShares {
+			m.keyShares[i].group = CurveID(rand.Intn(30000))
+			m.keyShares[i].data = randomBytes(rand.Intn(100)+1, rand)
+		}
+	}
+	if rand.Intn(10) > 5 {
+		m.pskModes = randomBytes(rand.Intn(3)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.cookie = randomBytes(rand.Intn(100)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.pskIdentities = make([]pskIdentity, rand.Intn(3)+1)
+		m.pskBinders = make([][]byte, len(m.pskIdentities))
+		for i := range m.pskIdentities {
+			m.pskIdentities[i].label = randomBytes(rand.Intn(100)+1, rand)
+			m.pskIdentities[i].obfuscatedTicketAge = uint32(rand.Int63())
+			m.pskBinders[i] = randomBytes(rand.Intn(32)+32, rand)
+		}
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -180,9 +240,46 @@ func (*serverHelloMsg) Generate(rand *rand.Rand, size int) reflect.Value {
 		numSCTs := rand.Intn(4)
 		m.scts = make([][]byte, numSCTs)
 		for i := range m.scts {
-			m.scts[i] = randomBytes(rand.Intn(500), rand)
+			m.scts[i] = randomBytes(rand.Intn(500)+1, rand)
 		}
 	}
+	if rand.Intn(10) > 5 {
+		m.encryptThenMAC = true
+	}
+	if rand.Intn(10) > 5 {
+		m.extendedMasterSecret = true
+	}
+	if rand.Intn(10) > 5 {
+		m.maxFragmentLength = uint8(rand.Intn(4) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(65535) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.clientCertificateTypePresent = true
+		m.clientCertificateType = CertificateType(rand.Intn(256))
+	}
+	if rand.Intn(10) > 5 {
+		m.serverCertificateTypePresent = true
+		m.serverCertificateType = CertificateType(rand.Intn(256))
+	}
+
+	if rand.Intn(10) > 5 {
+		m.supportedVersion = uint16(rand.Intn(65536))
+	}
+	if rand.Intn(10) > 5 {
+		m.selectedGroup = CurveID(rand.Intn(30000) + 1)
+	} else if rand.Intn(10) > 5 {
+		m.serverShare.group = CurveID(rand.Intn(30000))
+		m.serverShare.data = randomBytes(rand.Intn(100)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.selectedIdentityPresent = true
+		m.selectedIdentity = uint16(rand.Intn(65536))
+	}
+	if rand.Intn(10) > 5 {
+		m.cookie = randomBytes(rand.Intn(100)+1, rand)
+	}
 
 	return reflect.ValueOf(m)
 }
@@ -259,9 +356,87 @@ func (*sessionState) Generate(rand *rand.Rand, size int) reflect.Value {
 	for i := 0; i < numCerts; i++ {
 		s.certificates[i] = randomBytes(rand.Intn(10)+1, rand)
 	}
+	s.encryptThenMAC = rand.Intn(10) > 5
+	s.extendedMasterSecret = rand.Intn(10) > 5
+	if rand.Intn(10) > 5 {
+		s.pskIdentity = randomBytes(rand.Intn(10), rand)
+	}
+	if rand.Intn(10) > 5 {
+		s.curveID = CurveID(rand.Intn(65535) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		s.createdAt = uint64(rand.Int63()) + 1
+	}
 	return reflect.ValueOf(s)
 }
 
+func (*encryptedExtensionsMsg) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &encryptedExtensionsMsg{}
+	if rand.Intn(10) > 5 {
+		m.alpnProtocol = randomString(rand.Intn(32)+1, rand)
+	}
+	if rand.Intn(10) > 5 {
+		m.maxFragmentLength = uint8(rand.Intn(4) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.recordSizeLimit = uint16(rand.Intn(65535) + 1)
+	}
+	if rand.Intn(10) > 5 {
+		m.clientCertificateTypePresent = true
+		m.clientCertificateType = CertificateType(rand.Intn(256))
+	}
+	if rand.Intn(10) > 5 {
+		m.serverCertificateTypePresent = true
+		m.serverCertificateType = CertificateType(rand.Intn(256))
+	}
+	return reflect.ValueOf(m)
+}
+
+func (*certificateMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &certificateMsgTLS13{}
+	numCerts := rand.Intn(20)
+	m.certificates = make([][]byte, numCerts)
+	for i := 0; i < numCerts; i++ {
+		m.certificates[i] = randomBytes(rand.Intn(10)+1, rand)
+	}
+	if numCerts > 0 && rand.Intn(10) > 5 {
+		m.ocspStaple = randomBytes(rand.Intn(100)+1, rand)
+	}
+	if numCerts > 0 && rand.Intn(10) > 5 {
+		m.scts = make([][]byte, rand.Intn(4)+1)
+		for i := range m.scts {
+			m.scts[i] = randomBytes(rand.Intn(100)+1, rand)
+		}
+	}
+	return reflect.ValueOf(m)
+}
+
+func (*certificateRequestMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &certificateRequestMsgTLS13{}
+	m.signatureAndHashes = supportedSignatureAlgorithmsTLS13
+	numCAs := rand.Intn(100)
+	m.certificateAuthorities = make([][]byte, numCAs)
+	for i := 0; i < numCAs; i++ {
+		m.certificateAuthorities[i] = randomBytes(rand.Intn(15)+1, rand)
+	}
+	return reflect.ValueOf(m)
+}
+
+func (*newSessionTicketMsgTLS13) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &newSessionTicketMsgTLS13{}
+	m.lifetime = uint32(rand.Int63())
+	m.ageAdd = uint32(rand.Int63())
+	m.nonce = randomBytes(rand.Intn(255), rand)
+	m.label = randomBytes(rand.Intn(300)+1, rand)
+	return reflect.ValueOf(m)
+}
+
+func (*keyUpdateMsg) Generate(rand *rand.Rand, size int) reflect.Value {
+	m := &keyUpdateMsg{}
+	m.updateRequested = rand.Intn(10) > 5
+	return reflect.ValueOf(m)
+}
+
 func TestRejectEmptySCTList(t *testing.T) {
 	// https://tools.ietf.org/html/rfc6962#section-3.3.1 specifies that
 	// empty SCT lists are invalid.
@@ -323,3 +498,32 @@ func TestRejectEmptySCT(t *testing.T) {
 		t.Fatal("Unmarshaled ServerHello with zero-length SCT")
 	}
 }
+
+func TestCertificateMsgRawPublicKey(t *testing.T) {
+	// A Certificate message that carries a raw public key has the
+	// SubjectPublicKeyInfo in place of the certificate list, see RFC 7250,
+	// section 3.
+	for _, certificates := range [][][]byte{nil, {{0x30, 0x01, 0x42}}} {
+		m1 := &certificateMsg{certificates: certificates, rawPublicKey: true}
+		marshaled := m1.marshal()
+		var spki []byte
+		if len(certificates) > 0 {
+			spki = certificates[0]
+		}
+		if !bytes.Equal(marshaled[7:], spki) {
+			t.Errorf("raw public key of %x is not %x", marshaled, spki)
+		}
+		m2 := &certificateMsg{rawPublicKey: true}
+		if !m2.unmarshal(marshaled) {
+			t.Fatalf("failed to unmarshal %x", marshaled)
+		}
+		if !m1.equal(m2) {
+			t.Errorf("got:%#v want:%#v %x", m2, m1, marshaled)
+		}
+		for j := 0; j < len(marshaled); j++ {
+			if m2.unmarshal(marshaled[:j]) {
+				t.Errorf("unmarshaled a prefix of length %d of %x", j, marshaled)
+			}
+		}
+	}
+}
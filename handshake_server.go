@@ -6,14 +6,16 @@ package tls
 
 import (
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/subtle"
 	"crypto/x509"
-	"encoding/asn1"
 	"errors"
 	"fmt"
 	"io"
+
+	"golang_org/x/crypto/ed25519"
 )
 
 // serverHandshakeState contains details of a server handshake in progress.
@@ -25,6 +27,8 @@ type serverHandshakeState struct {
 	suite                 *cipherSuite
 	ellipticOk            bool
 	ecdsaOk               bool
+	dsaOk                 bool
+	sm2Ok                 bool
 	rsaDecryptOk          bool
 	rsaSignOk             bool
 	sessionState          *sessionState
@@ -33,6 +37,15 @@ type serverHandshakeState struct {
 	certsFromClient       [][]byte
 	cert                  *Certificate
 	cachedClientHelloInfo *ClientHelloInfo
+
+	// pskCandidates are the candidate keys for the PSK of the client when
+	// there are several, and pskMasterSecrets the master secret of each.
+	pskCandidates    []PSKCandidate
+	pskMasterSecrets [][]byte
+
+	// pskLookupErr is the error that a dummy PSK stands in for, see
+	// Config.PSKDummyKeySecret.
+	pskLookupErr error
 }
 
 // serverHandshake performs a TLS handshake as a server.
@@ -50,10 +63,20 @@ func (c *Conn) serverHandshake() error {
 		return err
 	}
 
+	if c.vers == VersionTLS13 {
+		hs13 := serverHandshakeStateTLS13{
+			c:               c,
+			clientHello:     hs.clientHello,
+			clientHelloInfo: hs.clientHelloInfo(),
+		}
+		return hs13.handshake()
+	}
+
 	// For an overview of TLS handshaking, see https://tools.ietf.org/html/rfc5246#section-7.3
 	c.buffering = true
 	if isResume {
-		// The client has included a session ticket and so we do an abbreviated handshake.
+		// The client has included a session ticket or a cached session
+		// ID and so we do an abbreviated handshake.
 		if err := hs.doResumeHandshake(); err != nil {
 			return err
 		}
@@ -80,15 +103,25 @@ func (c *Conn) serverHandshake() error {
 		}
 		c.didResume = true
 	} else {
-		// The client didn't include a session ticket, or it wasn't
-		// valid so we do a full handshake.
+		// The client didn't include a session ticket or session ID, or
+		// it wasn't valid so we do a full handshake.
 		if err := hs.doFullHandshake(); err != nil {
 			return err
 		}
 		if err := hs.establishKeys(); err != nil {
 			return err
 		}
-		if err := hs.readFinished(c.clientFinished[:]); err != nil {
+		err := hs.readFinished(c.clientFinished[:])
+		if hs.pskLookupErr != nil {
+			// The client can't know a dummy key, so the handshake
+			// fails either way.
+			if err == nil {
+				c.sendAlert(alertHandshakeFailure)
+				err = hs.pskLookupErr
+			}
+			c.config.reportConcealedPSKError(hs.clientHelloInfo(), c.pskIdentity, hs.pskLookupErr)
+		}
+		if err != nil {
 			return err
 		}
 		c.clientFinishedIsFirst = true
@@ -96,6 +129,7 @@ func (c *Conn) serverHandshake() error {
 		if err := hs.sendSessionTicket(); err != nil {
 			return err
 		}
+		hs.cacheSession()
 		if err := hs.sendFinished(nil); err != nil {
 			return err
 		}
@@ -138,13 +172,41 @@ func (hs *serverHandshakeState) readClientHello() (isResume bool, err error) {
 		}
 	}
 
-	c.vers, ok = c.config.mutualVersion(hs.clientHello.vers)
-	if !ok {
+	if hs.clientHello.vers == VersionTLCP && c.config.acceptsTLCP() && c.dtls == nil {
+		// TLCP is asked for by the version alone, see tlcp.go.
+		if c.config.ClientAuth != NoClientCert {
+			c.sendAlert(alertHandshakeFailure)
+			return false, errors.New("tls: TLCP clients can't authenticate")
+		}
+		c.tlcp = true
+		c.vers = VersionTLS12
+		hs.clientHello = tlcpClientHello(hs.clientHello)
+	} else if len(hs.clientHello.supportedVersions) > 0 && c.dtls == nil {
+		// A client offering TLS 1.3 lists its versions in the
+		// supported_versions extension, which takes precedence.
+		c.vers, ok = c.config.mutualVersionTLS13(hs.clientHello.supportedVersions)
+		if !ok {
+			c.sendAlert(alertProtocolVersion)
+			return false, fmt.Errorf("tls: client offered only unsupported versions: %x", hs.clientHello.supportedVersions)
+		}
+	} else {
+		c.vers, ok = c.config.mutualVersion(hs.clientHello.vers)
+		if !ok {
+			c.sendAlert(alertProtocolVersion)
+			return false, fmt.Errorf("tls: client offered an unsupported, maximum protocol version of %x", hs.clientHello.vers)
+		}
+	}
+	if c.dtls != nil && c.vers < VersionTLS12 {
 		c.sendAlert(alertProtocolVersion)
-		return false, fmt.Errorf("tls: client offered an unsupported, maximum protocol version of %x", hs.clientHello.vers)
+		return false, errors.New("tls: client offered only DTLS 1.0, which is not supported")
 	}
 	c.haveVers = true
 
+	if c.vers == VersionTLS13 {
+		// The TLS 1.3 handshake is in handshake_server_tls13.go.
+		return false, nil
+	}
+
 	hs.hello = new(serverHelloMsg)
 
 	supportedCurve := false
@@ -183,6 +245,9 @@ Curves:
 	}
 
 	hs.hello.vers = c.vers
+	if c.tlcp {
+		hs.hello.vers = VersionTLCP
+	}
 	hs.hello.random = make([]byte, 32)
 	_, err = io.ReadFull(c.config.rand(), hs.hello.random)
 	if err != nil {
@@ -190,6 +255,16 @@ Curves:
 		return false, err
 	}
 
+	// A TLS 1.3 server that negotiates an older version signals it in the
+	// last bytes of its random, see RFC 8446, section 4.1.3.
+	if c.config.maxVersion() >= VersionTLS13 && c.dtls == nil && !c.tlcp {
+		if c.vers == VersionTLS12 {
+			copy(hs.hello.random[24:], downgradeCanaryTLS12)
+		} else {
+			copy(hs.hello.random[24:], downgradeCanaryTLS11)
+		}
+	}
+
 	if len(hs.clientHello.secureRenegotiation) != 0 {
 		c.sendAlert(alertHandshakeFailure)
 		return false, errors.New("tls: initial handshake had non-empty renegotiation extension")
@@ -197,6 +272,29 @@ Curves:
 
 	hs.hello.secureRenegotiationSupported = hs.clientHello.secureRenegotiationSupported
 	hs.hello.compressionMethod = compressionNone
+
+	// The extended master secret isn't defined for SSL 3.0.
+	if c.vers >= VersionTLS10 && c.config.ExtendedMasterSecret != ExtendedMasterSecretNever {
+		hs.hello.extendedMasterSecret = hs.clientHello.extendedMasterSecret
+	}
+	if c.config.ExtendedMasterSecret == ExtendedMasterSecretRequired && !hs.hello.extendedMasterSecret {
+		c.sendAlert(alertHandshakeFailure)
+		return false, errors.New("tls: client doesn't support the extended master secret")
+	}
+	c.extendedMasterSecret = hs.hello.extendedMasterSecret
+
+	hs.hello.maxFragmentLength, hs.hello.recordSizeLimit, err = c.serverRecordSizeLimits(hs.clientHello)
+	if err != nil {
+		return false, err
+	}
+
+	hs.hello.clientCertificateTypePresent, hs.hello.serverCertificateTypePresent, err = c.serverCertificateTypes(hs.clientHello)
+	if err != nil {
+		return false, err
+	}
+	hs.hello.clientCertificateType = c.clientCertificateType
+	hs.hello.serverCertificateType = c.serverCertificateType
+
 	if len(hs.clientHello.serverName) > 0 {
 		c.serverName = hs.clientHello.serverName
 	}
@@ -217,8 +315,9 @@ Curves:
 		}
 	}
 
-	hs.cert, err = c.config.getCertificate(hs.clientHelloInfo())
-	if err != nil {
+	if c.tlcp {
+		hs.cert = c.config.TLCPSigningCertificate
+	} else if hs.cert, err = c.config.getCertificate(hs.clientHelloInfo()); err != nil {
 		c.sendAlert(alertInternalError)
 		return false, err
 	}
@@ -227,12 +326,28 @@ Curves:
 			hs.hello.scts = hs.cert.SignedCertificateTimestamps
 		}
 
+		// DSA keys don't implement crypto.Signer
+		if _, ok := hs.cert.PrivateKey.(*dsa.PrivateKey); ok {
+			hs.dsaOk = true
+		}
+		// Neither do SM2 keys
+		if _, ok := hs.cert.PrivateKey.(*sm2PrivateKey); ok {
+			hs.sm2Ok = true
+		}
 		if priv, ok := hs.cert.PrivateKey.(crypto.Signer); ok {
 			switch priv.Public().(type) {
 			case *ecdsa.PublicKey:
 				hs.ecdsaOk = true
+			case ed25519.PublicKey:
+				// Ed25519 keys sign for the ECDSA cipher suites,
+				// but only from TLS 1.2.
+				hs.ecdsaOk = c.vers >= VersionTLS12
 			case *rsa.PublicKey:
 				hs.rsaSignOk = true
+			case *rsaPSSPublicKey:
+				// RSASSA-PSS keys only sign with the RSA-PSS
+				// algorithms of TLS 1.2.
+				hs.rsaSignOk = c.vers >= VersionTLS12
 			default:
 				c.sendAlert(alertInternalError)
 				return false, fmt.Errorf("tls: unsupported signing key type (%T)", priv.Public())
@@ -249,13 +364,17 @@ Curves:
 		}
 	}
 
+	serverCipherSuites := c.config.cipherSuites()
+	if c.tlcp {
+		serverCipherSuites = c.config.cipherSuitesTLCP()
+	}
 	var preferenceList, supportedList []uint16
 	if c.config.PreferServerCipherSuites {
-		preferenceList = c.config.cipherSuites()
+		preferenceList = serverCipherSuites
 		supportedList = hs.clientHello.cipherSuites
 	} else {
 		preferenceList = hs.clientHello.cipherSuites
-		supportedList = c.config.cipherSuites()
+		supportedList = serverCipherSuites
 	}
 
 	for _, id := range preferenceList {
@@ -292,14 +411,24 @@ Curves:
 func (hs *serverHandshakeState) checkForResumption() bool {
 	c := hs.c
 
-	if c.config.SessionTicketsDisabled {
+	// TLCP sessions aren't resumed.
+	if c.tlcp {
 		return false
 	}
 
 	var ok bool
-	var sessionTicket = append([]uint8{}, hs.clientHello.sessionTicket...)
-	if hs.sessionState, ok = c.decryptTicket(sessionTicket); !ok {
-		return false
+	if len(hs.clientHello.sessionTicket) == 0 && c.config.ServerSessionCache != nil {
+		if hs.sessionState, ok = hs.getCachedSession(); !ok {
+			return false
+		}
+	} else {
+		if c.config.SessionTicketsDisabled {
+			return false
+		}
+		var sessionTicket = append([]uint8{}, hs.clientHello.sessionTicket...)
+		if hs.sessionState, ok = c.decryptTicket(sessionTicket); !ok {
+			return false
+		}
 	}
 
 	// Never resume a session for a different TLS version.
@@ -333,9 +462,47 @@ func (hs *serverHandshakeState) checkForResumption() bool {
 		return false
 	}
 
+	// The credentials of the session must be of the negotiated types.
+	if sessionHasClientCerts && hs.sessionState.clientRawPublicKey != (c.clientCertificateType == CertificateTypeRawPublicKey) {
+		return false
+	}
+	if hs.sessionState.serverRawPublicKey != (c.serverCertificateType == CertificateTypeRawPublicKey) {
+		return false
+	}
+
+	// A session authenticated with a PSK needs the PSK to be still known.
+	if hs.sessionState.pskIdentity != nil && !c.config.knowsPSKIdentity(hs.clientHelloInfo(), hs.sessionState.pskIdentity) {
+		return false
+	}
+
+	// Never resume a session with a different kind of master secret, see
+	// RFC 7627, section 5.3.
+	if hs.sessionState.extendedMasterSecret != hs.hello.extendedMasterSecret {
+		return false
+	}
+
+	// A session that uses encrypt-then-MAC can't be resumed without it.
+	if hs.sessionState.encryptThenMAC && (!hs.clientHello.encryptThenMAC || c.config.EncryptThenMACDisabled) {
+		return false
+	}
+
 	return true
 }
 
+// getCachedSession looks up the session that the client's session ID refers
+// to in the ServerSessionCache, unless it expired.
+func (hs *serverHandshakeState) getCachedSession() (*sessionState, bool) {
+	if len(hs.clientHello.sessionId) == 0 {
+		return nil, false
+	}
+	ss, ok := hs.c.config.ServerSessionCache.Get(string(hs.clientHello.sessionId))
+	if !ok || ss == nil || ss.session.createdAt == 0 || !hs.c.config.time().Before(ss.Expires()) {
+		return nil, false
+	}
+	state := ss.session
+	return &state, true
+}
+
 func (hs *serverHandshakeState) doResumeHandshake() error {
 	c := hs.c
 
@@ -344,7 +511,9 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 	// that we're doing a resumption.
 	hs.hello.sessionId = hs.clientHello.sessionId
 	hs.hello.ticketSupported = hs.sessionState.usedOldKey
+	hs.hello.encryptThenMAC = hs.sessionState.encryptThenMAC
 	hs.finishedHash = newFinishedHash(c.vers, hs.suite)
+	hs.finishedHash.dtls = c.dtls
 	hs.finishedHash.discardHandshakeBuffer()
 	hs.finishedHash.Write(hs.clientHello.marshal())
 	hs.finishedHash.Write(hs.hello.marshal())
@@ -353,12 +522,15 @@ func (hs *serverHandshakeState) doResumeHandshake() error {
 	}
 
 	if len(hs.sessionState.certificates) > 0 {
-		if _, err := hs.processCertsFromClient(hs.sessionState.certificates); err != nil {
+		hs.certsFromClient = hs.sessionState.certificates
+		if _, err := c.processCertsFromClient(hs.certsFromClient); err != nil {
 			return err
 		}
 	}
 
 	hs.masterSecret = hs.sessionState.masterSecret
+	c.pskIdentity = hs.sessionState.pskIdentity
+	c.curveID = hs.sessionState.curveID
 
 	return nil
 }
@@ -371,9 +543,21 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	}
 
 	hs.hello.ticketSupported = hs.clientHello.ticketSupported && !c.config.SessionTicketsDisabled
+	if !hs.hello.ticketSupported && c.config.ServerSessionCache != nil && !c.tlcp {
+		// Issue a session ID so that the client can resume the
+		// session without a ticket.
+		hs.hello.sessionId = make([]byte, 32)
+		if _, err := io.ReadFull(c.config.rand(), hs.hello.sessionId); err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+	}
 	hs.hello.cipherSuite = hs.suite.id
+	hs.hello.encryptThenMAC = hs.clientHello.encryptThenMAC && hs.suite.usesCBC() &&
+		c.vers >= VersionTLS10 && !c.config.EncryptThenMACDisabled
 
 	hs.finishedHash = newFinishedHash(hs.c.vers, hs.suite)
+	hs.finishedHash.dtls = hs.c.dtls
 	if c.config.ClientAuth == NoClientCert {
 		// No need to keep a full record of the handshake if client
 		// certificates won't be used.
@@ -387,7 +571,18 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 
 	certMsg := new(certificateMsg)
 	if hs.suite.flags&suiteNoCerts == 0 {
-		certMsg.certificates = hs.cert.Certificate
+		certificates, err := hs.cert.certificatesOfType(c.serverCertificateType)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		// A TLCP server sends its encryption certificate right after
+		// its signing one.
+		if c.tlcp {
+			certificates = append([][]byte{certificates[0], c.config.TLCPEncryptionCertificate.Certificate[0]}, certificates[1:]...)
+		}
+		certMsg.certificates = certificates
+		certMsg.rawPublicKey = c.serverCertificateType == CertificateTypeRawPublicKey
 		hs.finishedHash.Write(certMsg.marshal())
 		if _, err := c.writeRecord(recordTypeHandshake, certMsg.marshal()); err != nil {
 			return err
@@ -405,7 +600,12 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	}
 
 	keyAgreement := hs.suite.ka(c.vers)
+	setKeyAgreementClientHelloInfo(keyAgreement, hs.clientHelloInfo())
 	skx, err := keyAgreement.generateServerKeyExchange(c.config, hs.cert, hs.clientHello, hs.hello)
+	if err == errUnknownSRPUsername {
+		c.sendAlert(alertUnknownPSKIdentity)
+		return err
+	}
 	if err != nil {
 		c.sendAlert(alertHandshakeFailure)
 		return err
@@ -426,7 +626,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		}
 		if c.vers >= VersionTLS12 {
 			certReq.hasSignatureAndHash = true
-			certReq.signatureAndHashes = supportedSignatureAlgorithms
+			certReq.signatureAndHashes = c.config.signatureAlgorithms()
 		}
 
 		// An empty list of certificateAuthorities signals to
@@ -479,7 +679,8 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 			}
 		}
 
-		pub, err = hs.processCertsFromClient(certMsg.certificates)
+		hs.certsFromClient = certMsg.certificates
+		pub, err = c.processCertsFromClient(certMsg.certificates)
 		if err != nil {
 			return err
 		}
@@ -499,12 +700,31 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	hs.finishedHash.Write(ckx.marshal())
 
 	preMasterSecret, err := keyAgreement.processClientKeyExchange(c.config, hs.cert, ckx, c.vers)
+	if err == ErrUnknownPSKIdentity {
+		c.sendAlert(alertUnknownPSKIdentity)
+		return err
+	}
 	if err != nil {
 		c.sendAlert(alertHandshakeFailure)
 		return err
 	}
-	hs.masterSecret = masterFromPreMasterSecret(c.vers, hs.suite, preMasterSecret, hs.clientHello.random, hs.hello.random)
-	if err := c.config.writeKeyLog(hs.clientHello.random, hs.masterSecret); err != nil {
+	c.curveID = keyAgreementCurveID(keyAgreement)
+	c.pskIdentity = keyAgreementPSKIdentity(keyAgreement)
+	hs.pskLookupErr = keyAgreementPSKLookupError(keyAgreement)
+	hs.masterSecret = hs.masterFromPreMasterSecret(preMasterSecret)
+	candidates, preMasterSecrets := keyAgreementPSKCandidates(keyAgreement)
+	if len(candidates) > 0 {
+		c.pskKeyVersion = candidates[0].Version
+	}
+	if len(candidates) > 1 {
+		// Which key the client holds shows once its Finished message
+		// decrypts under one of them, see selectPSKCandidate.
+		hs.pskCandidates = candidates
+		hs.pskMasterSecrets = make([][]byte, len(candidates))
+		for i, preMasterSecret := range preMasterSecrets {
+			hs.pskMasterSecrets[i] = hs.masterFromPreMasterSecret(preMasterSecret)
+		}
+	} else if err := c.config.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret); err != nil {
 		c.sendAlert(alertInternalError)
 		return err
 	}
@@ -515,7 +735,7 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	// handshake-layer messages that is signed using the private key corresponding
 	// to the client's certificate. This allows us to verify that the client is in
 	// possession of the private key of the certificate.
-	if len(c.peerCertificates) > 0 {
+	if pub != nil {
 		msg, err = c.readHandshake()
 		if err != nil {
 			return err
@@ -530,53 +750,20 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 		var signatureAndHash signatureAndHash
 		if certVerify.hasSignatureAndHash {
 			signatureAndHash = certVerify.signatureAndHash
-			if !isSupportedSignatureAndHash(signatureAndHash, supportedSignatureAlgorithms) {
+			if !isSupportedSignatureAndHash(signatureAndHash, c.config.signatureAlgorithms()) {
 				return errors.New("tls: unsupported hash function for client certificate")
 			}
 		} else {
 			// Before TLS 1.2 the signature algorithm was implicit
 			// from the key type, and only one hash per signature
 			// algorithm was possible. Leave the hash as zero.
-			switch pub.(type) {
-			case *ecdsa.PublicKey:
-				signatureAndHash.signature = signatureECDSA
-			case *rsa.PublicKey:
-				signatureAndHash.signature = signatureRSA
-			}
+			signatureAndHash.signature = signatureTypeForKey(pub)
 		}
 
-		switch key := pub.(type) {
-		case *ecdsa.PublicKey:
-			if signatureAndHash.signature != signatureECDSA {
-				err = errors.New("tls: bad signature type for client's ECDSA certificate")
-				break
-			}
-			ecdsaSig := new(ecdsaSignature)
-			if _, err = asn1.Unmarshal(certVerify.signature, ecdsaSig); err != nil {
-				break
-			}
-			if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
-				err = errors.New("tls: ECDSA signature contained zero or negative values")
-				break
-			}
-			var digest []byte
-			if digest, _, err = hs.finishedHash.hashForClientCertificate(signatureAndHash, hs.masterSecret); err != nil {
-				break
-			}
-			if !ecdsa.Verify(key, digest, ecdsaSig.R, ecdsaSig.S) {
-				err = errors.New("tls: ECDSA verification failure")
-			}
-		case *rsa.PublicKey:
-			if signatureAndHash.signature != signatureRSA {
-				err = errors.New("tls: bad signature type for client's RSA certificate")
-				break
-			}
-			var digest []byte
-			var hashFunc crypto.Hash
-			if digest, hashFunc, err = hs.finishedHash.hashForClientCertificate(signatureAndHash, hs.masterSecret); err != nil {
-				break
-			}
-			err = rsa.VerifyPKCS1v15(key, hashFunc, digest, certVerify.signature)
+		var digest []byte
+		var hashFunc crypto.Hash
+		if digest, hashFunc, err = hs.finishedHash.hashForClientCertificate(signatureAndHash, hs.masterSecret); err == nil {
+			err = verifyHandshakeSignature(pub, signatureAndHash, hashFunc, digest, certVerify.signature)
 		}
 		if err != nil {
 			c.sendAlert(alertBadCertificate)
@@ -591,31 +778,69 @@ func (hs *serverHandshakeState) doFullHandshake() error {
 	return nil
 }
 
-func (hs *serverHandshakeState) establishKeys() error {
+// masterFromPreMasterSecret returns the master secret of the connection for
+// preMasterSecret.
+func (hs *serverHandshakeState) masterFromPreMasterSecret(preMasterSecret []byte) []byte {
+	if hs.c.extendedMasterSecret {
+		return extendedMasterFromPreMasterSecret(hs.c.vers, hs.suite, preMasterSecret, hs.finishedHash.Sum())
+	}
+	return masterFromPreMasterSecret(hs.c.vers, hs.suite, preMasterSecret, hs.clientHello.random, hs.hello.random)
+}
+
+// cipherSpecs returns the cipher specs of the client and of the server that
+// masterSecret yields.
+func (hs *serverHandshakeState) cipherSpecs(masterSecret []byte) (client, server cipherSpec) {
 	c := hs.c
 
 	clientMAC, serverMAC, clientKey, serverKey, clientIV, serverIV :=
-		keysFromMasterSecret(c.vers, hs.suite, hs.masterSecret, hs.clientHello.random, hs.hello.random, hs.suite.macLen, hs.suite.keyLen, hs.suite.ivLen)
-
-	var clientCipher, serverCipher interface{}
-	var clientHash, serverHash macFunction
+		keysFromMasterSecret(c.vers, hs.suite, masterSecret, hs.clientHello.random, hs.hello.random, hs.suite.macLen, hs.suite.keyLen, hs.suite.ivLen)
 
 	if hs.suite.aead == nil {
-		clientCipher = hs.suite.cipher(clientKey, clientIV, true /* for reading */)
-		clientHash = hs.suite.mac(c.vers, clientMAC)
-		serverCipher = hs.suite.cipher(serverKey, serverIV, false /* not for reading */)
-		serverHash = hs.suite.mac(c.vers, serverMAC)
+		client.cipher = hs.suite.cipher(clientKey, clientIV, true /* for reading */)
+		client.mac = hs.suite.mac(c.vers, clientMAC)
+		server.cipher = hs.suite.cipher(serverKey, serverIV, false /* not for reading */)
+		server.mac = hs.suite.mac(c.vers, serverMAC)
 	} else {
-		clientCipher = hs.suite.aead(clientKey, clientIV)
-		serverCipher = hs.suite.aead(serverKey, serverIV)
+		client.cipher = hs.suite.aead(clientKey, clientIV)
+		server.cipher = hs.suite.aead(serverKey, serverIV)
 	}
+	return client, server
+}
+
+func (hs *serverHandshakeState) establishKeys() error {
+	c := hs.c
+
+	client, server := hs.cipherSpecs(hs.masterSecret)
+	c.in.prepareCipherSpec(c.vers, client.cipher, client.mac, hs.hello.encryptThenMAC)
+	c.out.prepareCipherSpec(c.vers, server.cipher, server.mac, hs.hello.encryptThenMAC)
 
-	c.in.prepareCipherSpec(c.vers, clientCipher, clientHash)
-	c.out.prepareCipherSpec(c.vers, serverCipher, serverHash)
+	if hs.pskMasterSecrets != nil {
+		candidates := make([]cipherSpec, len(hs.pskMasterSecrets))
+		for i, masterSecret := range hs.pskMasterSecrets {
+			candidates[i], _ = hs.cipherSpecs(masterSecret)
+		}
+		c.in.prepareCandidateCipherSpecs(candidates)
+	}
 
 	return nil
 }
 
+// selectPSKCandidate switches to the PSK candidate whose keys the records of
+// the client decrypted under.
+func (hs *serverHandshakeState) selectPSKCandidate() error {
+	c := hs.c
+
+	i := c.in.candidate
+	hs.masterSecret = hs.pskMasterSecrets[i]
+	c.pskKeyVersion = hs.pskCandidates[i].Version
+	hs.pskCandidates, hs.pskMasterSecrets = nil, nil
+
+	_, server := hs.cipherSpecs(hs.masterSecret)
+	c.out.prepareCipherSpec(c.vers, server.cipher, server.mac, hs.hello.encryptThenMAC)
+
+	return c.config.writeKeyLog(keyLogLabelTLS12, hs.clientHello.random, hs.masterSecret)
+}
+
 func (hs *serverHandshakeState) readFinished(out []byte) error {
 	c := hs.c
 
@@ -648,6 +873,13 @@ func (hs *serverHandshakeState) readFinished(out []byte) error {
 		return unexpectedMessageError(clientFinished, msg)
 	}
 
+	if hs.pskCandidates != nil {
+		if err := hs.selectPSKCandidate(); err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+	}
+
 	verify := hs.finishedHash.clientSum(hs.masterSecret)
 	if len(verify) != len(clientFinished.verifyData) ||
 		subtle.ConstantTimeCompare(verify, clientFinished.verifyData) != 1 {
@@ -669,12 +901,7 @@ func (hs *serverHandshakeState) sendSessionTicket() error {
 	m := new(newSessionTicketMsg)
 
 	var err error
-	state := sessionState{
-		vers:         c.vers,
-		cipherSuite:  hs.suite.id,
-		masterSecret: hs.masterSecret,
-		certificates: hs.certsFromClient,
-	}
+	state := hs.newSessionState()
 	m.ticket, err = c.encryptTicket(&state)
 	if err != nil {
 		return err
@@ -688,6 +915,38 @@ func (hs *serverHandshakeState) sendSessionTicket() error {
 	return nil
 }
 
+// cacheSession adds the session to the ServerSessionCache under the session
+// ID that was issued for it.
+func (hs *serverHandshakeState) cacheSession() {
+	if len(hs.hello.sessionId) == 0 || hs.c.config.ServerSessionCache == nil {
+		return
+	}
+	state := hs.newSessionState()
+	state.createdAt = uint64(hs.c.config.time().Unix())
+	hs.c.config.ServerSessionCache.Put(string(hs.hello.sessionId), &ServerSessionState{session: state})
+}
+
+// newSessionState returns the state of the session that was just
+// established, to be resumed later.
+func (hs *serverHandshakeState) newSessionState() sessionState {
+	c := hs.c
+	state := sessionState{
+		vers:                 c.vers,
+		cipherSuite:          hs.suite.id,
+		masterSecret:         hs.masterSecret,
+		certificates:         hs.certsFromClient,
+		encryptThenMAC:       hs.hello.encryptThenMAC,
+		extendedMasterSecret: c.extendedMasterSecret,
+		clientRawPublicKey:   len(hs.certsFromClient) > 0 && c.clientCertificateType == CertificateTypeRawPublicKey,
+		serverRawPublicKey:   c.serverCertificateType == CertificateTypeRawPublicKey,
+		pskIdentity:          c.pskIdentity,
+	}
+	if c.pskIdentity != nil {
+		state.curveID = c.curveID
+	}
+	return state
+}
+
 func (hs *serverHandshakeState) sendFinished(out []byte) error {
 	c := hs.c
 
@@ -710,15 +969,20 @@ func (hs *serverHandshakeState) sendFinished(out []byte) error {
 
 // processCertsFromClient takes a chain of client certificates either from a
 // Certificates message or from a sessionState and verifies them. It returns
-// the public key of the leaf certificate.
-func (hs *serverHandshakeState) processCertsFromClient(certificates [][]byte) (crypto.PublicKey, error) {
-	c := hs.c
+// the public key of the leaf certificate. If the client authenticates with a
+// raw public key, certificates holds that key instead.
+func (c *Conn) processCertsFromClient(certificates [][]byte) (crypto.PublicKey, error) {
+	if c.clientCertificateType == CertificateTypeRawPublicKey {
+		if len(certificates) == 0 {
+			return nil, nil
+		}
+		return c.processRawPublicKey(certificates[0], c.config.ClientAuth >= VerifyClientCertIfGiven)
+	}
 
-	hs.certsFromClient = certificates
 	certs := make([]*x509.Certificate, len(certificates))
 	var err error
 	for i, asn1Data := range certificates {
-		if certs[i], err = x509.ParseCertificate(asn1Data); err != nil {
+		if certs[i], err = parseCertificate(asn1Data); err != nil {
 			c.sendAlert(alertBadCertificate)
 			return nil, errors.New("tls: failed to parse client certificate: " + err.Error())
 		}
@@ -758,7 +1022,7 @@ func (hs *serverHandshakeState) processCertsFromClient(certificates [][]byte) (c
 
 	var pub crypto.PublicKey
 	switch key := certs[0].PublicKey.(type) {
-	case *ecdsa.PublicKey, *rsa.PublicKey:
+	case *ecdsa.PublicKey, *rsa.PublicKey, *rsaPSSPublicKey, ed25519.PublicKey:
 		pub = key
 	default:
 		c.sendAlert(alertUnsupportedCertificate)
@@ -768,6 +1032,74 @@ func (hs *serverHandshakeState) processCertsFromClient(certificates [][]byte) (c
 	return pub, nil
 }
 
+// serverRecordSizeLimits sets the record size limits of the connection
+// from the max_fragment_length and record_size_limit extensions of the
+// client and returns the values of the extensions to answer with. A client's
+// record_size_limit takes precedence, see RFC 8449, section 5.
+func (c *Conn) serverRecordSizeLimits(hello *clientHelloMsg) (maxFragmentLength uint8, recordSizeLimit uint16, err error) {
+	c.maxFragmentLength = 0
+	c.recordSizeLimit = 0
+	c.peerRecordSizeLimit = 0
+
+	if hello.recordSizeLimit != 0 {
+		if hello.recordSizeLimit < minRecordSizeLimit {
+			c.sendAlert(alertIllegalParameter)
+			return 0, 0, errors.New("tls: client sent an invalid record size limit")
+		}
+		limit, err := c.config.recordSizeLimit()
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return 0, 0, err
+		}
+		c.recordSizeLimit = limit
+		c.peerRecordSizeLimit = recordSizeLimitPlaintext(hello.recordSizeLimit, c.vers)
+		return 0, recordSizeLimitValue(limit, c.vers), nil
+	}
+
+	if hello.maxFragmentLength != 0 {
+		if hello.maxFragmentLength > 4 {
+			c.sendAlert(alertIllegalParameter)
+			return 0, 0, errors.New("tls: client sent an invalid max fragment length")
+		}
+		c.maxFragmentLength = 1 << (8 + hello.maxFragmentLength)
+		return hello.maxFragmentLength, 0, nil
+	}
+
+	return 0, 0, nil
+}
+
+// serverCertificateTypes sets the certificate types of the connection from
+// the client_certificate_type and server_certificate_type extensions of the
+// client, see RFC 7250, section 4.2, and reports whether to answer each of
+// them. The client certificate type is only negotiated if client
+// certificates are requested.
+func (c *Conn) serverCertificateTypes(hello *clientHelloMsg) (clientTypePresent, serverTypePresent bool, err error) {
+	c.clientCertificateType = CertificateTypeX509
+	c.serverCertificateType = CertificateTypeX509
+
+	if len(hello.serverCertificateTypes) > 0 {
+		certType, ok := mutualCertificateType(certificateTypes(c.config.ServerCertificateTypes), hello.serverCertificateTypes)
+		if !ok {
+			c.sendAlert(alertUnsupportedCertificate)
+			return false, false, errors.New("tls: no server certificate type supported by both client and server")
+		}
+		c.serverCertificateType = certType
+		serverTypePresent = true
+	}
+
+	if len(hello.clientCertificateTypes) > 0 && c.config.ClientAuth != NoClientCert {
+		certType, ok := mutualCertificateType(certificateTypes(c.config.ClientCertificateTypes), hello.clientCertificateTypes)
+		if !ok {
+			c.sendAlert(alertUnsupportedCertificate)
+			return false, false, errors.New("tls: no client certificate type supported by both client and server")
+		}
+		c.clientCertificateType = certType
+		clientTypePresent = true
+	}
+
+	return clientTypePresent, serverTypePresent, nil
+}
+
 // setCipherSuite sets a cipherSuite with the given id as the serverHandshakeState
 // suite if that cipher suite is acceptable to use.
 // It returns a bool indicating if the suite was set.
@@ -787,6 +1119,10 @@ func (hs *serverHandshakeState) setCipherSuite(id uint16, supportedCipherSuites
 			}
 			// Don't select a ciphersuite which we can't
 			// support for this client.
+			// TLCP cipher suites are only used in TLCP and vice versa.
+			if (candidate.flags&suiteTLCP != 0) != hs.c.tlcp {
+				continue
+			}
 			if candidate.flags&suiteECDHE != 0 {
 				if !hs.ellipticOk {
 					continue
@@ -797,22 +1133,44 @@ func (hs *serverHandshakeState) setCipherSuite(id uint16, supportedCipherSuites
 					}
 				}
 			}
+			if candidate.flags&suiteDSS != 0 && !hs.dsaOk {
+				continue
+			}
+			if candidate.flags&suiteSM2 != 0 && !hs.sm2Ok {
+				continue
+			}
+			if candidate.flags&suiteNull != 0 && !hs.c.config.AllowNullCipherSuites {
+				continue
+			}
 			if candidate.flags&suiteRSA != 0 {
-				if !hs.rsaDecryptOk {
-					continue
-				} else if !hs.rsaSignOk {
+				// The RSA key signs the key exchange of the ECDHE,
+				// DHE and SRP cipher suites and decrypts the others.
+				if candidate.flags&(suiteECDHE|suiteDHE|suiteSRP) != 0 {
+					if !hs.rsaSignOk {
+						continue
+					}
+				} else if !hs.rsaDecryptOk {
 					continue
 				}
 			}
-			// If DH Parameters weren't configured, can't use DHE
+			// DHE needs a group that we and the client both support
 			if candidate.flags&suiteDHE != 0 {
-				if hs.c.config.DhParameters == nil {
+				if _, dhp := hs.c.config.serverDhParams(hs.clientHello); dhp == nil {
+					continue
+				}
+			}
+			// SRP needs a username from the client and a way to look it up
+			if candidate.flags&suiteSRP != 0 {
+				if len(hs.clientHello.srpUsername) == 0 || hs.c.config.GetSRPVerifier == nil {
 					continue
 				}
 			}
 			if version < VersionTLS12 && candidate.flags&suiteTLS12 != 0 {
 				continue
 			}
+			if hs.c.dtls != nil && candidate.flags&suiteNoDTLS != 0 {
+				continue
+			}
 			hs.suite = candidate
 			return true
 		}
@@ -829,7 +1187,9 @@ func (hs *serverHandshakeState) clientHelloInfo() *ClientHelloInfo {
 	}
 
 	var supportedVersions []uint16
-	if hs.clientHello.vers > VersionTLS12 {
+	if len(hs.clientHello.supportedVersions) > 0 {
+		supportedVersions = hs.clientHello.supportedVersions
+	} else if hs.clientHello.vers > VersionTLS12 {
 		supportedVersions = suppVersArray[:]
 	} else if hs.clientHello.vers >= VersionSSL30 {
 		supportedVersions = suppVersArray[VersionTLS12-hs.clientHello.vers:]
@@ -6,9 +6,11 @@ package tls
 
 import (
 	"bytes"
+	"crypto"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/rsa"
+	"crypto/x509"
 	"encoding/hex"
 	"encoding/pem"
 	"errors"
@@ -19,6 +21,7 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"reflect"
 	"strings"
 	"testing"
 	"time"
@@ -55,6 +58,16 @@ func init() {
 		MinVersion:         VersionSSL30,
 		MaxVersion:         VersionTLS12,
 		CipherSuites:       allCipherSuites(),
+		// The recorded handshakes in testdata predate encrypt-then-MAC
+		// and the extended master secret.
+		EncryptThenMACDisabled: true,
+		ExtendedMasterSecret:   ExtendedMasterSecretNever,
+		// And they were made with these signature algorithms only.
+		SignatureSchemes: []SignatureScheme{
+			PKCS1WithSHA256, ECDSAWithP256AndSHA256,
+			PKCS1WithSHA384, ECDSAWithP384AndSHA384,
+			PKCS1WithSHA1, ECDSAWithSHA1,
+		},
 	}
 	testConfig.Certificates[0].Certificate = [][]byte{testRSACertificate}
 	testConfig.Certificates[0].PrivateKey = testRSAPrivateKey
@@ -373,6 +386,698 @@ func TestCipherSuitePreference(t *testing.T) {
 	}
 }
 
+func TestECDHEPSKHandshake(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	suites := []uint16{
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+		TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA,
+		TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA384,
+		TLS_ECDHE_PSK_WITH_CHACHA20_POLY1305_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256,
+		TLS_ECDHE_PSK_WITH_AES_256_GCM_SHA384,
+	}
+	curves := []CurveID{X25519, CurveP256, CurveP384, CurveP521}
+
+	for _, suite := range suites {
+		for _, curve := range curves {
+			serverConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				Certificates:       testConfig.Certificates,
+				GetPSKIdentityHint: func() ([]byte, error) { return []byte("hint"), nil },
+				GetPSKKey: func(identity string) ([]byte, error) {
+					if identity != "client" {
+						return nil, errors.New("unknown identity")
+					}
+					return psk, nil
+				},
+			}
+			clientConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				CurvePreferences:   []CurveID{curve},
+				InsecureSkipVerify: true,
+				GetPSKIdentity: func(hint []byte) (string, error) {
+					if string(hint) != "hint" {
+						return "", fmt.Errorf("unexpected identity hint %q", hint)
+					}
+					return "client", nil
+				},
+				GetPSKKey: func(identity string) ([]byte, error) { return psk, nil },
+			}
+			state, _, err := testHandshake(clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%x/%d: handshake failed: %s", suite, curve, err)
+			}
+			if state.CipherSuite != suite {
+				t.Fatalf("%x/%d: got cipher suite %x", suite, curve, state.CipherSuite)
+			}
+		}
+	}
+}
+
+// testSuiteHandshake runs a handshake with suite as the only cipher suite,
+// an ECDSA server certificate if ecdsa is set and an RSA one otherwise, and
+// a PSK in case the suite needs one.
+func testSuiteHandshake(t *testing.T, suite uint16, ecdsa bool) {
+	psk := []byte("0123456789abcdef")
+	serverConfig := &Config{
+		CipherSuites: []uint16{suite},
+		Certificates: testConfig.Certificates,
+		GetPSKKey:    func(identity string) ([]byte, error) { return psk, nil },
+	}
+	if ecdsa {
+		serverConfig.Certificates = []Certificate{{
+			Certificate: [][]byte{testECDSACertificate},
+			PrivateKey:  testECDSAPrivateKey,
+		}}
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{suite},
+		InsecureSkipVerify: true,
+		GetPSKIdentity:     func(hint []byte) (string, error) { return "client", nil },
+		GetPSKKey:          func(identity string) ([]byte, error) { return psk, nil },
+	}
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("%x: handshake failed: %s", suite, err)
+	}
+	if state.CipherSuite != suite {
+		t.Fatalf("%x: got cipher suite %x", suite, state.CipherSuite)
+	}
+}
+
+func TestCCMHandshake(t *testing.T) {
+	tests := []struct {
+		suite uint16
+		ecdsa bool
+	}{
+		{TLS_PSK_WITH_AES_128_CCM, false},
+		{TLS_PSK_WITH_AES_256_CCM, false},
+		{TLS_PSK_WITH_AES_128_CCM_8, false},
+		{TLS_PSK_WITH_AES_256_CCM_8, false},
+		{TLS_DHE_PSK_WITH_AES_128_CCM, false},
+		{TLS_DHE_PSK_WITH_AES_256_CCM, false},
+		{TLS_PSK_DHE_WITH_AES_128_CCM_8, false},
+		{TLS_PSK_DHE_WITH_AES_256_CCM_8, false},
+		{TLS_DHE_RSA_WITH_AES_128_CCM, false},
+		{TLS_DHE_RSA_WITH_AES_256_CCM, false},
+		{TLS_DHE_RSA_WITH_AES_128_CCM_8, false},
+		{TLS_DHE_RSA_WITH_AES_256_CCM_8, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_CCM, true},
+		{TLS_ECDHE_ECDSA_WITH_AES_256_CCM, true},
+		{TLS_ECDHE_ECDSA_WITH_AES_128_CCM_8, true},
+		{TLS_ECDHE_ECDSA_WITH_AES_256_CCM_8, true},
+	}
+
+	for _, test := range tests {
+		testSuiteHandshake(t, test.suite, test.ecdsa)
+	}
+}
+
+func TestCamelliaARIAHandshake(t *testing.T) {
+	tests := []struct {
+		suite uint16
+		ecdsa bool
+	}{
+		{TLS_RSA_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_DHE_RSA_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_RSA_WITH_CAMELLIA_256_CBC_SHA256, false},
+		{TLS_DHE_RSA_WITH_CAMELLIA_256_CBC_SHA256, false},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_CBC_SHA256, true},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_CBC_SHA384, true},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_256_CBC_SHA384, false},
+		{TLS_RSA_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_RSA_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_DHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_DHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_128_GCM_SHA256, true},
+		{TLS_ECDHE_ECDSA_WITH_CAMELLIA_256_GCM_SHA384, true},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_ECDHE_RSA_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_PSK_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_PSK_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_DHE_PSK_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_DHE_PSK_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_RSA_PSK_WITH_CAMELLIA_128_GCM_SHA256, false},
+		{TLS_RSA_PSK_WITH_CAMELLIA_256_GCM_SHA384, false},
+		{TLS_PSK_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_PSK_WITH_CAMELLIA_256_CBC_SHA384, false},
+		{TLS_DHE_PSK_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_DHE_PSK_WITH_CAMELLIA_256_CBC_SHA384, false},
+		{TLS_RSA_PSK_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_RSA_PSK_WITH_CAMELLIA_256_CBC_SHA384, false},
+		{TLS_ECDHE_PSK_WITH_CAMELLIA_128_CBC_SHA256, false},
+		{TLS_ECDHE_PSK_WITH_CAMELLIA_256_CBC_SHA384, false},
+		{TLS_RSA_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_RSA_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_DHE_RSA_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_DHE_RSA_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_128_CBC_SHA256, true},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_256_CBC_SHA384, true},
+		{TLS_ECDHE_RSA_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_RSA_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_RSA_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_DHE_RSA_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_DHE_RSA_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_128_GCM_SHA256, true},
+		{TLS_ECDHE_ECDSA_WITH_ARIA_256_GCM_SHA384, true},
+		{TLS_ECDHE_RSA_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_ECDHE_RSA_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_PSK_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_PSK_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_DHE_PSK_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_DHE_PSK_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_RSA_PSK_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_RSA_PSK_WITH_ARIA_256_CBC_SHA384, false},
+		{TLS_PSK_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_PSK_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_DHE_PSK_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_DHE_PSK_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_RSA_PSK_WITH_ARIA_128_GCM_SHA256, false},
+		{TLS_RSA_PSK_WITH_ARIA_256_GCM_SHA384, false},
+		{TLS_ECDHE_PSK_WITH_ARIA_128_CBC_SHA256, false},
+		{TLS_ECDHE_PSK_WITH_ARIA_256_CBC_SHA384, false},
+	}
+
+	for _, test := range tests {
+		testSuiteHandshake(t, test.suite, test.ecdsa)
+	}
+}
+
+func TestSHA384CBCHandshake(t *testing.T) {
+	tests := []struct {
+		suite uint16
+		ecdsa bool
+	}{
+		{TLS_PSK_WITH_AES_256_CBC_SHA384, false},
+		{TLS_DHE_PSK_WITH_AES_256_CBC_SHA384, false},
+		{TLS_RSA_PSK_WITH_AES_256_CBC_SHA384, false},
+		{TLS_ECDHE_PSK_WITH_AES_256_CBC_SHA384, false},
+		{TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384, false},
+		{TLS_ECDHE_ECDSA_WITH_AES_256_CBC_SHA384, true},
+	}
+
+	for _, test := range tests {
+		testSuiteHandshake(t, test.suite, test.ecdsa)
+	}
+}
+
+func TestECDHAnonHandshake(t *testing.T) {
+	suites := []uint16{
+		TLS_ECDH_anon_WITH_AES_128_CBC_SHA,
+		TLS_ECDH_anon_WITH_AES_256_CBC_SHA,
+	}
+
+	for _, suite := range suites {
+		serverConfig := &Config{
+			CipherSuites: []uint16{suite},
+			Certificates: testConfig.Certificates,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{suite},
+			InsecureSkipVerify: true,
+		}
+		state, clientState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", suite, err)
+		}
+		if state.CipherSuite != suite {
+			t.Fatalf("%x: got cipher suite %x", suite, state.CipherSuite)
+		}
+		if len(clientState.PeerCertificates) != 0 {
+			t.Fatalf("%x: server sent a certificate", suite)
+		}
+		if state.CurveID != X25519 {
+			t.Fatalf("%x: got curve %d, want X25519", suite, state.CurveID)
+		}
+	}
+}
+
+func TestTLCPHandshake(t *testing.T) {
+	signCert, err := X509KeyPair([]byte(sm2CertPEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encCert, err := X509KeyPair([]byte(sm2EncCertPEM), []byte(sm2EncKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The same server speaks TLS with its RSA certificate.
+	serverConfig := &Config{
+		Certificates:              testConfig.Certificates,
+		TLCPSigningCertificate:    &signCert,
+		TLCPEncryptionCertificate: &encCert,
+	}
+
+	for _, suite := range []uint16{TLS_ECC_SM4_CBC_SM3, TLS_ECC_SM4_GCM_SM3} {
+		clientConfig := &Config{
+			CipherSuites:       []uint16{suite},
+			MaxVersion:         VersionTLCP,
+			InsecureSkipVerify: true,
+		}
+		state, clientState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", suite, err)
+		}
+		if state.Version != VersionTLCP || clientState.Version != VersionTLCP {
+			t.Fatalf("%x: got versions %x and %x, want TLCP", suite, state.Version, clientState.Version)
+		}
+		if state.CipherSuite != suite {
+			t.Fatalf("%x: got cipher suite %x", suite, state.CipherSuite)
+		}
+		peerCerts := clientState.PeerCertificates
+		if len(peerCerts) != 2 || !bytes.Equal(peerCerts[0].Raw, signCert.Certificate[0]) || !bytes.Equal(peerCerts[1].Raw, encCert.Certificate[0]) {
+			t.Fatalf("%x: the server didn't send its signing and encryption certificates", suite)
+		}
+	}
+
+	// TLS clients don't get TLCP cipher suites.
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_ECC_SM4_GCM_SM3, TLS_RSA_WITH_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+	}
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("TLS handshake failed: %s", err)
+	}
+	if state.Version != VersionTLS12 || state.CipherSuite != TLS_RSA_WITH_AES_128_GCM_SHA256 {
+		t.Fatalf("TLS client got version %x and cipher suite %x", state.Version, state.CipherSuite)
+	}
+
+	// Nor do TLCP clients get TLS from a server without TLCP certificates.
+	clientConfig = &Config{
+		MaxVersion:         VersionTLCP,
+		InsecureSkipVerify: true,
+	}
+	serverConfig = &Config{
+		Certificates:           testConfig.Certificates,
+		TLCPSigningCertificate: &signCert,
+	}
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Fatal("TLCP handshake succeeded without an encryption certificate")
+	}
+}
+
+func TestTLCPClientHello(t *testing.T) {
+	c, s := net.Pipe()
+	go func() {
+		Client(c, &Config{MaxVersion: VersionTLCP, InsecureSkipVerify: true}).Handshake()
+		c.Close()
+	}()
+	defer s.Close()
+
+	header := make([]byte, recordHeaderLen)
+	if _, err := io.ReadFull(s, header); err != nil {
+		t.Fatal(err)
+	}
+	body := make([]byte, int(header[3])<<8|int(header[4]))
+	if _, err := io.ReadFull(s, body); err != nil {
+		t.Fatal(err)
+	}
+	if vers := uint16(header[1])<<8 | uint16(header[2]); vers != VersionTLCP {
+		t.Errorf("got record version %x, want %x", vers, VersionTLCP)
+	}
+	var hello clientHelloMsg
+	if !hello.unmarshal(body) {
+		t.Fatal("failed to parse ClientHello")
+	}
+	if hello.vers != VersionTLCP {
+		t.Errorf("got ClientHello version %x, want %x", hello.vers, VersionTLCP)
+	}
+	// The cipher suites and compression methods end the ClientHello.
+	if want := 4 + 2 + 32 + 1 + 2 + 2*len(hello.cipherSuites) + 2; len(body) != want {
+		t.Errorf("ClientHello is %d bytes long, want %d without extensions", len(body), want)
+	}
+}
+
+func TestNullCipherHandshake(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	suites := []uint16{
+		TLS_PSK_WITH_NULL_SHA,
+		TLS_PSK_WITH_NULL_SHA256,
+		TLS_PSK_WITH_NULL_SHA384,
+		TLS_DHE_PSK_WITH_NULL_SHA,
+		TLS_DHE_PSK_WITH_NULL_SHA256,
+		TLS_DHE_PSK_WITH_NULL_SHA384,
+		TLS_RSA_PSK_WITH_NULL_SHA,
+		TLS_RSA_PSK_WITH_NULL_SHA256,
+		TLS_RSA_PSK_WITH_NULL_SHA384,
+	}
+
+	for _, suite := range suites {
+		serverConfig := &Config{
+			CipherSuites:          []uint16{suite},
+			Certificates:          testConfig.Certificates,
+			AllowNullCipherSuites: true,
+			GetPSKKey:             func(identity string) ([]byte, error) { return psk, nil },
+		}
+		clientConfig := &Config{
+			CipherSuites:          []uint16{suite},
+			InsecureSkipVerify:    true,
+			AllowNullCipherSuites: true,
+			GetPSKIdentity:        func(hint []byte) (string, error) { return "client", nil },
+			GetPSKKey:             func(identity string) ([]byte, error) { return psk, nil },
+		}
+		state, _, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", suite, err)
+		}
+		if state.CipherSuite != suite {
+			t.Fatalf("%x: got cipher suite %x", suite, state.CipherSuite)
+		}
+
+		// Listing the suite isn't enough without the opt-in.
+		serverConfig.AllowNullCipherSuites = false
+		if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+			t.Fatalf("%x: server accepted a NULL cipher suite without opting in", suite)
+		}
+		serverConfig.AllowNullCipherSuites = true
+		clientConfig.AllowNullCipherSuites = false
+		if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+			t.Fatalf("%x: client offered a NULL cipher suite without opting in", suite)
+		}
+	}
+}
+
+func TestSRPHandshake(t *testing.T) {
+	salt := []byte("0123456789abcdef")
+	suites := []uint16{
+		TLS_SRP_SHA_WITH_AES_128_CBC_SHA,
+		TLS_SRP_SHA_WITH_AES_256_CBC_SHA,
+		TLS_SRP_SHA_RSA_WITH_AES_128_CBC_SHA,
+		TLS_SRP_SHA_RSA_WITH_AES_256_CBC_SHA,
+	}
+
+	for _, suite := range suites {
+		serverConfig := &Config{
+			CipherSuites: []uint16{suite},
+			Certificates: testConfig.Certificates,
+			GetSRPVerifier: func(username string) (*SRPVerifier, error) {
+				if username != "alice" {
+					return nil, nil
+				}
+				return NewSRPVerifier(SRPGroup2048, "alice", "password123", salt), nil
+			},
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{suite},
+			InsecureSkipVerify: true,
+			SRPUsername:        "alice",
+			GetSRPPassword:     func(username string) (string, error) { return "password123", nil },
+		}
+		state, _, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", suite, err)
+		}
+		if state.CipherSuite != suite {
+			t.Fatalf("%x: got cipher suite %x", suite, state.CipherSuite)
+		}
+
+		clientConfig.GetSRPPassword = func(username string) (string, error) { return "wrong", nil }
+		if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+			t.Fatalf("%x: handshake succeeded with the wrong password", suite)
+		}
+	}
+}
+
+func TestSRPUnknownUsername(t *testing.T) {
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_SRP_SHA_WITH_AES_128_CBC_SHA},
+		Certificates: testConfig.Certificates,
+		GetSRPVerifier: func(username string) (*SRPVerifier, error) {
+			if username != "alice" {
+				return nil, nil
+			}
+			return NewSRPVerifier(SRPGroup2048, "alice", "password123", []byte("0123456789abcdef")), nil
+		},
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_SRP_SHA_WITH_AES_128_CBC_SHA},
+		InsecureSkipVerify: true,
+		SRPUsername:        "mallory",
+		GetSRPPassword:     func(username string) (string, error) { return "wrong", nil },
+	}
+
+	serverErr, clientErr := testHandshakeErrors(t, clientConfig, serverConfig)
+	if serverErr != errUnknownSRPUsername {
+		t.Errorf("got server error %v, want errUnknownSRPUsername", serverErr)
+	}
+	if opErr, ok := clientErr.(*net.OpError); !ok || opErr.Err != alert(alertUnknownPSKIdentity) {
+		t.Errorf("got client error %v, want an unknown_psk_identity alert", clientErr)
+	}
+
+	// With SRPDummySecret, an unknown username and a known one with the
+	// wrong password must fail alike.
+	serverConfig.SRPDummySecret = []byte("dummy secret")
+	var clientErrs []error
+	for _, username := range []string{"alice", "mallory"} {
+		clientConfig.SRPUsername = username
+		serverErr, clientErr := testHandshakeErrors(t, clientConfig, serverConfig)
+		if serverErr == nil || clientErr == nil {
+			t.Fatalf("%s: handshake succeeded", username)
+		}
+		clientErrs = append(clientErrs, clientErr)
+	}
+	if clientErrs[0].Error() != clientErrs[1].Error() {
+		t.Errorf("got client errors %q and %q", clientErrs[0], clientErrs[1])
+	}
+
+	v1, err := serverConfig.dummySRPVerifier("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	v2, err := serverConfig.dummySRPVerifier("mallory")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(v1.Salt, v2.Salt) || len(v1.Salt) != 16 {
+		t.Errorf("got salts %x and %x, want the same 16 bytes", v1.Salt, v2.Salt)
+	}
+}
+
+func TestDHEDSSHandshake(t *testing.T) {
+	cert, err := X509KeyPair([]byte(dsaCertPEM), []byte(dsaKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	suites := []uint16{
+		TLS_DHE_DSS_WITH_AES_128_CBC_SHA,
+		TLS_DHE_DSS_WITH_AES_256_CBC_SHA,
+		TLS_DHE_DSS_WITH_AES_128_CBC_SHA256,
+		TLS_DHE_DSS_WITH_AES_256_CBC_SHA256,
+		TLS_DHE_DSS_WITH_AES_128_GCM_SHA256,
+		TLS_DHE_DSS_WITH_AES_256_GCM_SHA384,
+	}
+
+	for _, version := range []uint16{VersionTLS10, VersionTLS12} {
+		for _, suite := range suites {
+			if version < VersionTLS12 && (suite == TLS_DHE_DSS_WITH_AES_128_GCM_SHA256 || suite == TLS_DHE_DSS_WITH_AES_256_GCM_SHA384) {
+				continue
+			}
+			serverConfig := &Config{
+				CipherSuites: []uint16{suite},
+				Certificates: []Certificate{cert},
+				MaxVersion:   version,
+			}
+			clientConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				InsecureSkipVerify: true,
+			}
+			state, _, err := testHandshake(clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%x/%x: handshake failed: %s", version, suite, err)
+			}
+			if state.CipherSuite != suite {
+				t.Fatalf("%x/%x: got cipher suite %x", version, suite, state.CipherSuite)
+			}
+		}
+	}
+
+	// A DSA certificate can't be used for the RSA suites.
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_GCM_SHA256},
+		Certificates: []Certificate{cert},
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, TLS_RSA_WITH_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+	}
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake succeeded with an RSA suite and a DSA certificate")
+	}
+}
+
+func TestDHEDSSSignatureTrailingData(t *testing.T) {
+	cert, err := X509KeyPair([]byte(dsaCertPEM), []byte(dsaKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x509Cert, err := parseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	config := &Config{}
+	clientHello := &clientHelloMsg{random: make([]byte, 32), supportedCurves: []CurveID{FFDHE2048}}
+	serverHello := &serverHelloMsg{random: make([]byte, 32)}
+
+	skx, err := dheDSSKA(VersionTLS10).generateServerKeyExchange(config, &cert, clientHello, serverHello)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := dheDSSKA(VersionTLS10).processServerKeyExchange(config, clientHello, serverHello, x509Cert, skx); err != nil {
+		t.Fatalf("valid signature rejected: %s", err)
+	}
+
+	// Append a byte to the DER signature and fix up its length prefix.
+	_, rest, _ := parseUint16Chunk(skx.key)
+	_, rest, _ = parseUint16Chunk(rest)
+	_, rest, _ = parseUint16Chunk(rest)
+	off := len(skx.key) - len(rest)
+	sigLen := int(rest[0])<<8 | int(rest[1]) + 1
+	key := append(append([]byte(nil), skx.key...), 0)
+	key[off] = byte(sigLen >> 8)
+	key[off+1] = byte(sigLen)
+	if err := dheDSSKA(VersionTLS10).processServerKeyExchange(config, clientHello, serverHello, x509Cert, &serverKeyExchangeMsg{key: key}); err == nil {
+		t.Fatal("signature with trailing data accepted")
+	}
+}
+
+func TestFFDHEGroupNegotiation(t *testing.T) {
+	tests := []struct {
+		clientGroups, serverGroups []CurveID
+		expectedSuite              uint16
+		expectedGroup              CurveID
+	}{
+		{nil, nil, TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, FFDHE2048},
+		{nil, []CurveID{FFDHE3072, FFDHE2048}, TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, FFDHE3072},
+		{[]CurveID{X25519, FFDHE3072}, nil, TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, FFDHE3072},
+		// Without a mutual group the server falls back to ECDHE.
+		{[]CurveID{FFDHE2048}, []CurveID{FFDHE3072}, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, X25519},
+	}
+
+	for i, test := range tests {
+		serverConfig := &Config{
+			CipherSuites:     []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			CurvePreferences: test.serverGroups,
+			Certificates:     testConfig.Certificates,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			CurvePreferences:   test.clientGroups,
+			InsecureSkipVerify: true,
+		}
+		serverState, clientState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("#%d: handshake failed: %s", i, err)
+		}
+		if serverState.CipherSuite != test.expectedSuite {
+			t.Errorf("#%d: got cipher suite %x, want %x", i, serverState.CipherSuite, test.expectedSuite)
+		}
+		if serverState.CurveID != test.expectedGroup || clientState.CurveID != test.expectedGroup {
+			t.Errorf("#%d: got groups %d (server) and %d (client), want %d", i, serverState.CurveID, clientState.CurveID, test.expectedGroup)
+		}
+	}
+
+	// A client that doesn't offer RFC 7919 groups gets DhParameters, or no
+	// DHE at all.
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256},
+		Certificates: testConfig.Certificates,
+	}
+	clientHello := &clientHelloMsg{
+		vers:               VersionTLS12,
+		random:             make([]byte, 32),
+		cipherSuites:       []uint16{TLS_DHE_RSA_WITH_AES_128_GCM_SHA256},
+		compressionMethods: []uint8{compressionNone},
+		supportedCurves:    []CurveID{CurveP256},
+		supportedPoints:    []uint8{pointFormatUncompressed},
+	}
+	testClientHelloFailure(t, serverConfig, clientHello, "no cipher suite supported by both client and server")
+
+	serverConfig.DhParameters = ffdheGroups[FFDHE2048]
+	if group, dhp := serverConfig.serverDhParams(clientHello); group != 0 || dhp != serverConfig.DhParameters {
+		t.Errorf("got group %d and parameters %v, want DhParameters", group, dhp)
+	}
+}
+
+func TestX25519MLKEM768Negotiation(t *testing.T) {
+	hybrid := []CurveID{X25519MLKEM768, X25519}
+	tests := []struct {
+		clientCurves, serverCurves []CurveID
+		suite                      uint16
+		expectedCurve              CurveID
+	}{
+		{hybrid, hybrid, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, X25519MLKEM768},
+		{hybrid, hybrid, TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, X25519MLKEM768},
+		{hybrid, hybrid, TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, X25519MLKEM768},
+		{hybrid, hybrid, TLS_ECDH_anon_WITH_AES_128_CBC_SHA, X25519MLKEM768},
+		// The hybrid is only used when both sides list it.
+		{hybrid, nil, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, X25519},
+		{nil, hybrid, TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, X25519},
+	}
+
+	psk := []byte("0123456789abcdef")
+	for i, test := range tests {
+		serverConfig := &Config{
+			CipherSuites:     []uint16{test.suite},
+			CurvePreferences: test.serverCurves,
+			Certificates:     testConfig.Certificates,
+			GetPSKKey:        func(string) ([]byte, error) { return psk, nil },
+		}
+		if test.suite == TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256 {
+			serverConfig.Certificates = []Certificate{{
+				Certificate: [][]byte{testECDSACertificate},
+				PrivateKey:  testECDSAPrivateKey,
+			}}
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{test.suite},
+			CurvePreferences:   test.clientCurves,
+			InsecureSkipVerify: true,
+			GetPSKIdentity:     func([]byte) (string, error) { return "client", nil },
+			GetPSKKey:          func(string) ([]byte, error) { return psk, nil },
+		}
+		serverState, clientState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("#%d: handshake failed: %s", i, err)
+		}
+		if serverState.CurveID != test.expectedCurve || clientState.CurveID != test.expectedCurve {
+			t.Errorf("#%d: got curves %d (server) and %d (client), want %d", i, serverState.CurveID, clientState.CurveID, test.expectedCurve)
+		}
+	}
+
+	// TLS 1.3 ignores the hybrid and uses the next mutual group.
+	config := &Config{
+		MaxVersion:         VersionTLS13,
+		CurvePreferences:   hybrid,
+		Certificates:       testConfig.Certificates,
+		InsecureSkipVerify: true,
+	}
+	serverState, _, err := testTLS13Handshake(t, config, config)
+	if err != nil {
+		t.Fatalf("TLS 1.3 handshake failed: %s", err)
+	}
+	if serverState.Version != VersionTLS13 || serverState.CurveID != X25519 {
+		t.Errorf("TLS 1.3 negotiated version %x and curve %d, want X25519", serverState.Version, serverState.CurveID)
+	}
+
+	// A client rejects the hybrid if it didn't offer it.
+	server := &ecdheKeyAgreement{curveid: X25519MLKEM768}
+	params, err := server.generateECDHParams(testConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := &ecdheKeyAgreement{}
+	if _, _, err := client.processServerECDHParams(&clientHelloMsg{supportedCurves: []CurveID{X25519}}, params); err == nil {
+		t.Error("client accepted a curve it didn't offer")
+	}
+	if _, _, err := client.processServerECDHParams(&clientHelloMsg{supportedCurves: hybrid}, params); err != nil {
+		t.Errorf("client rejected the hybrid it offered: %s", err)
+	}
+}
+
 func TestSCTHandshake(t *testing.T) {
 	expected := [][]byte{[]byte("certificate"), []byte("transparency")}
 	serverConfig := &Config{
@@ -400,7 +1105,75 @@ func TestSCTHandshake(t *testing.T) {
 	}
 }
 
-func TestCrossVersionResume(t *testing.T) {
+// testEncryptThenMAC runs a handshake after which the client sends a message
+// to the server, and reports whether each side used encrypt-then-MAC.
+func testEncryptThenMAC(clientConfig, serverConfig *Config) (state ConnectionState, clientETM, serverETM bool, err error) {
+	c, s := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		cli := Client(c, clientConfig)
+		_, err := cli.Write([]byte("hello"))
+		clientETM = cli.out.encryptThenMAC
+		c.Close()
+		done <- err
+	}()
+	server := Server(s, serverConfig)
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(server, buf); err == nil {
+		state = server.ConnectionState()
+		serverETM = server.in.encryptThenMAC
+	}
+	s.Close()
+	if clientErr := <-done; err == nil {
+		err = clientErr
+	}
+	return
+}
+
+func TestEncryptThenMAC(t *testing.T) {
+	tests := []struct {
+		suite          uint16
+		maxVersion     uint16
+		clientDisabled bool
+		serverDisabled bool
+		want           bool
+	}{
+		{TLS_RSA_WITH_AES_128_CBC_SHA, VersionTLS12, false, false, true},
+		{TLS_RSA_WITH_AES_128_CBC_SHA, VersionTLS10, false, false, true},
+		{TLS_RSA_WITH_AES_128_CBC_SHA256, VersionTLS12, false, false, true},
+		{TLS_ECDHE_RSA_WITH_AES_256_CBC_SHA384, VersionTLS12, false, false, true},
+		{TLS_RSA_WITH_AES_128_CBC_SHA, VersionTLS12, true, false, false},
+		{TLS_RSA_WITH_AES_128_CBC_SHA, VersionTLS12, false, true, false},
+		{TLS_RSA_WITH_AES_128_GCM_SHA256, VersionTLS12, false, false, false},
+		{TLS_RSA_WITH_RC4_128_SHA, VersionTLS12, false, false, false},
+	}
+	for _, test := range tests {
+		serverConfig := &Config{
+			CipherSuites:           []uint16{test.suite},
+			Certificates:           testConfig.Certificates,
+			EncryptThenMACDisabled: test.serverDisabled,
+		}
+		clientConfig := &Config{
+			CipherSuites:           []uint16{test.suite},
+			MaxVersion:             test.maxVersion,
+			InsecureSkipVerify:     true,
+			EncryptThenMACDisabled: test.clientDisabled,
+		}
+		state, clientETM, serverETM, err := testEncryptThenMAC(clientConfig, serverConfig)
+		if err != nil {
+			t.Errorf("%x: %s", test.suite, err)
+			continue
+		}
+		if state.CipherSuite != test.suite {
+			t.Errorf("%x: got cipher suite %x", test.suite, state.CipherSuite)
+		}
+		if clientETM != test.want || serverETM != test.want {
+			t.Errorf("%x: client and server used encrypt-then-MAC: %v, %v, want %v", test.suite, clientETM, serverETM, test.want)
+		}
+	}
+}
+
+func TestEncryptThenMACResume(t *testing.T) {
 	serverConfig := &Config{
 		CipherSuites: []uint16{TLS_RSA_WITH_AES_128_CBC_SHA},
 		Certificates: testConfig.Certificates,
@@ -412,49 +1185,1557 @@ func TestCrossVersionResume(t *testing.T) {
 		ServerName:         "servername",
 	}
 
-	// Establish a session at TLS 1.1.
-	clientConfig.MaxVersion = VersionTLS11
-	_, _, err := testHandshake(clientConfig, serverConfig)
-	if err != nil {
-		t.Fatalf("handshake failed: %s", err)
+	// A session with encrypt-then-MAC is resumed with it.
+	if _, _, _, err := testEncryptThenMAC(clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
 	}
-
-	// The client session cache now contains a TLS 1.1 session.
-	state, _, err := testHandshake(clientConfig, serverConfig)
+	state, clientETM, serverETM, err := testEncryptThenMAC(clientConfig, serverConfig)
 	if err != nil {
-		t.Fatalf("handshake failed: %s", err)
+		t.Fatal(err)
 	}
-	if !state.DidResume {
-		t.Fatalf("handshake did not resume at the same version")
+	if !state.DidResume || !clientETM || !serverETM {
+		t.Fatalf("got resumption %v with encrypt-then-MAC %v, %v", state.DidResume, clientETM, serverETM)
 	}
 
-	// Test that the server will decline to resume at a lower version.
-	clientConfig.MaxVersion = VersionTLS10
-	state, _, err = testHandshake(clientConfig, serverConfig)
+	// But not without it.
+	clientConfig.EncryptThenMACDisabled = true
+	state, _, _, err = testEncryptThenMAC(clientConfig, serverConfig)
 	if err != nil {
-		t.Fatalf("handshake failed: %s", err)
+		t.Fatal(err)
 	}
 	if state.DidResume {
-		t.Fatalf("handshake resumed at a lower version")
+		t.Fatal("resumed a session with encrypt-then-MAC without it")
 	}
 
-	// The client session cache now contains a TLS 1.0 session.
-	state, _, err = testHandshake(clientConfig, serverConfig)
+	// A session without encrypt-then-MAC is resumed without it.
+	clientConfig.EncryptThenMACDisabled = false
+	state, clientETM, serverETM, err = testEncryptThenMAC(clientConfig, serverConfig)
 	if err != nil {
-		t.Fatalf("handshake failed: %s", err)
+		t.Fatal(err)
 	}
-	if !state.DidResume {
-		t.Fatalf("handshake did not resume at the same version")
+	if !state.DidResume || clientETM || serverETM {
+		t.Fatalf("got resumption %v with encrypt-then-MAC %v, %v", state.DidResume, clientETM, serverETM)
+	}
+}
+
+func TestSessionIDResumption(t *testing.T) {
+	serverCache := NewLRUServerSessionCache(1)
+	serverConfig := &Config{
+		CipherSuites:           []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		Certificates:           testConfig.Certificates,
+		SessionTicketsDisabled: true,
+		ServerSessionCache:     serverCache,
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+		ServerName:         "servername",
+	}
+
+	// The server issues a session ID instead of a ticket, and resumes the
+	// session by it.
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	session, ok := clientConfig.ClientSessionCache.Get("servername")
+	if !ok || len(session.sessionId) != 32 || session.sessionTicket != nil {
+		t.Fatalf("client didn't cache a session by session ID")
+	}
+	if _, ok := serverCache.Get(string(session.sessionId)); !ok {
+		t.Fatal("server didn't cache the session")
+	}
+	serverState, clientState, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("resumption failed: %s", err)
+	}
+	if !serverState.DidResume || !clientState.DidResume {
+		t.Fatalf("session wasn't resumed")
+	}
+
+	// An unknown session ID gets a full handshake.
+	serverConfig.ServerSessionCache = NewLRUServerSessionCache(1)
+	serverState, _, err = testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.DidResume {
+		t.Fatal("resumed a session that isn't in the cache")
+	}
+
+	// A server that sends tickets doesn't issue session IDs.
+	serverConfig = serverConfig.Clone()
+	serverConfig.SessionTicketsDisabled = false
+	clientConfig.ClientSessionCache = NewLRUClientSessionCache(1)
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	session, ok = clientConfig.ClientSessionCache.Get("servername")
+	if !ok || session.sessionId != nil || session.sessionTicket == nil {
+		t.Fatalf("client didn't cache a session by ticket")
+	}
+}
+
+// marshalingServerSessionCache keeps the encodings of sessions, as a cache
+// shared by several servers would.
+type marshalingServerSessionCache map[string][]byte
+
+func (c marshalingServerSessionCache) Get(sessionId string) (*ServerSessionState, bool) {
+	data, ok := c[sessionId]
+	if !ok {
+		return nil, false
+	}
+	ss := new(ServerSessionState)
+	if err := ss.Unmarshal(data); err != nil {
+		return nil, false
+	}
+	return ss, true
+}
+
+func (c marshalingServerSessionCache) Put(sessionId string, ss *ServerSessionState) {
+	c[sessionId] = ss.Marshal()
+}
+
+func TestSessionIDResumptionMarshaled(t *testing.T) {
+	now := time.Now()
+	serverCache := make(marshalingServerSessionCache)
+	serverConfig := &Config{
+		Certificates:           testConfig.Certificates,
+		SessionTicketsDisabled: true,
+		ServerSessionCache:     serverCache,
+		Time:                   func() time.Time { return now },
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+		ServerName:         "servername",
+	}
+
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	session, ok := clientConfig.ClientSessionCache.Get("servername")
+	if !ok {
+		t.Fatal("client didn't cache the session")
+	}
+	ss, ok := serverCache.Get(string(session.sessionId))
+	if !ok {
+		t.Fatal("server didn't cache the session")
+	}
+	if expires := ss.Expires(); expires.Before(now) || expires.After(now.Add(24*time.Hour)) {
+		t.Fatalf("session of %v expires at %v", now, expires)
+	}
+	if err := new(ServerSessionState).Unmarshal([]byte("garbage")); err == nil {
+		t.Fatal("unmarshaled garbage")
+	}
+
+	serverState, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("resumption failed: %s", err)
+	}
+	if !serverState.DidResume {
+		t.Fatal("session wasn't resumed")
+	}
+
+	// The session expires after a day.
+	serverConfig.Time = func() time.Time { return now.Add(24*time.Hour + time.Second) }
+	serverState, _, err = testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.DidResume {
+		t.Fatal("resumed an expired session")
+	}
+}
+
+func TestLRUServerSessionCache(t *testing.T) {
+	cache := NewLRUServerSessionCache(2)
+	ss := make([]ServerSessionState, 3)
+	ids := []string{"0", "1", "2"}
+
+	for i := 0; i < 2; i++ {
+		cache.Put(ids[i], &ss[i])
+	}
+
+	// Touch entry 0. LRU should evict 1 next.
+	if s, ok := cache.Get(ids[0]); !ok || s != &ss[0] {
+		t.Fatalf("session cache failed lookup for added session ID: %s", ids[0])
+	}
+	cache.Put(ids[2], &ss[2])
+	if s, ok := cache.Get(ids[1]); ok || s != nil {
+		t.Fatal("session cache should have evicted session ID 1")
+	}
+	if s, ok := cache.Get(ids[2]); !ok || s != &ss[2] {
+		t.Fatalf("session cache failed lookup for added session ID: %s", ids[2])
+	}
+
+	// Update entry 0 in place.
+	cache.Put(ids[0], &ss[1])
+	if s, ok := cache.Get(ids[0]); !ok || s != &ss[1] {
+		t.Fatal("session cache failed update for session ID 0")
+	}
+}
+
+// testExtendedMasterSecret runs a handshake and reports the state of both
+// sides and whether each of them used the extended master secret.
+func testExtendedMasterSecret(clientConfig, serverConfig *Config) (serverState, clientState ConnectionState, clientEMS, serverEMS bool, err error) {
+	c, s := net.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		cli := Client(c, clientConfig)
+		err := cli.Handshake()
+		if err == nil {
+			clientState = cli.ConnectionState()
+			clientEMS = cli.extendedMasterSecret
+			_, err = cli.Write([]byte("hello"))
+		}
+		c.Close()
+		done <- err
+	}()
+	server := Server(s, serverConfig)
+	buf := make([]byte, 5)
+	if _, err = io.ReadFull(server, buf); err == nil {
+		serverState = server.ConnectionState()
+		serverEMS = server.extendedMasterSecret
+	}
+	s.Close()
+	if clientErr := <-done; err == nil {
+		err = clientErr
+	}
+	return
+}
+
+func TestExtendedMasterSecret(t *testing.T) {
+	tests := []struct {
+		maxVersion uint16
+		client     ExtendedMasterSecretSupport
+		server     ExtendedMasterSecretSupport
+		want       bool
+		wantErr    bool
+	}{
+		{VersionTLS12, ExtendedMasterSecretOffered, ExtendedMasterSecretOffered, true, false},
+		{VersionTLS10, ExtendedMasterSecretOffered, ExtendedMasterSecretOffered, true, false},
+		{VersionTLS12, ExtendedMasterSecretRequired, ExtendedMasterSecretOffered, true, false},
+		{VersionTLS12, ExtendedMasterSecretOffered, ExtendedMasterSecretRequired, true, false},
+		{VersionTLS12, ExtendedMasterSecretNever, ExtendedMasterSecretOffered, false, false},
+		{VersionTLS12, ExtendedMasterSecretOffered, ExtendedMasterSecretNever, false, false},
+		{VersionTLS12, ExtendedMasterSecretRequired, ExtendedMasterSecretNever, false, true},
+		{VersionTLS12, ExtendedMasterSecretNever, ExtendedMasterSecretRequired, false, true},
+	}
+	for i, test := range tests {
+		serverConfig := &Config{
+			Certificates:         testConfig.Certificates,
+			ExtendedMasterSecret: test.server,
+		}
+		clientConfig := &Config{
+			MaxVersion:           test.maxVersion,
+			InsecureSkipVerify:   true,
+			ExtendedMasterSecret: test.client,
+		}
+		serverState, clientState, clientEMS, serverEMS, err := testExtendedMasterSecret(clientConfig, serverConfig)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("#%d: handshake succeeded", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("#%d: %s", i, err)
+			continue
+		}
+		if clientEMS != test.want || serverEMS != test.want {
+			t.Errorf("#%d: client and server used the extended master secret: %v, %v, want %v", i, clientEMS, serverEMS, test.want)
+		}
+		if !bytes.Equal(clientState.TLSUnique, serverState.TLSUnique) {
+			t.Errorf("#%d: client and server disagree on tls-unique", i)
+		}
+	}
+}
+
+func TestExtendedMasterSecretResume(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS12,
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+		ServerName:         "servername",
+	}
+
+	// A session with the extended master secret is resumed with it, and
+	// tls-unique is available after the resumption.
+	if _, _, _, _, err := testExtendedMasterSecret(clientConfig, serverConfig); err != nil {
+		t.Fatal(err)
+	}
+	serverState, clientState, clientEMS, serverEMS, err := testExtendedMasterSecret(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serverState.DidResume || !clientEMS || !serverEMS {
+		t.Fatalf("got resumption %v with extended master secret %v, %v", serverState.DidResume, clientEMS, serverEMS)
+	}
+	if serverState.TLSUnique == nil || !bytes.Equal(clientState.TLSUnique, serverState.TLSUnique) {
+		t.Fatalf("bad tls-unique after resumption: %x, %x", clientState.TLSUnique, serverState.TLSUnique)
+	}
+
+	// But not by a server that doesn't use it.
+	serverConfig.ExtendedMasterSecret = ExtendedMasterSecretNever
+	serverState, _, _, serverEMS, err = testExtendedMasterSecret(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverState.DidResume || serverEMS {
+		t.Fatalf("got resumption %v with extended master secret %v", serverState.DidResume, serverEMS)
+	}
+
+	// A session without the extended master secret isn't offered by a client
+	// that uses it, but is resumed by one that doesn't.
+	serverState, _, _, _, err = testExtendedMasterSecret(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if serverState.DidResume {
+		t.Fatal("resumed a session without the extended master secret")
+	}
+	clientConfig.ExtendedMasterSecret = ExtendedMasterSecretNever
+	serverState, clientState, _, _, err = testExtendedMasterSecret(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !serverState.DidResume || clientState.TLSUnique != nil {
+		t.Fatalf("got resumption %v with tls-unique %x", serverState.DidResume, clientState.TLSUnique)
+	}
+}
+
+func TestSignatureAlgorithmsTLS12(t *testing.T) {
+	ed25519Cert, err := X509KeyPair([]byte(ed25519CertPEM), []byte(ed25519KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPSSCert, err := X509KeyPair([]byte(rsaPSSCertPEM), []byte(rsaPSSKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaPSSSHA384Cert, err := X509KeyPair([]byte(rsaPSSSHA384CertPEM), []byte(rsaPSSSHA384KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	rsaCert := testConfig.Certificates[0]
+	ecdsaCert := Certificate{
+		Certificate: [][]byte{testECDSACertificate},
+		PrivateKey:  testECDSAPrivateKey,
+	}
+
+	tests := []struct {
+		name   string
+		suite  uint16
+		cert   Certificate
+		scheme SignatureScheme
+	}{
+		{"ECDHE-RSA-PSS-SHA256", TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsaCert, PSSWithSHA256},
+		{"ECDHE-RSA-PSS-SHA384", TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsaCert, PSSWithSHA384},
+		{"ECDHE-RSA-PKCS1-SHA512", TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsaCert, PKCS1WithSHA512},
+		{"DHE-RSA-PSS-SHA256", TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, rsaCert, PSSWithSHA256},
+		{"ECDHE-ECDSA-SHA512", TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, ecdsaCert, ECDSAWithP521AndSHA512},
+		{"ECDHE-Ed25519", TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256, ed25519Cert, Ed25519},
+		{"ECDHE-RSAPSSKey-SHA256", TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsaPSSCert, PSSKeyWithSHA256},
+		{"DHE-RSAPSSKey-SHA384", TLS_DHE_RSA_WITH_AES_128_GCM_SHA256, rsaPSSCert, PSSKeyWithSHA384},
+		{"ECDHE-RSAPSSKey-SHA384-Params", TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256, rsaPSSSHA384Cert, PSSKeyWithSHA384},
+	}
+	for _, test := range tests {
+		// The client only accepts the one algorithm, for both the server's
+		// key exchange and its own CertificateVerify.
+		serverConfig := &Config{
+			Certificates: []Certificate{test.cert},
+			CipherSuites: []uint16{test.suite},
+			ClientAuth:   RequireAnyClientCert,
+			MaxVersion:   VersionTLS12,
+		}
+		clientConfig := &Config{
+			Certificates:       []Certificate{test.cert},
+			CipherSuites:       []uint16{test.suite},
+			SignatureSchemes:   []SignatureScheme{test.scheme},
+			InsecureSkipVerify: true,
+		}
+		serverState, clientState, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Errorf("%s: handshake failed: %s", test.name, err)
+			continue
+		}
+		if !clientState.HandshakeComplete || clientState.CipherSuite != test.suite {
+			t.Errorf("%s: client negotiated %x, complete %v", test.name, clientState.CipherSuite, clientState.HandshakeComplete)
+		}
+		if len(serverState.PeerCertificates) != 1 {
+			t.Errorf("%s: server got %d client certificates", test.name, len(serverState.PeerCertificates))
+		}
+	}
+
+	// A 1024-bit key is too small for RSA-PSS with SHA-512.
+	serverConfig := &Config{
+		Certificates: []Certificate{rsaCert},
+		CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+		MaxVersion:   VersionTLS12,
+	}
+	clientConfig := &Config{
+		SignatureSchemes:   []SignatureScheme{PSSWithSHA512},
+		InsecureSkipVerify: true,
+	}
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Error("handshake succeeded with RSA-PSS SHA-512 and a 1024-bit key")
+	}
+
+	// RSASSA-PSS keys only sign with the rsa_pss_pss algorithms, and only
+	// with those that their parameters allow, and rsaEncryption keys never
+	// sign with them.
+	for _, test := range []struct {
+		name   string
+		cert   Certificate
+		scheme SignatureScheme
+	}{
+		{"RSAPSSKey-rsae", rsaPSSCert, PSSWithSHA256},
+		{"RSAPSSKey-PKCS1", rsaPSSCert, PKCS1WithSHA256},
+		{"RSAPSSKey-SHA384-Params", rsaPSSSHA384Cert, PSSKeyWithSHA256},
+		{"RSA-pss", rsaCert, PSSKeyWithSHA256},
+	} {
+		serverConfig := &Config{
+			Certificates: []Certificate{test.cert},
+			CipherSuites: []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			MaxVersion:   VersionTLS12,
+		}
+		clientConfig := &Config{
+			SignatureSchemes:   []SignatureScheme{test.scheme},
+			InsecureSkipVerify: true,
+		}
+		if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+			t.Errorf("%s: handshake succeeded", test.name)
+		}
+	}
+
+	// Ed25519 keys need TLS 1.2.
+	serverConfig = &Config{
+		Certificates: []Certificate{ed25519Cert},
+		MaxVersion:   VersionTLS11,
+	}
+	clientConfig = &Config{
+		InsecureSkipVerify: true,
+	}
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Error("handshake succeeded with an Ed25519 key at TLS 1.1")
+	}
+}
+
+func TestSignatureAlgorithmsEd25519TLS13(t *testing.T) {
+	ed25519Cert, err := X509KeyPair([]byte(ed25519CertPEM), []byte(ed25519KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &Config{
+		Certificates: []Certificate{ed25519Cert},
+		MaxVersion:   VersionTLS13,
+	}
+	clientConfig := &Config{
+		MaxVersion:         VersionTLS13,
+		InsecureSkipVerify: true,
+	}
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.Version != VersionTLS13 {
+		t.Fatalf("got version %x, expected TLS 1.3", state.Version)
+	}
+}
+
+func TestSignatureAlgorithmsRSAPSSKeyTLS13(t *testing.T) {
+	rsaPSSCert, err := X509KeyPair([]byte(rsaPSSCertPEM), []byte(rsaPSSKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverConfig := &Config{
+		Certificates: []Certificate{rsaPSSCert},
+		ClientAuth:   RequireAnyClientCert,
+		MaxVersion:   VersionTLS13,
+	}
+	clientConfig := &Config{
+		Certificates:       []Certificate{rsaPSSCert},
+		MaxVersion:         VersionTLS13,
+		InsecureSkipVerify: true,
+	}
+	state, _, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.Version != VersionTLS13 || len(state.PeerCertificates) != 1 {
+		t.Fatalf("got version %x and %d client certificates", state.Version, len(state.PeerCertificates))
+	}
+}
+
+// recordSizeConn tracks the largest record written to it.
+type recordSizeConn struct {
+	net.Conn
+	buf []byte
+	max int
+}
+
+func (c *recordSizeConn) Write(b []byte) (int, error) {
+	c.buf = append(c.buf, b...)
+	for len(c.buf) >= recordHeaderLen {
+		n := int(c.buf[3])<<8 | int(c.buf[4])
+		if len(c.buf) < recordHeaderLen+n {
+			break
+		}
+		if n > c.max {
+			c.max = n
+		}
+		c.buf = c.buf[recordHeaderLen+n:]
+	}
+	return c.Conn.Write(b)
+}
+
+// testRecordSizes sends 5000 bytes of application data each way after the
+// handshake and returns the largest records sent by the client and the
+// server.
+func testRecordSizes(t *testing.T, clientConfig, serverConfig *Config) (serverState, clientState ConnectionState, clientMax, serverMax int, err error) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+
+	data := make([]byte, 5000)
+	errChan := make(chan error, 1)
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer c.Close()
+		cc := &recordSizeConn{Conn: c}
+		cli := Client(cc, clientConfig)
+		if err := cli.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		if _, err := io.ReadFull(cli, make([]byte, len(data))); err != nil {
+			errChan <- err
+			return
+		}
+		if _, err := cli.Write(data); err != nil {
+			errChan <- err
+			return
+		}
+		clientState = cli.ConnectionState()
+		clientMax = cc.max
+		errChan <- nil
+	}()
+
+	s, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	defer s.Close()
+	sc := &recordSizeConn{Conn: s}
+	server := Server(sc, serverConfig)
+	if err = server.Handshake(); err == nil {
+		if _, err = server.Write(data); err == nil {
+			_, err = io.ReadFull(server, make([]byte, len(data)))
+		}
+	}
+	if err != nil {
+		s.Close()
+		<-errChan
+		return
+	}
+	if err = <-errChan; err != nil {
+		return
+	}
+	return server.ConnectionState(), clientState, clientMax, sc.max, nil
+}
+
+func TestRecordSizeLimits(t *testing.T) {
+	// AES-GCM adds an explicit nonce and a tag to each TLS 1.2 record, and
+	// a tag and the content type to each TLS 1.3 record.
+	const overhead = 8 + 16
+
+	tests := []struct {
+		name                             string
+		vers                             uint16
+		clientMFL, clientRSL, serverRSL  int
+		clientLimit, serverLimit         int
+		maxFragmentLength, clientRecords int
+	}{
+		{"MFL", VersionTLS12, 512, 0, 0, 512, 512, 512, 0},
+		{"MFL-TLS13", VersionTLS13, 1024, 0, 0, 1024, 1024, 1024, 0},
+		{"RSL", VersionTLS12, 0, 1000, 2000, 2000, 1000, 0, 2000},
+		{"RSL-ServerDefault", VersionTLS12, 0, 1000, 0, maxPlaintext, 1000, 0, maxPlaintext},
+		{"RSL-TLS13", VersionTLS13, 0, 1000, 2000, 2000, 1000, 0, 2000},
+		{"RSL-OverMFL", VersionTLS12, 512, 1000, 0, maxPlaintext, 1000, 0, maxPlaintext},
+	}
+	for _, test := range tests {
+		serverConfig := &Config{
+			Certificates:    testConfig.Certificates,
+			CipherSuites:    []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			MaxVersion:      test.vers,
+			RecordSizeLimit: test.serverRSL,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256},
+			MaxVersion:         test.vers,
+			MaxFragmentLength:  test.clientMFL,
+			RecordSizeLimit:    test.clientRSL,
+			InsecureSkipVerify: true,
+		}
+		serverState, clientState, clientMax, serverMax, err := testRecordSizes(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Errorf("%s: %s", test.name, err)
+			continue
+		}
+		if clientState.Version != test.vers {
+			t.Errorf("%s: negotiated version %x", test.name, clientState.Version)
+		}
+		if clientMax > test.clientLimit+overhead || serverMax > test.serverLimit+overhead {
+			t.Errorf("%s: got records of %d and %d bytes, expected at most %d and %d bytes of plaintext", test.name, clientMax, serverMax, test.clientLimit, test.serverLimit)
+		}
+		if clientState.MaxFragmentLength != test.maxFragmentLength || serverState.MaxFragmentLength != test.maxFragmentLength {
+			t.Errorf("%s: got max fragment lengths %d and %d, expected %d", test.name, clientState.MaxFragmentLength, serverState.MaxFragmentLength, test.maxFragmentLength)
+		}
+		if clientState.RecordSizeLimit != test.clientRecords {
+			t.Errorf("%s: client got record size limit %d, expected %d", test.name, clientState.RecordSizeLimit, test.clientRecords)
+		}
+		if serverState.RecordSizeLimit != 0 && serverState.RecordSizeLimit != test.serverLimit {
+			t.Errorf("%s: server got record size limit %d, expected %d", test.name, serverState.RecordSizeLimit, test.serverLimit)
+		}
+	}
+
+	// Invalid limits are rejected.
+	for _, clientConfig := range []*Config{
+		{MaxFragmentLength: 1000, InsecureSkipVerify: true},
+		{RecordSizeLimit: 63, InsecureSkipVerify: true},
+		{RecordSizeLimit: maxPlaintext + 1, InsecureSkipVerify: true},
+	} {
+		if _, _, err := testHandshake(clientConfig, testConfig); err == nil {
+			t.Errorf("handshake succeeded with MaxFragmentLength %d and RecordSizeLimit %d", clientConfig.MaxFragmentLength, clientConfig.RecordSizeLimit)
+		}
+	}
+}
+
+func TestRecordSizeLimitEnforced(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS12,
+	}
+	clientConfig := &Config{
+		RecordSizeLimit:    1000,
+		InsecureSkipVerify: true,
+	}
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+	errChan := make(chan error, 1)
+	go func() {
+		c, err := net.Dial("tcp", ln.Addr().String())
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer c.Close()
+		cli := Client(c, clientConfig)
+		if err := cli.Handshake(); err != nil {
+			errChan <- err
+			return
+		}
+		_, err = io.ReadFull(cli, make([]byte, 5000))
+		errChan <- err
+	}()
+
+	s, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	server := Server(s, serverConfig)
+	if err := server.Handshake(); err != nil {
+		t.Fatal(err)
+	}
+	// A server that ignores the client's limit gets a record_overflow
+	// alert.
+	server.peerRecordSizeLimit = 0
+	server.Write(make([]byte, 5000))
+	err = <-errChan
+	if err == nil || !strings.Contains(err.Error(), "record overflow") {
+		t.Fatalf("expected a record overflow error, got %v", err)
+	}
+}
+
+func TestCrossVersionResume(t *testing.T) {
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_RSA_WITH_AES_128_CBC_SHA},
+		Certificates: testConfig.Certificates,
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_RSA_WITH_AES_128_CBC_SHA},
+		InsecureSkipVerify: true,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+		ServerName:         "servername",
+	}
+
+	// Establish a session at TLS 1.1.
+	clientConfig.MaxVersion = VersionTLS11
+	_, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+
+	// The client session cache now contains a TLS 1.1 session.
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if !state.DidResume {
+		t.Fatalf("handshake did not resume at the same version")
+	}
+
+	// Test that the server will decline to resume at a lower version.
+	clientConfig.MaxVersion = VersionTLS10
+	state, _, err = testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.DidResume {
+		t.Fatalf("handshake resumed at a lower version")
+	}
+
+	// The client session cache now contains a TLS 1.0 session.
+	state, _, err = testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if !state.DidResume {
+		t.Fatalf("handshake did not resume at the same version")
+	}
+
+	// Test that the server will decline to resume at a higher version.
+	clientConfig.MaxVersion = VersionTLS11
+	state, _, err = testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.DidResume {
+		t.Fatalf("handshake resumed at a higher version")
+	}
+}
+
+// testTLS13Handshake is like testHandshake, but over TCP and with the server
+// writing some data after the handshake. A TLS 1.3 server sends session
+// tickets after the handshake, which net.Pipe would block on and which the
+// client only reads along with application data.
+func testTLS13Handshake(t *testing.T, clientConfig, serverConfig *Config) (serverState, clientState ConnectionState, err error) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		cli, err := Dial("tcp", ln.Addr().String(), clientConfig)
+		if err != nil {
+			errChan <- err
+			return
+		}
+		defer cli.Close()
+		if _, err := io.ReadFull(cli, make([]byte, 5)); err != nil {
+			errChan <- err
+			return
+		}
+		clientState = cli.ConnectionState()
+		errChan <- nil
+	}()
+
+	conn, err := ln.Accept()
+	if err != nil {
+		return
+	}
+	server := Server(conn, serverConfig)
+	err = server.Handshake()
+	if err == nil {
+		serverState = server.ConnectionState()
+		_, err = server.Write([]byte("hello"))
+	}
+	server.Close()
+	if clientErr := <-errChan; err == nil {
+		err = clientErr
+	}
+	return
+}
+
+func TestTLS13Handshake(t *testing.T) {
+	suites := []uint16{
+		TLS_AES_128_GCM_SHA256,
+		TLS_AES_256_GCM_SHA384,
+		TLS_CHACHA20_POLY1305_SHA256,
+		TLS_AES_128_CCM_SHA256,
+		TLS_AES_128_CCM_8_SHA256,
+	}
+	ecdsaCertificates := []Certificate{{
+		Certificate: [][]byte{testECDSACertificate},
+		PrivateKey:  testECDSAPrivateKey,
+	}}
+
+	for _, suite := range suites {
+		for _, certificates := range [][]Certificate{testConfig.Certificates, ecdsaCertificates} {
+			serverConfig := &Config{
+				CipherSuites: []uint16{suite},
+				Certificates: certificates,
+				MaxVersion:   VersionTLS13,
+			}
+			clientConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				InsecureSkipVerify: true,
+				MaxVersion:         VersionTLS13,
+			}
+			serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%x: handshake failed: %s", suite, err)
+			}
+			if serverState.Version != VersionTLS13 || clientState.Version != VersionTLS13 {
+				t.Fatalf("%x: got versions %x and %x", suite, serverState.Version, clientState.Version)
+			}
+			if serverState.CipherSuite != suite || clientState.CipherSuite != suite {
+				t.Fatalf("%x: got cipher suites %x and %x", suite, serverState.CipherSuite, clientState.CipherSuite)
+			}
+			if len(clientState.PeerCertificates) != 1 {
+				t.Fatalf("%x: got %d server certificates", suite, len(clientState.PeerCertificates))
+			}
+		}
+	}
+}
+
+func TestTLS13Fallback(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS12,
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+	}
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.Version != VersionTLS12 {
+		t.Fatalf("got version %x, expected %x", state.Version, VersionTLS12)
+	}
+
+	// A TLS 1.3 server negotiating TLS 1.2 marks its random, which a TLS
+	// 1.3 client takes as a downgrade attack.
+	serverConfig.MaxVersion = VersionTLS13
+	clientConfig.MaxVersion = VersionTLS12
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	serverConfig.MaxVersion = VersionTLS12
+	serverConfig.Rand = downgradeSource{}
+	clientConfig.MaxVersion = VersionTLS13
+	if _, _, err := testHandshake(clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake with a downgrade canary succeeded")
+	}
+}
+
+// downgradeSource is an io.Reader that returns bytes ending in the TLS 1.2
+// downgrade canary, as if a TLS 1.3 server had made up its random.
+type downgradeSource struct{}
+
+func (downgradeSource) Read(b []byte) (n int, err error) {
+	for i := range b {
+		b[i] = downgradeCanaryTLS12[i%len(downgradeCanaryTLS12)]
+	}
+	return len(b), nil
+}
+
+func TestTLS13HelloRetryRequest(t *testing.T) {
+	serverConfig := &Config{
+		Certificates:     testConfig.Certificates,
+		MaxVersion:       VersionTLS13,
+		CurvePreferences: []CurveID{CurveP384},
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+		CurvePreferences:   []CurveID{X25519, CurveP384},
+	}
+	serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.Version != VersionTLS13 || clientState.Version != VersionTLS13 {
+		t.Fatalf("got versions %x and %x", serverState.Version, clientState.Version)
 	}
 
-	// Test that the server will decline to resume at a higher version.
-	clientConfig.MaxVersion = VersionTLS11
-	state, _, err = testHandshake(clientConfig, serverConfig)
+	serverConfig.CurvePreferences = []CurveID{CurveP256}
+	if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake without a common group succeeded")
+	}
+}
+
+func TestTLS13PSKHandshake(t *testing.T) {
+	psk := []byte("0123456789abcdef")
+	serverConfig := &Config{
+		MaxVersion: VersionTLS13,
+		GetPSKKey: func(identity string) ([]byte, error) {
+			if identity != "client" {
+				return nil, errors.New("unknown identity")
+			}
+			return psk, nil
+		},
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+		GetPSKIdentity:     func(hint []byte) (string, error) { return "client", nil },
+		GetPSKKey:          func(identity string) ([]byte, error) { return psk, nil },
+	}
+
+	// With a key share the server uses psk_dhe_ke and, having no
+	// certificate, can only use the PSK.
+	serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
 	if err != nil {
 		t.Fatalf("handshake failed: %s", err)
 	}
-	if state.DidResume {
-		t.Fatalf("handshake resumed at a higher version")
+	if serverState.Version != VersionTLS13 || clientState.Version != VersionTLS13 {
+		t.Fatalf("got versions %x and %x", serverState.Version, clientState.Version)
+	}
+	if len(clientState.PeerCertificates) != 0 {
+		t.Fatalf("got %d server certificates with a PSK", len(clientState.PeerCertificates))
+	}
+
+	// Without a mutual key share, psk_ke saves a HelloRetryRequest.
+	serverConfig.CurvePreferences = []CurveID{CurveP256}
+	clientConfig.CurvePreferences = []CurveID{X25519, CurveP256}
+	if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatalf("psk_ke handshake failed: %s", err)
+	}
+
+	// A wrong key fails the binder check.
+	clientConfig.GetPSKKey = func(identity string) ([]byte, error) { return []byte("fedcba9876543210"), nil }
+	if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "binder") {
+		t.Fatalf("handshake with a wrong PSK returned %v", err)
+	}
+}
+
+func TestTLS13Resumption(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS13,
+		ClientAuth:   RequestClientCert,
+	}
+	clientCert, err := X509KeyPair([]byte(clientCertificatePEM), []byte(clientKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientConfig := &Config{
+		Certificates:       []Certificate{clientCert},
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+	}
+
+	serverState, _, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.DidResume {
+		t.Fatal("first handshake resumed")
+	}
+	if len(serverState.PeerCertificates) != 1 {
+		t.Fatalf("got %d client certificates", len(serverState.PeerCertificates))
+	}
+
+	serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("resumption failed: %s", err)
+	}
+	if !serverState.DidResume || !clientState.DidResume {
+		t.Fatal("second handshake did not resume")
+	}
+	if len(serverState.PeerCertificates) != 1 || len(clientState.PeerCertificates) != 1 {
+		t.Fatalf("got %d client and %d server certificates after resumption", len(serverState.PeerCertificates), len(clientState.PeerCertificates))
+	}
+
+	// A session with a client certificate can't be resumed by a server
+	// that no longer asks for one.
+	serverConfig.ClientAuth = NoClientCert
+	serverState, _, err = testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.DidResume {
+		t.Fatal("resumed a session with a client certificate")
+	}
+
+	// Tickets can't be used past their lifetime.
+	serverState, _, err = testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("resumption failed: %s", err)
+	}
+	if !serverState.DidResume {
+		t.Fatal("handshake without client certificates did not resume")
+	}
+	serverConfig.Time = func() time.Time { return time.Now().Add(maxSessionTicketLifetime + time.Hour) }
+	serverState, _, err = testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.DidResume {
+		t.Fatal("resumed a session from an expired ticket")
+	}
+}
+
+func TestPSKResumption(t *testing.T) {
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		known := "alice"
+		getPSKKey := func(identity string) ([]byte, error) {
+			if identity != known {
+				return nil, errors.New("unknown identity")
+			}
+			return []byte("0123456789abcdef"), nil
+		}
+		serverConfig := &Config{
+			CipherSuites: []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+			Certificates: testConfig.Certificates,
+			MaxVersion:   vers,
+			GetPSKKey:    getPSKKey,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+			InsecureSkipVerify: true,
+			MaxVersion:         vers,
+			ClientSessionCache: NewLRUClientSessionCache(1),
+			GetPSKIdentity:     func([]byte) (string, error) { return known, nil },
+			GetPSKKey:          getPSKKey,
+		}
+
+		for i, resume := range []bool{false, true} {
+			serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%x #%d: handshake failed: %s", vers, i, err)
+			}
+			if serverState.DidResume != resume || clientState.DidResume != resume {
+				t.Fatalf("%x #%d: got resumption %v and %v, want %v", vers, i, serverState.DidResume, clientState.DidResume, resume)
+			}
+			if serverState.PSKIdentity != "alice" || clientState.PSKIdentity != "alice" {
+				t.Errorf("%x #%d: got PSK identities %q and %q", vers, i, serverState.PSKIdentity, clientState.PSKIdentity)
+			}
+			if serverState.CurveID != X25519 || clientState.CurveID != X25519 {
+				t.Errorf("%x #%d: got curves %d and %d", vers, i, serverState.CurveID, clientState.CurveID)
+			}
+		}
+
+		// The session can't be resumed once its PSK is no longer known.
+		known = "bob"
+		serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", vers, err)
+		}
+		if serverState.DidResume || clientState.DidResume {
+			t.Fatalf("%x: resumed a session with an unknown PSK identity", vers)
+		}
+		if serverState.PSKIdentity != "bob" || clientState.PSKIdentity != "bob" {
+			t.Errorf("%x: got PSK identities %q and %q, want bob", vers, serverState.PSKIdentity, clientState.PSKIdentity)
+		}
+	}
+}
+
+var pskSuitesTLS12 = []uint16{
+	TLS_PSK_WITH_AES_128_CBC_SHA,
+	TLS_RSA_PSK_WITH_AES_128_CBC_SHA,
+	TLS_DHE_PSK_WITH_AES_128_CBC_SHA,
+	TLS_ECDHE_PSK_WITH_AES_128_CBC_SHA,
+}
+
+func TestPSKCallbacksForClient(t *testing.T) {
+	keys := map[string][]byte{
+		"a.example": []byte("0123456789abcdef"),
+		"b.example": []byte("fedcba9876543210"),
+	}
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		GetPSKIdentityHintForClient: func(info *ClientHelloInfo) ([]byte, error) {
+			if info.Conn == nil {
+				return nil, errors.New("missing connection")
+			}
+			return []byte("hint for " + info.ServerName), nil
+		},
+		GetPSKKeyForClient: func(info *ClientHelloInfo, identity string) ([]byte, error) {
+			if identity != "client@"+info.ServerName {
+				return nil, ErrUnknownPSKIdentity
+			}
+			return keys[info.ServerName], nil
+		},
+	}
+
+	for _, suite := range pskSuitesTLS12 {
+		serverConfig.CipherSuites = []uint16{suite}
+		for serverName, key := range keys {
+			serverName, key := serverName, key
+			clientConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				ServerName:         serverName,
+				InsecureSkipVerify: true,
+				GetPSKIdentity: func(hint []byte) (string, error) {
+					if string(hint) != "hint for "+serverName {
+						return "", fmt.Errorf("unexpected identity hint %q", hint)
+					}
+					return "client@" + serverName, nil
+				},
+				GetPSKKey: func(string) ([]byte, error) { return key, nil },
+			}
+			state, _, err := testHandshake(clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%x/%s: handshake failed: %s", suite, serverName, err)
+			}
+			if state.PSKIdentity != "client@"+serverName {
+				t.Errorf("%x/%s: got PSK identity %q", suite, serverName, state.PSKIdentity)
+			}
+		}
+	}
+}
+
+// testHandshakeErrors runs a handshake over TCP and returns the errors of
+// both sides.
+func testHandshakeErrors(t *testing.T, clientConfig, serverConfig *Config) (serverErr, clientErr error) {
+	ln := newLocalListener(t)
+	defer ln.Close()
+
+	errChan := make(chan error, 1)
+	go func() {
+		cli, err := Dial("tcp", ln.Addr().String(), clientConfig)
+		if err == nil {
+			cli.Close()
+		}
+		errChan <- err
+	}()
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverErr = Server(conn, serverConfig).Handshake()
+	conn.Close()
+	return serverErr, <-errChan
+}
+
+func TestUnknownPSKIdentityAlert(t *testing.T) {
+	for _, suite := range pskSuitesTLS12 {
+		serverConfig := &Config{
+			CipherSuites: []uint16{suite},
+			Certificates: testConfig.Certificates,
+			GetPSKKey: func(identity string) ([]byte, error) {
+				return nil, ErrUnknownPSKIdentity
+			},
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{suite},
+			InsecureSkipVerify: true,
+			GetPSKIdentity:     func([]byte) (string, error) { return "mallory", nil },
+			GetPSKKey:          func(string) ([]byte, error) { return []byte("0123456789abcdef"), nil },
+		}
+
+		serverErr, clientErr := testHandshakeErrors(t, clientConfig, serverConfig)
+		if serverErr != ErrUnknownPSKIdentity {
+			t.Errorf("%x: got server error %v, want ErrUnknownPSKIdentity", suite, serverErr)
+		}
+		if opErr, ok := clientErr.(*net.OpError); !ok || opErr.Err != alert(alertUnknownPSKIdentity) {
+			t.Errorf("%x: got client error %v, want an unknown_psk_identity alert", suite, clientErr)
+		}
+	}
+}
+
+func TestConcealedPSKIdentities(t *testing.T) {
+	type concealedError struct {
+		identity string
+		err      error
+	}
+	var concealed []concealedError
+	// longIdentity is longer than the smallest session ticket.
+	longIdentity := "device-" + strings.Repeat("0123456789abcdef", 4)
+
+	for _, suite := range append([]uint16{TLS_AES_128_GCM_SHA256}, pskSuitesTLS12...) {
+		vers := uint16(VersionTLS12)
+		if suite == TLS_AES_128_GCM_SHA256 {
+			vers = VersionTLS13
+		}
+		serverConfig := &Config{
+			CipherSuites: []uint16{suite},
+			Certificates: testConfig.Certificates,
+			MaxVersion:   vers,
+			GetPSKKey: func(identity string) ([]byte, error) {
+				if identity != "alice" {
+					return nil, ErrUnknownPSKIdentity
+				}
+				return []byte("0123456789abcdef"), nil
+			},
+			PSKDummyKeySecret: []byte("dummy key secret"),
+			ConcealedPSKError: func(info *ClientHelloInfo, identity string, err error) {
+				concealed = append(concealed, concealedError{identity, err})
+			},
+		}
+
+		// A known identity with the wrong key and unknown identities,
+		// even ones as long as a session ticket, must fail alike.
+		var clientErrs []error
+		for _, identity := range []string{"alice", "mallory", longIdentity} {
+			identity := identity
+			clientConfig := &Config{
+				CipherSuites:       []uint16{suite},
+				InsecureSkipVerify: true,
+				MaxVersion:         vers,
+				GetPSKIdentity:     func([]byte) (string, error) { return identity, nil },
+				GetPSKKey:          func(string) ([]byte, error) { return []byte("fedcba9876543210"), nil },
+			}
+			concealed = nil
+			serverErr, clientErr := testHandshakeErrors(t, clientConfig, serverConfig)
+			if serverErr == nil || clientErr == nil {
+				t.Fatalf("%x/%s: handshake succeeded", suite, identity)
+			}
+			clientErrs = append(clientErrs, clientErr)
+
+			want := []concealedError(nil)
+			if identity != "alice" {
+				want = []concealedError{{identity, ErrUnknownPSKIdentity}}
+			}
+			if !reflect.DeepEqual(concealed, want) {
+				t.Errorf("%x/%s: got concealed errors %v, want %v", suite, identity, concealed, want)
+			}
+		}
+		for _, err := range clientErrs[1:] {
+			if err.Error() != clientErrs[0].Error() {
+				t.Errorf("%x: got client errors %q and %q", suite, clientErrs[0], err)
+			}
+		}
+	}
+}
+
+// TestConcealedPSKRotatedTicket checks that a dummy PSK doesn't stand in
+// for a session ticket that the server can no longer decrypt.
+func TestConcealedPSKRotatedTicket(t *testing.T) {
+	var concealed []string
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS13,
+		GetPSKKey: func(identity string) ([]byte, error) {
+			if identity != "alice" {
+				return nil, ErrUnknownPSKIdentity
+			}
+			return []byte("0123456789abcdef"), nil
+		},
+		PSKDummyKeySecret: []byte("dummy key secret"),
+		ConcealedPSKError: func(info *ClientHelloInfo, identity string, err error) {
+			concealed = append(concealed, identity)
+		},
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+	}
+
+	if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	serverConfig.SetSessionTicketKeys([][32]byte{{1}})
+
+	serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake with a ticket of a rotated key failed: %s", err)
+	}
+	if serverState.DidResume || clientState.DidResume {
+		t.Fatal("resumed a session with a ticket of a rotated key")
+	}
+	if len(concealed) != 0 {
+		t.Errorf("concealed identities %q", concealed)
+	}
+}
+
+// TestConcealedPSKUnknownFirst checks that an unknown identity doesn't hide
+// a known one that the client offers after it.
+func TestConcealedPSKUnknownFirst(t *testing.T) {
+	var concealed []string
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_AES_128_GCM_SHA256},
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS13,
+		GetPSKKey: func(identity string) ([]byte, error) {
+			if identity != "alice" {
+				return nil, ErrUnknownPSKIdentity
+			}
+			return []byte("0123456789abcdef"), nil
+		},
+		PSKDummyKeySecret: []byte("dummy key secret"),
+		ConcealedPSKError: func(info *ClientHelloInfo, identity string, err error) {
+			concealed = append(concealed, identity)
+		},
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_AES_128_GCM_SHA256},
+		ServerName:         "example.com",
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+		ClientSessionCache: NewLRUClientSessionCache(1),
+		GetPSKIdentity:     func([]byte) (string, error) { return "alice", nil },
+		GetPSKKey:          func(string) ([]byte, error) { return []byte("0123456789abcdef"), nil },
+	}
+	// The client offers its session ticket before the external PSK, so a
+	// session with the ticket "mallory" makes it offer [mallory, alice].
+	now := time.Now()
+	clientConfig.ClientSessionCache.Put("example.com", &ClientSessionState{
+		sessionTicket: []byte("mallory"),
+		vers:          VersionTLS13,
+		cipherSuite:   TLS_AES_128_GCM_SHA256,
+		masterSecret:  make([]byte, 32),
+		receivedAt:    now,
+		useBy:         now.Add(time.Hour),
+	})
+
+	serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if serverState.PSKIdentity != "alice" || clientState.PSKIdentity != "alice" {
+		t.Errorf("got PSK identities %q and %q", serverState.PSKIdentity, clientState.PSKIdentity)
+	}
+	if serverState.DidResume {
+		t.Error("resumed the session of an unknown ticket")
+	}
+	if len(concealed) != 0 {
+		t.Errorf("concealed identities %q", concealed)
+	}
+}
+
+func TestPSKKeyCandidates(t *testing.T) {
+	candidates := []PSKCandidate{
+		{Key: []byte("0123456789abcdef"), Version: 1},
+		{Key: []byte("fedcba9876543210"), Version: 2},
+	}
+	getPSKKeyCandidates := func(info *ClientHelloInfo, identity string) ([]PSKCandidate, error) {
+		if identity != "device" {
+			return nil, ErrUnknownPSKIdentity
+		}
+		return candidates, nil
+	}
+
+	type pskCandidatesTest struct {
+		name      string
+		suite     uint16
+		vers      uint16
+		handshake func(t *testing.T, clientConfig, serverConfig *Config) (ConnectionState, ConnectionState, error)
+	}
+	tests := []pskCandidatesTest{
+		{"TLS 1.3", TLS_AES_128_GCM_SHA256, VersionTLS13, testTLS13Handshake},
+		{"GCM", TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256, VersionTLS12, testTLS13Handshake},
+		{"TLS 1.0", TLS_PSK_WITH_AES_128_CBC_SHA, VersionTLS10, testTLS13Handshake},
+		{"DTLS", TLS_PSK_WITH_AES_128_GCM_SHA256, VersionTLS12, testDTLSHandshake},
+	}
+	for _, suite := range pskSuitesTLS12 {
+		tests = append(tests, pskCandidatesTest{fmt.Sprintf("%x", suite), suite, VersionTLS12, testTLS13Handshake})
+	}
+
+	for _, test := range tests {
+		serverConfig := &Config{
+			CipherSuites:        []uint16{test.suite},
+			Certificates:        testConfig.Certificates,
+			MaxVersion:          test.vers,
+			GetPSKKeyCandidates: getPSKKeyCandidates,
+		}
+		for _, candidate := range candidates {
+			key := candidate.Key
+			clientConfig := &Config{
+				CipherSuites:       []uint16{test.suite},
+				InsecureSkipVerify: true,
+				MaxVersion:         test.vers,
+				GetPSKIdentity:     func([]byte) (string, error) { return "device", nil },
+				GetPSKKey:          func(string) ([]byte, error) { return key, nil },
+			}
+			serverState, _, err := test.handshake(t, clientConfig, serverConfig)
+			if err != nil {
+				t.Fatalf("%s, key %d: handshake failed: %s", test.name, candidate.Version, err)
+			}
+			if serverState.PSKKeyVersion != candidate.Version {
+				t.Errorf("%s: got PSK key version %d, want %d", test.name, serverState.PSKKeyVersion, candidate.Version)
+			}
+			if serverState.PSKIdentity != "device" {
+				t.Errorf("%s, key %d: got PSK identity %q", test.name, candidate.Version, serverState.PSKIdentity)
+			}
+		}
+	}
+
+	// A client with neither key fails the handshake.
+	serverConfig := &Config{
+		CipherSuites:        []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		Certificates:        testConfig.Certificates,
+		GetPSKKeyCandidates: getPSKKeyCandidates,
+	}
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+		GetPSKIdentity:     func([]byte) (string, error) { return "device", nil },
+		GetPSKKey:          func(string) ([]byte, error) { return []byte("not the right key"), nil },
+	}
+	if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil {
+		t.Fatal("handshake with an unknown key succeeded")
+	}
+}
+
+func TestTLS13KeyUpdate(t *testing.T) {
+	serverConfig := &Config{
+		Certificates: testConfig.Certificates,
+		MaxVersion:   VersionTLS13,
+	}
+	clientConfig := &Config{
+		InsecureSkipVerify: true,
+		MaxVersion:         VersionTLS13,
+	}
+
+	ln := newLocalListener(t)
+	defer ln.Close()
+
+	done := make(chan error, 1)
+	go func() {
+		conn, err := ln.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		server := Server(conn, serverConfig)
+		defer server.Close()
+		if err := server.Handshake(); err != nil {
+			done <- err
+			return
+		}
+		// Ask for an update of the client keys along with our own.
+		suite := cipherSuiteTLS13ByID(server.cipherSuite)
+		server.out.Lock()
+		_, err = server.writeRecordLocked(recordTypeHandshake, (&keyUpdateMsg{updateRequested: true}).marshal())
+		if err == nil {
+			server.out.setTrafficSecret(suite, suite.nextTrafficSecret(server.out.trafficSecret))
+		}
+		server.out.Unlock()
+		if err != nil {
+			done <- err
+			return
+		}
+		if _, err := server.Write([]byte("hello")); err != nil {
+			done <- err
+			return
+		}
+		_, err = io.ReadFull(server, make([]byte, 5))
+		done <- err
+	}()
+
+	client, err := Dial("tcp", ln.Addr().String(), clientConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	defer client.Close()
+	if _, err := io.ReadFull(client, make([]byte, 5)); err != nil {
+		t.Fatalf("client read failed: %s", err)
+	}
+	if _, err := client.Write([]byte("hello")); err != nil {
+		t.Fatalf("client write failed: %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("server failed: %s", err)
+	}
+}
+
+func TestRawPublicKeys(t *testing.T) {
+	clientCert, err := X509KeyPair([]byte(clientCertificatePEM), []byte(clientKeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serverSPKI, err := x509.MarshalPKIXPublicKey(testConfig.Certificates[0].PrivateKey.(crypto.Signer).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clientSPKI, err := x509.MarshalPKIXPublicKey(clientCert.PrivateKey.(crypto.Signer).Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	rawPublicKeyOnly := []CertificateType{CertificateTypeRawPublicKey}
+	verifyRawPublicKey := func(want []byte) func([]byte, crypto.PublicKey) error {
+		return func(rawPublicKey []byte, publicKey crypto.PublicKey) error {
+			if !bytes.Equal(rawPublicKey, want) {
+				return errors.New("unknown raw public key")
+			}
+			return nil
+		}
+	}
+
+	for _, vers := range []uint16{VersionTLS12, VersionTLS13} {
+		serverConfig := &Config{
+			Certificates:           testConfig.Certificates,
+			MaxVersion:             vers,
+			ClientAuth:             RequireAndVerifyClientCert,
+			ServerCertificateTypes: rawPublicKeyOnly,
+			ClientCertificateTypes: rawPublicKeyOnly,
+			VerifyPeerRawPublicKey: verifyRawPublicKey(clientSPKI),
+		}
+		clientConfig := &Config{
+			// The client has a key but no certificate.
+			Certificates:           []Certificate{{PrivateKey: clientCert.PrivateKey}},
+			ServerName:             "example.golang",
+			MaxVersion:             vers,
+			ServerCertificateTypes: rawPublicKeyOnly,
+			ClientCertificateTypes: rawPublicKeyOnly,
+			VerifyPeerRawPublicKey: verifyRawPublicKey(serverSPKI),
+			ClientSessionCache:     NewLRUClientSessionCache(1),
+		}
+
+		serverState, clientState, err := testTLS13Handshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", vers, err)
+		}
+		if clientState.Version != vers {
+			t.Fatalf("%x: negotiated version %x", vers, clientState.Version)
+		}
+		if len(clientState.PeerCertificates) != 0 || len(serverState.PeerCertificates) != 0 {
+			t.Errorf("%x: got %d server and %d client certificates with raw public keys", vers, len(clientState.PeerCertificates), len(serverState.PeerCertificates))
+		}
+		if !bytes.Equal(clientState.PeerRawPublicKey, serverSPKI) || !bytes.Equal(serverState.PeerRawPublicKey, clientSPKI) {
+			t.Errorf("%x: got raw public keys %x and %x", vers, clientState.PeerRawPublicKey, serverState.PeerRawPublicKey)
+		}
+		if _, ok := clientState.PeerPublicKey.(*rsa.PublicKey); !ok {
+			t.Errorf("%x: got server public key of type %T", vers, clientState.PeerPublicKey)
+		}
+
+		// The raw public keys are restored on resumption.
+		serverState, clientState, err = testTLS13Handshake(t, clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: resumption failed: %s", vers, err)
+		}
+		if !serverState.DidResume || !clientState.DidResume {
+			t.Fatalf("%x: second handshake did not resume", vers)
+		}
+		if !bytes.Equal(clientState.PeerRawPublicKey, serverSPKI) || !bytes.Equal(serverState.PeerRawPublicKey, clientSPKI) {
+			t.Errorf("%x: got raw public keys %x and %x after resumption", vers, clientState.PeerRawPublicKey, serverState.PeerRawPublicKey)
+		}
+
+		// A raw public key is only trusted through VerifyPeerRawPublicKey.
+		clientConfig.ClientSessionCache = nil
+		clientConfig.VerifyPeerRawPublicKey = nil
+		if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil {
+			t.Errorf("%x: handshake succeeded without VerifyPeerRawPublicKey", vers)
+		}
+		clientConfig.VerifyPeerRawPublicKey = verifyRawPublicKey(clientSPKI)
+		if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil {
+			t.Errorf("%x: handshake succeeded with the wrong raw public key", vers)
+		}
+
+		// A server with only X.509 certificates can't authenticate to a
+		// client that only accepts raw public keys.
+		clientConfig.VerifyPeerRawPublicKey = verifyRawPublicKey(serverSPKI)
+		serverConfig.ServerCertificateTypes = nil
+		if _, _, err := testTLS13Handshake(t, clientConfig, serverConfig); err == nil || !strings.Contains(err.Error(), "certificate type") {
+			t.Errorf("%x: handshake without a mutual certificate type returned %v", vers, err)
+		}
 	}
 }
 
@@ -0,0 +1,848 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/hmac"
+	"errors"
+	"hash"
+	"io"
+	"time"
+)
+
+// maxSessionTicketLifetime is the longest lifetime of a TLS 1.3 session
+// ticket allowed by RFC 8446, section 4.6.1, and the one the server
+// advertises.
+const maxSessionTicketLifetime = 7 * 24 * time.Hour
+
+// serverHandshakeStateTLS13 contains details of a TLS 1.3 server handshake in
+// progress. serverHandshake hands over to it once readClientHello has
+// selected TLS 1.3.
+type serverHandshakeStateTLS13 struct {
+	c               *Conn
+	clientHello     *clientHelloMsg
+	clientHelloInfo *ClientHelloInfo
+	hello           *serverHelloMsg
+	hrrPrefix       []byte // the transcript of a HelloRetryRequest, if any
+	sentDummyCCS    bool
+
+	suites          []*cipherSuiteTLS13 // mutual cipher suites, in preference order
+	suite           *cipherSuiteTLS13
+	psk             *selectedPSK
+	sharedKey       []byte
+	cert            *Certificate
+	sigScheme       SignatureScheme
+	transcript      hash.Hash
+	masterSecret    []byte
+	trafficSecret   []byte // client application traffic secret
+	clientFinished  []byte
+	certsFromClient [][]byte
+
+	// clientCertificateTypePresent and serverCertificateTypePresent are
+	// set if the EncryptedExtensions answer the certificate type
+	// extensions of the client.
+	clientCertificateTypePresent bool
+	serverCertificateTypePresent bool
+}
+
+// A selectedPSK is the PSK that the server accepted from the ClientHello.
+type selectedPSK struct {
+	index        int
+	key          []byte
+	suite        *cipherSuiteTLS13
+	isResumption bool
+	certificates [][]byte       // the client certificates of a resumed session
+	identity     []byte         // the identity of the external PSK, or of the one that authenticated a resumed session
+	candidates   []PSKCandidate // the candidate keys of an external PSK, the first of which is key
+	lookupErr    error          // the error that a dummy external PSK stands in for
+}
+
+func (hs *serverHandshakeStateTLS13) handshake() error {
+	c := hs.c
+
+	if err := hs.processClientHello(); err != nil {
+		return err
+	}
+	if err := hs.pickCertificate(); err != nil {
+		return err
+	}
+
+	c.buffering = true
+	if err := hs.sendServerParameters(); err != nil {
+		return err
+	}
+	if err := hs.sendServerCertificate(); err != nil {
+		return err
+	}
+	if err := hs.sendServerFinished(); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+
+	if err := hs.readClientCertificate(); err != nil {
+		return err
+	}
+	if err := hs.readClientFinished(); err != nil {
+		return err
+	}
+
+	c.handshakeComplete = true
+	return nil
+}
+
+// processClientHello selects the cipher suite, PSK and key exchange, asking
+// the client for another key share with a HelloRetryRequest if needed.
+func (hs *serverHandshakeStateTLS13) processClientHello() error {
+	c := hs.c
+
+	hs.hello = &serverHelloMsg{
+		vers:              VersionTLS12,
+		supportedVersion:  c.vers,
+		random:            make([]byte, 32),
+		sessionId:         hs.clientHello.sessionId,
+		compressionMethod: compressionNone,
+	}
+	if _, err := io.ReadFull(c.config.rand(), hs.hello.random); err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+
+	if len(hs.clientHello.compressionMethods) != 1 ||
+		hs.clientHello.compressionMethods[0] != compressionNone {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: TLS 1.3 client supports illegal compression methods")
+	}
+
+	if len(hs.clientHello.serverName) > 0 {
+		c.serverName = hs.clientHello.serverName
+	}
+
+	preferenceList, supportedList := hs.clientHello.cipherSuites, c.config.cipherSuitesTLS13()
+	if c.config.PreferServerCipherSuites {
+		preferenceList, supportedList = supportedList, preferenceList
+	}
+	for _, id := range preferenceList {
+		if suite := mutualCipherSuiteTLS13(supportedList, id); suite != nil {
+			hs.suites = append(hs.suites, suite)
+		}
+	}
+	if len(hs.suites) == 0 {
+		c.sendAlert(alertHandshakeFailure)
+		return errors.New("tls: no cipher suite supported by both client and server")
+	}
+
+	// Prefer a group the client sent a key share for, to avoid a round
+	// trip, and fall back to the first mutually supported one.
+	var selectedGroup CurveID
+	var clientKeyShare *keyShare
+GroupSelection:
+	for _, preferredGroup := range c.config.curvePreferences() {
+		if !curveSupportedTLS13(preferredGroup) {
+			continue
+		}
+		for i := range hs.clientHello.keyShares {
+			if hs.clientHello.keyShares[i].group == preferredGroup {
+				selectedGroup = preferredGroup
+				clientKeyShare = &hs.clientHello.keyShares[i]
+				break GroupSelection
+			}
+		}
+		if selectedGroup != 0 {
+			continue
+		}
+		for _, group := range hs.clientHello.supportedCurves {
+			if group == preferredGroup {
+				selectedGroup = group
+				break
+			}
+		}
+	}
+
+	// The certificate types are negotiated first, since a session can
+	// only be resumed with the same ones.
+	var err error
+	hs.clientCertificateTypePresent, hs.serverCertificateTypePresent, err = c.serverCertificateTypes(hs.clientHello)
+	if err != nil {
+		return err
+	}
+
+	if err := hs.selectPSK(); err != nil {
+		return err
+	}
+	if hs.psk != nil {
+		hs.suite = hs.psk.suite
+	} else {
+		hs.suite = hs.suites[0]
+	}
+
+	// psk_dhe_ke is preferred, and psk_ke is used if the client only
+	// offers it or if it avoids a HelloRetryRequest.
+	useDHE := hs.psk == nil || hs.pskModeOffered(pskModeDHE) &&
+		(clientKeyShare != nil || !hs.pskModeOffered(pskModePlain))
+	if useDHE && clientKeyShare == nil {
+		if selectedGroup == 0 {
+			c.sendAlert(alertHandshakeFailure)
+			return errors.New("tls: no ECDHE curve supported by both client and server")
+		}
+		if err := hs.doHelloRetryRequest(selectedGroup); err != nil {
+			return err
+		}
+		clientKeyShare = &hs.clientHello.keyShares[0]
+
+		// The PSK identities may have changed.
+		if err := hs.selectPSK(); err != nil {
+			return err
+		}
+		if hs.psk != nil && !hs.pskModeOffered(pskModeDHE) {
+			hs.psk = nil
+		}
+	}
+	c.cipherSuite = hs.suite.id
+
+	if hs.psk != nil {
+		binderTranscript := hs.suite.hash.New()
+		binderTranscript.Write(hs.hrrPrefix)
+		binderTranscript.Write(hs.clientHello.marshalWithoutBinders())
+		// The client can't know a dummy key, so its binder is never
+		// valid.
+		if !hs.checkPSKBinder(binderTranscript) || hs.psk.lookupErr != nil {
+			if hs.psk.lookupErr != nil {
+				c.config.reportConcealedPSKError(hs.clientHelloInfo, hs.psk.identity, hs.psk.lookupErr)
+			}
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid PSK binder")
+		}
+		hs.hello.selectedIdentityPresent = true
+		hs.hello.selectedIdentity = uint16(hs.psk.index)
+		c.pskIdentity = hs.psk.identity
+
+		if hs.psk.isResumption {
+			hs.certsFromClient = hs.psk.certificates
+			if len(hs.certsFromClient) > 0 {
+				if _, err := c.processCertsFromClient(hs.certsFromClient); err != nil {
+					return err
+				}
+			}
+			c.didResume = true
+		}
+	}
+
+	if useDHE {
+		ka := &ecdheKeyAgreement{curveid: selectedGroup}
+		params, err := ka.generateECDHParams(c.config)
+		if err != nil {
+			c.sendAlert(alertInternalError)
+			return err
+		}
+		// Skip the curve_type, named_curve and length of the ServerECDHParams.
+		hs.hello.serverShare = keyShare{group: selectedGroup, data: params[4:]}
+		hs.sharedKey, err = ka.sharedSecret(clientKeyShare.data)
+		if err != nil {
+			c.sendAlert(alertIllegalParameter)
+			return errors.New("tls: invalid client key share")
+		}
+		c.curveID = selectedGroup
+	}
+
+	return nil
+}
+
+func (hs *serverHandshakeStateTLS13) pskModeOffered(mode uint8) bool {
+	for _, m := range hs.clientHello.pskModes {
+		if m == mode {
+			return true
+		}
+	}
+	return false
+}
+
+// selectPSK sets hs.psk to the first PSK identity in the ClientHello that is
+// either a valid session ticket or known to the GetPSKKeyForClient or
+// GetPSKKey callback, and that can be used with one of hs.suites. The binder
+// is checked later, once the ClientHello is final.
+func (hs *serverHandshakeStateTLS13) selectPSK() error {
+	c := hs.c
+	hs.psk = nil
+
+	// The client must send psk_key_exchange_modes along with PSKs, see RFC
+	// 8446, section 4.2.9.
+	if len(hs.clientHello.pskIdentities) == 0 {
+		return nil
+	}
+	if len(hs.clientHello.pskModes) == 0 {
+		c.sendAlert(alertMissingExtension)
+		return errors.New("tls: client sent PSKs without psk_key_exchange_modes")
+	}
+
+	// concealed is the first unknown identity, kept in case the client
+	// offers nothing better.
+	var concealed *selectedPSK
+	for i, identity := range hs.clientHello.pskIdentities {
+		// decryptTicket decrypts in place.
+		label := append([]byte(nil), identity.label...)
+		if sessionState, ok := c.decryptTicket(label); ok && sessionState.vers == VersionTLS13 {
+			if hs.acceptsSessionState(sessionState) {
+				hs.psk = &selectedPSK{
+					index:        i,
+					key:          sessionState.masterSecret,
+					suite:        hs.suiteWithHash(cipherSuiteTLS13ByID(sessionState.cipherSuite).hash),
+					isResumption: true,
+					certificates: sessionState.certificates,
+					identity:     sessionState.pskIdentity,
+				}
+				return nil
+			}
+			continue
+		}
+
+		if !c.config.hasServerPSKKey() {
+			continue
+		}
+		// External PSKs use SHA-256, see RFC 8446, section 4.2.11.
+		suite := hs.suiteWithHash(crypto.SHA256)
+		if suite == nil {
+			continue
+		}
+		candidates, lookupErr, err := c.config.concealedPSKCandidates(hs.clientHelloInfo, string(identity.label))
+		if err != nil || len(candidates[0].Key) == 0 {
+			continue
+		}
+		psk := &selectedPSK{
+			index:      i,
+			key:        candidates[0].Key,
+			suite:      suite,
+			identity:   identity.label,
+			candidates: candidates,
+			lookupErr:  lookupErr,
+		}
+		if lookupErr != nil {
+			if concealed == nil && !c.mayBeTicket(identity.label) {
+				concealed = psk
+			}
+			continue
+		}
+		hs.psk = psk
+		return nil
+	}
+
+	hs.psk = concealed
+	return nil
+}
+
+// checkPSKBinder reports whether the binder of hs.psk is valid. An external
+// PSK with several candidate keys settles on the one that it is valid for.
+func (hs *serverHandshakeStateTLS13) checkPSKBinder(transcript hash.Hash) bool {
+	c := hs.c
+	want := hs.clientHello.pskBinders[hs.psk.index]
+
+	if hs.psk.isResumption {
+		return hmac.Equal(hs.suite.binder(hs.psk.key, true, transcript), want)
+	}
+	for _, candidate := range hs.psk.candidates {
+		if hmac.Equal(hs.suite.binder(candidate.Key, false, transcript), want) {
+			hs.psk.key = candidate.Key
+			c.pskKeyVersion = candidate.Version
+			return true
+		}
+	}
+	return false
+}
+
+// acceptsSessionState reports whether a TLS 1.3 session from a ticket can be
+// resumed on this connection.
+func (hs *serverHandshakeStateTLS13) acceptsSessionState(sessionState *sessionState) bool {
+	c := hs.c
+
+	suite := cipherSuiteTLS13ByID(sessionState.cipherSuite)
+	if suite == nil || hs.suiteWithHash(suite.hash) == nil {
+		return false
+	}
+
+	// The ticket mustn't outlive the lifetime that it was issued with.
+	if sessionState.createdAt == 0 {
+		return false
+	}
+	createdAt := time.Unix(int64(sessionState.createdAt), 0)
+	if c.config.time().Sub(createdAt) > maxSessionTicketLifetime {
+		return false
+	}
+
+	sessionHasClientCerts := len(sessionState.certificates) != 0
+	needClientCerts := c.config.ClientAuth == RequireAnyClientCert || c.config.ClientAuth == RequireAndVerifyClientCert
+	if needClientCerts && !sessionHasClientCerts {
+		return false
+	}
+	if sessionHasClientCerts && c.config.ClientAuth == NoClientCert {
+		return false
+	}
+
+	// The credentials of the session must be of the negotiated types.
+	if sessionHasClientCerts && sessionState.clientRawPublicKey != (c.clientCertificateType == CertificateTypeRawPublicKey) {
+		return false
+	}
+	if sessionState.serverRawPublicKey != (c.serverCertificateType == CertificateTypeRawPublicKey) {
+		return false
+	}
+
+	// A session authenticated with a PSK needs the PSK to be still known.
+	if sessionState.pskIdentity != nil && !c.config.knowsPSKIdentity(hs.clientHelloInfo, sessionState.pskIdentity) {
+		return false
+	}
+
+	return true
+}
+
+// suiteWithHash returns the most preferred mutual cipher suite using h, or
+// nil if there isn't one.
+func (hs *serverHandshakeStateTLS13) suiteWithHash(h crypto.Hash) *cipherSuiteTLS13 {
+	for _, suite := range hs.suites {
+		if suite.hash == h {
+			return suite
+		}
+	}
+	return nil
+}
+
+// doHelloRetryRequest asks the client for a key share for group and reads
+// the second ClientHello.
+func (hs *serverHandshakeStateTLS13) doHelloRetryRequest(group CurveID) error {
+	c := hs.c
+
+	helloRetryRequest := &serverHelloMsg{
+		vers:              hs.hello.vers,
+		random:            helloRetryRequestRandom,
+		sessionId:         hs.hello.sessionId,
+		cipherSuite:       hs.suite.id,
+		compressionMethod: hs.hello.compressionMethod,
+		supportedVersion:  hs.hello.supportedVersion,
+		selectedGroup:     group,
+	}
+
+	// The first ClientHello is replaced in the transcript by the
+	// synthetic message_hash message, see RFC 8446, section 4.4.1.
+	h := hs.suite.hash.New()
+	h.Write(hs.clientHello.marshal())
+	chHash := h.Sum(nil)
+	hs.hrrPrefix = append([]byte{typeMessageHash, 0, 0, uint8(len(chHash))}, chHash...)
+	hs.hrrPrefix = append(hs.hrrPrefix, helloRetryRequest.marshal()...)
+
+	if _, err := c.writeRecord(recordTypeHandshake, helloRetryRequest.marshal()); err != nil {
+		return err
+	}
+	if err := hs.sendDummyChangeCipherSpec(); err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	clientHello, ok := msg.(*clientHelloMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(clientHello, msg)
+	}
+
+	if len(clientHello.keyShares) != 1 || clientHello.keyShares[0].group != group {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: client sent invalid key share in second ClientHello")
+	}
+	if illegalClientHelloChange(clientHello, hs.clientHello) {
+		c.sendAlert(alertIllegalParameter)
+		return errors.New("tls: client illegally modified second ClientHello")
+	}
+	hs.clientHello = clientHello
+
+	// The cipher suite of the HelloRetryRequest is final.
+	hs.suites = []*cipherSuiteTLS13{hs.suite}
+
+	return nil
+}
+
+// illegalClientHelloChange reports whether the two ClientHello messages
+// differ in more than the ways allowed after a HelloRetryRequest, see RFC
+// 8446, section 4.1.2.
+func illegalClientHelloChange(ch, ch1 *clientHelloMsg) bool {
+	if ch.vers != ch1.vers ||
+		!bytes.Equal(ch.random, ch1.random) ||
+		!bytes.Equal(ch.sessionId, ch1.sessionId) ||
+		!bytes.Equal(ch.compressionMethods, ch1.compressionMethods) ||
+		ch.serverName != ch1.serverName ||
+		len(ch.cipherSuites) != len(ch1.cipherSuites) ||
+		len(ch.supportedCurves) != len(ch1.supportedCurves) ||
+		len(ch.signatureAndHashes) != len(ch1.signatureAndHashes) ||
+		len(ch.supportedVersions) != len(ch1.supportedVersions) ||
+		len(ch.alpnProtocols) != len(ch1.alpnProtocols) ||
+		!eqCertificateTypes(ch.clientCertificateTypes, ch1.clientCertificateTypes) ||
+		!eqCertificateTypes(ch.serverCertificateTypes, ch1.serverCertificateTypes) {
+		return true
+	}
+	for i := range ch.cipherSuites {
+		if ch.cipherSuites[i] != ch1.cipherSuites[i] {
+			return true
+		}
+	}
+	for i := range ch.supportedCurves {
+		if ch.supportedCurves[i] != ch1.supportedCurves[i] {
+			return true
+		}
+	}
+	for i := range ch.signatureAndHashes {
+		if ch.signatureAndHashes[i] != ch1.signatureAndHashes[i] {
+			return true
+		}
+	}
+	for i := range ch.supportedVersions {
+		if ch.supportedVersions[i] != ch1.supportedVersions[i] {
+			return true
+		}
+	}
+	for i := range ch.alpnProtocols {
+		if ch.alpnProtocols[i] != ch1.alpnProtocols[i] {
+			return true
+		}
+	}
+	return false
+}
+
+// pickCertificate selects the certificate and signature algorithm, unless a
+// PSK authenticates the server.
+func (hs *serverHandshakeStateTLS13) pickCertificate() error {
+	c := hs.c
+
+	if hs.psk != nil {
+		return nil
+	}
+
+	cert, err := c.config.getCertificate(hs.clientHelloInfo)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	priv, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: certificate private key does not implement crypto.Signer")
+	}
+	hs.sigScheme, err = pickSignatureSchemeTLS13(priv.Public(), hs.clientHello.signatureAndHashes)
+	if err != nil {
+		c.sendAlert(alertHandshakeFailure)
+		return err
+	}
+	hs.cert = cert
+
+	return nil
+}
+
+// sendDummyChangeCipherSpec sends the ChangeCipherSpec record that TLS 1.3
+// servers send, once, to clients in middlebox compatibility mode.
+func (hs *serverHandshakeStateTLS13) sendDummyChangeCipherSpec() error {
+	if hs.sentDummyCCS || len(hs.clientHello.sessionId) == 0 {
+		return nil
+	}
+	hs.sentDummyCCS = true
+
+	_, err := hs.c.writeRecord(recordTypeChangeCipherSpec, []byte{1})
+	return err
+}
+
+func (hs *serverHandshakeStateTLS13) sendServerParameters() error {
+	c := hs.c
+
+	hs.hello.cipherSuite = hs.suite.id
+
+	hs.transcript = hs.suite.hash.New()
+	hs.transcript.Write(hs.hrrPrefix)
+	hs.transcript.Write(hs.clientHello.marshal())
+	hs.transcript.Write(hs.hello.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, hs.hello.marshal()); err != nil {
+		return err
+	}
+
+	if err := hs.sendDummyChangeCipherSpec(); err != nil {
+		return err
+	}
+
+	var psk []byte
+	if hs.psk != nil {
+		psk = hs.psk.key
+	}
+	earlySecret := hs.suite.earlySecret(psk)
+	handshakeSecret := hs.suite.handshakeSecret(earlySecret, hs.sharedKey)
+
+	clientSecret := hs.suite.deriveSecret(handshakeSecret, clientHandshakeTrafficLabel, hs.transcript)
+	serverSecret := hs.suite.deriveSecret(handshakeSecret, serverHandshakeTrafficLabel, hs.transcript)
+	c.in.setTrafficSecret(hs.suite, clientSecret)
+	c.out.setTrafficSecret(hs.suite, serverSecret)
+
+	if err := c.config.writeKeyLog(keyLogLabelClientHandshake, hs.clientHello.random, clientSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+	if err := c.config.writeKeyLog(keyLogLabelServerHandshake, hs.clientHello.random, serverSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+
+	hs.masterSecret = hs.suite.masterSecret(handshakeSecret)
+
+	encryptedExtensions := new(encryptedExtensionsMsg)
+	if len(hs.clientHello.alpnProtocols) > 0 {
+		if selectedProto, fallback := mutualProtocol(hs.clientHello.alpnProtocols, c.config.NextProtos); !fallback {
+			encryptedExtensions.alpnProtocol = selectedProto
+			c.clientProtocol = selectedProto
+		}
+	}
+	maxFragmentLength, recordSizeLimit, err := c.serverRecordSizeLimits(hs.clientHello)
+	if err != nil {
+		return err
+	}
+	encryptedExtensions.maxFragmentLength = maxFragmentLength
+	encryptedExtensions.recordSizeLimit = recordSizeLimit
+	// Without certificates there are no certificate types to agree on.
+	if hs.psk == nil {
+		encryptedExtensions.clientCertificateTypePresent = hs.clientCertificateTypePresent
+		encryptedExtensions.clientCertificateType = c.clientCertificateType
+		encryptedExtensions.serverCertificateTypePresent = hs.serverCertificateTypePresent
+		encryptedExtensions.serverCertificateType = c.serverCertificateType
+	}
+
+	hs.transcript.Write(encryptedExtensions.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, encryptedExtensions.marshal()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (hs *serverHandshakeStateTLS13) requestClientCert() bool {
+	return hs.c.config.ClientAuth >= RequestClientCert && hs.psk == nil
+}
+
+func (hs *serverHandshakeStateTLS13) sendServerCertificate() error {
+	c := hs.c
+
+	// Either the PSK authenticates the server or its certificate does.
+	if hs.psk != nil {
+		return nil
+	}
+
+	if hs.requestClientCert() {
+		certReq := &certificateRequestMsgTLS13{
+			signatureAndHashes: signatureAlgorithmsTLS13(),
+		}
+		if c.config.ClientCAs != nil {
+			certReq.certificateAuthorities = c.config.ClientCAs.Subjects()
+		}
+
+		hs.transcript.Write(certReq.marshal())
+		if _, err := c.writeRecord(recordTypeHandshake, certReq.marshal()); err != nil {
+			return err
+		}
+	}
+
+	certificates, err := hs.cert.certificatesOfType(c.serverCertificateType)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	certMsg := &certificateMsgTLS13{certificates: certificates}
+	if hs.clientHello.ocspStapling {
+		certMsg.ocspStaple = hs.cert.OCSPStaple
+	}
+	if hs.clientHello.scts {
+		certMsg.scts = hs.cert.SignedCertificateTimestamps
+	}
+
+	hs.transcript.Write(certMsg.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, certMsg.marshal()); err != nil {
+		return err
+	}
+
+	signature, err := signTLS13(c.config.rand(), hs.cert.PrivateKey.(crypto.Signer), hs.sigScheme, serverSignatureContext, hs.transcript)
+	if err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to sign handshake: " + err.Error())
+	}
+	certVerify := &certificateVerifyMsg{
+		hasSignatureAndHash: true,
+		signatureAndHash:    signatureAndHash{hash: uint8(hs.sigScheme >> 8), signature: uint8(hs.sigScheme)},
+		signature:           signature,
+	}
+
+	hs.transcript.Write(certVerify.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, certVerify.marshal()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (hs *serverHandshakeStateTLS13) sendServerFinished() error {
+	c := hs.c
+
+	finished := &finishedMsg{
+		verifyData: hs.suite.finishedHash(c.out.trafficSecret, hs.transcript),
+	}
+
+	hs.transcript.Write(finished.marshal())
+	if _, err := c.writeRecord(recordTypeHandshake, finished.marshal()); err != nil {
+		return err
+	}
+
+	// The application traffic secrets cover the handshake up to the
+	// server Finished.
+	hs.trafficSecret = hs.suite.deriveSecret(hs.masterSecret, clientApplicationTrafficLabel, hs.transcript)
+	serverSecret := hs.suite.deriveSecret(hs.masterSecret, serverApplicationTrafficLabel, hs.transcript)
+	c.out.setTrafficSecret(hs.suite, serverSecret)
+
+	if err := c.config.writeKeyLog(keyLogLabelClientTraffic, hs.clientHello.random, hs.trafficSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+	if err := c.config.writeKeyLog(keyLogLabelServerTraffic, hs.clientHello.random, serverSecret); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: failed to write to key log: " + err.Error())
+	}
+
+	// Without client certificates the rest of the transcript is known, so
+	// the session ticket can go out in the first flight.
+	if !hs.requestClientCert() {
+		if err := hs.sendSessionTickets(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// sendSessionTickets computes the expected client Finished, which completes
+// the transcript, and sends a session ticket for the resumption secret.
+func (hs *serverHandshakeStateTLS13) sendSessionTickets() error {
+	c := hs.c
+
+	hs.clientFinished = hs.suite.finishedHash(c.in.trafficSecret, hs.transcript)
+	finished := &finishedMsg{verifyData: hs.clientFinished}
+	hs.transcript.Write(finished.marshal())
+
+	// Tickets are only useful to clients that can offer them back.
+	if c.config.SessionTicketsDisabled || !hs.pskModeOffered(pskModeDHE) && !hs.pskModeOffered(pskModePlain) {
+		return nil
+	}
+
+	c.resumptionSecret = hs.suite.deriveSecret(hs.masterSecret, resumptionLabel, hs.transcript)
+
+	// A single ticket is sent per connection, so the nonce can be empty.
+	m := &newSessionTicketMsgTLS13{
+		lifetime: uint32(maxSessionTicketLifetime / time.Second),
+	}
+	var ageAdd [4]byte
+	if _, err := io.ReadFull(c.config.rand(), ageAdd[:]); err != nil {
+		c.sendAlert(alertInternalError)
+		return err
+	}
+	m.ageAdd = uint32(ageAdd[0])<<24 | uint32(ageAdd[1])<<16 | uint32(ageAdd[2])<<8 | uint32(ageAdd[3])
+
+	state := sessionState{
+		vers:               c.vers,
+		cipherSuite:        hs.suite.id,
+		masterSecret:       hs.suite.resumptionPSK(c.resumptionSecret, m.nonce),
+		certificates:       hs.certsFromClient,
+		clientRawPublicKey: len(hs.certsFromClient) > 0 && c.clientCertificateType == CertificateTypeRawPublicKey,
+		serverRawPublicKey: c.serverCertificateType == CertificateTypeRawPublicKey,
+		pskIdentity:        c.pskIdentity,
+		createdAt:          uint64(c.config.time().Unix()),
+	}
+	var err error
+	m.label, err = c.encryptTicket(&state)
+	if err != nil {
+		return err
+	}
+
+	if _, err := c.writeRecord(recordTypeHandshake, m.marshal()); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (hs *serverHandshakeStateTLS13) readClientCertificate() error {
+	c := hs.c
+
+	if !hs.requestClientCert() {
+		return nil
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	certMsg, ok := msg.(*certificateMsgTLS13)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(certMsg, msg)
+	}
+	hs.transcript.Write(certMsg.marshal())
+
+	if len(certMsg.certificates) == 0 && c.config.ClientAuth >= RequireAnyClientCert {
+		c.sendAlert(alertCertificateRequired)
+		return errors.New("tls: client didn't provide a certificate")
+	}
+
+	hs.certsFromClient = certMsg.certificates
+	pub, err := c.processCertsFromClient(certMsg.certificates)
+	if err != nil {
+		return err
+	}
+
+	if len(certMsg.certificates) > 0 {
+		msg, err = c.readHandshake()
+		if err != nil {
+			return err
+		}
+		certVerify, ok := msg.(*certificateVerifyMsg)
+		if !ok {
+			c.sendAlert(alertUnexpectedMessage)
+			return unexpectedMessageError(certVerify, msg)
+		}
+
+		scheme := SignatureScheme(certVerify.signatureAndHash.hash)<<8 | SignatureScheme(certVerify.signatureAndHash.signature)
+		if err := verifyTLS13(pub, scheme, clientSignatureContext, hs.transcript, certVerify.signature); err != nil {
+			c.sendAlert(alertDecryptError)
+			return errors.New("tls: invalid signature by the client certificate: " + err.Error())
+		}
+		hs.transcript.Write(certVerify.marshal())
+	}
+
+	// The ticket waited for the client certificates that it includes.
+	return hs.sendSessionTickets()
+}
+
+func (hs *serverHandshakeStateTLS13) readClientFinished() error {
+	c := hs.c
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	finished, ok := msg.(*finishedMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(finished, msg)
+	}
+
+	if !hmac.Equal(hs.clientFinished, finished.verifyData) {
+		c.sendAlert(alertDecryptError)
+		return errors.New("tls: invalid client finished hash")
+	}
+
+	c.in.setTrafficSecret(hs.suite, hs.trafficSecret)
+
+	return nil
+}
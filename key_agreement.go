@@ -5,7 +5,9 @@
 package tls
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/elliptic"
 	"crypto/md5"
@@ -15,11 +17,14 @@ import (
 	"crypto/x509"
 	"encoding/asn1"
 	"errors"
+	"fmt"
+	"hash"
 	"io"
 	"math/big"
 	"unicode/utf8"
 
 	"golang_org/x/crypto/curve25519"
+	"golang_org/x/crypto/ed25519"
 )
 
 var errClientKeyExchange = errors.New("tls: invalid ClientKeyExchange message")
@@ -77,7 +82,12 @@ func (ka rsaKeyAgreement) generateClientKeyExchange(config *Config, clientHello
 		return nil, nil, err
 	}
 
-	encrypted, err := rsa.EncryptPKCS1v15(config.rand(), cert.PublicKey.(*rsa.PublicKey), preMasterSecret)
+	// RSASSA-PSS keys don't encrypt.
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("tls: RSA key exchange requires a RSA server public key")
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(config.rand(), pub, preMasterSecret)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -111,17 +121,106 @@ func md5SHA1Hash(slices [][]byte) []byte {
 	return md5sha1
 }
 
+// signatureAlgorithmTLS12 returns the type of key that signs with a TLS 1.2
+// signature algorithm, the hash function it uses and whether it uses RSA-PSS.
+// Ed25519 hashes on its own and has a zero hash function.
+func signatureAlgorithmTLS12(sigAndHash signatureAndHash) (sigType uint8, hashFunc crypto.Hash, isPSS bool, ok bool) {
+	switch SignatureScheme(sigAndHash.hash)<<8 | SignatureScheme(sigAndHash.signature) {
+	case PSSWithSHA256:
+		return signatureRSA, crypto.SHA256, true, true
+	case PSSWithSHA384:
+		return signatureRSA, crypto.SHA384, true, true
+	case PSSWithSHA512:
+		return signatureRSA, crypto.SHA512, true, true
+	case PSSKeyWithSHA256:
+		return signatureRSAPSS, crypto.SHA256, true, true
+	case PSSKeyWithSHA384:
+		return signatureRSAPSS, crypto.SHA384, true, true
+	case PSSKeyWithSHA512:
+		return signatureRSAPSS, crypto.SHA512, true, true
+	case Ed25519:
+		return signatureEd25519, 0, false, true
+	}
+	switch sigAndHash.signature {
+	case signatureRSA, signatureDSA, signatureECDSA:
+		hashFunc, err := lookupTLSHash(sigAndHash.hash)
+		if err != nil {
+			return 0, 0, false, false
+		}
+		return sigAndHash.signature, hashFunc, false, true
+	}
+	return 0, 0, false, false
+}
+
+// signatureTypeForKey returns the signature type of pub, or zero if TLS 1.2
+// doesn't sign with it.
+func signatureTypeForKey(pub crypto.PublicKey) uint8 {
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return signatureRSA
+	case *rsaPSSPublicKey:
+		return signatureRSAPSS
+	case *dsa.PublicKey:
+		return signatureDSA
+	case *ecdsa.PublicKey:
+		return signatureECDSA
+	case ed25519.PublicKey:
+		return signatureEd25519
+	}
+	return 0
+}
+
+// isRSAKey reports whether pub signs for the cipher suites that authenticate
+// with RSA, which RSASSA-PSS keys do from TLS 1.2.
+func isRSAKey(pub crypto.PublicKey) bool {
+	switch pub.(type) {
+	case *rsa.PublicKey, *rsaPSSPublicKey:
+		return true
+	}
+	return false
+}
+
+// suiteSignatureType returns the signature type of the cipher suites that
+// keys of sigType sign for.
+func suiteSignatureType(sigType uint8) uint8 {
+	if sigType == signatureRSAPSS {
+		return signatureRSA
+	}
+	return sigType
+}
+
+// canSignPSS reports whether pub, an RSA or RSASSA-PSS key, can sign with
+// RSA-PSS using hashFunc. A salt as long as the hash needs room for two
+// hashes, see RFC 8017, section 9.1.1.
+func canSignPSS(pub crypto.PublicKey, hashFunc crypto.Hash) bool {
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		return (pub.N.BitLen()-1+7)/8 >= 2*hashFunc.Size()+2
+	case *rsaPSSPublicKey:
+		return pub.allowsHash(hashFunc) && canSignPSS(pub.PublicKey, hashFunc)
+	}
+	return false
+}
+
 // hashForServerKeyExchange hashes the given slices and returns their digest
 // and the identifier of the hash function used. The sigAndHash argument is
 // only used for >= TLS 1.2 and precisely identifies the hash function to use.
-func hashForServerKeyExchange(sigAndHash signatureAndHash, version uint16, slices ...[]byte) ([]byte, crypto.Hash, error) {
+// Ed25519 signs the concatenated slices, which are returned unhashed.
+func hashForServerKeyExchange(config *Config, sigAndHash signatureAndHash, version uint16, slices ...[]byte) ([]byte, crypto.Hash, error) {
 	if version >= VersionTLS12 {
-		if !isSupportedSignatureAndHash(sigAndHash, supportedSignatureAlgorithms) {
+		supported := config.signatureAlgorithms()
+		if sigAndHash.signature == signatureDSA {
+			supported = supportedDSASignatureAlgorithms
+		}
+		if !isSupportedSignatureAndHash(sigAndHash, supported) {
 			return nil, crypto.Hash(0), errors.New("tls: unsupported hash function used by peer")
 		}
-		hashFunc, err := lookupTLSHash(sigAndHash.hash)
-		if err != nil {
-			return nil, crypto.Hash(0), err
+		_, hashFunc, _, ok := signatureAlgorithmTLS12(sigAndHash)
+		if !ok {
+			return nil, crypto.Hash(0), errors.New("tls: unsupported hash algorithm")
+		}
+		if hashFunc == 0 {
+			return bytes.Join(slices, nil), hashFunc, nil
 		}
 		h := hashFunc.New()
 		for _, slice := range slices {
@@ -130,33 +229,288 @@ func hashForServerKeyExchange(sigAndHash signatureAndHash, version uint16, slice
 		digest := h.Sum(nil)
 		return digest, hashFunc, nil
 	}
-	if sigAndHash.signature == signatureECDSA {
+	if sigAndHash.signature == signatureECDSA || sigAndHash.signature == signatureDSA {
 		return sha1Hash(slices), crypto.SHA1, nil
 	}
 	return md5SHA1Hash(slices), crypto.MD5SHA1, nil
 }
 
-// pickTLS12HashForSignature returns a TLS 1.2 hash identifier for signing a
-// ServerKeyExchange given the signature type being used and the client's
-// advertised list of supported signature and hash combinations.
-func pickTLS12HashForSignature(sigType uint8, clientList []signatureAndHash) (uint8, error) {
-	if len(clientList) == 0 {
-		// If the client didn't specify any signature_algorithms
+// signHandshake signs a digest from hashForServerKeyExchange or
+// hashForClientCertificate with the algorithm of sigAndHash.
+func signHandshake(rand io.Reader, priv crypto.Signer, sigAndHash signatureAndHash, hashFunc crypto.Hash, digest []byte) ([]byte, error) {
+	var opts crypto.SignerOpts = hashFunc
+	if _, _, isPSS, _ := signatureAlgorithmTLS12(sigAndHash); isPSS {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hashFunc}
+	}
+	return priv.Sign(rand, digest, opts)
+}
+
+// verifyHandshakeSignature checks a signature made by signHandshake with the
+// private key of pub. Before TLS 1.2, sigAndHash only has a signature type.
+func verifyHandshakeSignature(pub crypto.PublicKey, sigAndHash signatureAndHash, hashFunc crypto.Hash, digest, sig []byte) error {
+	sigType, _, isPSS, ok := signatureAlgorithmTLS12(sigAndHash)
+	if !ok {
+		sigType = sigAndHash.signature
+	}
+	if sigType != signatureTypeForKey(pub) {
+		return errors.New("tls: signature algorithm doesn't match the key type")
+	}
+	switch pub := pub.(type) {
+	case *ecdsa.PublicKey:
+		ecdsaSig := new(ecdsaSignature)
+		if _, err := asn1.Unmarshal(sig, ecdsaSig); err != nil {
+			return err
+		}
+		if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
+			return errors.New("tls: ECDSA signature contained zero or negative values")
+		}
+		if !ecdsa.Verify(pub, digest, ecdsaSig.R, ecdsaSig.S) {
+			return errors.New("tls: ECDSA verification failure")
+		}
+		return nil
+	case *rsa.PublicKey:
+		if isPSS {
+			return rsa.VerifyPSS(pub, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+		}
+		return rsa.VerifyPKCS1v15(pub, hashFunc, digest, sig)
+	case *rsaPSSPublicKey:
+		if !isPSS || !pub.allowsHash(hashFunc) {
+			return errors.New("tls: signature algorithm not allowed by the RSASSA-PSS key")
+		}
+		return rsa.VerifyPSS(pub.PublicKey, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case ed25519.PublicKey:
+		if !ed25519.Verify(pub, digest, sig) {
+			return errors.New("tls: Ed25519 verification failure")
+		}
+		return nil
+	}
+	return fmt.Errorf("tls: unsupported public key type %T", pub)
+}
+
+// truncateDSADigest truncates digest to the byte length of the subgroup
+// order q, as FIPS 186-3 section 4.6 requires and crypto/dsa doesn't do.
+func truncateDSADigest(digest []byte, q *big.Int) []byte {
+	if n := (q.BitLen() + 7) / 8; len(digest) > n {
+		return digest[:n]
+	}
+	return digest
+}
+
+// signDSA returns the DER encoded DSA signature of digest.
+func signDSA(rand io.Reader, priv *dsa.PrivateKey, digest []byte) ([]byte, error) {
+	r, s, err := dsa.Sign(rand, priv, truncateDSADigest(digest, priv.Q))
+	if err != nil {
+		return nil, err
+	}
+	return asn1.Marshal(dsaSignature{r, s})
+}
+
+// pickTLS12SignatureAlgorithm returns a TLS 1.2 signature algorithm for
+// signing with pub, in the order of the peer's list, from those that config
+// supports. RSA-PSS is only used with keys that are large enough for it, and
+// RSASSA-PSS keys only sign with the algorithms that their parameters allow.
+func pickTLS12SignatureAlgorithm(config *Config, pub crypto.PublicKey, peerList []signatureAndHash) (signatureAndHash, error) {
+	sigType := signatureTypeForKey(pub)
+	supported := config.signatureAlgorithms()
+	if sigType == signatureDSA {
+		supported = supportedDSASignatureAlgorithms
+	}
+
+	if len(peerList) == 0 && sigType != signatureEd25519 && sigType != signatureRSAPSS {
+		// If the peer didn't specify any signature_algorithms
 		// extension then we can assume that it supports SHA1. See
 		// http://tools.ietf.org/html/rfc5246#section-7.4.1.4.1
-		return hashSHA1, nil
+		return signatureAndHash{hashSHA1, sigType}, nil
+	}
+
+	for _, sigAndHash := range peerList {
+		if !isSupportedSignatureAndHash(sigAndHash, supported) {
+			continue
+		}
+		keyType, hashFunc, isPSS, ok := signatureAlgorithmTLS12(sigAndHash)
+		if !ok || keyType != sigType {
+			continue
+		}
+		if isPSS && !canSignPSS(pub, hashFunc) {
+			continue
+		}
+		return sigAndHash, nil
+	}
+
+	return signatureAndHash{}, errors.New("tls: peer doesn't support any common signature algorithms")
+}
+
+// Contexts of the TLS 1.3 CertificateVerify signatures, see RFC 8446,
+// section 4.4.3.
+const (
+	serverSignatureContext = "TLS 1.3, server CertificateVerify\x00"
+	clientSignatureContext = "TLS 1.3, client CertificateVerify\x00"
+)
+
+// signedMessageTLS13 returns the digest that is signed in a TLS 1.3
+// CertificateVerify message, or the message itself for Ed25519, which hashes
+// on its own and has a zero hashFunc.
+func signedMessageTLS13(hashFunc crypto.Hash, context string, transcript hash.Hash) []byte {
+	msg := bytes.Repeat([]byte{0x20}, 64)
+	msg = append(msg, context...)
+	msg = append(msg, transcript.Sum(nil)...)
+	if hashFunc == 0 {
+		return msg
+	}
+	h := hashFunc.New()
+	h.Write(msg)
+	return h.Sum(nil)
+}
+
+// signatureSchemeTLS13 returns the parameters of a signature scheme usable in
+// TLS 1.3. ECDSA schemes are bound to a curve, RSA keys always sign with
+// RSA-PSS and Ed25519 has a zero hashFunc.
+func signatureSchemeTLS13(scheme SignatureScheme) (hashFunc crypto.Hash, curve elliptic.Curve, isPSS bool, ok bool) {
+	switch scheme {
+	case PSSWithSHA256, PSSKeyWithSHA256:
+		return crypto.SHA256, nil, true, true
+	case PSSWithSHA384, PSSKeyWithSHA384:
+		return crypto.SHA384, nil, true, true
+	case PSSWithSHA512, PSSKeyWithSHA512:
+		return crypto.SHA512, nil, true, true
+	case ECDSAWithP256AndSHA256:
+		return crypto.SHA256, elliptic.P256(), false, true
+	case ECDSAWithP384AndSHA384:
+		return crypto.SHA384, elliptic.P384(), false, true
+	case ECDSAWithP521AndSHA512:
+		return crypto.SHA512, elliptic.P521(), false, true
+	case Ed25519:
+		return 0, nil, false, true
+	}
+	return 0, nil, false, false
+}
+
+// isPSSKeyScheme reports whether scheme is one of the rsa_pss_pss algorithms,
+// which are for RSASSA-PSS keys only, see RFC 8446, section 4.2.3.
+func isPSSKeyScheme(scheme SignatureScheme) bool {
+	switch scheme {
+	case PSSKeyWithSHA256, PSSKeyWithSHA384, PSSKeyWithSHA512:
+		return true
 	}
+	return false
+}
 
-	for _, sigAndHash := range clientList {
-		if sigAndHash.signature != sigType {
+// pickSignatureSchemeTLS13 selects a signature scheme for pub, in the order
+// of the peer's list.
+func pickSignatureSchemeTLS13(pub crypto.PublicKey, peerList []signatureAndHash) (SignatureScheme, error) {
+	for _, sah := range peerList {
+		scheme := SignatureScheme(sah.hash)<<8 | SignatureScheme(sah.signature)
+		hashFunc, curve, isPSS, ok := signatureSchemeTLS13(scheme)
+		if !ok {
 			continue
 		}
-		if isSupportedSignatureAndHash(sigAndHash, supportedSignatureAlgorithms) {
-			return sigAndHash.hash, nil
+		switch pub := pub.(type) {
+		case *rsa.PublicKey:
+			if isPSS && !isPSSKeyScheme(scheme) && canSignPSS(pub, hashFunc) {
+				return scheme, nil
+			}
+		case *rsaPSSPublicKey:
+			if isPSSKeyScheme(scheme) && canSignPSS(pub, hashFunc) {
+				return scheme, nil
+			}
+		case *ecdsa.PublicKey:
+			if curve == pub.Curve {
+				return scheme, nil
+			}
+		case ed25519.PublicKey:
+			if scheme == Ed25519 {
+				return scheme, nil
+			}
+		}
+	}
+	return 0, errors.New("tls: peer doesn't support any of the certificate's signature algorithms")
+}
+
+// signTLS13 creates the signature of a TLS 1.3 CertificateVerify message.
+func signTLS13(rand io.Reader, key crypto.Signer, scheme SignatureScheme, context string, transcript hash.Hash) ([]byte, error) {
+	hashFunc, _, isPSS, ok := signatureSchemeTLS13(scheme)
+	if !ok {
+		return nil, errors.New("tls: unsupported signature algorithm")
+	}
+	digest := signedMessageTLS13(hashFunc, context, transcript)
+	var opts crypto.SignerOpts = hashFunc
+	if isPSS {
+		opts = &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash, Hash: hashFunc}
+	}
+	return key.Sign(rand, digest, opts)
+}
+
+// verifyTLS13 checks the signature of a TLS 1.3 CertificateVerify message.
+func verifyTLS13(pub crypto.PublicKey, scheme SignatureScheme, context string, transcript hash.Hash, sig []byte) error {
+	hashFunc, curve, isPSS, ok := signatureSchemeTLS13(scheme)
+	if !ok {
+		return errors.New("tls: unsupported signature algorithm")
+	}
+	digest := signedMessageTLS13(hashFunc, context, transcript)
+	switch pub := pub.(type) {
+	case *rsa.PublicKey:
+		if !isPSS {
+			return errors.New("tls: RSA signatures must use RSA-PSS in TLS 1.3")
+		}
+		if isPSSKeyScheme(scheme) {
+			return errors.New("tls: signature algorithm is for RSASSA-PSS keys only")
+		}
+		return rsa.VerifyPSS(pub, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case *rsaPSSPublicKey:
+		if !isPSSKeyScheme(scheme) || !pub.allowsHash(hashFunc) {
+			return errors.New("tls: signature algorithm not allowed by the RSASSA-PSS key")
+		}
+		return rsa.VerifyPSS(pub.PublicKey, hashFunc, digest, sig, &rsa.PSSOptions{SaltLength: rsa.PSSSaltLengthEqualsHash})
+	case *ecdsa.PublicKey:
+		if curve != pub.Curve {
+			return errors.New("tls: ECDSA signature algorithm doesn't match the curve of the key")
+		}
+		ecdsaSig := new(ecdsaSignature)
+		if _, err := asn1.Unmarshal(sig, ecdsaSig); err != nil {
+			return err
+		}
+		if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
+			return errors.New("tls: ECDSA signature contained zero or negative values")
+		}
+		if !ecdsa.Verify(pub, digest, ecdsaSig.R, ecdsaSig.S) {
+			return errors.New("tls: ECDSA verification failure")
+		}
+		return nil
+	case ed25519.PublicKey:
+		if scheme != Ed25519 {
+			return errors.New("tls: Ed25519 keys must sign with Ed25519")
+		}
+		if !ed25519.Verify(pub, digest, sig) {
+			return errors.New("tls: Ed25519 verification failure")
+		}
+		return nil
+	}
+	return fmt.Errorf("tls: unsupported public key type %T in TLS 1.3", pub)
+}
+
+// signatureAlgorithmsTLS13 returns the supported signature algorithms that
+// are valid in TLS 1.3, for a CertificateRequest.
+func signatureAlgorithmsTLS13() []signatureAndHash {
+	var sigAndHashes []signatureAndHash
+	for _, list := range [][]signatureAndHash{supportedSignatureAlgorithmsTLS13, supportedSignatureAlgorithms} {
+		for _, sah := range list {
+			scheme := SignatureScheme(sah.hash)<<8 | SignatureScheme(sah.signature)
+			if _, _, _, ok := signatureSchemeTLS13(scheme); ok && !isSupportedSignatureAndHash(sah, sigAndHashes) {
+				sigAndHashes = append(sigAndHashes, sah)
+			}
 		}
 	}
+	return sigAndHashes
+}
 
-	return 0, errors.New("tls: client doesn't support any common hash functions")
+// curveSupportedTLS13 reports whether id is a group that can be used for a
+// TLS 1.3 key share.
+func curveSupportedTLS13(id CurveID) bool {
+	switch id {
+	case X25519, CurveP256, CurveP384, CurveP521:
+		return true
+	}
+	return false
 }
 
 func curveForCurveID(id CurveID) (elliptic.Curve, bool) {
@@ -183,15 +537,23 @@ type ecdheKeyAgreement struct {
 	privateKey []byte
 	curveid    CurveID
 
-	// publicKey is used to store the peer's public value when X25519 is
-	// being used.
+	// publicKey is used to store the peer's public value when X25519 or
+	// X25519MLKEM768 is being used.
 	publicKey []byte
 	// x and y are used to store the peer's public value when one of the
 	// NIST curves is being used.
 	x, y *big.Int
+	// mlkemKey is the server's ML-KEM half of an X25519MLKEM768 key pair.
+	mlkemKey *mlkem768DecapsulationKey
 }
 
-func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+func (ka *ecdheKeyAgreement) curveID() CurveID {
+	return ka.curveid
+}
+
+// generateServerECDHParams picks a curve offered by the client, generates an
+// ephemeral key pair on it and returns the serialised ServerECDHParams.
+func (ka *ecdheKeyAgreement) generateServerECDHParams(config *Config, clientHello *clientHelloMsg) ([]byte, error) {
 	preferredCurves := config.curvePreferences()
 
 NextCandidate:
@@ -208,9 +570,15 @@ NextCandidate:
 		return nil, errors.New("tls: no supported elliptic curves offered")
 	}
 
+	return ka.generateECDHParams(config)
+}
+
+// generateECDHParams generates an ephemeral key pair on the curve in
+// ka.curveid and returns the serialised ServerECDHParams.
+func (ka *ecdheKeyAgreement) generateECDHParams(config *Config) ([]byte, error) {
 	var ecdhePublic []byte
 
-	if ka.curveid == X25519 {
+	if ka.curveid == X25519 || ka.curveid == X25519MLKEM768 {
 		var scalar, public [32]byte
 		if _, err := io.ReadFull(config.rand(), scalar[:]); err != nil {
 			return nil, err
@@ -219,6 +587,14 @@ NextCandidate:
 		curve25519.ScalarBaseMult(&public, &scalar)
 		ka.privateKey = scalar[:]
 		ecdhePublic = public[:]
+
+		if ka.curveid == X25519MLKEM768 {
+			var err error
+			if ka.mlkemKey, err = generateMLKEM768(config.rand()); err != nil {
+				return nil, err
+			}
+			ecdhePublic = append(append([]byte(nil), ka.mlkemKey.encapsulationKey()...), ecdhePublic...)
+		}
 	} else {
 		curve, ok := curveForCurveID(ka.curveid)
 		if !ok {
@@ -235,23 +611,44 @@ NextCandidate:
 	}
 
 	// http://tools.ietf.org/html/rfc4492#section-5.4
-	serverECDHParams := make([]byte, 1+2+1+len(ecdhePublic))
-	serverECDHParams[0] = 3 // named curve
-	serverECDHParams[1] = byte(ka.curveid >> 8)
-	serverECDHParams[2] = byte(ka.curveid)
-	serverECDHParams[3] = byte(len(ecdhePublic))
-	copy(serverECDHParams[4:], ecdhePublic)
+	serverECDHParams := []byte{3, byte(ka.curveid >> 8), byte(ka.curveid)} // named curve
+	return appendECDHPublic(serverECDHParams, ka.curveid, ecdhePublic), nil
+}
 
-	sigAndHash := signatureAndHash{signature: ka.sigType}
+// ecdhPublicLengthSize returns the size of the length prefix of the public
+// values for the curve id. The public values of X25519MLKEM768 are too long
+// for the single byte of RFC 4492, so they take two.
+func ecdhPublicLengthSize(id CurveID) int {
+	if id == X25519MLKEM768 {
+		return 2
+	}
+	return 1
+}
 
-	if ka.version >= VersionTLS12 {
-		var err error
-		if sigAndHash.hash, err = pickTLS12HashForSignature(ka.sigType, clientHello.signatureAndHashes); err != nil {
-			return nil, err
-		}
+// appendECDHPublic appends the public value for the curve id, prefixed with
+// its length, to b.
+func appendECDHPublic(b []byte, id CurveID, public []byte) []byte {
+	if ecdhPublicLengthSize(id) == 2 {
+		b = append(b, byte(len(public)>>8))
 	}
+	b = append(b, byte(len(public)))
+	return append(b, public...)
+}
+
+// parseECDHPublic parses a length-prefixed public value for the curve id at
+// the start of data.
+func parseECDHPublic(data []byte, id CurveID) (public, rest []byte, ok bool) {
+	if ecdhPublicLengthSize(id) == 2 {
+		return parseUint16Chunk(data)
+	}
+	if len(data) < 1 || int(data[0])+1 > len(data) {
+		return nil, nil, false
+	}
+	return data[1 : 1+int(data[0])], data[1+int(data[0]):], true
+}
 
-	digest, hashFunc, err := hashForServerKeyExchange(sigAndHash, ka.version, clientHello.random, hello.random, serverECDHParams)
+func (ka *ecdheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	serverECDHParams, err := ka.generateServerECDHParams(config, clientHello)
 	if err != nil {
 		return nil, err
 	}
@@ -260,22 +657,39 @@ NextCandidate:
 	if !ok {
 		return nil, errors.New("tls: certificate private key does not implement crypto.Signer")
 	}
-	var sig []byte
 	switch ka.sigType {
 	case signatureECDSA:
-		_, ok := priv.Public().(*ecdsa.PublicKey)
-		if !ok {
-			return nil, errors.New("tls: ECDHE ECDSA requires an ECDSA server key")
+		// Ed25519 keys sign for the ECDSA cipher suites, see RFC 8422,
+		// section 5.1.
+		switch priv.Public().(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return nil, errors.New("tls: ECDHE ECDSA requires an ECDSA or Ed25519 server key")
 		}
 	case signatureRSA:
-		_, ok := priv.Public().(*rsa.PublicKey)
-		if !ok {
+		if !isRSAKey(priv.Public()) {
 			return nil, errors.New("tls: ECDHE RSA requires a RSA server key")
 		}
 	default:
 		return nil, errors.New("tls: unknown ECDHE signature algorithm")
 	}
-	sig, err = priv.Sign(config.rand(), digest, hashFunc)
+
+	sigAndHash := signatureAndHash{signature: ka.sigType}
+
+	if ka.version >= VersionTLS12 {
+		if sigAndHash, err = pickTLS12SignatureAlgorithm(config, priv.Public(), clientHello.signatureAndHashes); err != nil {
+			return nil, err
+		}
+	} else if _, ok := priv.Public().(ed25519.PublicKey); ok {
+		return nil, errors.New("tls: Ed25519 server keys require TLS 1.2")
+	}
+
+	digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, hello.random, serverECDHParams)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signHandshake(config.rand(), priv, sigAndHash, hashFunc, digest)
 	if err != nil {
 		return nil, errors.New("tls: failed to sign ECDHE parameters: " + err.Error())
 	}
@@ -301,17 +715,43 @@ NextCandidate:
 }
 
 func (ka *ecdheKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
-	if len(ckx.ciphertext) == 0 || int(ckx.ciphertext[0]) != len(ckx.ciphertext)-1 {
+	clientPublic, rest, ok := parseECDHPublic(ckx.ciphertext, ka.curveid)
+	if !ok || len(clientPublic) == 0 || len(rest) != 0 {
 		return nil, errClientKeyExchange
 	}
 
+	return ka.sharedSecret(clientPublic)
+}
+
+// sharedSecret returns the ECDH shared secret between the server's ephemeral
+// private key and the client's public value. For X25519MLKEM768 the client's
+// public value is an ML-KEM ciphertext followed by an X25519 public value, and
+// the shared secret is the ML-KEM shared key followed by the X25519 one.
+func (ka *ecdheKeyAgreement) sharedSecret(clientPublic []byte) ([]byte, error) {
+	if ka.curveid == X25519MLKEM768 {
+		if len(clientPublic) != mlkem768CiphertextSize+32 {
+			return nil, errClientKeyExchange
+		}
+
+		mlkemShared, err := ka.mlkemKey.decapsulate(clientPublic[:mlkem768CiphertextSize])
+		if err != nil {
+			return nil, errClientKeyExchange
+		}
+
+		var theirPublic, sharedKey, scalar [32]byte
+		copy(theirPublic[:], clientPublic[mlkem768CiphertextSize:])
+		copy(scalar[:], ka.privateKey)
+		curve25519.ScalarMult(&sharedKey, &scalar, &theirPublic)
+		return append(mlkemShared, sharedKey[:]...), nil
+	}
+
 	if ka.curveid == X25519 {
-		if len(ckx.ciphertext) != 1+32 {
+		if len(clientPublic) != 32 {
 			return nil, errClientKeyExchange
 		}
 
 		var theirPublic, sharedKey, scalar [32]byte
-		copy(theirPublic[:], ckx.ciphertext[1:])
+		copy(theirPublic[:], clientPublic)
 		copy(scalar[:], ka.privateKey)
 		curve25519.ScalarMult(&sharedKey, &scalar, &theirPublic)
 		return sharedKey[:], nil
@@ -321,7 +761,7 @@ func (ka *ecdheKeyAgreement) processClientKeyExchange(config *Config, cert *Cert
 	if !ok {
 		panic("internal error")
 	}
-	x, y := elliptic.Unmarshal(curve, ckx.ciphertext[1:])
+	x, y := elliptic.Unmarshal(curve, clientPublic)
 	if x == nil {
 		return nil, errClientKeyExchange
 	}
@@ -336,54 +776,78 @@ func (ka *ecdheKeyAgreement) processClientKeyExchange(config *Config, cert *Cert
 	return preMasterSecret, nil
 }
 
-func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
-	if len(skx.key) < 4 {
-		return errServerKeyExchange
+// processServerECDHParams parses the ServerECDHParams at the start of data and
+// stores the server's public value. It returns the raw params and whatever
+// follows them. The curve must be one that the client offered.
+func (ka *ecdheKeyAgreement) processServerECDHParams(clientHello *clientHelloMsg, data []byte) (params, rest []byte, err error) {
+	if len(data) < 3 {
+		return nil, nil, errServerKeyExchange
 	}
-	if skx.key[0] != 3 { // named curve
-		return errors.New("tls: server selected unsupported curve")
+	if data[0] != 3 { // named curve
+		return nil, nil, errors.New("tls: server selected unsupported curve")
 	}
-	ka.curveid = CurveID(skx.key[1])<<8 | CurveID(skx.key[2])
-
-	publicLen := int(skx.key[3])
-	if publicLen+4 > len(skx.key) {
-		return errServerKeyExchange
+	ka.curveid = CurveID(data[1])<<8 | CurveID(data[2])
+	if !isSupportedCurve(ka.curveid, clientHello.supportedCurves) {
+		return nil, nil, errors.New("tls: server selected unsupported curve")
 	}
-	serverECDHParams := skx.key[:4+publicLen]
-	publicKey := serverECDHParams[4:]
 
-	sig := skx.key[4+publicLen:]
-	if len(sig) < 2 {
-		return errServerKeyExchange
+	publicKey, rest, ok := parseECDHPublic(data[3:], ka.curveid)
+	if !ok || len(publicKey) == 0 {
+		return nil, nil, errServerKeyExchange
 	}
+	params = data[:len(data)-len(rest)]
 
-	if ka.curveid == X25519 {
+	if ka.curveid == X25519MLKEM768 {
+		if len(publicKey) != mlkem768EncapsulationKeySize+32 {
+			return nil, nil, errors.New("tls: bad X25519MLKEM768 public value")
+		}
+		ka.publicKey = publicKey
+	} else if ka.curveid == X25519 {
 		if len(publicKey) != 32 {
-			return errors.New("tls: bad X25519 public value")
+			return nil, nil, errors.New("tls: bad X25519 public value")
 		}
 		ka.publicKey = publicKey
 	} else {
 		curve, ok := curveForCurveID(ka.curveid)
 		if !ok {
-			return errors.New("tls: server selected unsupported curve")
+			return nil, nil, errors.New("tls: server selected unsupported curve")
 		}
 
 		ka.x, ka.y = elliptic.Unmarshal(curve, publicKey)
 		if ka.x == nil {
-			return errServerKeyExchange
+			return nil, nil, errServerKeyExchange
 		}
 		if !curve.IsOnCurve(ka.x, ka.y) {
-			return errServerKeyExchange
+			return nil, nil, errServerKeyExchange
+		}
+	}
+
+	return params, rest, nil
+}
+
+// isSupportedCurve reports whether id is in curves.
+func isSupportedCurve(id CurveID, curves []CurveID) bool {
+	for _, c := range curves {
+		if c == id {
+			return true
 		}
 	}
+	return false
+}
+
+func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	serverECDHParams, sig, err := ka.processServerECDHParams(clientHello, skx.key)
+	if err != nil {
+		return err
+	}
+	if len(sig) < 2 {
+		return errServerKeyExchange
+	}
 
 	sigAndHash := signatureAndHash{signature: ka.sigType}
 	if ka.version >= VersionTLS12 {
 		// handle SignatureAndHashAlgorithm
 		sigAndHash = signatureAndHash{hash: sig[0], signature: sig[1]}
-		if sigAndHash.signature != ka.sigType {
-			return errServerKeyExchange
-		}
 		sig = sig[2:]
 		if len(sig) < 2 {
 			return errServerKeyExchange
@@ -395,39 +859,26 @@ func (ka *ecdheKeyAgreement) processServerKeyExchange(config *Config, clientHell
 	}
 	sig = sig[2:]
 
-	digest, hashFunc, err := hashForServerKeyExchange(sigAndHash, ka.version, clientHello.random, serverHello.random, serverECDHParams)
-	if err != nil {
-		return err
-	}
 	switch ka.sigType {
 	case signatureECDSA:
-		pubKey, ok := cert.PublicKey.(*ecdsa.PublicKey)
-		if !ok {
-			return errors.New("tls: ECDHE ECDSA requires a ECDSA server public key")
-		}
-		ecdsaSig := new(ecdsaSignature)
-		if _, err := asn1.Unmarshal(sig, ecdsaSig); err != nil {
-			return err
-		}
-		if ecdsaSig.R.Sign() <= 0 || ecdsaSig.S.Sign() <= 0 {
-			return errors.New("tls: ECDSA signature contained zero or negative values")
-		}
-		if !ecdsa.Verify(pubKey, digest, ecdsaSig.R, ecdsaSig.S) {
-			return errors.New("tls: ECDSA verification failure")
+		switch cert.PublicKey.(type) {
+		case *ecdsa.PublicKey, ed25519.PublicKey:
+		default:
+			return errors.New("tls: ECDHE ECDSA requires a ECDSA or Ed25519 server public key")
 		}
 	case signatureRSA:
-		pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
-		if !ok {
+		if !isRSAKey(cert.PublicKey) {
 			return errors.New("tls: ECDHE RSA requires a RSA server public key")
 		}
-		if err := rsa.VerifyPKCS1v15(pubKey, hashFunc, digest, sig); err != nil {
-			return err
-		}
 	default:
 		return errors.New("tls: unknown ECDHE signature algorithm")
 	}
 
-	return nil
+	digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, serverHello.random, serverECDHParams)
+	if err != nil {
+		return err
+	}
+	return verifyHandshakeSignature(cert.PublicKey, sigAndHash, hashFunc, digest, sig)
 }
 
 func (ka *ecdheKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
@@ -435,78 +886,281 @@ func (ka *ecdheKeyAgreement) generateClientKeyExchange(config *Config, clientHel
 		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
 	}
 
-	var serialized, preMasterSecret []byte
+	serialized, preMasterSecret, err := ka.generateClientECDH(config)
+	if err != nil {
+		return nil, nil, err
+	}
 
-	if ka.curveid == X25519 {
+	ckx := new(clientKeyExchangeMsg)
+	ckx.ciphertext = appendECDHPublic(nil, ka.curveid, serialized)
+
+	return preMasterSecret, ckx, nil
+}
+
+// generateClientECDH generates the client's ephemeral key pair on the curve
+// selected by the server. It returns the client's public value and the ECDH
+// shared secret. For X25519MLKEM768 it also encapsulates a key to the
+// server's ML-KEM encapsulation key, see sharedSecret.
+func (ka *ecdheKeyAgreement) generateClientECDH(config *Config) (serialized, sharedSecret []byte, err error) {
+	if ka.curveid == X25519 || ka.curveid == X25519MLKEM768 {
 		var ourPublic, theirPublic, sharedKey, scalar [32]byte
 
 		if _, err := io.ReadFull(config.rand(), scalar[:]); err != nil {
 			return nil, nil, err
 		}
 
-		copy(theirPublic[:], ka.publicKey)
+		copy(theirPublic[:], ka.publicKey[len(ka.publicKey)-32:])
 		curve25519.ScalarBaseMult(&ourPublic, &scalar)
 		curve25519.ScalarMult(&sharedKey, &scalar, &theirPublic)
-		serialized = ourPublic[:]
-		preMasterSecret = sharedKey[:]
-	} else {
-		curve, ok := curveForCurveID(ka.curveid)
-		if !ok {
-			panic("internal error")
+		if ka.curveid == X25519 {
+			return ourPublic[:], sharedKey[:], nil
 		}
-		priv, mx, my, err := elliptic.GenerateKey(curve, config.rand())
+
+		ciphertext, mlkemShared, err := mlkem768Encapsulate(config.rand(), ka.publicKey[:mlkem768EncapsulationKeySize])
 		if err != nil {
 			return nil, nil, err
 		}
-		x, _ := curve.ScalarMult(ka.x, ka.y, priv)
-		preMasterSecret = make([]byte, (curve.Params().BitSize+7)>>3)
-		xBytes := x.Bytes()
-		copy(preMasterSecret[len(preMasterSecret)-len(xBytes):], xBytes)
-
-		serialized = elliptic.Marshal(curve, mx, my)
+		return append(ciphertext, ourPublic[:]...), append(mlkemShared, sharedKey[:]...), nil
 	}
 
-	ckx := new(clientKeyExchangeMsg)
-	ckx.ciphertext = make([]byte, 1+len(serialized))
-	ckx.ciphertext[0] = byte(len(serialized))
-	copy(ckx.ciphertext[1:], serialized)
-
-	return preMasterSecret, ckx, nil
-}
-
-// returns chunk, rest, ok
-func parseUint16Chunk(data []byte) ([]byte, []byte, bool) {
-	if len(data) < 2 {
-		return nil, nil, false
+	curve, ok := curveForCurveID(ka.curveid)
+	if !ok {
+		panic("internal error")
 	}
-	length := int(data[0])<<8 | int(data[1])
-	if len(data) < 2+length {
-		return nil, nil, false
+	priv, mx, my, err := elliptic.GenerateKey(curve, config.rand())
+	if err != nil {
+		return nil, nil, err
 	}
-	chunk := data[2 : 2+length]
-	return chunk, data[2+length:], true
-}
+	x, _ := curve.ScalarMult(ka.x, ka.y, priv)
+	sharedSecret = make([]byte, (curve.Params().BitSize+7)>>3)
+	xBytes := x.Bytes()
+	copy(sharedSecret[len(sharedSecret)-len(xBytes):], xBytes)
 
-type pskKeyAgreement struct {
-	identityHint []byte // provided by serrver and stashed by client
+	return elliptic.Marshal(curve, mx, my), sharedSecret, nil
 }
 
-func (ka *pskKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
-	if config.GetPSKIdentityHint == nil {
-		return nil, nil
-	}
+// ecdhAnonKeyAgreement implements the ECDH_anon key agreement from RFC 4492
+// section 2.5. It is ECDHE with the ServerKeyExchange signature omitted, so
+// like DH_anon it provides no authentication.
+type ecdhAnonKeyAgreement struct {
+	ecdheKeyAgreement
+}
 
-	hint, err := config.GetPSKIdentityHint() // TODO what should be args to gethint()?
+func (ka *ecdhAnonKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	serverECDHParams, err := ka.generateServerECDHParams(config, clientHello)
 	if err != nil {
 		return nil, err
 	}
 
-	if hint == nil {
-		return nil, nil
-	}
-
 	skx := new(serverKeyExchangeMsg)
-	skx.key = make([]byte, 2+len(hint))
+	skx.key = serverECDHParams
+
+	return skx, nil
+}
+
+func (ka *ecdhAnonKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	_, rest, err := ka.processServerECDHParams(clientHello, skx.key)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errServerKeyExchange
+	}
+
+	return nil
+}
+
+// sm2KeyAgreement implements the ECC key exchange of TLCP, see tlcp.go. The
+// client encrypts the pre-master secret to the SM2 key of the server's
+// encryption certificate, like rsaKeyAgreement, and the server signs both
+// randoms and that certificate with the key of its signing certificate.
+type sm2KeyAgreement struct {
+	encCert *x509.Certificate // the server's encryption certificate, on the client
+}
+
+func (ka *sm2KeyAgreement) setEncryptionCertificate(cert *x509.Certificate) {
+	ka.encCert = cert
+}
+
+// sm2SignedParams returns the message that the ServerKeyExchange of the
+// TLCP ECC key exchange signs.
+func sm2SignedParams(clientRandom, serverRandom, encCert []byte) []byte {
+	msg := make([]byte, 0, len(clientRandom)+len(serverRandom)+3+len(encCert))
+	msg = append(msg, clientRandom...)
+	msg = append(msg, serverRandom...)
+	msg = append(msg, byte(len(encCert)>>16), byte(len(encCert)>>8), byte(len(encCert)))
+	return append(msg, encCert...)
+}
+
+func (ka *sm2KeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	priv, ok := cert.PrivateKey.(*sm2PrivateKey)
+	if !ok {
+		return nil, errors.New("tls: TLCP requires an SM2 signing key")
+	}
+	encCert := config.TLCPEncryptionCertificate
+	if encCert == nil || len(encCert.Certificate) == 0 {
+		return nil, errors.New("tls: TLCP requires an encryption certificate")
+	}
+
+	sig, err := priv.sign(config.rand(), sm2SignedParams(clientHello.random, hello.random, encCert.Certificate[0]))
+	if err != nil {
+		return nil, errors.New("tls: failed to sign TLCP ServerKeyExchange: " + err.Error())
+	}
+
+	// Unlike TLS 1.2, TLCP doesn't name the signature algorithm.
+	skx := new(serverKeyExchangeMsg)
+	skx.key = make([]byte, 2+len(sig))
+	skx.key[0] = byte(len(sig) >> 8)
+	skx.key[1] = byte(len(sig))
+	copy(skx.key[2:], sig)
+	return skx, nil
+}
+
+func (ka *sm2KeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	ciphertext, rest, ok := parseUint16Chunk(ckx.ciphertext)
+	if !ok || len(rest) != 0 {
+		return nil, errClientKeyExchange
+	}
+	var priv *sm2PrivateKey
+	if encCert := config.TLCPEncryptionCertificate; encCert != nil {
+		priv, _ = encCert.PrivateKey.(*sm2PrivateKey)
+	}
+	if priv == nil {
+		return nil, errors.New("tls: TLCP requires an SM2 encryption key")
+	}
+	// Unlike RSA PKCS#1 v1.5, SM2 ciphertexts carry a hash of the
+	// plaintext, so a failed decryption reveals nothing an attacker
+	// couldn't compute and needn't be hidden.
+	preMasterSecret, err := priv.decrypt(ciphertext)
+	if err != nil {
+		return nil, err
+	}
+	if len(preMasterSecret) != 48 {
+		return nil, errClientKeyExchange
+	}
+	return preMasterSecret, nil
+}
+
+func (ka *sm2KeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	pub, ok := cert.PublicKey.(*sm2PublicKey)
+	if !ok {
+		return errors.New("tls: TLCP requires an SM2 signing certificate")
+	}
+	if ka.encCert == nil {
+		return errors.New("tls: server didn't send a TLCP encryption certificate")
+	}
+	sig, rest, ok := parseUint16Chunk(skx.key)
+	if !ok || len(rest) != 0 {
+		return errServerKeyExchange
+	}
+	return pub.verify(sm2SignedParams(clientHello.random, serverHello.random, ka.encCert.Raw), sig)
+}
+
+func (ka *sm2KeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	if ka.encCert == nil {
+		return nil, nil, errors.New("tls: server didn't send a TLCP encryption certificate")
+	}
+	pub, ok := ka.encCert.PublicKey.(*sm2PublicKey)
+	if !ok {
+		return nil, nil, errors.New("tls: TLCP requires an SM2 encryption certificate")
+	}
+
+	preMasterSecret := make([]byte, 48)
+	preMasterSecret[0] = byte(clientHello.vers >> 8)
+	preMasterSecret[1] = byte(clientHello.vers)
+	_, err := io.ReadFull(config.rand(), preMasterSecret[2:])
+	if err != nil {
+		return nil, nil, err
+	}
+
+	encrypted, err := pub.encrypt(config.rand(), preMasterSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+	ckx := new(clientKeyExchangeMsg)
+	ckx.ciphertext = make([]byte, len(encrypted)+2)
+	ckx.ciphertext[0] = byte(len(encrypted) >> 8)
+	ckx.ciphertext[1] = byte(len(encrypted))
+	copy(ckx.ciphertext[2:], encrypted)
+	return preMasterSecret, ckx, nil
+}
+
+// returns chunk, rest, ok
+func parseUint16Chunk(data []byte) ([]byte, []byte, bool) {
+	if len(data) < 2 {
+		return nil, nil, false
+	}
+	length := int(data[0])<<8 | int(data[1])
+	if len(data) < 2+length {
+		return nil, nil, false
+	}
+	chunk := data[2 : 2+length]
+	return chunk, data[2+length:], true
+}
+
+type pskKeyAgreement struct {
+	identityHint    []byte           // provided by serrver and stashed by client
+	identity        []byte           // identity of the PSK in use, on both sides
+	clientHelloInfo *ClientHelloInfo // passed to the server's PSK callbacks
+	candidates      []PSKCandidate   // server's keys for identity
+	otherSecret     []byte           // server's other_secret of RFC 4279
+	lookupErr       error            // server's error for an identity concealed by a dummy key
+}
+
+func (ka *pskKeyAgreement) pskIdentity() []byte {
+	return ka.identity
+}
+
+func (ka *pskKeyAgreement) setClientHelloInfo(info *ClientHelloInfo) {
+	ka.clientHelloInfo = info
+}
+
+// serverPSK looks up the server's keys for the identity sent by the client
+// and records the identity. The error of the key callback, such as
+// ErrUnknownPSKIdentity, is returned unchanged unless a dummy key conceals
+// it.
+func (ka *pskKeyAgreement) serverPSK(config *Config, identity []byte) error {
+	candidates, lookupErr, err := config.concealedPSKCandidates(ka.clientHelloInfo, string(identity))
+	if err != nil {
+		return err
+	}
+	ka.identity = identity
+	ka.candidates = candidates
+	ka.lookupErr = lookupErr
+	return nil
+}
+
+func (ka *pskKeyAgreement) pskLookupError() error {
+	return ka.lookupErr
+}
+
+// serverPreMasterSecret records the other_secret of the key exchange and
+// returns the pre-master secret that it yields with the first candidate key.
+func (ka *pskKeyAgreement) serverPreMasterSecret(otherSecret []byte) []byte {
+	ka.otherSecret = otherSecret
+	return pskPreMasterSecret(otherSecret, ka.candidates[0].Key)
+}
+
+func (ka *pskKeyAgreement) pskCandidates() ([]PSKCandidate, [][]byte) {
+	preMasterSecrets := make([][]byte, len(ka.candidates))
+	for i, candidate := range ka.candidates {
+		preMasterSecrets[i] = pskPreMasterSecret(ka.otherSecret, candidate.Key)
+	}
+	return ka.candidates, preMasterSecrets
+}
+
+func (ka *pskKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	hint, err := config.serverPSKIdentityHint(ka.clientHelloInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	if hint == nil {
+		return nil, nil
+	}
+
+	skx := new(serverKeyExchangeMsg)
+	skx.key = make([]byte, 2+len(hint))
 	skx.key[0] = byte(len(hint) >> 8)
 	skx.key[1] = byte(len(hint))
 	copy(skx.key[2:], hint)
@@ -515,10 +1169,6 @@ func (ka *pskKeyAgreement) generateServerKeyExchange(config *Config, cert *Certi
 }
 
 func (ka *pskKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
-	if config.GetPSKKey == nil {
-		return nil, errors.New("tls: missing PSK key function")
-	}
-
 	identityBytes, rest, ok := parseUint16Chunk(ckx.ciphertext)
 	if !ok || len(rest) != 0 {
 		return nil, errClientKeyExchange
@@ -529,21 +1179,11 @@ func (ka *pskKeyAgreement) processClientKeyExchange(config *Config, cert *Certif
 		return nil, errors.New("tls: received invalid PSK identity")
 	}
 
-	psk, err := config.GetPSKKey(string(identityBytes))
-	if err != nil {
+	if err := ka.serverPSK(config, identityBytes); err != nil {
 		return nil, err
 	}
-	lenPsk := len(psk)
-	// TODO(movits) here is where you'd alert unknown identity
-
-	preMasterSecret := make([]byte, 2*lenPsk+4) // RFC4279 specifies an null-filled other_secret of the same length as PSK
-	preMasterSecret[0] = byte(lenPsk >> 8)
-	preMasterSecret[1] = byte(lenPsk)
-	preMasterSecret[lenPsk+2] = preMasterSecret[0] // the actual PSK begins here
-	preMasterSecret[lenPsk+3] = preMasterSecret[1]
-	copy(preMasterSecret[lenPsk+4:], psk)
 
-	return preMasterSecret, nil
+	return ka.serverPreMasterSecret(nil), nil
 }
 
 func (ka *pskKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
@@ -572,6 +1212,7 @@ func (ka *pskKeyAgreement) generateClientKeyExchange(config *Config, clientHello
 	if err != nil {
 		return nil, nil, err
 	}
+	ka.identity = []byte(identity)
 	lenPsk := len(psk)
 
 	ckx := new(clientKeyExchangeMsg)
@@ -595,11 +1236,7 @@ type pskRsaKeyAgreement struct {
 }
 
 func (ka *pskRsaKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
-	if config.GetPSKIdentityHint == nil {
-		return nil, nil
-	}
-
-	hint, err := config.GetPSKIdentityHint() // TODO what should be args to gethint()?
+	hint, err := config.serverPSKIdentityHint(ka.clientHelloInfo)
 	if err != nil {
 		return nil, err
 	}
@@ -628,12 +1265,9 @@ func (ka *pskRsaKeyAgreement) processClientKeyExchange(config *Config, cert *Cer
 		return nil, errClientKeyExchange
 	}
 
-	psk, err := config.GetPSKKey(string(identityBytes))
-	if err != nil {
+	if err := ka.serverPSK(config, identityBytes); err != nil {
 		return nil, err
 	}
-	lenPsk := len(psk)
-	// TODO(movits) here is where you'd alert unknown identity
 
 	priv, ok := cert.PrivateKey.(crypto.Decrypter)
 	if !ok {
@@ -645,14 +1279,7 @@ func (ka *pskRsaKeyAgreement) processClientKeyExchange(config *Config, cert *Cer
 		return nil, err
 	}
 
-	preMasterSecret := make([]byte, 2+48+2+lenPsk)
-	preMasterSecret[1] = byte(48)
-	copy(preMasterSecret[2:50], decrypted)
-	preMasterSecret[50] = byte(lenPsk >> 8)
-	preMasterSecret[51] = byte(lenPsk)
-	copy(preMasterSecret[52:], psk)
-
-	return preMasterSecret, nil
+	return ka.serverPreMasterSecret(decrypted), nil
 }
 
 func (ka *pskRsaKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
@@ -681,6 +1308,7 @@ func (ka *pskRsaKeyAgreement) generateClientKeyExchange(config *Config, clientHe
 	if err != nil {
 		return nil, nil, err
 	}
+	ka.identity = []byte(identity)
 	lenPsk := len(psk)
 
 	preMasterSecret := make([]byte, 2+48+2+lenPsk)
@@ -695,7 +1323,11 @@ func (ka *pskRsaKeyAgreement) generateClientKeyExchange(config *Config, clientHe
 	preMasterSecret[51] = byte(lenPsk)
 	copy(preMasterSecret[52:], psk)
 
-	encrypted, err := rsa.EncryptPKCS1v15(config.rand(), cert.PublicKey.(*rsa.PublicKey), preMasterSecret[2:50])
+	pub, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, nil, errors.New("tls: RSA PSK key exchange requires a RSA server public key")
+	}
+	encrypted, err := rsa.EncryptPKCS1v15(config.rand(), pub, preMasterSecret[2:50])
 	if err != nil {
 		return nil, nil, err
 	}
@@ -713,43 +1345,48 @@ func (ka *pskRsaKeyAgreement) generateClientKeyExchange(config *Config, clientHe
 }
 
 type serverDheParams struct {
-	dhp DhParams // Server's dh params
-	Ys  *big.Int // Server's pubkey
+	dhp   DhParams // Server's dh params
+	Ys    *big.Int // Server's pubkey
+	group CurveID  // RFC 7919 group that dhp is, if any
 }
 
-type dheKeyAgreement struct {
-	// stuff stored in ka by client
-	serverDheParams
-	// stuff stored in ka by server
-	x *big.Int // Server's private key
+func (p *serverDheParams) curveID() CurveID {
+	return p.group
 }
 
-func (ka *dheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
-	// Shouldn't possible for a DHE ciphersuite to have been chosen by a server with a nil
-	// DhParameters, but extra care
-	if config.DhParameters == nil {
-		return nil, errors.New("tls: config is missing Diffie-Hellman parameters needed for DHE ciphersuite")
+// generateServerDHParams picks the Diffie-Hellman group to use with the
+// client, creates the server's private key and returns it along with the
+// encoded ServerDHParams.
+func (p *serverDheParams) generateServerDHParams(config *Config, clientHello *clientHelloMsg) (*big.Int, []byte, error) {
+	group, dhp := config.serverDhParams(clientHello)
+	// Shouldn't possible for a DHE ciphersuite to have been chosen by a server without
+	// a group, but extra care
+	if dhp == nil {
+		return nil, nil, errors.New("tls: no Diffie-Hellman group available for DHE ciphersuite")
 	}
+	p.dhp = *dhp
+	p.group = group
 
-	pBytes := config.DhParameters.P.Bytes()
+	pBytes := dhp.P.Bytes()
 	lenPBytes := len(pBytes)
-	gBytes := config.DhParameters.G.Bytes()
+	gBytes := dhp.G.Bytes()
 	lenGBytes := len(gBytes)
 
 	// create a private key based on p and g
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
+	pMinus1 := new(big.Int).Sub(dhp.P, bigOne)
+	var x *big.Int
 	for {
 		var err error
-		if ka.x, err = rand.Int(config.rand(), pMinus1); err != nil {
-			return nil, err
+		if x, err = rand.Int(config.rand(), pMinus1); err != nil {
+			return nil, nil, err
 		}
-		if ka.x.Sign() > 0 {
+		if x.Sign() > 0 {
 			break
 		}
 	}
 
 	// create a public key
-	pubKey := new(big.Int).Exp(config.DhParameters.G, ka.x, config.DhParameters.P)
+	pubKey := new(big.Int).Exp(dhp.G, x, dhp.P)
 	pubKeyBytes := pubKey.Bytes()
 	lenPubKeyBytes := len(pubKeyBytes)
 
@@ -771,6 +1408,48 @@ func (ka *dheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certi
 	serverDHParams[pubKeyLenOffset+1] = byte(lenPubKeyBytes)
 	copy(serverDHParams[pubKeyLenOffset+2:], pubKeyBytes)
 
+	return x, serverDHParams, nil
+}
+
+// processServerDHParams validates the server's dh params and stores them in
+// p. Groups from RFC 7919 that the client offered are recognised as such;
+// any other group must pass the usual checks.
+func (p *serverDheParams) processServerDHParams(clientHello *clientHelloMsg, pBytes, gBytes, pubKeyBytes []byte) error {
+	if len(pBytes) < 128 {
+		return errors.New("tls: DH primes < 1024 bits are not supported")
+	}
+	p.dhp.P = new(big.Int).SetBytes(pBytes)
+	p.dhp.G = new(big.Int).SetBytes(gBytes)
+	p.group = ffdheGroupForParams(clientHello, p.dhp)
+	if p.group == 0 {
+		if err := validateDhParams(p.dhp); err != nil {
+			return err
+		}
+	}
+
+	p.Ys = new(big.Int).SetBytes(pubKeyBytes)
+	// validate that the server's PubKey is non-zero
+	if p.Ys.Cmp(bigZero) == 0 {
+		return errors.New("tls: invalid server DHE public key")
+	}
+
+	return nil
+}
+
+type dheKeyAgreement struct {
+	// stuff stored in ka by client
+	serverDheParams
+	// stuff stored in ka by server
+	x *big.Int // Server's private key
+}
+
+func (ka *dheKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	x, serverDHParams, err := ka.generateServerDHParams(config, clientHello)
+	if err != nil {
+		return nil, err
+	}
+	ka.x = x
+
 	skx := new(serverKeyExchangeMsg)
 	skx.key = make([]byte, len(serverDHParams))
 	copy(skx.key, serverDHParams)
@@ -785,12 +1464,12 @@ func (ka *dheKeyAgreement) processClientKeyExchange(config *Config, cert *Certif
 	}
 	clientPubKey := new(big.Int).SetBytes(clientPubKeyBytes)
 
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
+	pMinus1 := new(big.Int).Sub(ka.dhp.P, bigOne)
 
 	if clientPubKey.Cmp(bigOne) <= 0 || clientPubKey.Cmp(pMinus1) >= 0 {
 		return nil, errors.New("tls: Client DH parameter out of bounds")
 	}
-	preMasterSecret := new(big.Int).Exp(clientPubKey, ka.x, config.DhParameters.P).Bytes()
+	preMasterSecret := new(big.Int).Exp(clientPubKey, ka.x, ka.dhp.P).Bytes()
 
 	return preMasterSecret, nil
 }
@@ -809,24 +1488,7 @@ func (ka *dheKeyAgreement) processServerKeyExchange(config *Config, clientHello
 		return errServerKeyExchange
 	}
 
-	// validate & store server's dh params in ka
-	if len(serverP) < 128 {
-		return errors.New("tls: DH primes < 1024 bits are not supported")
-	}
-	ka.dhp.P = new(big.Int).SetBytes(serverP)
-	ka.dhp.G = new(big.Int).SetBytes(serverG)
-	err := validateDhParams(ka.dhp)
-	if err != nil {
-		return err
-	}
-
-	ka.Ys = new(big.Int).SetBytes(serverPubKey)
-	// validate that the server's PubKey is non-zero
-	if ka.Ys.Cmp(bigZero) == 0 {
-		return errors.New("tls: invalid server DHE public key")
-	}
-
-	return nil
+	return ka.processServerDHParams(clientHello, serverP, serverG, serverPubKey)
 }
 
 func (ka *dheKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
@@ -877,72 +1539,14 @@ var bigZero = big.NewInt(0)
 var bigOne = big.NewInt(1)
 
 func (ka *dheRsaKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
-	// Shouldn't possible for a DHE ciphersuite to have been chosen by a server with a nil
-	// DhParameters, but extra care
-	if config.DhParameters == nil {
-		return nil, errors.New("tls: config is missing Diffie-Hellman parameters needed for DHE ciphersuite")
-	}
-
-	pBytes := config.DhParameters.P.Bytes()
-	lenPBytes := len(pBytes)
-	gBytes := config.DhParameters.G.Bytes()
-	lenGBytes := len(gBytes)
-
-	// create a private key based on p and g
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
-	for {
-		var err error
-		if ka.x, err = rand.Int(config.rand(), pMinus1); err != nil {
-			return nil, err
-		}
-		if ka.x.Sign() > 0 {
-			break
-		}
-	}
-
-	// create a public key
-	pubKey := new(big.Int).Exp(config.DhParameters.G, ka.x, config.DhParameters.P)
-	pubKeyBytes := pubKey.Bytes()
-	lenPubKeyBytes := len(pubKeyBytes)
-
-	lenServerDHParams := 2 + lenPBytes + 2 + lenGBytes + 2 + lenPubKeyBytes
-	serverDHParams := make([]byte, lenServerDHParams)
-
-	// pack the serverDHparams
-	serverDHParams[0] = byte(lenPBytes >> 8)
-	serverDHParams[1] = byte(lenPBytes)
-	copy(serverDHParams[2:], pBytes)
-
-	gLenOffset := 2 + lenPBytes
-	serverDHParams[gLenOffset] = byte(lenGBytes >> 8)
-	serverDHParams[gLenOffset+1] = byte(lenGBytes)
-	copy(serverDHParams[gLenOffset+2:], gBytes)
-
-	pubKeyLenOffset := gLenOffset + 2 + lenGBytes
-	serverDHParams[pubKeyLenOffset] = byte(lenPubKeyBytes >> 8)
-	serverDHParams[pubKeyLenOffset+1] = byte(lenPubKeyBytes)
-	copy(serverDHParams[pubKeyLenOffset+2:], pubKeyBytes)
-
-	//sign the serverDHParams
-	sigAndHash := signatureAndHash{signature: ka.sigType}
-
-	if ka.version >= VersionTLS12 {
-		var err error
-		if sigAndHash.hash, err = pickTLS12HashForSignature(ka.sigType, clientHello.signatureAndHashes); err != nil {
-			return nil, err
-		}
-	}
-
-	digest, hashFunc, err := hashForServerKeyExchange(sigAndHash, ka.version, clientHello.random, hello.random, serverDHParams)
+	x, serverDHParams, err := ka.generateServerDHParams(config, clientHello)
 	if err != nil {
 		return nil, err
 	}
+	ka.x = x
 
-	priv, ok := cert.PrivateKey.(crypto.Signer)
-	if !ok {
-		return nil, errors.New("tls: certificate private key does not implement crypto.Signer")
-	}
-	var sig []byte
+	//sign the serverDHParams
+	var pub crypto.PublicKey
 	switch ka.sigType {
 	/* // Currently there are no ciphersuites implemented in crypto/tls that use DHE and ECDSA
 	   case signatureECDSA:
@@ -952,20 +1556,50 @@ func (ka *dheRsaKeyAgreement) generateServerKeyExchange(config *Config, cert *Ce
 	       }
 	*/
 	case signatureRSA:
-		_, ok := priv.Public().(*rsa.PublicKey)
+		priv, ok := cert.PrivateKey.(crypto.Signer)
 		if !ok {
+			return nil, errors.New("tls: certificate private key does not implement crypto.Signer")
+		}
+		if pub = priv.Public(); !isRSAKey(pub) {
 			return nil, errors.New("tls: DHE RSA requires a RSA server key")
 		}
+	case signatureDSA:
+		// crypto/dsa keys don't implement crypto.Signer
+		priv, ok := cert.PrivateKey.(*dsa.PrivateKey)
+		if !ok {
+			return nil, errors.New("tls: DHE DSS requires a DSA server key")
+		}
+		pub = &priv.PublicKey
 	default:
 		return nil, errors.New("tls: unknown DHE signature algorithm")
 	}
-	sig, err = priv.Sign(config.rand(), digest, hashFunc)
-	if err != nil {
-		return nil, errors.New("tls: failed to sign DHE parameters: " + err.Error())
-	}
 
-	skx := new(serverKeyExchangeMsg)
-	sigAndHashLen := 0
+	sigAndHash := signatureAndHash{signature: ka.sigType}
+
+	if ka.version >= VersionTLS12 {
+		var err error
+		if sigAndHash, err = pickTLS12SignatureAlgorithm(config, pub, clientHello.signatureAndHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, hello.random, serverDHParams)
+	if err != nil {
+		return nil, err
+	}
+
+	var sig []byte
+	if ka.sigType == signatureDSA {
+		sig, err = signDSA(config.rand(), cert.PrivateKey.(*dsa.PrivateKey), digest)
+	} else {
+		sig, err = signHandshake(config.rand(), cert.PrivateKey.(crypto.Signer), sigAndHash, hashFunc, digest)
+	}
+	if err != nil {
+		return nil, errors.New("tls: failed to sign DHE parameters: " + err.Error())
+	}
+
+	skx := new(serverKeyExchangeMsg)
+	sigAndHashLen := 0
 	if ka.version >= VersionTLS12 {
 		sigAndHashLen = 2
 	}
@@ -991,12 +1625,12 @@ func (ka *dheRsaKeyAgreement) processClientKeyExchange(config *Config, cert *Cer
 	}
 	clientPubKey := new(big.Int).SetBytes(clientPubKeyBytes)
 
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
+	pMinus1 := new(big.Int).Sub(ka.dhp.P, bigOne)
 
 	if clientPubKey.Cmp(bigOne) <= 0 || clientPubKey.Cmp(pMinus1) >= 0 {
 		return nil, errors.New("tls: Client DH parameter out of bounds")
 	}
-	preMasterSecret := new(big.Int).Exp(clientPubKey, ka.x, config.DhParameters.P).Bytes()
+	preMasterSecret := new(big.Int).Exp(clientPubKey, ka.x, ka.dhp.P).Bytes()
 
 	return preMasterSecret, nil
 }
@@ -1023,7 +1657,7 @@ func (ka *dheRsaKeyAgreement) processServerKeyExchange(config *Config, clientHel
 	if ka.version >= VersionTLS12 {
 		// handle SignatureAndHashAlgorithm
 		sigAndHash = signatureAndHash{hash: sig[0], signature: sig[1]}
-		if sigAndHash.signature != ka.sigType {
+		if sigType, _, _, _ := signatureAlgorithmTLS12(sigAndHash); suiteSignatureType(sigType) != ka.sigType {
 			return errServerKeyExchange
 		}
 		sig = sig[2:]
@@ -1040,7 +1674,7 @@ func (ka *dheRsaKeyAgreement) processServerKeyExchange(config *Config, clientHel
 	lenServerDHParams := 2 + len(serverP) + 2 + len(serverG) + 2 + len(serverPubKey)
 	serverDHParams := make([]byte, lenServerDHParams)   // we know the exact size in advance
 	copy(serverDHParams, skx.key[:lenServerDHParams+1]) // everything in the skx up until the sig
-	digest, hashFunc, err := hashForServerKeyExchange(sigAndHash, ka.version, clientHello.random, serverHello.random, serverDHParams)
+	digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, serverHello.random, serverDHParams)
 	if err != nil {
 		return err
 	}
@@ -1048,35 +1682,34 @@ func (ka *dheRsaKeyAgreement) processServerKeyExchange(config *Config, clientHel
 	switch ka.sigType {
 	//case signatureECDSA: // Currently there are no ciphersuites implemented in crypto/tls that use DHE and ECDSA
 	case signatureRSA:
-		pubKey, ok := cert.PublicKey.(*rsa.PublicKey)
-		if !ok {
+		if !isRSAKey(cert.PublicKey) {
 			return errors.New("tls: DHE RSA requires a RSA server public key")
 		}
-		if err := rsa.VerifyPKCS1v15(pubKey, hashFunc, digest, sig); err != nil {
+		if err := verifyHandshakeSignature(cert.PublicKey, sigAndHash, hashFunc, digest, sig); err != nil {
 			return err
 		}
+	case signatureDSA:
+		pubKey, ok := cert.PublicKey.(*dsa.PublicKey)
+		if !ok {
+			return errors.New("tls: DHE DSS requires a DSA server public key")
+		}
+		dsaSig := new(dsaSignature)
+		if rest, err := asn1.Unmarshal(sig, dsaSig); err != nil {
+			return err
+		} else if len(rest) != 0 {
+			return errors.New("tls: trailing data after DSA signature")
+		}
+		if dsaSig.R.Sign() <= 0 || dsaSig.S.Sign() <= 0 {
+			return errors.New("tls: DSA signature contained zero or negative values")
+		}
+		if !dsa.Verify(pubKey, truncateDSADigest(digest, pubKey.Q), dsaSig.R, dsaSig.S) {
+			return errors.New("tls: DSA verification failure")
+		}
 	default:
 		return errors.New("tls: unknown DHE signature algorithm")
 	}
 
-	// validate & store server's dh params in ka
-	if len(serverP) < 128 {
-		return errors.New("tls: DH primes < 1024 bits are not supported")
-	}
-	ka.dhp.P = new(big.Int).SetBytes(serverP)
-	ka.dhp.G = new(big.Int).SetBytes(serverG)
-	err = validateDhParams(ka.dhp)
-	if err != nil {
-		return err
-	}
-
-	ka.Ys = new(big.Int).SetBytes(serverPubKey)
-	// validate that the server's PubKey is non-zero
-	if ka.Ys.Cmp(bigZero) == 0 {
-		return errors.New("tls: invalid server DHE public key")
-	}
-
-	return nil
+	return ka.processServerDHParams(clientHello, serverP, serverG, serverPubKey)
 }
 
 func (ka *dheRsaKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
@@ -1125,66 +1758,19 @@ type dhePskKeyAgreement struct {
 }
 
 func (ka *dhePskKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
-	// Shouldn't possible for a DHE ciphersuite to have been chosen by a server with a nil
-	// DhParameters, but extra care
-	if config.DhParameters == nil {
-		return nil, errors.New("tls: config is missing Diffie-Hellman parameters needed for DHE ciphersuite")
-	}
-
-	var hint []byte
-	if config.GetPSKIdentityHint == nil {
-		hint = []byte{}
-	} else {
-		hint, err := config.GetPSKIdentityHint() // TODO what should be args to gethint()?
-		if err != nil {
-			return nil, err
-		}
-		if hint == nil {
-			hint = []byte{}
-		}
+	hint, err := config.serverPSKIdentityHint(ka.clientHelloInfo)
+	if err != nil {
+		return nil, err
 	}
 
-	pBytes := config.DhParameters.P.Bytes()
-	lenPBytes := len(pBytes)
-	gBytes := config.DhParameters.G.Bytes()
-	lenGBytes := len(gBytes)
-
-	// create a private key based on p and g
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
-	for {
-		var err error
-		if ka.x, err = rand.Int(config.rand(), pMinus1); err != nil {
-			return nil, err
-		}
-		if ka.x.Sign() > 0 {
-			break
-		}
+	x, serverDHParams, err := ka.generateServerDHParams(config, clientHello)
+	if err != nil {
+		return nil, err
 	}
-
-	// create a public key
-	pubKey := new(big.Int).Exp(config.DhParameters.G, ka.x, config.DhParameters.P)
-	pubKeyBytes := pubKey.Bytes()
-	lenPubKeyBytes := len(pubKeyBytes)
-
-	lenServerDHParams := 2 + lenPBytes + 2 + lenGBytes + 2 + lenPubKeyBytes
-	serverDHParams := make([]byte, lenServerDHParams)
-
-	serverDHParams[0] = byte(lenPBytes >> 8)
-	serverDHParams[1] = byte(lenPBytes)
-	copy(serverDHParams[2:], pBytes)
-
-	gLenOffset := 2 + lenPBytes
-	serverDHParams[gLenOffset] = byte(lenGBytes >> 8)
-	serverDHParams[gLenOffset+1] = byte(lenGBytes)
-	copy(serverDHParams[gLenOffset+2:], gBytes)
-
-	pubKeyLenOffset := gLenOffset + 2 + lenGBytes
-	serverDHParams[pubKeyLenOffset] = byte(lenPubKeyBytes >> 8)
-	serverDHParams[pubKeyLenOffset+1] = byte(lenPubKeyBytes)
-	copy(serverDHParams[pubKeyLenOffset+2:], pubKeyBytes)
+	ka.x = x
 
 	skx := new(serverKeyExchangeMsg)
-	skx.key = make([]byte, 2+len(hint)+lenServerDHParams)
+	skx.key = make([]byte, 2+len(hint)+len(serverDHParams))
 	skx.key[0] = byte(len(hint) >> 8)
 	skx.key[1] = byte(len(hint))
 	copy(skx.key[2:], hint)
@@ -1194,10 +1780,6 @@ func (ka *dhePskKeyAgreement) generateServerKeyExchange(config *Config, cert *Ce
 }
 
 func (ka *dhePskKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
-	if config.GetPSKKey == nil {
-		return nil, errors.New("tls: missing PSK key function")
-	}
-
 	identityBytes, rest, ok := parseUint16Chunk(ckx.ciphertext)
 	if !ok {
 		return nil, errClientKeyExchange
@@ -1207,12 +1789,9 @@ func (ka *dhePskKeyAgreement) processClientKeyExchange(config *Config, cert *Cer
 		return nil, errors.New("tls: received invalid PSK identity")
 	}
 
-	psk, err := config.GetPSKKey(string(identityBytes))
-	if err != nil {
+	if err := ka.serverPSK(config, identityBytes); err != nil {
 		return nil, err
 	}
-	lenPsk := len(psk)
-	// TODO(movits) here is where you'd alert unknown identity
 
 	clientPubKeyBytes, rest, ok := parseUint16Chunk(rest)
 	if !ok || len(rest) != 0 {
@@ -1221,23 +1800,14 @@ func (ka *dhePskKeyAgreement) processClientKeyExchange(config *Config, cert *Cer
 
 	clientPubKey := new(big.Int).SetBytes(clientPubKeyBytes)
 
-	pMinus1 := new(big.Int).Sub(config.DhParameters.P, bigOne)
+	pMinus1 := new(big.Int).Sub(ka.dhp.P, bigOne)
 
 	if clientPubKey.Cmp(bigOne) <= 0 || clientPubKey.Cmp(pMinus1) >= 0 {
 		return nil, errors.New("tls: Client DH parameter out of bounds")
 	}
-	ZBytes := new(big.Int).Exp(clientPubKey, ka.x, config.DhParameters.P).Bytes()
-	lenZBytes := len(ZBytes)
+	ZBytes := new(big.Int).Exp(clientPubKey, ka.x, ka.dhp.P).Bytes()
 
-	preMasterSecret := make([]byte, 2+lenZBytes+2+lenPsk)
-	preMasterSecret[0] = byte(lenZBytes >> 8)
-	preMasterSecret[1] = byte(lenZBytes)
-	copy(preMasterSecret[2:], ZBytes)
-	preMasterSecret[lenZBytes+2] = byte(lenPsk >> 8)
-	preMasterSecret[lenZBytes+3] = byte(lenPsk)
-	copy(preMasterSecret[lenZBytes+4:], psk)
-
-	return preMasterSecret, nil
+	return ka.serverPreMasterSecret(ZBytes), nil
 }
 
 func (ka *dhePskKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
@@ -1261,24 +1831,7 @@ func (ka *dhePskKeyAgreement) processServerKeyExchange(config *Config, clientHel
 		return errServerKeyExchange
 	}
 
-	// validate and store server's dh params in ka
-	if len(pBytes) < 128 {
-		return errors.New("tls: DH primes < 1024 bits are not supported")
-	}
-	ka.dhp.P = new(big.Int).SetBytes(pBytes)
-	ka.dhp.G = new(big.Int).SetBytes(gBytes)
-	err := validateDhParams(ka.dhp)
-	if err != nil {
-		return err
-	}
-
-	ka.Ys = new(big.Int).SetBytes(pubKeyBytes)
-	// validate that the server's PubKey is non-zero
-	if ka.Ys.Cmp(bigZero) == 0 {
-		return errors.New("tls: invalid server DHE public key")
-	}
-
-	return nil
+	return ka.processServerDHParams(clientHello, pBytes, gBytes, pubKeyBytes)
 }
 
 func (ka *dhePskKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
@@ -1295,6 +1848,7 @@ func (ka *dhePskKeyAgreement) generateClientKeyExchange(config *Config, clientHe
 	if err != nil {
 		return nil, nil, err
 	}
+	ka.identity = []byte(identity)
 	lenPsk := len(psk)
 
 	pMinus1 := new(big.Int).Sub(ka.dhp.P, bigOne)
@@ -1344,3 +1898,414 @@ func (ka *dhePskKeyAgreement) generateClientKeyExchange(config *Config, clientHe
 
 	return preMasterSecret, ckx, nil
 }
+
+// ecdhePskKeyAgreement implements the ECDHE_PSK key agreement from RFC 5489.
+// The server sends an optional identity hint followed by ephemeral, unsigned
+// ECDH parameters, and the pre-master secret combines the ECDH shared secret
+// with the PSK.
+type ecdhePskKeyAgreement struct {
+	pskKeyAgreement
+	ecdheKeyAgreement
+}
+
+func (ka *ecdhePskKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	hint, err := config.serverPSKIdentityHint(ka.clientHelloInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	serverECDHParams, err := ka.generateServerECDHParams(config, clientHello)
+	if err != nil {
+		return nil, err
+	}
+
+	// RFC 5489 2: the hint is always present, even if it is empty.
+	skx := new(serverKeyExchangeMsg)
+	skx.key = make([]byte, 2+len(hint)+len(serverECDHParams))
+	skx.key[0] = byte(len(hint) >> 8)
+	skx.key[1] = byte(len(hint))
+	copy(skx.key[2:], hint)
+	copy(skx.key[2+len(hint):], serverECDHParams)
+
+	return skx, nil
+}
+
+func (ka *ecdhePskKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	identityBytes, rest, ok := parseUint16Chunk(ckx.ciphertext)
+	if !ok {
+		return nil, errClientKeyExchange
+	}
+	// RFC 4279 5.1 says it MUST be utf8
+	if !utf8.Valid(identityBytes) {
+		return nil, errors.New("tls: received invalid PSK identity")
+	}
+	clientPublic, rest, ok := parseECDHPublic(rest, ka.curveid)
+	if !ok || len(clientPublic) == 0 || len(rest) != 0 {
+		return nil, errClientKeyExchange
+	}
+
+	if err := ka.serverPSK(config, identityBytes); err != nil {
+		return nil, err
+	}
+
+	z, err := ka.sharedSecret(clientPublic)
+	if err != nil {
+		return nil, err
+	}
+
+	return ka.serverPreMasterSecret(z), nil
+}
+
+func (ka *ecdhePskKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	// per RFC 4279 server can send a "identity hint", so stash it in the ka
+	hint, rest, ok := parseUint16Chunk(skx.key)
+	if !ok {
+		return errServerKeyExchange
+	}
+	ka.identityHint = hint
+
+	_, rest, err := ka.processServerECDHParams(clientHello, rest)
+	if err != nil {
+		return err
+	}
+	if len(rest) != 0 {
+		return errServerKeyExchange
+	}
+
+	return nil
+}
+
+func (ka *ecdhePskKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	if ka.curveid == 0 {
+		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
+	}
+	if config.GetPSKIdentity == nil || config.GetPSKKey == nil {
+		return nil, nil, errors.New("tls: missing psk functions in config")
+	}
+
+	identity, err := config.GetPSKIdentity(ka.identityHint)
+	if err != nil {
+		return nil, nil, err
+	}
+	lenIdentity := len(identity)
+
+	psk, err := config.GetPSKKey(identity)
+	if err != nil {
+		return nil, nil, err
+	}
+	ka.identity = []byte(identity)
+
+	serialized, z, err := ka.generateClientECDH(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	ckx := new(clientKeyExchangeMsg)
+	ckx.ciphertext = make([]byte, 2+lenIdentity, 2+lenIdentity+2+len(serialized))
+	ckx.ciphertext[0] = byte(lenIdentity >> 8)
+	ckx.ciphertext[1] = byte(lenIdentity)
+	copy(ckx.ciphertext[2:], identity)
+	ckx.ciphertext = appendECDHPublic(ckx.ciphertext, ka.curveid, serialized)
+
+	return pskPreMasterSecret(z, psk), ckx, nil
+}
+
+// pskPreMasterSecret builds the pre-master secret described in RFC 4279 2
+// and RFC 5489 2: the other_secret of the key exchange and the PSK, each
+// prefixed with a uint16 length. A nil other_secret stands for the zeros of
+// the length of the PSK that the plain PSK key exchange uses.
+func pskPreMasterSecret(otherSecret, psk []byte) []byte {
+	if otherSecret == nil {
+		otherSecret = make([]byte, len(psk))
+	}
+	preMasterSecret := make([]byte, 2+len(otherSecret)+2+len(psk))
+	preMasterSecret[0] = byte(len(otherSecret) >> 8)
+	preMasterSecret[1] = byte(len(otherSecret))
+	copy(preMasterSecret[2:], otherSecret)
+	preMasterSecret[2+len(otherSecret)] = byte(len(psk) >> 8)
+	preMasterSecret[3+len(otherSecret)] = byte(len(psk))
+	copy(preMasterSecret[4+len(otherSecret):], psk)
+	return preMasterSecret
+}
+
+// errUnknownSRPUsername is returned by a server for an SRP username that
+// GetSRPVerifier doesn't know. It is answered with an unknown_psk_identity
+// alert, see RFC 5054, section 2.9.
+var errUnknownSRPUsername = errors.New("tls: unknown SRP username")
+
+// srpKeyAgreement implements the SRP key agreement from RFC 5054. The server
+// sends the group, the salt and its public value B, optionally signed with its
+// RSA certificate, and the client answers with its public value A.
+type srpKeyAgreement struct {
+	version uint16
+	sigType uint8 // zero for the unauthenticated SRP_SHA suites
+
+	group *SRPGroup
+	salt  []byte
+	B     *big.Int
+	// stuff stored in ka by server
+	v *big.Int // verifier
+	b *big.Int // server's private key
+}
+
+func (ka *srpKeyAgreement) generateServerKeyExchange(config *Config, cert *Certificate, clientHello *clientHelloMsg, hello *serverHelloMsg) (*serverKeyExchangeMsg, error) {
+	if config.GetSRPVerifier == nil {
+		return nil, errors.New("tls: missing SRP verifier function")
+	}
+	if len(clientHello.srpUsername) == 0 {
+		return nil, errors.New("tls: client did not send an SRP username")
+	}
+
+	verifier, err := config.GetSRPVerifier(clientHello.srpUsername)
+	if err != nil {
+		return nil, err
+	}
+	if verifier == nil {
+		if config.SRPDummySecret == nil {
+			return nil, errUnknownSRPUsername
+		}
+		if verifier, err = config.dummySRPVerifier(clientHello.srpUsername); err != nil {
+			return nil, err
+		}
+	}
+	if verifier.Group == nil || verifier.Verifier == nil {
+		return nil, errors.New("tls: invalid SRP verifier")
+	}
+	if len(verifier.Salt) == 0 || len(verifier.Salt) > 255 {
+		return nil, errors.New("tls: invalid SRP salt")
+	}
+	ka.group = verifier.Group
+	ka.salt = verifier.Salt
+	ka.v = verifier.Verifier
+
+	// RFC 5054 2.5.3 recommends a private value of at least 256 bits
+	bBytes := make([]byte, 32)
+	if _, err := io.ReadFull(config.rand(), bBytes); err != nil {
+		return nil, err
+	}
+	ka.b = new(big.Int).SetBytes(bBytes)
+
+	// B = k*v + g^b % N
+	ka.B = new(big.Int).Mul(srpMultiplier(ka.group), ka.v)
+	ka.B.Add(ka.B, new(big.Int).Exp(ka.group.G, ka.b, ka.group.N))
+	ka.B.Mod(ka.B, ka.group.N)
+
+	nBytes := ka.group.N.Bytes()
+	gBytes := ka.group.G.Bytes()
+	BBytes := ka.B.Bytes()
+
+	serverSRPParams := make([]byte, 2+len(nBytes)+2+len(gBytes)+1+len(ka.salt)+2+len(BBytes))
+	k := serverSRPParams
+	k[0] = byte(len(nBytes) >> 8)
+	k[1] = byte(len(nBytes))
+	copy(k[2:], nBytes)
+	k = k[2+len(nBytes):]
+	k[0] = byte(len(gBytes) >> 8)
+	k[1] = byte(len(gBytes))
+	copy(k[2:], gBytes)
+	k = k[2+len(gBytes):]
+	k[0] = byte(len(ka.salt))
+	copy(k[1:], ka.salt)
+	k = k[1+len(ka.salt):]
+	k[0] = byte(len(BBytes) >> 8)
+	k[1] = byte(len(BBytes))
+	copy(k[2:], BBytes)
+
+	skx := new(serverKeyExchangeMsg)
+	if ka.sigType == 0 {
+		skx.key = serverSRPParams
+		return skx, nil
+	}
+
+	// sign the serverSRPParams
+	priv, ok := cert.PrivateKey.(crypto.Signer)
+	if !ok {
+		return nil, errors.New("tls: certificate private key does not implement crypto.Signer")
+	}
+	switch ka.sigType {
+	case signatureRSA:
+		if !isRSAKey(priv.Public()) {
+			return nil, errors.New("tls: SRP RSA requires a RSA server key")
+		}
+	default:
+		return nil, errors.New("tls: unknown SRP signature algorithm")
+	}
+
+	sigAndHash := signatureAndHash{signature: ka.sigType}
+
+	if ka.version >= VersionTLS12 {
+		if sigAndHash, err = pickTLS12SignatureAlgorithm(config, priv.Public(), clientHello.signatureAndHashes); err != nil {
+			return nil, err
+		}
+	}
+
+	digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, hello.random, serverSRPParams)
+	if err != nil {
+		return nil, err
+	}
+
+	sig, err := signHandshake(config.rand(), priv, sigAndHash, hashFunc, digest)
+	if err != nil {
+		return nil, errors.New("tls: failed to sign SRP parameters: " + err.Error())
+	}
+
+	sigAndHashLen := 0
+	if ka.version >= VersionTLS12 {
+		sigAndHashLen = 2
+	}
+	skx.key = make([]byte, len(serverSRPParams)+sigAndHashLen+2+len(sig))
+	copy(skx.key, serverSRPParams)
+	k = skx.key[len(serverSRPParams):]
+	if ka.version >= VersionTLS12 {
+		k[0] = sigAndHash.hash
+		k[1] = sigAndHash.signature
+		k = k[2:]
+	}
+	k[0] = byte(len(sig) >> 8)
+	k[1] = byte(len(sig))
+	copy(k[2:], sig)
+
+	return skx, nil
+}
+
+func (ka *srpKeyAgreement) processClientKeyExchange(config *Config, cert *Certificate, ckx *clientKeyExchangeMsg, version uint16) ([]byte, error) {
+	ABytes, rest, ok := parseUint16Chunk(ckx.ciphertext)
+	if !ok || len(rest) != 0 {
+		return nil, errClientKeyExchange
+	}
+
+	A := new(big.Int).SetBytes(ABytes)
+	// RFC 5054 2.5.4: abort if A % N is zero
+	if new(big.Int).Mod(A, ka.group.N).Sign() == 0 {
+		return nil, errors.New("tls: invalid SRP client public value")
+	}
+
+	// S = (A * v^u) ^ b % N
+	u := srpScrambler(ka.group, A, ka.B)
+	S := new(big.Int).Exp(ka.v, u, ka.group.N)
+	S.Mul(S, A)
+	S.Exp(S, ka.b, ka.group.N)
+
+	return S.Bytes(), nil
+}
+
+func (ka *srpKeyAgreement) processServerKeyExchange(config *Config, clientHello *clientHelloMsg, serverHello *serverHelloMsg, cert *x509.Certificate, skx *serverKeyExchangeMsg) error {
+	nBytes, rest, ok := parseUint16Chunk(skx.key)
+	if !ok {
+		return errServerKeyExchange
+	}
+	gBytes, rest, ok := parseUint16Chunk(rest)
+	if !ok {
+		return errServerKeyExchange
+	}
+	if len(rest) < 1 || len(rest) < 1+int(rest[0]) {
+		return errServerKeyExchange
+	}
+	salt := rest[1 : 1+int(rest[0])]
+	BBytes, sig, ok := parseUint16Chunk(rest[1+len(salt):])
+	if !ok {
+		return errServerKeyExchange
+	}
+	serverSRPParams := skx.key[:len(skx.key)-len(sig)]
+
+	if ka.sigType == 0 {
+		if len(sig) != 0 {
+			return errServerKeyExchange
+		}
+	} else {
+		if len(sig) < 2 {
+			return errServerKeyExchange
+		}
+
+		sigAndHash := signatureAndHash{signature: ka.sigType}
+		if ka.version >= VersionTLS12 {
+			// handle SignatureAndHashAlgorithm
+			sigAndHash = signatureAndHash{hash: sig[0], signature: sig[1]}
+			if sigType, _, _, _ := signatureAlgorithmTLS12(sigAndHash); suiteSignatureType(sigType) != ka.sigType {
+				return errServerKeyExchange
+			}
+			sig = sig[2:]
+		}
+
+		sig, rest, ok = parseUint16Chunk(sig)
+		if !ok || len(rest) != 0 {
+			return errServerKeyExchange
+		}
+
+		digest, hashFunc, err := hashForServerKeyExchange(config, sigAndHash, ka.version, clientHello.random, serverHello.random, serverSRPParams)
+		if err != nil {
+			return err
+		}
+
+		switch ka.sigType {
+		case signatureRSA:
+			if !isRSAKey(cert.PublicKey) {
+				return errors.New("tls: SRP RSA requires a RSA server public key")
+			}
+			if err := verifyHandshakeSignature(cert.PublicKey, sigAndHash, hashFunc, digest, sig); err != nil {
+				return err
+			}
+		default:
+			return errors.New("tls: unknown SRP signature algorithm")
+		}
+	}
+
+	// RFC 5054 2.5.3: only accept groups that are known to be safe
+	ka.group = srpKnownGroup(new(big.Int).SetBytes(nBytes), new(big.Int).SetBytes(gBytes))
+	if ka.group == nil {
+		return errors.New("tls: server sent unknown SRP group")
+	}
+	ka.salt = salt
+	ka.B = new(big.Int).SetBytes(BBytes)
+	// RFC 5054 2.5.4: abort if B % N is zero
+	if new(big.Int).Mod(ka.B, ka.group.N).Sign() == 0 {
+		return errors.New("tls: invalid SRP server public value")
+	}
+
+	return nil
+}
+
+func (ka *srpKeyAgreement) generateClientKeyExchange(config *Config, clientHello *clientHelloMsg, cert *x509.Certificate) ([]byte, *clientKeyExchangeMsg, error) {
+	if ka.group == nil {
+		return nil, nil, errors.New("tls: missing ServerKeyExchange message")
+	}
+	if config.GetSRPPassword == nil {
+		return nil, nil, errors.New("tls: missing SRP password function")
+	}
+
+	password, err := config.GetSRPPassword(clientHello.srpUsername)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	aBytes := make([]byte, 32)
+	if _, err := io.ReadFull(config.rand(), aBytes); err != nil {
+		return nil, nil, err
+	}
+	a := new(big.Int).SetBytes(aBytes)
+	A := new(big.Int).Exp(ka.group.G, a, ka.group.N)
+
+	u := srpScrambler(ka.group, A, ka.B)
+	if u.Sign() == 0 {
+		return nil, nil, errors.New("tls: invalid SRP server public value")
+	}
+	x := srpPrivateKey(ka.salt, clientHello.srpUsername, password)
+
+	// S = (B - (k * g^x)) ^ (a + (u * x)) % N
+	base := new(big.Int).Exp(ka.group.G, x, ka.group.N)
+	base.Mul(base, srpMultiplier(ka.group))
+	base.Sub(ka.B, base)
+	base.Mod(base, ka.group.N)
+	exp := new(big.Int).Mul(u, x)
+	exp.Add(exp, a)
+	S := new(big.Int).Exp(base, exp, ka.group.N)
+
+	ABytes := A.Bytes()
+	ckx := new(clientKeyExchangeMsg)
+	ckx.ciphertext = make([]byte, 2+len(ABytes))
+	ckx.ciphertext[0] = byte(len(ABytes) >> 8)
+	ckx.ciphertext[1] = byte(len(ABytes))
+	copy(ckx.ciphertext[2:], ABytes)
+
+	return S.Bytes(), ckx, nil
+}
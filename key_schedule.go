@@ -0,0 +1,140 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/hmac"
+	"hash"
+)
+
+// This file contains the TLS 1.3 key schedule, see RFC 8446, section 7.1.
+// It takes the place of the PRF in prf.go.
+
+const (
+	resumptionBinderLabel         = "res binder"
+	externalBinderLabel           = "ext binder"
+	clientHandshakeTrafficLabel   = "c hs traffic"
+	serverHandshakeTrafficLabel   = "s hs traffic"
+	clientApplicationTrafficLabel = "c ap traffic"
+	serverApplicationTrafficLabel = "s ap traffic"
+	resumptionLabel               = "res master"
+	trafficUpdateLabel            = "traffic upd"
+)
+
+// hkdfExtract implements HKDF-Extract, as defined in RFC 5869, section 2.2.
+func hkdfExtract(hash func() hash.Hash, secret, salt []byte) []byte {
+	if salt == nil {
+		salt = make([]byte, hash().Size())
+	}
+	extractor := hmac.New(hash, salt)
+	extractor.Write(secret)
+	return extractor.Sum(nil)
+}
+
+// hkdfExpand implements HKDF-Expand, as defined in RFC 5869, section 2.3.
+func hkdfExpand(hash func() hash.Hash, pseudorandomKey, info []byte, length int) []byte {
+	expander := hmac.New(hash, pseudorandomKey)
+	var counter [1]byte
+	var prev []byte
+	out := make([]byte, 0, length)
+	for len(out) < length {
+		counter[0]++
+		expander.Reset()
+		expander.Write(prev)
+		expander.Write(info)
+		expander.Write(counter[:])
+		prev = expander.Sum(prev[:0])
+		out = append(out, prev...)
+	}
+	return out[:length]
+}
+
+// expandLabel implements HKDF-Expand-Label from RFC 8446, section 7.1.
+func (c *cipherSuiteTLS13) expandLabel(secret []byte, label string, context []byte, length int) []byte {
+	label = "tls13 " + label
+	hkdfLabel := make([]byte, 0, 2+1+len(label)+1+len(context))
+	hkdfLabel = append(hkdfLabel, byte(length>>8), byte(length))
+	hkdfLabel = append(hkdfLabel, byte(len(label)))
+	hkdfLabel = append(hkdfLabel, label...)
+	hkdfLabel = append(hkdfLabel, byte(len(context)))
+	hkdfLabel = append(hkdfLabel, context...)
+	return hkdfExpand(c.hash.New, secret, hkdfLabel, length)
+}
+
+// deriveSecret implements Derive-Secret from RFC 8446, section 7.1. A nil
+// transcript stands for the empty handshake transcript.
+func (c *cipherSuiteTLS13) deriveSecret(secret []byte, label string, transcript hash.Hash) []byte {
+	if transcript == nil {
+		transcript = c.hash.New()
+	}
+	return c.expandLabel(secret, label, transcript.Sum(nil), c.hash.Size())
+}
+
+// extract implements HKDF-Extract with the cipher suite hash. A nil newSecret
+// stands for a string of zeros, as used when there is no PSK or no (EC)DHE.
+func (c *cipherSuiteTLS13) extract(newSecret, currentSecret []byte) []byte {
+	if newSecret == nil {
+		newSecret = make([]byte, c.hash.Size())
+	}
+	return hkdfExtract(c.hash.New, newSecret, currentSecret)
+}
+
+// earlySecret returns the Early Secret for the given PSK, which is nil if
+// there isn't one.
+func (c *cipherSuiteTLS13) earlySecret(psk []byte) []byte {
+	return c.extract(psk, nil)
+}
+
+// handshakeSecret returns the Handshake Secret that follows earlySecret,
+// given the (EC)DHE shared secret, which is nil if there isn't one.
+func (c *cipherSuiteTLS13) handshakeSecret(earlySecret, sharedSecret []byte) []byte {
+	return c.extract(sharedSecret, c.deriveSecret(earlySecret, "derived", nil))
+}
+
+// masterSecret returns the Master Secret that follows handshakeSecret.
+func (c *cipherSuiteTLS13) masterSecret(handshakeSecret []byte) []byte {
+	return c.extract(nil, c.deriveSecret(handshakeSecret, "derived", nil))
+}
+
+// nextTrafficSecret generates the next traffic secret, given the current one,
+// according to RFC 8446, section 7.2.
+func (c *cipherSuiteTLS13) nextTrafficSecret(trafficSecret []byte) []byte {
+	return c.expandLabel(trafficSecret, trafficUpdateLabel, nil, c.hash.Size())
+}
+
+// trafficKey generates traffic keys according to RFC 8446, section 7.3.
+func (c *cipherSuiteTLS13) trafficKey(trafficSecret []byte) (key, iv []byte) {
+	key = c.expandLabel(trafficSecret, "key", nil, c.keyLen)
+	iv = c.expandLabel(trafficSecret, "iv", nil, 12)
+	return
+}
+
+// finishedHash generates the Finished verify_data or PskBinderEntry according
+// to RFC 8446, section 4.4.4. See sections 4.4 and 4.2.11.2 for the baseKey
+// selection.
+func (c *cipherSuiteTLS13) finishedHash(baseKey []byte, transcript hash.Hash) []byte {
+	finishedKey := c.expandLabel(baseKey, "finished", nil, c.hash.Size())
+	verifyData := hmac.New(c.hash.New, finishedKey)
+	verifyData.Write(transcript.Sum(nil))
+	return verifyData.Sum(nil)
+}
+
+// resumptionPSK derives the PSK of a session ticket from the resumption
+// master secret and the ticket nonce, see RFC 8446, section 4.6.1.
+func (c *cipherSuiteTLS13) resumptionPSK(resumptionSecret, nonce []byte) []byte {
+	return c.expandLabel(resumptionSecret, "resumption", nonce, c.hash.Size())
+}
+
+// binder returns the PSK binder for psk, which is an external PSK unless
+// isResumption is true, over the partial ClientHello in transcript. See RFC
+// 8446, section 4.2.11.2.
+func (c *cipherSuiteTLS13) binder(psk []byte, isResumption bool, transcript hash.Hash) []byte {
+	label := externalBinderLabel
+	if isResumption {
+		label = resumptionBinderLabel
+	}
+	binderKey := c.deriveSecret(c.earlySecret(psk), label, nil)
+	return c.finishedHash(binderKey, transcript)
+}
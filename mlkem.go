@@ -0,0 +1,417 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/subtle"
+	"errors"
+	"io"
+)
+
+// This file implements ML-KEM-768, the module-lattice-based key encapsulation
+// mechanism of FIPS 203, which is the post-quantum half of the
+// X25519MLKEM768 hybrid group.
+
+const (
+	mlkemN = 256
+	mlkemQ = 3329
+
+	// The ML-KEM-768 parameter set.
+	mlkemK    = 3
+	mlkemEta1 = 2
+	mlkemEta2 = 2
+	mlkemDu   = 10
+	mlkemDv   = 4
+
+	mlkemSeedSize                = 64
+	mlkemSharedKeySize           = 32
+	mlkem768EncapsulationKeySize = 384*mlkemK + 32
+	mlkem768CiphertextSize       = 32 * (mlkemDu*mlkemK + mlkemDv)
+)
+
+// mlkemZetas holds 17^BitRev7(i) mod q, the twiddle factors of the NTT.
+var mlkemZetas = [128]uint16{
+	1, 1729, 2580, 3289, 2642, 630, 1897, 848, 1062, 1919, 193, 797, 2786, 3260, 569, 1746,
+	296, 2447, 1339, 1476, 3046, 56, 2240, 1333, 1426, 2094, 535, 2882, 2393, 2879, 1974, 821,
+	289, 331, 3253, 1756, 1197, 2304, 2277, 2055, 650, 1977, 2513, 632, 2865, 33, 1320, 1915,
+	2319, 1435, 807, 452, 1438, 2868, 1534, 2402, 2647, 2617, 1481, 648, 2474, 3110, 1227, 910,
+	17, 2761, 583, 2649, 1637, 723, 2288, 1100, 1409, 2662, 3281, 233, 756, 2156, 3015, 3050,
+	1703, 1651, 2789, 1789, 1847, 952, 1461, 2687, 939, 2308, 2437, 2388, 733, 2337, 268, 641,
+	1584, 2298, 2037, 3220, 375, 2549, 2090, 1645, 1063, 319, 2773, 757, 2099, 561, 2466, 2594,
+	2804, 1092, 403, 1026, 1143, 2150, 2775, 886, 1722, 1212, 1874, 1029, 2110, 2935, 885, 2154,
+}
+
+// mlkemGammas holds 17^(2*BitRev7(i)+1) mod q, the moduli of the degree-two
+// base case multiplications in the NTT domain.
+var mlkemGammas = [128]uint16{
+	17, 3312, 2761, 568, 583, 2746, 2649, 680, 1637, 1692, 723, 2606, 2288, 1041, 1100, 2229,
+	1409, 1920, 2662, 667, 3281, 48, 233, 3096, 756, 2573, 2156, 1173, 3015, 314, 3050, 279,
+	1703, 1626, 1651, 1678, 2789, 540, 1789, 1540, 1847, 1482, 952, 2377, 1461, 1868, 2687, 642,
+	939, 2390, 2308, 1021, 2437, 892, 2388, 941, 733, 2596, 2337, 992, 268, 3061, 641, 2688,
+	1584, 1745, 2298, 1031, 2037, 1292, 3220, 109, 375, 2954, 2549, 780, 2090, 1239, 1645, 1684,
+	1063, 2266, 319, 3010, 2773, 556, 757, 2572, 2099, 1230, 561, 2768, 2466, 863, 2594, 735,
+	2804, 525, 1092, 2237, 403, 2926, 1026, 2303, 1143, 2186, 2150, 1179, 2775, 554, 886, 2443,
+	1722, 1607, 1212, 2117, 1874, 1455, 1029, 2300, 2110, 1219, 2935, 394, 885, 2444, 2154, 1175,
+}
+
+// mlkemPoly is a polynomial of R_q, or its NTT representation, with every
+// coefficient reduced modulo q.
+type mlkemPoly [mlkemN]uint16
+
+func fieldAdd(a, b uint16) uint16 {
+	return uint16((uint32(a) + uint32(b)) % mlkemQ)
+}
+
+func fieldSub(a, b uint16) uint16 {
+	return uint16((uint32(a) + mlkemQ - uint32(b)) % mlkemQ)
+}
+
+func fieldMul(a, b uint16) uint16 {
+	return uint16(uint32(a) * uint32(b) % mlkemQ)
+}
+
+func polyAdd(f, g *mlkemPoly) (h mlkemPoly) {
+	for i := range h {
+		h[i] = fieldAdd(f[i], g[i])
+	}
+	return h
+}
+
+func polySub(f, g *mlkemPoly) (h mlkemPoly) {
+	for i := range h {
+		h[i] = fieldSub(f[i], g[i])
+	}
+	return h
+}
+
+// ntt transforms f into the NTT domain, FIPS 203, Algorithm 9.
+func ntt(f *mlkemPoly) {
+	k := 1
+	for length := 128; length >= 2; length /= 2 {
+		for start := 0; start < mlkemN; start += 2 * length {
+			zeta := mlkemZetas[k]
+			k++
+			for j := start; j < start+length; j++ {
+				t := fieldMul(zeta, f[j+length])
+				f[j+length] = fieldSub(f[j], t)
+				f[j] = fieldAdd(f[j], t)
+			}
+		}
+	}
+}
+
+// inverseNTT transforms f back out of the NTT domain, FIPS 203, Algorithm 10.
+func inverseNTT(f *mlkemPoly) {
+	k := 127
+	for length := 2; length <= 128; length *= 2 {
+		for start := 0; start < mlkemN; start += 2 * length {
+			zeta := mlkemZetas[k]
+			k--
+			for j := start; j < start+length; j++ {
+				t := f[j]
+				f[j] = fieldAdd(t, f[j+length])
+				f[j+length] = fieldMul(zeta, fieldSub(f[j+length], t))
+			}
+		}
+	}
+	for i := range f {
+		f[i] = fieldMul(f[i], 3303) // 128^-1 mod q
+	}
+}
+
+// nttMulAdd adds the product of f and g, both in the NTT domain, to acc,
+// FIPS 203, Algorithm 11.
+func nttMulAdd(acc, f, g *mlkemPoly) {
+	for i := 0; i < mlkemN/2; i++ {
+		a0, a1 := f[2*i], f[2*i+1]
+		b0, b1 := g[2*i], g[2*i+1]
+		c0 := fieldAdd(fieldMul(a0, b0), fieldMul(fieldMul(a1, b1), mlkemGammas[i]))
+		c1 := fieldAdd(fieldMul(a0, b1), fieldMul(a1, b0))
+		acc[2*i] = fieldAdd(acc[2*i], c0)
+		acc[2*i+1] = fieldAdd(acc[2*i+1], c1)
+	}
+}
+
+// compress maps x to round(2^d/q * x) mod 2^d.
+func compress(x uint16, d uint) uint16 {
+	return uint16(((uint32(x)<<d + mlkemQ/2) / mlkemQ) & (1<<d - 1))
+}
+
+// decompress maps y to round(q/2^d * y).
+func decompress(y uint16, d uint) uint16 {
+	return uint16((uint32(y)*mlkemQ + 1<<(d-1)) >> d)
+}
+
+// polyByteEncode appends the d-bit encoding of f to b, FIPS 203,
+// Algorithm 5.
+func polyByteEncode(b []byte, f *mlkemPoly, d uint) []byte {
+	var acc uint32
+	var n uint
+	for _, c := range f {
+		acc |= uint32(c) << n
+		n += d
+		for n >= 8 {
+			b = append(b, byte(acc))
+			acc >>= 8
+			n -= 8
+		}
+	}
+	return b
+}
+
+// polyByteDecode decodes the 32*d bytes of b into a polynomial, FIPS 203,
+// Algorithm 6. For d = 12 it reports false if a coefficient isn't reduced
+// modulo q.
+func polyByteDecode(b []byte, d uint) (f mlkemPoly, ok bool) {
+	var acc uint32
+	var n uint
+	ok = true
+	for i := range f {
+		for n < d {
+			acc |= uint32(b[0]) << n
+			b = b[1:]
+			n += 8
+		}
+		c := uint16(acc & (1<<d - 1))
+		acc >>= d
+		n -= d
+		if c >= mlkemQ {
+			ok = false
+		}
+		f[i] = c
+	}
+	return f, ok
+}
+
+// sampleNTT samples a uniformly random polynomial in the NTT domain from the
+// SHAKE128 stream for rho, j and i, FIPS 203, Algorithm 7.
+func sampleNTT(rho []byte, j, i byte) (f mlkemPoly) {
+	xof := newSHAKE128()
+	xof.Write(rho)
+	xof.Write([]byte{j, i})
+
+	var buf [168]byte
+	n := 0
+	for n < mlkemN {
+		xof.Read(buf[:])
+		for k := 0; k+3 <= len(buf) && n < mlkemN; k += 3 {
+			d1 := uint16(buf[k]) | uint16(buf[k+1]&0x0f)<<8
+			d2 := uint16(buf[k+1])>>4 | uint16(buf[k+2])<<4
+			if d1 < mlkemQ {
+				f[n] = d1
+				n++
+			}
+			if d2 < mlkemQ && n < mlkemN {
+				f[n] = d2
+				n++
+			}
+		}
+	}
+	return f
+}
+
+// samplePolyCBD samples a polynomial from the centered binomial distribution
+// with parameter eta, using PRF_eta(s, b), FIPS 203, Algorithm 8.
+func samplePolyCBD(s []byte, b byte, eta int) (f mlkemPoly) {
+	buf := shake256Sum(64*eta, s, []byte{b})
+	bit := func(i int) uint16 {
+		return uint16(buf[i/8]>>uint(i%8)) & 1
+	}
+	for i := range f {
+		var x, y uint16
+		for j := 0; j < eta; j++ {
+			x += bit(2*i*eta + j)
+			y += bit(2*i*eta + eta + j)
+		}
+		f[i] = fieldSub(x, y)
+	}
+	return f
+}
+
+// mlkemMatrix expands rho into the matrix Â, with a[i][j] = SampleNTT(rho‖j‖i).
+func mlkemMatrix(rho []byte) (a [mlkemK][mlkemK]mlkemPoly) {
+	for i := 0; i < mlkemK; i++ {
+		for j := 0; j < mlkemK; j++ {
+			a[i][j] = sampleNTT(rho, byte(j), byte(i))
+		}
+	}
+	return a
+}
+
+// mlkem768DecapsulationKey is an ML-KEM-768 key pair.
+type mlkem768DecapsulationKey struct {
+	s  [mlkemK]mlkemPoly // the secret vector, in the NTT domain
+	ek []byte            // the encapsulation key
+	h  []byte            // H(ek)
+	z  []byte            // the implicit rejection value
+}
+
+// generateMLKEM768 generates a new ML-KEM-768 key pair using rand.
+func generateMLKEM768(rand io.Reader) (*mlkem768DecapsulationKey, error) {
+	seed := make([]byte, mlkemSeedSize)
+	if _, err := io.ReadFull(rand, seed); err != nil {
+		return nil, err
+	}
+	return newMLKEM768DecapsulationKey(seed)
+}
+
+// newMLKEM768DecapsulationKey derives the key pair for the 64-byte seed d‖z,
+// FIPS 203, Algorithms 13 and 16.
+func newMLKEM768DecapsulationKey(seed []byte) (*mlkem768DecapsulationKey, error) {
+	if len(seed) != mlkemSeedSize {
+		return nil, errors.New("tls: invalid ML-KEM seed length")
+	}
+	d, z := seed[:32], seed[32:]
+
+	g := sha3Sum512(d, []byte{mlkemK})
+	rho, sigma := g[:32], g[32:]
+	a := mlkemMatrix(rho)
+
+	dk := &mlkem768DecapsulationKey{z: append([]byte(nil), z...)}
+	var e [mlkemK]mlkemPoly
+	for i := range dk.s {
+		dk.s[i] = samplePolyCBD(sigma, byte(i), mlkemEta1)
+		ntt(&dk.s[i])
+	}
+	for i := range e {
+		e[i] = samplePolyCBD(sigma, byte(mlkemK+i), mlkemEta1)
+		ntt(&e[i])
+	}
+
+	dk.ek = make([]byte, 0, mlkem768EncapsulationKeySize)
+	for i := 0; i < mlkemK; i++ {
+		t := e[i]
+		for j := 0; j < mlkemK; j++ {
+			nttMulAdd(&t, &a[i][j], &dk.s[j])
+		}
+		dk.ek = polyByteEncode(dk.ek, &t, 12)
+	}
+	dk.ek = append(dk.ek, rho...)
+	dk.h = sha3Sum256(dk.ek)
+
+	return dk, nil
+}
+
+// encapsulationKey returns the encoded encapsulation key.
+func (dk *mlkem768DecapsulationKey) encapsulationKey() []byte {
+	return dk.ek
+}
+
+// mlkemEncrypt is K-PKE.Encrypt, FIPS 203, Algorithm 14.
+func mlkemEncrypt(ek, m, r []byte) ([]byte, error) {
+	var t [mlkemK]mlkemPoly
+	for i := range t {
+		var ok bool
+		t[i], ok = polyByteDecode(ek[384*i:384*(i+1)], 12)
+		if !ok {
+			return nil, errors.New("tls: invalid ML-KEM encapsulation key")
+		}
+	}
+	a := mlkemMatrix(ek[384*mlkemK:])
+
+	var y, e1 [mlkemK]mlkemPoly
+	for i := range y {
+		y[i] = samplePolyCBD(r, byte(i), mlkemEta1)
+		ntt(&y[i])
+	}
+	for i := range e1 {
+		e1[i] = samplePolyCBD(r, byte(mlkemK+i), mlkemEta2)
+	}
+	e2 := samplePolyCBD(r, 2*mlkemK, mlkemEta2)
+
+	c := make([]byte, 0, mlkem768CiphertextSize)
+	for i := 0; i < mlkemK; i++ {
+		var u mlkemPoly
+		for j := 0; j < mlkemK; j++ {
+			nttMulAdd(&u, &a[j][i], &y[j])
+		}
+		inverseNTT(&u)
+		u = polyAdd(&u, &e1[i])
+		for k := range u {
+			u[k] = compress(u[k], mlkemDu)
+		}
+		c = polyByteEncode(c, &u, mlkemDu)
+	}
+
+	mu, _ := polyByteDecode(m, 1)
+	var v mlkemPoly
+	for j := 0; j < mlkemK; j++ {
+		nttMulAdd(&v, &t[j], &y[j])
+	}
+	inverseNTT(&v)
+	v = polyAdd(&v, &e2)
+	for k := range v {
+		v[k] = compress(fieldAdd(v[k], decompress(mu[k], 1)), mlkemDv)
+	}
+	return polyByteEncode(c, &v, mlkemDv), nil
+}
+
+// decrypt is K-PKE.Decrypt, FIPS 203, Algorithm 15.
+func (dk *mlkem768DecapsulationKey) decrypt(c []byte) []byte {
+	var w mlkemPoly
+	for i := 0; i < mlkemK; i++ {
+		u, _ := polyByteDecode(c[32*mlkemDu*i:32*mlkemDu*(i+1)], mlkemDu)
+		for k := range u {
+			u[k] = decompress(u[k], mlkemDu)
+		}
+		ntt(&u)
+		nttMulAdd(&w, &dk.s[i], &u)
+	}
+	inverseNTT(&w)
+
+	v, _ := polyByteDecode(c[32*mlkemDu*mlkemK:], mlkemDv)
+	for k := range v {
+		v[k] = decompress(v[k], mlkemDv)
+	}
+	w = polySub(&v, &w)
+	for k := range w {
+		w[k] = compress(w[k], 1)
+	}
+	return polyByteEncode(nil, &w, 1)
+}
+
+// mlkem768Encapsulate generates a shared key and its encapsulation under the
+// encoded encapsulation key ek, using rand.
+func mlkem768Encapsulate(rand io.Reader, ek []byte) (ciphertext, sharedKey []byte, err error) {
+	m := make([]byte, 32)
+	if _, err := io.ReadFull(rand, m); err != nil {
+		return nil, nil, err
+	}
+	return mlkem768EncapsulateDerand(ek, m)
+}
+
+// mlkem768EncapsulateDerand is ML-KEM.Encaps_internal, FIPS 203,
+// Algorithm 17, with the 32-byte message m.
+func mlkem768EncapsulateDerand(ek, m []byte) (ciphertext, sharedKey []byte, err error) {
+	if len(ek) != mlkem768EncapsulationKeySize {
+		return nil, nil, errors.New("tls: invalid ML-KEM encapsulation key length")
+	}
+	g := sha3Sum512(m, sha3Sum256(ek))
+	ciphertext, err = mlkemEncrypt(ek, m, g[32:])
+	if err != nil {
+		return nil, nil, err
+	}
+	return ciphertext, g[:32], nil
+}
+
+// decapsulate returns the shared key encapsulated in ciphertext, FIPS 203,
+// Algorithm 18. A ciphertext that doesn't re-encrypt to itself yields a
+// pseudorandom key derived from z instead of an error.
+func (dk *mlkem768DecapsulationKey) decapsulate(ciphertext []byte) ([]byte, error) {
+	if len(ciphertext) != mlkem768CiphertextSize {
+		return nil, errors.New("tls: invalid ML-KEM ciphertext length")
+	}
+	m := dk.decrypt(ciphertext)
+	g := sha3Sum512(m, dk.h)
+	sharedKey, r := g[:32], g[32:]
+	rejectionKey := shake256Sum(mlkemSharedKeySize, dk.z, ciphertext)
+
+	c, err := mlkemEncrypt(dk.ek, m, r)
+	if err != nil {
+		return nil, err
+	}
+	subtle.ConstantTimeCopy(1-subtle.ConstantTimeCompare(ciphertext, c), sharedKey, rejectionKey)
+	return sharedKey, nil
+}
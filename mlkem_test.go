@@ -0,0 +1,136 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"testing"
+)
+
+func TestMLKEM768Vector(t *testing.T) {
+	seed := make([]byte, mlkemSeedSize)
+	for i := range seed {
+		seed[i] = byte(i)
+	}
+	m := make([]byte, 32)
+	for i := range m {
+		m[i] = byte(0x40 + i)
+	}
+
+	dk, err := newMLKEM768DecapsulationKey(seed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ek := dk.encapsulationKey()
+	if got, want := hex.EncodeToString(sha3Sum256(ek)), "a24e16d8f8f9383a95b77050f4d9fd2f5733eec1d63ef3c23ebf9918173669a7"; got != want {
+		t.Errorf("H(ek): got %s, want %s", got, want)
+	}
+
+	ct, k, err := mlkem768EncapsulateDerand(ek, m)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(sha3Sum256(ct)), "b4cfbd24cef67afd3764276c6980e0f88f8e9ca57f59b7f12fe1a9c1e72f4710"; got != want {
+		t.Errorf("H(ciphertext): got %s, want %s", got, want)
+	}
+	if got, want := hex.EncodeToString(k), "9cddd089ffe70e3996e76f7c8d06746df34d07e8657bc0fcf2bb0e1c3084aea1"; got != want {
+		t.Errorf("shared key: got %s, want %s", got, want)
+	}
+
+	// A modified ciphertext decapsulates to the implicit rejection key.
+	ct[0] ^= 1
+	k, err = dk.decapsulate(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(k), "dcfc80c6db46ff7028e3a4398651c063ae7a42c107a6dc8cb07141861698ab92"; got != want {
+		t.Errorf("rejection key: got %s, want %s", got, want)
+	}
+}
+
+// TestMLKEM768Accumulated derives 100 key pairs, encapsulations and
+// decapsulations of random ciphertexts from a SHAKE128 stream and checks the
+// SHAKE128 hash of all the outputs against a known answer.
+func TestMLKEM768Accumulated(t *testing.T) {
+	s := newSHAKE128()
+	o := newSHAKE128()
+	seed := make([]byte, mlkemSeedSize)
+	m := make([]byte, 32)
+	ct1 := make([]byte, mlkem768CiphertextSize)
+
+	for i := 0; i < 100; i++ {
+		s.Read(seed)
+		dk, err := newMLKEM768DecapsulationKey(seed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ek := dk.encapsulationKey()
+		o.Write(ek)
+
+		s.Read(m)
+		ct, k, err := mlkem768EncapsulateDerand(ek, m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Write(ct)
+		o.Write(k)
+
+		kk, err := dk.decapsulate(ct)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(kk, k) {
+			t.Errorf("#%d: decapsulated %x, want %x", i, kk, k)
+		}
+
+		s.Read(ct1)
+		k1, err := dk.decapsulate(ct1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		o.Write(k1)
+	}
+
+	got := make([]byte, 32)
+	o.Read(got)
+	if want := "1114b1b6699ed191734fa339376afa7e285c9e6acf6ff0177d346696ce564415"; hex.EncodeToString(got) != want {
+		t.Errorf("got %x, want %s", got, want)
+	}
+}
+
+func TestMLKEM768RoundTrip(t *testing.T) {
+	dk, err := generateMLKEM768(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ct, k, err := mlkem768Encapsulate(rand.Reader, dk.encapsulationKey())
+	if err != nil {
+		t.Fatal(err)
+	}
+	kk, err := dk.decapsulate(ct)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(k, kk) {
+		t.Errorf("decapsulated %x, want %x", kk, k)
+	}
+
+	if _, err := dk.decapsulate(ct[1:]); err == nil {
+		t.Error("short ciphertext was accepted")
+	}
+	if _, _, err := mlkem768Encapsulate(rand.Reader, dk.encapsulationKey()[1:]); err == nil {
+		t.Error("short encapsulation key was accepted")
+	}
+
+	// An encapsulation key with a coefficient of q fails the modulus check.
+	ek := append([]byte(nil), dk.encapsulationKey()...)
+	ek[0] = byte(mlkemQ & 0xff)
+	ek[1] = ek[1]&0xf0 | byte(mlkemQ>>8)
+	if _, _, err := mlkem768Encapsulate(rand.Reader, ek); err == nil {
+		t.Error("unreduced encapsulation key was accepted")
+	}
+}
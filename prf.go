@@ -117,21 +117,28 @@ const (
 )
 
 var masterSecretLabel = []byte("master secret")
+var extendedMasterSecretLabel = []byte("extended master secret")
 var keyExpansionLabel = []byte("key expansion")
 var clientFinishedLabel = []byte("client finished")
 var serverFinishedLabel = []byte("server finished")
 
-func prfAndHashForVersion(version uint16, suite *cipherSuite) (func(result, secret, label, seed []byte), crypto.Hash) {
+// prfAndHashForVersion returns the PRF and the handshake hash for a version
+// and cipher suite. The hash is nil before TLS 1.2, where the handshake is
+// hashed with both MD5 and SHA1.
+func prfAndHashForVersion(version uint16, suite *cipherSuite) (func(result, secret, label, seed []byte), func() hash.Hash) {
 	switch version {
 	case VersionSSL30:
-		return prf30, crypto.Hash(0)
+		return prf30, nil
 	case VersionTLS10, VersionTLS11:
-		return prf10, crypto.Hash(0)
+		return prf10, nil
 	case VersionTLS12:
+		if suite.flags&suiteSM3 != 0 {
+			return prf12(newSM3), newSM3
+		}
 		if suite.flags&suiteSHA384 != 0 {
-			return prf12(sha512.New384), crypto.SHA384
+			return prf12(sha512.New384), sha512.New384
 		}
-		return prf12(sha256.New), crypto.SHA256
+		return prf12(sha256.New), sha256.New
 	default:
 		panic("unknown version")
 	}
@@ -154,6 +161,15 @@ func masterFromPreMasterSecret(version uint16, suite *cipherSuite, preMasterSecr
 	return masterSecret
 }
 
+// extendedMasterFromPreMasterSecret generates the extended master secret from
+// the pre-master secret and the session hash, the hash of the handshake up to
+// and including the ClientKeyExchange. See RFC 7627, section 4.
+func extendedMasterFromPreMasterSecret(version uint16, suite *cipherSuite, preMasterSecret, sessionHash []byte) []byte {
+	masterSecret := make([]byte, masterSecretLength)
+	prfForVersion(version, suite)(masterSecret, preMasterSecret, extendedMasterSecretLabel, sessionHash)
+	return masterSecret
+}
+
 // keysFromMasterSecret generates the connection keys from the master
 // secret, given the lengths of the MAC key, cipher key and IV, as defined in
 // RFC 2246, section 6.3.
@@ -189,6 +205,8 @@ func lookupTLSHash(hash uint8) (crypto.Hash, error) {
 		return crypto.SHA256, nil
 	case hashSHA384:
 		return crypto.SHA384, nil
+	case hashSHA512:
+		return crypto.SHA512, nil
 	default:
 		return 0, errors.New("tls: unsupported hash algorithm")
 	}
@@ -201,11 +219,11 @@ func newFinishedHash(version uint16, cipherSuite *cipherSuite) finishedHash {
 	}
 
 	prf, hash := prfAndHashForVersion(version, cipherSuite)
-	if hash != 0 {
-		return finishedHash{hash.New(), hash.New(), nil, nil, buffer, version, prf}
+	if hash != nil {
+		return finishedHash{hash(), hash(), nil, nil, buffer, version, prf, nil}
 	}
 
-	return finishedHash{sha1.New(), sha1.New(), md5.New(), md5.New(), buffer, version, prf}
+	return finishedHash{sha1.New(), sha1.New(), md5.New(), md5.New(), buffer, version, prf, nil}
 }
 
 // A finishedHash calculates the hash of a set of handshake messages suitable
@@ -223,9 +241,18 @@ type finishedHash struct {
 
 	version uint16
 	prf     func(result, secret, label, seed []byte)
+
+	// dtls is set on DTLS connections, whose transcript holds the messages
+	// with their full DTLS handshake headers.
+	dtls *dtlsState
 }
 
 func (h *finishedHash) Write(msg []byte) (n int, err error) {
+	n = len(msg)
+	if h.dtls != nil {
+		msg = h.dtls.transcriptMessage(msg)
+	}
+
 	h.client.Write(msg)
 	h.server.Write(msg)
 
@@ -238,7 +265,7 @@ func (h *finishedHash) Write(msg []byte) (n int, err error) {
 		h.buffer = append(h.buffer, msg...)
 	}
 
-	return len(msg), nil
+	return n, nil
 }
 
 func (h finishedHash) Sum() []byte {
@@ -311,23 +338,23 @@ func (h finishedHash) serverSum(masterSecret []byte) []byte {
 }
 
 // selectClientCertSignatureAlgorithm returns a signatureAndHash to sign a
-// client's CertificateVerify with, or an error if none can be found.
-func (h finishedHash) selectClientCertSignatureAlgorithm(serverList []signatureAndHash, sigType uint8) (signatureAndHash, error) {
+// client's CertificateVerify with pub, or an error if none can be found.
+func (h finishedHash) selectClientCertSignatureAlgorithm(config *Config, pub crypto.PublicKey, serverList []signatureAndHash) (signatureAndHash, error) {
 	if h.version < VersionTLS12 {
 		// Nothing to negotiate before TLS 1.2.
-		return signatureAndHash{signature: sigType}, nil
+		return signatureAndHash{signature: signatureTypeForKey(pub)}, nil
 	}
 
-	for _, v := range serverList {
-		if v.signature == sigType && isSupportedSignatureAndHash(v, supportedSignatureAlgorithms) {
-			return v, nil
-		}
+	sigAndHash, err := pickTLS12SignatureAlgorithm(config, pub, serverList)
+	if err != nil {
+		return signatureAndHash{}, errors.New("tls: no supported signature algorithm found for signing client certificate")
 	}
-	return signatureAndHash{}, errors.New("tls: no supported signature algorithm found for signing client certificate")
+	return sigAndHash, nil
 }
 
 // hashForClientCertificate returns a digest, hash function, and TLS 1.2 hash
-// id suitable for signing by a TLS client certificate.
+// id suitable for signing by a TLS client certificate. For Ed25519, which
+// hashes on its own, it returns the handshake messages.
 func (h finishedHash) hashForClientCertificate(signatureAndHash signatureAndHash, masterSecret []byte) ([]byte, crypto.Hash, error) {
 	if (h.version == VersionSSL30 || h.version >= VersionTLS12) && h.buffer == nil {
 		panic("a handshake hash for a client-certificate was requested after discarding the handshake buffer")
@@ -345,9 +372,12 @@ func (h finishedHash) hashForClientCertificate(signatureAndHash signatureAndHash
 		return finishedSum30(md5Hash, sha1Hash, masterSecret, nil), crypto.MD5SHA1, nil
 	}
 	if h.version >= VersionTLS12 {
-		hashAlg, err := lookupTLSHash(signatureAndHash.hash)
-		if err != nil {
-			return nil, 0, err
+		_, hashAlg, _, ok := signatureAlgorithmTLS12(signatureAndHash)
+		if !ok {
+			return nil, 0, errors.New("tls: unsupported hash algorithm")
+		}
+		if hashAlg == 0 {
+			return h.buffer, hashAlg, nil
 		}
 		hash := hashAlg.New()
 		hash.Write(h.buffer)
@@ -0,0 +1,100 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto"
+	"errors"
+	"strings"
+)
+
+// A PSKMasterKey is one generation of the master key of a PSKDeriver. It
+// derives the keys of the identities that start with Prefix.
+type PSKMasterKey struct {
+	Prefix string
+	Key    []byte
+}
+
+// A PSKDeriver derives the PSK of each identity from a master key, so that a
+// server needs no table of the keys of its clients. The key of an identity
+// is
+//
+//	HKDF-Expand(HKDF-Extract(nil, master key), info, KeyLength)
+//
+// with the HKDF of RFC 5869 over Hash, where info is Label, the identity and
+// Context, each preceded by its length as a big-endian uint16.
+//
+// The Key method of a PSKDeriver can be used as Config.GetPSKKey, on the
+// server and on clients alike. A PSKDeriver must not be modified once it is
+// in use.
+type PSKDeriver struct {
+	// Hash is the hash function of HKDF. If zero, SHA-256 is used.
+	Hash crypto.Hash
+
+	// Label and Context keep the keys derived for one purpose apart from
+	// those derived from the same master key for another.
+	Label   string
+	Context []byte
+
+	// KeyLength is the length of the derived keys. If zero, it is the
+	// size of Hash.
+	KeyLength int
+
+	// MasterKeys are the generations of the master key. An identity takes
+	// its key from the one with the longest Prefix that it starts with, so
+	// that a new generation can be rolled out under a new prefix while
+	// identities with the old one keep working.
+	MasterKeys []PSKMasterKey
+}
+
+// Key returns the key of identity. It returns ErrUnknownPSKIdentity if no
+// master key has a prefix of identity.
+func (d *PSKDeriver) Key(identity string) ([]byte, error) {
+	masterKey, ok := d.masterKey(identity)
+	if !ok {
+		return nil, ErrUnknownPSKIdentity
+	}
+
+	h := d.Hash
+	if h == 0 {
+		h = crypto.SHA256
+	}
+	if !h.Available() {
+		return nil, errors.New("tls: PSKDeriver hash function is not available")
+	}
+	keyLength := d.KeyLength
+	if keyLength == 0 {
+		keyLength = h.Size()
+	}
+	if keyLength < 0 || keyLength > 255*h.Size() {
+		return nil, errors.New("tls: invalid PSKDeriver key length")
+	}
+	if len(d.Label) > 0xffff || len(identity) > 0xffff || len(d.Context) > 0xffff {
+		return nil, errors.New("tls: PSKDeriver label, identity or context too long")
+	}
+
+	info := make([]byte, 0, 2+len(d.Label)+2+len(identity)+2+len(d.Context))
+	info = append(info, byte(len(d.Label)>>8), byte(len(d.Label)))
+	info = append(info, d.Label...)
+	info = append(info, byte(len(identity)>>8), byte(len(identity)))
+	info = append(info, identity...)
+	info = append(info, byte(len(d.Context)>>8), byte(len(d.Context)))
+	info = append(info, d.Context...)
+
+	return hkdfExpand(h.New, hkdfExtract(h.New, masterKey, nil), info, keyLength), nil
+}
+
+// masterKey returns the master key with the longest prefix of identity. On
+// return, ok is true if there is one.
+func (d *PSKDeriver) masterKey(identity string) (key []byte, ok bool) {
+	longest := -1
+	for _, masterKey := range d.MasterKeys {
+		if len(masterKey.Prefix) > longest && strings.HasPrefix(identity, masterKey.Prefix) {
+			key = masterKey.Key
+			longest = len(masterKey.Prefix)
+		}
+	}
+	return key, longest >= 0
+}
@@ -0,0 +1,100 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/hex"
+	"testing"
+)
+
+var testPSKDeriver = &PSKDeriver{
+	Label:   "device psk",
+	Context: []byte("edge"),
+	MasterKeys: []PSKMasterKey{
+		{Prefix: "g1-", Key: []byte("generation one master key")},
+		{Prefix: "g2-", Key: []byte("generation two master key")},
+	},
+}
+
+func TestPSKDeriver(t *testing.T) {
+	key, err := testPSKDeriver.Key("g1-device-0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(key), "e21e206d988570f598b927b18168c7bdbf053ef587c9394a8becb1cda273d3e6"; got != want {
+		t.Errorf("got key %s, want %s", got, want)
+	}
+
+	d := &PSKDeriver{
+		Hash:       crypto.SHA384,
+		KeyLength:  16,
+		MasterKeys: testPSKDeriver.MasterKeys,
+	}
+	key, err = d.Key("g2-device-0042")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := hex.EncodeToString(key), "9a1ce6d6e529dfda782a3a6cf1e41844"; got != want {
+		t.Errorf("got key %s, want %s", got, want)
+	}
+
+	if _, err := testPSKDeriver.Key("g3-device-0042"); err != ErrUnknownPSKIdentity {
+		t.Errorf("got error %v for an identity without a master key", err)
+	}
+}
+
+func TestPSKDeriverLongestPrefix(t *testing.T) {
+	defaultKey := PSKMasterKey{Prefix: "", Key: []byte("default master key")}
+	labKey := PSKMasterKey{Prefix: "lab-", Key: []byte("lab master key")}
+	d := &PSKDeriver{MasterKeys: []PSKMasterKey{labKey, defaultKey}}
+
+	tests := []struct {
+		identity  string
+		masterKey PSKMasterKey
+	}{
+		{"lab-device", labKey},
+		{"device", defaultKey},
+	}
+	for _, test := range tests {
+		got, err := d.Key(test.identity)
+		if err != nil {
+			t.Fatal(err)
+		}
+		want, _ := (&PSKDeriver{MasterKeys: []PSKMasterKey{test.masterKey}}).Key(test.identity)
+		if !bytes.Equal(got, want) {
+			t.Errorf("%s: derived the key from the wrong master key", test.identity)
+		}
+	}
+}
+
+func TestPSKDeriverHandshake(t *testing.T) {
+	suites := []uint16{
+		TLS_PSK_WITH_AES_128_CBC_SHA,
+		TLS_DHE_PSK_WITH_AES_128_CBC_SHA,
+		TLS_RSA_PSK_WITH_AES_128_CBC_SHA,
+	}
+	for _, suite := range suites {
+		serverConfig := &Config{
+			CipherSuites: []uint16{suite},
+			Certificates: testConfig.Certificates,
+			GetPSKKey:    testPSKDeriver.Key,
+		}
+		clientConfig := &Config{
+			CipherSuites:       []uint16{suite},
+			InsecureSkipVerify: true,
+			GetPSKIdentity:     func([]byte) (string, error) { return "g2-device-0042", nil },
+			GetPSKKey:          testPSKDeriver.Key,
+		}
+		state, _, err := testHandshake(clientConfig, serverConfig)
+		if err != nil {
+			t.Fatalf("%x: handshake failed: %s", suite, err)
+		}
+		if state.PSKIdentity != "g2-device-0042" {
+			t.Errorf("%x: got PSK identity %q", suite, state.PSKIdentity)
+		}
+	}
+}
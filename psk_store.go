@@ -0,0 +1,174 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// PSKStore is a set of pre-shared keys indexed by identity, for use with the
+// PSK cipher suites and TLS 1.3 external PSKs. PSKStore implementations
+// should expect to be called concurrently from different goroutines, also
+// while connections that use them are being established.
+type PSKStore interface {
+	// Get returns the key of identity. On return, ok is true if one was
+	// found.
+	Get(identity string) (key []byte, ok bool)
+
+	// Identities returns the identities in the store in sorted order.
+	Identities() []string
+
+	// Put adds the key of identity to the store, replacing any previous
+	// key of identity.
+	Put(identity string, key []byte)
+
+	// Remove removes identity and its key from the store.
+	Remove(identity string)
+}
+
+// memoryPSKStore is a PSKStore that keeps its keys in a map.
+type memoryPSKStore struct {
+	sync.RWMutex
+
+	keys map[string][]byte
+}
+
+// NewMemoryPSKStore returns an empty PSKStore that is held in memory.
+func NewMemoryPSKStore() PSKStore {
+	return &memoryPSKStore{keys: make(map[string][]byte)}
+}
+
+// Get returns a copy of the key of identity. It returns (nil, false) if
+// identity is unknown.
+func (s *memoryPSKStore) Get(identity string) ([]byte, bool) {
+	s.RLock()
+	defer s.RUnlock()
+
+	key, ok := s.keys[identity]
+	if !ok {
+		return nil, false
+	}
+	return append([]byte(nil), key...), true
+}
+
+func (s *memoryPSKStore) Identities() []string {
+	s.RLock()
+	defer s.RUnlock()
+
+	identities := make([]string, 0, len(s.keys))
+	for identity := range s.keys {
+		identities = append(identities, identity)
+	}
+	sort.Strings(identities)
+	return identities
+}
+
+// Put adds a copy of key to the store.
+func (s *memoryPSKStore) Put(identity string, key []byte) {
+	s.Lock()
+	defer s.Unlock()
+
+	s.keys[identity] = append([]byte(nil), key...)
+}
+
+func (s *memoryPSKStore) Remove(identity string) {
+	s.Lock()
+	defer s.Unlock()
+
+	delete(s.keys, identity)
+}
+
+// LoadPSKFile reads a file of pre-shared keys, as parsed by ParsePSKFile,
+// into a new in-memory PSKStore.
+func LoadPSKFile(filename string) (PSKStore, error) {
+	data, err := ioutil.ReadFile(filename)
+	if err != nil {
+		return nil, err
+	}
+	return ParsePSKFile(data)
+}
+
+// ParsePSKFile parses pre-shared keys in the "identity:hexkey" format of
+// stunnel and OpenSSL tooling into a new in-memory PSKStore. Each line holds
+// an identity and its hex-encoded key, separated by the last colon of the
+// line. White space around either is ignored, as are blank lines and lines
+// starting with '#'.
+func ParsePSKFile(data []byte) (PSKStore, error) {
+	store := NewMemoryPSKStore()
+
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := strings.TrimSpace(scanner.Text())
+		if len(line) == 0 || line[0] == '#' {
+			continue
+		}
+
+		i := strings.LastIndexByte(line, ':')
+		if i < 0 {
+			return nil, fmt.Errorf("tls: PSK file line %d: missing ':' separator", lineNum)
+		}
+		identity, hexKey := strings.TrimSpace(line[:i]), strings.TrimSpace(line[i+1:])
+		if err := checkPSKIdentity(identity); err != nil {
+			return nil, fmt.Errorf("tls: PSK file line %d: %s", lineNum, err)
+		}
+		if _, ok := store.Get(identity); ok {
+			return nil, fmt.Errorf("tls: PSK file line %d: duplicate identity %q", lineNum, identity)
+		}
+		key, err := hex.DecodeString(hexKey)
+		if err != nil {
+			return nil, fmt.Errorf("tls: PSK file line %d: invalid hex key: %s", lineNum, err)
+		}
+		if len(key) == 0 || len(key) > 0xffff {
+			return nil, fmt.Errorf("tls: PSK file line %d: key of invalid length %d", lineNum, len(key))
+		}
+		store.Put(identity, key)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, errors.New("tls: failed to read PSK file: " + err.Error())
+	}
+	return store, nil
+}
+
+// checkPSKIdentity checks that identity can be sent in a handshake: RFC 4279,
+// section 5.1, wants it to be UTF-8, and it has a two-byte length.
+func checkPSKIdentity(identity string) error {
+	if len(identity) == 0 || len(identity) > 0xffff {
+		return fmt.Errorf("identity of invalid length %d", len(identity))
+	}
+	if !utf8.ValidString(identity) {
+		return errors.New("identity is not valid UTF-8")
+	}
+	return nil
+}
+
+// SetPSKStore sets GetPSKKey to look keys up in store, returning
+// ErrUnknownPSKIdentity for an identity the store doesn't have. If
+// clientIdentity is not empty, it also sets GetPSKIdentity to always choose
+// clientIdentity, as a client does. Since store is consulted on every
+// handshake, keys can be added to and removed from it while the Config is
+// in use.
+func (c *Config) SetPSKStore(store PSKStore, clientIdentity string) {
+	c.GetPSKKey = func(identity string) ([]byte, error) {
+		key, ok := store.Get(identity)
+		if !ok {
+			return nil, ErrUnknownPSKIdentity
+		}
+		return key, nil
+	}
+	if clientIdentity != "" {
+		c.GetPSKIdentity = func([]byte) (string, error) {
+			return clientIdentity, nil
+		}
+	}
+}
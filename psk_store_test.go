@@ -0,0 +1,153 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestMemoryPSKStore(t *testing.T) {
+	store := NewMemoryPSKStore()
+	if _, ok := store.Get("alice"); ok {
+		t.Fatal("empty store has a key")
+	}
+
+	key := []byte("0123456789abcdef")
+	store.Put("bob", []byte("fedcba9876543210"))
+	store.Put("alice", key)
+	key[0] = 'x'
+	if got, ok := store.Get("alice"); !ok || string(got) != "0123456789abcdef" {
+		t.Fatalf("got key %q, %v", got, ok)
+	}
+	if got := store.Identities(); !reflect.DeepEqual(got, []string{"alice", "bob"}) {
+		t.Fatalf("got identities %q", got)
+	}
+
+	store.Remove("alice")
+	if _, ok := store.Get("alice"); ok {
+		t.Fatal("removed identity still has a key")
+	}
+	if got := store.Identities(); !reflect.DeepEqual(got, []string{"bob"}) {
+		t.Fatalf("got identities %q", got)
+	}
+}
+
+func TestMemoryPSKStoreConcurrency(t *testing.T) {
+	store := NewMemoryPSKStore()
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			identity := string(rune('a' + i))
+			for j := 0; j < 100; j++ {
+				store.Put(identity, []byte{byte(j)})
+				store.Get(identity)
+				store.Identities()
+				store.Remove(identity)
+			}
+		}(i)
+	}
+	wg.Wait()
+	if got := store.Identities(); len(got) != 0 {
+		t.Fatalf("got identities %q", got)
+	}
+}
+
+func TestParsePSKFile(t *testing.T) {
+	data := []byte("# comment\n" +
+		"alice:00112233445566778899aabbccddeeff\n" +
+		"\n" +
+		"  urn:example:bob : 0A0B0C0D \r\n")
+	store, err := ParsePSKFile(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := store.Identities(); !reflect.DeepEqual(got, []string{"alice", "urn:example:bob"}) {
+		t.Fatalf("got identities %q", got)
+	}
+	if key, _ := store.Get("urn:example:bob"); !bytes.Equal(key, []byte{10, 11, 12, 13}) {
+		t.Fatalf("got key %x", key)
+	}
+}
+
+var badPSKFileTests = []struct {
+	data, err string
+}{
+	{"alice", "missing ':'"},
+	{":00", "identity of invalid length 0"},
+	{"al\xffice:00", "not valid UTF-8"},
+	{"alice:xyz", "invalid hex key"},
+	{"alice:", "key of invalid length 0"},
+	{"alice:00\nalice:01", "line 2: duplicate identity"},
+}
+
+func TestParsePSKFileErrors(t *testing.T) {
+	for _, test := range badPSKFileTests {
+		_, err := ParsePSKFile([]byte(test.data))
+		if err == nil || !strings.Contains(err.Error(), test.err) {
+			t.Errorf("%q: got error %v, want one containing %q", test.data, err, test.err)
+		}
+	}
+}
+
+func TestLoadPSKFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "psk")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "psk.txt")
+	if err := ioutil.WriteFile(filename, []byte("alice:30313233343536373839616263646566\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	store, err := LoadPSKFile(filename)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if key, _ := store.Get("alice"); string(key) != "0123456789abcdef" {
+		t.Fatalf("got key %q", key)
+	}
+}
+
+func TestSetPSKStore(t *testing.T) {
+	serverStore := NewMemoryPSKStore()
+	serverStore.Put("alice", []byte("0123456789abcdef"))
+	clientStore := NewMemoryPSKStore()
+	clientStore.Put("alice", []byte("0123456789abcdef"))
+
+	serverConfig := &Config{
+		CipherSuites: []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		Certificates: testConfig.Certificates,
+	}
+	serverConfig.SetPSKStore(serverStore, "")
+	clientConfig := &Config{
+		CipherSuites:       []uint16{TLS_ECDHE_PSK_WITH_AES_128_GCM_SHA256},
+		InsecureSkipVerify: true,
+	}
+	clientConfig.SetPSKStore(clientStore, "alice")
+
+	state, _, err := testHandshake(clientConfig, serverConfig)
+	if err != nil {
+		t.Fatalf("handshake failed: %s", err)
+	}
+	if state.PSKIdentity != "alice" {
+		t.Fatalf("got PSK identity %q", state.PSKIdentity)
+	}
+
+	// Keys removed from the store are no longer accepted.
+	serverStore.Remove("alice")
+	if _, _, err := testHandshake(clientConfig, serverConfig); err != ErrUnknownPSKIdentity {
+		t.Fatalf("got error %v, want ErrUnknownPSKIdentity", err)
+	}
+}
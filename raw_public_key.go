@@ -0,0 +1,126 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"golang_org/x/crypto/ed25519"
+)
+
+// This file contains the raw public keys of RFC 7250, which authenticate a
+// peer with a bare SubjectPublicKeyInfo in place of a certificate chain. The
+// Certificate messages of both TLS 1.2 and TLS 1.3 then carry a single entry
+// holding that SubjectPublicKeyInfo.
+
+// certificatesOfType returns what a Certificate message carries for cert
+// when certType has been negotiated: the chain of cert, or its raw public
+// key. It returns nil if cert is empty.
+func (cert *Certificate) certificatesOfType(certType CertificateType) ([][]byte, error) {
+	if certType != CertificateTypeRawPublicKey {
+		return cert.Certificate, nil
+	}
+	if cert.RawPublicKey != nil {
+		return [][]byte{cert.RawPublicKey}, nil
+	}
+	if cert.PrivateKey == nil {
+		return nil, nil
+	}
+
+	priv, ok := cert.PrivateKey.(interface {
+		Public() crypto.PublicKey
+	})
+	if !ok {
+		return nil, fmt.Errorf("tls: can't derive a raw public key from a private key of type %T", cert.PrivateKey)
+	}
+	spki, err := marshalPublicKey(priv.Public())
+	if err != nil {
+		return nil, errors.New("tls: failed to marshal raw public key: " + err.Error())
+	}
+	return [][]byte{spki}, nil
+}
+
+// marshalPublicKey returns the SubjectPublicKeyInfo of pub, which
+// crypto/x509 can't produce for Ed25519 and RSASSA-PSS keys.
+func marshalPublicKey(pub crypto.PublicKey) ([]byte, error) {
+	switch pub := pub.(type) {
+	case ed25519.PublicKey:
+		return marshalEd25519PublicKey(pub)
+	case *rsaPSSPublicKey:
+		return pub.raw, nil
+	}
+	return x509.MarshalPKIXPublicKey(pub)
+}
+
+// parsePublicKey parses a SubjectPublicKeyInfo, which crypto/x509 can't do
+// for Ed25519 and RSASSA-PSS keys.
+func parsePublicKey(spki []byte) (crypto.PublicKey, error) {
+	if pub, err := parseEd25519PublicKey(spki); err == nil {
+		return pub, nil
+	}
+	if pub, err := parseRSAPSSPublicKey(spki); err == nil {
+		return pub, nil
+	}
+	return x509.ParsePKIXPublicKey(spki)
+}
+
+// mutualCertificateType returns the first of the preferred certificate
+// types that is also in offered.
+func mutualCertificateType(preferred, offered []CertificateType) (CertificateType, bool) {
+	for _, certType := range preferred {
+		for _, offeredType := range offered {
+			if certType == offeredType {
+				return certType, true
+			}
+		}
+	}
+	return 0, false
+}
+
+// certificateTypes returns the certificate types of types, or the implicit
+// X.509 type if there are none.
+func certificateTypes(types []CertificateType) []CertificateType {
+	if len(types) == 0 {
+		return []CertificateType{CertificateTypeX509}
+	}
+	return types
+}
+
+// processRawPublicKey parses the raw public key that the peer sent and
+// passes it to VerifyPeerRawPublicKey, which must be set if verify is true.
+// It sets c.peerRawPublicKey and returns the public key.
+func (c *Conn) processRawPublicKey(spki []byte, verify bool) (crypto.PublicKey, error) {
+	pub, err := parsePublicKey(spki)
+	if err != nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errors.New("tls: failed to parse raw public key: " + err.Error())
+	}
+	switch pub.(type) {
+	case *rsa.PublicKey, *rsaPSSPublicKey, *ecdsa.PublicKey, ed25519.PublicKey:
+	default:
+		c.sendAlert(alertUnsupportedCertificate)
+		return nil, fmt.Errorf("tls: raw public key of unsupported type %T", pub)
+	}
+
+	if verify && c.config.VerifyPeerRawPublicKey == nil {
+		c.sendAlert(alertBadCertificate)
+		return nil, errors.New("tls: VerifyPeerRawPublicKey must be set to verify a raw public key")
+	}
+	if c.config.VerifyPeerRawPublicKey != nil {
+		if err := c.config.VerifyPeerRawPublicKey(spki, pub); err != nil {
+			c.sendAlert(alertBadCertificate)
+			return nil, err
+		}
+	}
+
+	c.peerRawPublicKey = spki
+	c.peerPublicKey = pub
+	return pub, nil
+}
@@ -0,0 +1,153 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+// This file implements the SHA-3 hash functions and SHAKE extendable-output
+// functions of FIPS 202, which ML-KEM is built on.
+
+// keccakRC holds the round constants of Keccak-f[1600].
+var keccakRC = [24]uint64{
+	0x0000000000000001, 0x0000000000008082, 0x800000000000808a, 0x8000000080008000,
+	0x000000000000808b, 0x0000000080000001, 0x8000000080008081, 0x8000000000008009,
+	0x000000000000008a, 0x0000000000000088, 0x0000000080008009, 0x000000008000000a,
+	0x000000008000808b, 0x800000000000008b, 0x8000000000008089, 0x8000000000008003,
+	0x8000000000008002, 0x8000000000000080, 0x000000000000800a, 0x800000008000000a,
+	0x8000000080008081, 0x8000000000008080, 0x0000000080000001, 0x8000000080008008,
+}
+
+// keccakRotc and keccakPiln are the rotation offsets of the rho step and the
+// lane order of the pi step, following the lane path that starts at lane 1.
+var keccakRotc = [24]uint{
+	1, 3, 6, 10, 15, 21, 28, 36, 45, 55, 2, 14, 27, 41, 56, 8, 25, 43, 62, 18, 39, 61, 20, 44,
+}
+
+var keccakPiln = [24]int{
+	10, 7, 11, 17, 18, 3, 5, 16, 8, 21, 24, 4, 15, 23, 19, 13, 12, 2, 20, 14, 22, 9, 6, 1,
+}
+
+func rotl64(x uint64, n uint) uint64 { return x<<n | x>>(64-n) }
+
+// keccakF1600 applies the Keccak-f[1600] permutation to a.
+func keccakF1600(a *[25]uint64) {
+	var bc [5]uint64
+	for round := 0; round < 24; round++ {
+		// theta
+		for i := 0; i < 5; i++ {
+			bc[i] = a[i] ^ a[i+5] ^ a[i+10] ^ a[i+15] ^ a[i+20]
+		}
+		for i := 0; i < 5; i++ {
+			t := bc[(i+4)%5] ^ rotl64(bc[(i+1)%5], 1)
+			for j := 0; j < 25; j += 5 {
+				a[j+i] ^= t
+			}
+		}
+
+		// rho and pi
+		t := a[1]
+		for i := 0; i < 24; i++ {
+			j := keccakPiln[i]
+			t, a[j] = a[j], rotl64(t, keccakRotc[i])
+		}
+
+		// chi
+		for j := 0; j < 25; j += 5 {
+			copy(bc[:], a[j:j+5])
+			for i := 0; i < 5; i++ {
+				a[j+i] ^= ^bc[(i+1)%5] & bc[(i+2)%5]
+			}
+		}
+
+		// iota
+		a[0] ^= keccakRC[round]
+	}
+}
+
+const (
+	sha3DomainSeparator  = 0x06
+	shakeDomainSeparator = 0x1f
+)
+
+// keccakSponge is the sponge construction over Keccak-f[1600]. Input is
+// absorbed with Write; the first Read pads it and switches to squeezing.
+type keccakSponge struct {
+	a         [25]uint64
+	rate      int
+	dsbyte    byte
+	pos       int
+	squeezing bool
+}
+
+func newSHAKE128() *keccakSponge {
+	return &keccakSponge{rate: 168, dsbyte: shakeDomainSeparator}
+}
+
+func newSHAKE256() *keccakSponge {
+	return &keccakSponge{rate: 136, dsbyte: shakeDomainSeparator}
+}
+
+func (s *keccakSponge) xorByte(i int, b byte) {
+	s.a[i/8] ^= uint64(b) << (8 * uint(i%8))
+}
+
+func (s *keccakSponge) Write(p []byte) (int, error) {
+	if s.squeezing {
+		panic("tls: write to a Keccak sponge after read")
+	}
+	for _, b := range p {
+		s.xorByte(s.pos, b)
+		s.pos++
+		if s.pos == s.rate {
+			keccakF1600(&s.a)
+			s.pos = 0
+		}
+	}
+	return len(p), nil
+}
+
+func (s *keccakSponge) Read(out []byte) (int, error) {
+	if !s.squeezing {
+		s.xorByte(s.pos, s.dsbyte)
+		s.xorByte(s.rate-1, 0x80)
+		keccakF1600(&s.a)
+		s.pos = 0
+		s.squeezing = true
+	}
+	for i := range out {
+		if s.pos == s.rate {
+			keccakF1600(&s.a)
+			s.pos = 0
+		}
+		out[i] = byte(s.a[s.pos/8] >> (8 * uint(s.pos%8)))
+		s.pos++
+	}
+	return len(out), nil
+}
+
+// keccakSum absorbs the concatenation of slices into a fresh sponge and
+// squeezes size bytes out of it.
+func keccakSum(s *keccakSponge, size int, slices ...[]byte) []byte {
+	for _, b := range slices {
+		s.Write(b)
+	}
+	out := make([]byte, size)
+	s.Read(out)
+	return out
+}
+
+// sha3Sum256 returns the SHA3-256 digest of the concatenation of slices.
+func sha3Sum256(slices ...[]byte) []byte {
+	return keccakSum(&keccakSponge{rate: 136, dsbyte: sha3DomainSeparator}, 32, slices...)
+}
+
+// sha3Sum512 returns the SHA3-512 digest of the concatenation of slices.
+func sha3Sum512(slices ...[]byte) []byte {
+	return keccakSum(&keccakSponge{rate: 72, dsbyte: sha3DomainSeparator}, 64, slices...)
+}
+
+// shake256Sum returns size bytes of SHAKE256 output for the concatenation of
+// slices.
+func shake256Sum(size int, slices ...[]byte) []byte {
+	return keccakSum(newSHAKE256(), size, slices...)
+}
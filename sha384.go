@@ -0,0 +1,296 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import "hash"
+
+// sha384Digest is a SHA-384 implementation that, like crypto/sha1, provides
+// a ConstantTimeSum method. crypto/sha512 does not, so this is needed for
+// the Lucky13 countermeasures on the SHA-384 CBC cipher suites.
+type sha384Digest struct {
+	h   [8]uint64
+	x   [sha512BlockSize]byte
+	nx  int
+	len uint64
+}
+
+const (
+	sha384Size      = 48
+	sha512BlockSize = 128
+)
+
+func newConstantTimeSHA384() hash.Hash {
+	d := new(sha384Digest)
+	d.Reset()
+	return d
+}
+
+func (d *sha384Digest) Reset() {
+	d.h = [8]uint64{
+		0xcbbb9d5dc1059ed8,
+		0x629a292a367cd507,
+		0x9159015a3070dd17,
+		0x152fecd8f70e5939,
+		0x67332667ffc00b31,
+		0x8eb44a8768581511,
+		0xdb0c2e0d64f98fa7,
+		0x47b5481dbefa4fa4,
+	}
+	d.nx = 0
+	d.len = 0
+}
+
+func (d *sha384Digest) Size() int      { return sha384Size }
+func (d *sha384Digest) BlockSize() int { return sha512BlockSize }
+
+func (d *sha384Digest) Write(p []byte) (n int, err error) {
+	n = len(p)
+	d.len += uint64(n)
+	if d.nx > 0 {
+		c := copy(d.x[d.nx:], p)
+		d.nx += c
+		if d.nx == sha512BlockSize {
+			sha512Block(d, d.x[:])
+			d.nx = 0
+		}
+		p = p[c:]
+	}
+	if len(p) >= sha512BlockSize {
+		m := len(p) &^ (sha512BlockSize - 1)
+		sha512Block(d, p[:m])
+		p = p[m:]
+	}
+	if len(p) > 0 {
+		d.nx = copy(d.x[:], p)
+	}
+	return
+}
+
+func (d *sha384Digest) Sum(in []byte) []byte {
+	d0 := *d
+	l := d0.len
+	// Padding. Add a 1 bit and 0 bits until 112 bytes mod 128.
+	var tmp [sha512BlockSize + 16]byte
+	tmp[0] = 0x80
+	var t uint64
+	if l%sha512BlockSize < 112 {
+		t = 112 - l%sha512BlockSize
+	} else {
+		t = sha512BlockSize + 112 - l%sha512BlockSize
+	}
+	// Length in bits; the upper 64 bits of the 128-bit length are zero.
+	putUint64(tmp[t+8:], l<<3)
+	d0.Write(tmp[:t+16])
+
+	return d0.appendDigest(in)
+}
+
+// ConstantTimeSum computes the same result as Sum, but always compresses
+// exactly two blocks so that its timing is independent of the amount of
+// buffered data.
+func (d *sha384Digest) ConstantTimeSum(in []byte) []byte {
+	d0 := *d
+
+	var length [16]byte
+	putUint64(length[8:], d0.len<<3)
+
+	nx := d0.nx
+	// mask1 is all ones iff the padding and length fit in the current block.
+	mask1 := uint64(int64(nx-112) >> 63)
+	mask1b := byte(mask1)
+
+	separator := byte(0x80) // gets reset to 0x00 once used
+	for i := 0; i < sha512BlockSize; i++ {
+		mask := byte(int64(i-nx) >> 63) // 0x00 after the end of data
+
+		// if we reached the end of the data, replace with 0x80 or 0x00
+		d0.x[i] = (^mask & separator) | (mask & d0.x[i])
+
+		// zero the separator once used
+		separator &= mask
+
+		if i >= 112 {
+			// we might have to write the length here if all fit in one block
+			d0.x[i] |= mask1b & length[i-112]
+		}
+	}
+
+	// compress, and only keep the digest if all fit in one block
+	sha512Block(&d0, d0.x[:])
+	var h [6]uint64
+	for i := range h {
+		h[i] = mask1 & d0.h[i]
+	}
+
+	for i := 0; i < sha512BlockSize; i++ {
+		// second block, it's always past the end of data, might start with 0x80
+		if i < 112 {
+			d0.x[i] = separator
+			separator = 0
+		} else {
+			d0.x[i] = length[i-112]
+		}
+	}
+
+	// compress, and only keep the digest if we actually needed the second block
+	sha512Block(&d0, d0.x[:])
+	for i := range h {
+		h[i] |= ^mask1 & d0.h[i]
+	}
+	d0.h = [8]uint64{h[0], h[1], h[2], h[3], h[4], h[5]}
+
+	return d0.appendDigest(in)
+}
+
+func (d *sha384Digest) appendDigest(in []byte) []byte {
+	var digest [sha384Size]byte
+	for i := 0; i < sha384Size/8; i++ {
+		putUint64(digest[i*8:], d.h[i])
+	}
+	return append(in, digest[:]...)
+}
+
+func putUint64(b []byte, v uint64) {
+	b[0] = byte(v >> 56)
+	b[1] = byte(v >> 48)
+	b[2] = byte(v >> 40)
+	b[3] = byte(v >> 32)
+	b[4] = byte(v >> 24)
+	b[5] = byte(v >> 16)
+	b[6] = byte(v >> 8)
+	b[7] = byte(v)
+}
+
+var sha512K = [...]uint64{
+	0x428a2f98d728ae22,
+	0x7137449123ef65cd,
+	0xb5c0fbcfec4d3b2f,
+	0xe9b5dba58189dbbc,
+	0x3956c25bf348b538,
+	0x59f111f1b605d019,
+	0x923f82a4af194f9b,
+	0xab1c5ed5da6d8118,
+	0xd807aa98a3030242,
+	0x12835b0145706fbe,
+	0x243185be4ee4b28c,
+	0x550c7dc3d5ffb4e2,
+	0x72be5d74f27b896f,
+	0x80deb1fe3b1696b1,
+	0x9bdc06a725c71235,
+	0xc19bf174cf692694,
+	0xe49b69c19ef14ad2,
+	0xefbe4786384f25e3,
+	0x0fc19dc68b8cd5b5,
+	0x240ca1cc77ac9c65,
+	0x2de92c6f592b0275,
+	0x4a7484aa6ea6e483,
+	0x5cb0a9dcbd41fbd4,
+	0x76f988da831153b5,
+	0x983e5152ee66dfab,
+	0xa831c66d2db43210,
+	0xb00327c898fb213f,
+	0xbf597fc7beef0ee4,
+	0xc6e00bf33da88fc2,
+	0xd5a79147930aa725,
+	0x06ca6351e003826f,
+	0x142929670a0e6e70,
+	0x27b70a8546d22ffc,
+	0x2e1b21385c26c926,
+	0x4d2c6dfc5ac42aed,
+	0x53380d139d95b3df,
+	0x650a73548baf63de,
+	0x766a0abb3c77b2a8,
+	0x81c2c92e47edaee6,
+	0x92722c851482353b,
+	0xa2bfe8a14cf10364,
+	0xa81a664bbc423001,
+	0xc24b8b70d0f89791,
+	0xc76c51a30654be30,
+	0xd192e819d6ef5218,
+	0xd69906245565a910,
+	0xf40e35855771202a,
+	0x106aa07032bbd1b8,
+	0x19a4c116b8d2d0c8,
+	0x1e376c085141ab53,
+	0x2748774cdf8eeb99,
+	0x34b0bcb5e19b48a8,
+	0x391c0cb3c5c95a63,
+	0x4ed8aa4ae3418acb,
+	0x5b9cca4f7763e373,
+	0x682e6ff3d6b2b8a3,
+	0x748f82ee5defb2fc,
+	0x78a5636f43172f60,
+	0x84c87814a1f0ab72,
+	0x8cc702081a6439ec,
+	0x90befffa23631e28,
+	0xa4506cebde82bde9,
+	0xbef9a3f7b2c67915,
+	0xc67178f2e372532b,
+	0xca273eceea26619c,
+	0xd186b8c721c0c207,
+	0xeada7dd6cde0eb1e,
+	0xf57d4f7fee6ed178,
+	0x06f067aa72176fba,
+	0x0a637dc5a2c898a6,
+	0x113f9804bef90dae,
+	0x1b710b35131c471b,
+	0x28db77f523047d84,
+	0x32caab7b40c72493,
+	0x3c9ebe0a15c9bebc,
+	0x431d67c49c100d4c,
+	0x4cc5d4becb3e42b6,
+	0x597f299cfc657e2a,
+	0x5fcb6fab3ad6faec,
+	0x6c44198c4a475817,
+}
+
+func rotr64(x uint64, n uint) uint64 { return x>>n | x<<(64-n) }
+
+func sha512Block(d *sha384Digest, p []byte) {
+	var w [80]uint64
+	h0, h1, h2, h3, h4, h5, h6, h7 := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7]
+	for len(p) >= sha512BlockSize {
+		for i := 0; i < 16; i++ {
+			j := i * 8
+			w[i] = uint64(p[j])<<56 | uint64(p[j+1])<<48 | uint64(p[j+2])<<40 | uint64(p[j+3])<<32 |
+				uint64(p[j+4])<<24 | uint64(p[j+5])<<16 | uint64(p[j+6])<<8 | uint64(p[j+7])
+		}
+		for i := 16; i < 80; i++ {
+			v1 := w[i-2]
+			t1 := rotr64(v1, 19) ^ rotr64(v1, 61) ^ (v1 >> 6)
+			v2 := w[i-15]
+			t2 := rotr64(v2, 1) ^ rotr64(v2, 8) ^ (v2 >> 7)
+			w[i] = t1 + w[i-7] + t2 + w[i-16]
+		}
+
+		a, b, c, dd, e, f, g, h := h0, h1, h2, h3, h4, h5, h6, h7
+
+		for i := 0; i < 80; i++ {
+			t1 := h + (rotr64(e, 14) ^ rotr64(e, 18) ^ rotr64(e, 41)) + ((e & f) ^ (^e & g)) + sha512K[i] + w[i]
+			t2 := (rotr64(a, 28) ^ rotr64(a, 34) ^ rotr64(a, 39)) + ((a & b) ^ (a & c) ^ (b & c))
+
+			h = g
+			g = f
+			f = e
+			e = dd + t1
+			dd = c
+			c = b
+			b = a
+			a = t1 + t2
+		}
+
+		h0 += a
+		h1 += b
+		h2 += c
+		h3 += dd
+		h4 += e
+		h5 += f
+		h6 += g
+		h7 += h
+
+		p = p[sha512BlockSize:]
+	}
+	d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7] = h0, h1, h2, h3, h4, h5, h6, h7
+}
@@ -0,0 +1,34 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/sha512"
+	"testing"
+)
+
+func TestConstantTimeSHA384(t *testing.T) {
+	// Cover every buffered length, including both sides of the point where
+	// the padding and length no longer fit in the final block.
+	data := make([]byte, 3*sha512BlockSize)
+	for i := range data {
+		data[i] = byte(i)
+	}
+
+	h := newConstantTimeSHA384()
+	for n := 0; n <= len(data); n++ {
+		want := sha512.Sum384(data[:n])
+
+		h.Reset()
+		h.Write(data[:n])
+		if got := h.Sum(nil); !bytes.Equal(got, want[:]) {
+			t.Fatalf("Sum of %d bytes: got %x, want %x", n, got, want)
+		}
+		if got := h.(constantTimeHash).ConstantTimeSum(nil); !bytes.Equal(got, want[:]) {
+			t.Fatalf("ConstantTimeSum of %d bytes: got %x, want %x", n, got, want)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+// Copyright 2018 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test vectors from FIPS 202 and the NIST SHA-3 example values. The
+// 200-byte input spans more than one block at every rate.
+var sha3Tests = []struct {
+	name string
+	sum  func(in []byte) []byte
+	in   string
+	out  string
+}{
+	{"SHA3-256", func(in []byte) []byte { return sha3Sum256(in) }, "abc", "3a985da74fe225b2045c172d6bd390bd855f086e3e9d525b46bfe24511431532"},
+	{"SHA3-256", func(in []byte) []byte { return sha3Sum256(in) }, strings.Repeat("a", 200), "cce34485baf2bf2aca99b94833892a4f52896d3d153f7b840cc4f9fe695f1387"},
+	{"SHA3-512", func(in []byte) []byte { return sha3Sum512(in) }, "abc", "b751850b1a57168a5693cd924b6b096e08f621827444f70d884f5d0240d2712e10e116e9192af3c91a7ec57647e3934057340b4cf408d5a56592f8274eec53f0"},
+	{"SHA3-512", func(in []byte) []byte { return sha3Sum512(in) }, strings.Repeat("a", 200), "eae6c85c6904f11075de9f9d5e1064371d000510fa3d2d79d40cf9be34892fb01859d0a0234e138bcb0ad5c84f6c0dca226a414b0c9a2897cb695f5185fe36ec"},
+	{"SHAKE128", func(in []byte) []byte { return keccakSum(newSHAKE128(), 32, in) }, "", "7f9c2ba4e88f827d616045507605853ed73b8093f6efbc88eb1a6eacfa66ef26"},
+	{"SHAKE128", func(in []byte) []byte { return keccakSum(newSHAKE128(), 32, in) }, strings.Repeat("a", 200), "70ac9b97e891be583e08929ce4cce50d346b05f9597356d6af94d4643d2af3b6"},
+	{"SHAKE256", func(in []byte) []byte { return shake256Sum(32, in) }, "", "46b9dd2b0ba88d13233b3feb743eeb243fcd52ea62b81b82b50c27646ed5762f"},
+	// The last 32 bytes of 300 bytes of output, squeezed over three blocks.
+	{"SHAKE256", func(in []byte) []byte { return shake256Sum(300, in)[268:] }, strings.Repeat("a", 200), "1503dcfed2e05c9abcc695b4ee296305548f7390dfc905036b5cfe9be26fb170"},
+}
+
+func TestSHA3Vectors(t *testing.T) {
+	for i, test := range sha3Tests {
+		if got := test.sum([]byte(test.in)); !bytes.Equal(got, fromHex(test.out)) {
+			t.Errorf("#%d: %s got %x, want %s", i, test.name, got, test.out)
+		}
+	}
+}
+
+func TestSHAKEByteWise(t *testing.T) {
+	in := []byte(strings.Repeat("a", 200))
+	expected := shake256Sum(300, in)
+
+	s := newSHAKE256()
+	for _, b := range in {
+		s.Write([]byte{b})
+	}
+	got := make([]byte, 300)
+	for i := range got {
+		s.Read(got[i : i+1])
+	}
+	if !bytes.Equal(got, expected) {
+		t.Errorf("got %x from byte-wise writes and reads, want %x", got, expected)
+	}
+}
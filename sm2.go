@@ -0,0 +1,396 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/elliptic"
+	"crypto/subtle"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/big"
+	"time"
+)
+
+// This file implements the parts of the SM2 public key algorithms of
+// GB/T 32918-2016 that the SM cipher suites need: signatures, which are
+// always computed over SM3, and public key encryption. It also parses SM2
+// keys and certificates, which crypto/x509 rejects because of their curve.
+//
+// The curve arithmetic is that of elliptic.CurveParams, and the scalar
+// arithmetic that of math/big, neither of which runs in constant time. Their
+// timing depends on the private key in sign and decrypt and on the nonce in
+// sign, so an attacker who can time many of those operations may learn the
+// key. Servers that can't rule that out shouldn't use the SM cipher suites.
+
+// sm2Curve is the curve recommended for SM2. Like the NIST curves its a is
+// -3, so the generic elliptic.CurveParams arithmetic applies.
+var sm2Curve *elliptic.CurveParams
+
+func init() {
+	sm2Curve = &elliptic.CurveParams{Name: "SM2-P-256", BitSize: 256}
+	sm2Curve.P, _ = new(big.Int).SetString("fffffffeffffffffffffffffffffffffffffffff00000000ffffffffffffffff", 16)
+	sm2Curve.N, _ = new(big.Int).SetString("fffffffeffffffffffffffffffffffff7203df6b21c6052b53bbf40939d54123", 16)
+	sm2Curve.B, _ = new(big.Int).SetString("28e9fa9e9d9f5e344d5a9e4bcf6509a7f39789f515ab8f92ddbcbd414d940e93", 16)
+	sm2Curve.Gx, _ = new(big.Int).SetString("32c4ae2c1f1981195f9904466a39c9948fe30bbff2660be1715a4589334c74c7", 16)
+	sm2Curve.Gy, _ = new(big.Int).SetString("bc3736a2f4f6779c59bdcee36b692153d0a9877cc62a474002df32e52139f0a0", 16)
+}
+
+// sm2DefaultID is the signer identity used when none was agreed on, as
+// specified by GM/T 0009-2012.
+var sm2DefaultID = []byte("1234567812345678")
+
+var (
+	oidPublicKeyECDSA = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidNamedCurveSM2  = asn1.ObjectIdentifier{1, 2, 156, 10197, 1, 301}
+)
+
+type sm2PublicKey struct {
+	X, Y *big.Int
+}
+
+type sm2PrivateKey struct {
+	sm2PublicKey
+	D *big.Int
+}
+
+// sm2Z returns Z_A, the hash of the signer's identity and public key that is
+// prepended to every signed message.
+func (pub *sm2PublicKey) sm2Z(id []byte) []byte {
+	params := sm2Curve
+	a := new(big.Int).Sub(params.P, big.NewInt(3))
+	var entl [2]byte
+	binary.BigEndian.PutUint16(entl[:], uint16(len(id)*8))
+	return sm3Sum(entl[:], id, sm2FieldBytes(a), sm2FieldBytes(params.B),
+		sm2FieldBytes(params.Gx), sm2FieldBytes(params.Gy),
+		sm2FieldBytes(pub.X), sm2FieldBytes(pub.Y))
+}
+
+// sm2FieldBytes returns x as a 32 byte, big-endian field element.
+func sm2FieldBytes(x *big.Int) []byte {
+	b := make([]byte, 32)
+	xBytes := x.Bytes()
+	copy(b[len(b)-len(xBytes):], xBytes)
+	return b
+}
+
+// sm2RandScalar returns a random integer in [1, n-1].
+func sm2RandScalar(rand io.Reader) (*big.Int, error) {
+	b := make([]byte, sm2Curve.BitSize/8+8)
+	if _, err := io.ReadFull(rand, b); err != nil {
+		return nil, err
+	}
+	k := new(big.Int).SetBytes(b)
+	n := new(big.Int).Sub(sm2Curve.N, bigOne)
+	k.Mod(k, n)
+	k.Add(k, bigOne)
+	return k, nil
+}
+
+// generateSM2Key returns a new SM2 key pair.
+func generateSM2Key(rand io.Reader) (*sm2PrivateKey, error) {
+	d, err := sm2RandScalar(rand)
+	if err != nil {
+		return nil, err
+	}
+	priv := &sm2PrivateKey{D: d}
+	priv.X, priv.Y = sm2Curve.ScalarBaseMult(d.Bytes())
+	return priv, nil
+}
+
+// sign returns the DER encoded SM2 signature of msg, using the default
+// identity. It isn't constant time, see the top of this file.
+func (priv *sm2PrivateKey) sign(rand io.Reader, msg []byte) ([]byte, error) {
+	n := sm2Curve.N
+	e := new(big.Int).SetBytes(sm3Sum(priv.sm2Z(sm2DefaultID), msg))
+	dPlus1Inv := new(big.Int).Add(priv.D, bigOne)
+	dPlus1Inv.ModInverse(dPlus1Inv, n)
+
+	for {
+		k, err := sm2RandScalar(rand)
+		if err != nil {
+			return nil, err
+		}
+		x1, _ := sm2Curve.ScalarBaseMult(k.Bytes())
+
+		r := new(big.Int).Add(e, x1)
+		r.Mod(r, n)
+		if r.Sign() == 0 || new(big.Int).Add(r, k).Cmp(n) == 0 {
+			continue
+		}
+
+		s := new(big.Int).Mul(r, priv.D)
+		s.Sub(k, s)
+		s.Mul(s, dPlus1Inv)
+		s.Mod(s, n)
+		if s.Sign() == 0 {
+			continue
+		}
+
+		return asn1.Marshal(ecdsaSignature{r, s})
+	}
+}
+
+// verify checks a DER encoded SM2 signature of msg made with the default
+// identity.
+func (pub *sm2PublicKey) verify(msg, sig []byte) error {
+	errVerify := errors.New("tls: SM2 verification failure")
+
+	sm2Sig := new(ecdsaSignature)
+	if rest, err := asn1.Unmarshal(sig, sm2Sig); err != nil || len(rest) != 0 {
+		return errVerify
+	}
+	n := sm2Curve.N
+	r, s := sm2Sig.R, sm2Sig.S
+	if r.Sign() <= 0 || s.Sign() <= 0 || r.Cmp(n) >= 0 || s.Cmp(n) >= 0 {
+		return errVerify
+	}
+
+	t := new(big.Int).Add(r, s)
+	t.Mod(t, n)
+	if t.Sign() == 0 {
+		return errVerify
+	}
+
+	x1, y1 := sm2Curve.ScalarBaseMult(s.Bytes())
+	x2, y2 := sm2Curve.ScalarMult(pub.X, pub.Y, t.Bytes())
+	x1, _ = sm2Curve.Add(x1, y1, x2, y2)
+
+	e := new(big.Int).SetBytes(sm3Sum(pub.sm2Z(sm2DefaultID), msg))
+	e.Add(e, x1)
+	e.Mod(e, n)
+	if e.Cmp(r) != 0 {
+		return errVerify
+	}
+	return nil
+}
+
+// sm2Ciphertext is the ASN.1 structure of an SM2 ciphertext from
+// GM/T 0009-2012: the point C1, the hash C3 and the masked message C2.
+type sm2Ciphertext struct {
+	X, Y       *big.Int
+	Hash       []byte
+	Ciphertext []byte
+}
+
+// sm2KDF is the key derivation function of GB/T 32918.4, section 5.4.3.
+func sm2KDF(z []byte, length int) []byte {
+	out := make([]byte, 0, length+sm3Size)
+	var ct [4]byte
+	for i := uint32(1); len(out) < length; i++ {
+		binary.BigEndian.PutUint32(ct[:], i)
+		out = append(out, sm3Sum(z, ct[:])...)
+	}
+	return out[:length]
+}
+
+// encrypt returns the DER encoded SM2 encryption of msg to pub.
+func (pub *sm2PublicKey) encrypt(rand io.Reader, msg []byte) ([]byte, error) {
+	for {
+		k, err := sm2RandScalar(rand)
+		if err != nil {
+			return nil, err
+		}
+		x1, y1 := sm2Curve.ScalarBaseMult(k.Bytes())
+		x2, y2 := sm2Curve.ScalarMult(pub.X, pub.Y, k.Bytes())
+		x2Bytes, y2Bytes := sm2FieldBytes(x2), sm2FieldBytes(y2)
+
+		t := sm2KDF(append(x2Bytes, y2Bytes...), len(msg))
+		if subtle.ConstantTimeCompare(t, make([]byte, len(t))) == 1 {
+			continue
+		}
+		c2 := make([]byte, len(msg))
+		for i := range c2 {
+			c2[i] = msg[i] ^ t[i]
+		}
+
+		return asn1.Marshal(sm2Ciphertext{
+			X:          x1,
+			Y:          y1,
+			Hash:       sm3Sum(x2Bytes, msg, y2Bytes),
+			Ciphertext: c2,
+		})
+	}
+}
+
+// decrypt returns the plaintext of a DER encoded SM2 ciphertext. Its scalar
+// multiplication by the private key isn't constant time, see the top of
+// this file.
+func (priv *sm2PrivateKey) decrypt(ciphertext []byte) ([]byte, error) {
+	errDecrypt := errors.New("tls: SM2 decryption failure")
+
+	var c sm2Ciphertext
+	if rest, err := asn1.Unmarshal(ciphertext, &c); err != nil || len(rest) != 0 {
+		return nil, errDecrypt
+	}
+	if c.X.Sign() < 0 || c.Y.Sign() < 0 || !sm2Curve.IsOnCurve(c.X, c.Y) {
+		return nil, errDecrypt
+	}
+
+	x2, y2 := sm2Curve.ScalarMult(c.X, c.Y, priv.D.Bytes())
+	x2Bytes, y2Bytes := sm2FieldBytes(x2), sm2FieldBytes(y2)
+
+	t := sm2KDF(append(x2Bytes, y2Bytes...), len(c.Ciphertext))
+	msg := make([]byte, len(c.Ciphertext))
+	for i := range msg {
+		msg[i] = c.Ciphertext[i] ^ t[i]
+	}
+	if subtle.ConstantTimeCompare(sm3Sum(x2Bytes, msg, y2Bytes), c.Hash) != 1 {
+		return nil, errDecrypt
+	}
+	return msg, nil
+}
+
+// sm2ECPrivateKey is the SEC 1 ECPrivateKey structure, see RFC 5915.
+type sm2ECPrivateKey struct {
+	Version       int
+	PrivateKey    []byte
+	NamedCurveOID asn1.ObjectIdentifier `asn1:"optional,explicit,tag:0"`
+	PublicKey     asn1.BitString        `asn1:"optional,explicit,tag:1"`
+}
+
+// sm2PKCS8PrivateKey is the PKCS#8 structure, see RFC 5208.
+type sm2PKCS8PrivateKey struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+// parseSM2PrivateKey parses an SM2 private key in SEC 1 or PKCS#8 form.
+func parseSM2PrivateKey(der []byte) (*sm2PrivateKey, error) {
+	var p8 sm2PKCS8PrivateKey
+	if _, err := asn1.Unmarshal(der, &p8); err == nil {
+		if !isSM2Algorithm(p8.Algo) {
+			return nil, errors.New("tls: PKCS#8 key is not an SM2 key")
+		}
+		der = p8.PrivateKey
+	}
+
+	var ec sm2ECPrivateKey
+	if _, err := asn1.Unmarshal(der, &ec); err != nil {
+		return nil, err
+	}
+	if ec.Version != 1 {
+		return nil, errors.New("tls: unknown EC private key version")
+	}
+	if len(ec.NamedCurveOID) > 0 && !ec.NamedCurveOID.Equal(oidNamedCurveSM2) {
+		return nil, errors.New("tls: EC private key is not on the SM2 curve")
+	}
+
+	d := new(big.Int).SetBytes(ec.PrivateKey)
+	if d.Sign() <= 0 || d.Cmp(sm2Curve.N) >= 0 {
+		return nil, errors.New("tls: invalid SM2 private key")
+	}
+	priv := &sm2PrivateKey{D: d}
+	priv.X, priv.Y = sm2Curve.ScalarBaseMult(d.Bytes())
+	return priv, nil
+}
+
+// isSM2Algorithm reports whether a public key algorithm identifier names an
+// EC key on the SM2 curve.
+func isSM2Algorithm(algo pkix.AlgorithmIdentifier) bool {
+	if algo.Algorithm.Equal(oidNamedCurveSM2) {
+		return true
+	}
+	if !algo.Algorithm.Equal(oidPublicKeyECDSA) {
+		return false
+	}
+	var curve asn1.ObjectIdentifier
+	if _, err := asn1.Unmarshal(algo.Parameters.FullBytes, &curve); err != nil {
+		return false
+	}
+	return curve.Equal(oidNamedCurveSM2)
+}
+
+// The following structures mirror the unexported ones that crypto/x509 uses
+// to parse certificates, see RFC 5280, section 4.1.
+type sm2Certificate struct {
+	Raw                asn1.RawContent
+	TBSCertificate     sm2TBSCertificate
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	SignatureValue     asn1.BitString
+}
+
+type sm2TBSCertificate struct {
+	Raw                asn1.RawContent
+	Version            int `asn1:"optional,explicit,default:0,tag:0"`
+	SerialNumber       *big.Int
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Issuer             asn1.RawValue
+	Validity           struct{ NotBefore, NotAfter time.Time }
+	Subject            asn1.RawValue
+	PublicKey          struct {
+		Raw       asn1.RawContent
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	UniqueID        asn1.BitString   `asn1:"optional,tag:1"`
+	SubjectUniqueID asn1.BitString   `asn1:"optional,tag:2"`
+	Extensions      []pkix.Extension `asn1:"optional,explicit,tag:3"`
+}
+
+// parseCertificate parses a DER encoded certificate. Certificates with an
+// SM2 public key, which crypto/x509 can't parse, are returned with just the
+// fields that TLS needs and an *sm2PublicKey. crypto/x509 can't verify
+// their signatures either, so chains containing them can only be checked in
+// Config.VerifyPeerCertificate. RSASSA-PSS and Ed25519 public keys, which
+// crypto/x509 leaves nil, are filled in as an *rsaPSSPublicKey and an
+// ed25519.PublicKey.
+func parseCertificate(der []byte) (*x509.Certificate, error) {
+	cert, x509Err := x509.ParseCertificate(der)
+	if x509Err == nil {
+		if pub, err := parseEd25519PublicKey(cert.RawSubjectPublicKeyInfo); err == nil {
+			// Newer versions of crypto/x509 parse Ed25519 keys into a
+			// type of their own, which is replaced as well.
+			cert.PublicKey = pub
+		} else if cert.PublicKey == nil {
+			if pub, err := parseRSAPSSPublicKey(cert.RawSubjectPublicKeyInfo); err == nil {
+				cert.PublicKey = pub
+				cert.PublicKeyAlgorithm = x509.RSA
+			}
+		}
+		return cert, nil
+	}
+
+	var c sm2Certificate
+	if rest, err := asn1.Unmarshal(der, &c); err != nil || len(rest) != 0 {
+		return nil, x509Err
+	}
+	tbs := &c.TBSCertificate
+	if !isSM2Algorithm(tbs.PublicKey.Algorithm) {
+		return nil, x509Err
+	}
+
+	x, y := elliptic.Unmarshal(sm2Curve, tbs.PublicKey.PublicKey.RightAlign())
+	if x == nil {
+		return nil, errors.New("tls: invalid SM2 public key")
+	}
+
+	cert = &x509.Certificate{
+		Raw:                     c.Raw,
+		RawTBSCertificate:       tbs.Raw,
+		RawSubjectPublicKeyInfo: tbs.PublicKey.Raw,
+		RawSubject:              tbs.Subject.FullBytes,
+		RawIssuer:               tbs.Issuer.FullBytes,
+		Signature:               c.SignatureValue.RightAlign(),
+		PublicKey:               &sm2PublicKey{X: x, Y: y},
+		Version:                 tbs.Version + 1,
+		SerialNumber:            tbs.SerialNumber,
+		NotBefore:               tbs.Validity.NotBefore,
+		NotAfter:                tbs.Validity.NotAfter,
+		Extensions:              tbs.Extensions,
+	}
+	var subject, issuer pkix.RDNSequence
+	if _, err := asn1.Unmarshal(tbs.Subject.FullBytes, &subject); err == nil {
+		cert.Subject.FillFromRDNSequence(&subject)
+	}
+	if _, err := asn1.Unmarshal(tbs.Issuer.FullBytes, &issuer); err == nil {
+		cert.Issuer.FillFromRDNSequence(&issuer)
+	}
+	return cert, nil
+}
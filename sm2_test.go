@@ -0,0 +1,97 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"crypto/rand"
+	"testing"
+)
+
+func TestSM2SignVerify(t *testing.T) {
+	priv, err := generateSM2Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello sm2 message")
+	sig, err := priv.sign(rand.Reader, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := priv.verify(msg, sig); err != nil {
+		t.Errorf("failed to verify signature: %s", err)
+	}
+	msg[0] ^= 1
+	if err := priv.verify(msg, sig); err == nil {
+		t.Error("verified signature of a modified message")
+	}
+}
+
+func TestSM2EncryptDecrypt(t *testing.T) {
+	priv, err := generateSM2Key(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := []byte("hello sm2 message")
+	ciphertext, err := priv.encrypt(rand.Reader, msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := priv.decrypt(ciphertext)
+	if err != nil {
+		t.Fatalf("failed to decrypt: %s", err)
+	}
+	if !bytes.Equal(plaintext, msg) {
+		t.Errorf("got plaintext %q, want %q", plaintext, msg)
+	}
+	ciphertext[len(ciphertext)-1] ^= 1
+	if _, err := priv.decrypt(ciphertext); err == nil {
+		t.Error("decrypted a modified ciphertext")
+	}
+}
+
+// The following were generated by OpenSSL, with the key in sm2KeyPEM and
+// the default signer identity.
+var (
+	sm2OpenSSLMessage   = "hello sm2 message"
+	sm2OpenSSLSignature = "3044022021e9ac091506a494193171ced631e16be505470d965f2243c2bb2b3bef0af71e02206148e09ec53268abe24fb540c94e4dbd45ee61ed852e18b42e273b6dd43570f9"
+	sm2OpenSSLEncrypted = "307902200b487d7fa0d65cc80bbefe8f3c8264d9cdee8f16aacfa9dcd205090bf93fec6a022079f7b33ea59c732924ded94cc7b1256ec3dfb32fccd19ee516dd8787bb180c9504201895a96b8f15c77cf1187b564157a0b59ec94b905df8aeea71c36c20f08ef37904118d31d93bcef122d4810626a5416dcb5f4b"
+)
+
+func TestSM2OpenSSLVectors(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertPEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	priv := cert.PrivateKey.(*sm2PrivateKey)
+
+	if err := priv.verify([]byte(sm2OpenSSLMessage), fromHex(sm2OpenSSLSignature)); err != nil {
+		t.Errorf("failed to verify OpenSSL signature: %s", err)
+	}
+	plaintext, err := priv.decrypt(fromHex(sm2OpenSSLEncrypted))
+	if err != nil {
+		t.Fatalf("failed to decrypt OpenSSL ciphertext: %s", err)
+	}
+	if string(plaintext) != sm2OpenSSLMessage {
+		t.Errorf("got plaintext %q, want %q", plaintext, sm2OpenSSLMessage)
+	}
+}
+
+func TestParseSM2Certificate(t *testing.T) {
+	cert, err := X509KeyPair([]byte(sm2CertPEM), []byte(sm2KeyPEM))
+	if err != nil {
+		t.Fatal(err)
+	}
+	x509Cert, err := parseCertificate(cert.Certificate[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := x509Cert.PublicKey.(*sm2PublicKey); !ok {
+		t.Fatalf("got public key of type %T, want *sm2PublicKey", x509Cert.PublicKey)
+	}
+	if x509Cert.Subject.CommonName != "sm2.example.com" {
+		t.Errorf("got subject %q, want sm2.example.com", x509Cert.Subject.CommonName)
+	}
+}
@@ -0,0 +1,149 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"encoding/binary"
+	"hash"
+)
+
+// This file implements the SM3 hash function of GB/T 32905-2016, which is
+// used by the SM cipher suites.
+
+const (
+	sm3Size      = 32
+	sm3BlockSize = 64
+)
+
+type sm3Digest struct {
+	h   [8]uint32
+	x   [sm3BlockSize]byte
+	nx  int
+	len uint64
+}
+
+func newSM3() hash.Hash {
+	d := new(sm3Digest)
+	d.Reset()
+	return d
+}
+
+// sm3Sum returns the SM3 digest of the concatenation of the given slices.
+func sm3Sum(slices ...[]byte) []byte {
+	h := newSM3()
+	for _, s := range slices {
+		h.Write(s)
+	}
+	return h.Sum(nil)
+}
+
+func (d *sm3Digest) Reset() {
+	d.h = [8]uint32{
+		0x7380166f, 0x4914b2b9, 0x172442d7, 0xda8a0600,
+		0xa96f30bc, 0x163138aa, 0xe38dee4d, 0xb0fb0e4e,
+	}
+	d.nx = 0
+	d.len = 0
+}
+
+func (d *sm3Digest) Size() int      { return sm3Size }
+func (d *sm3Digest) BlockSize() int { return sm3BlockSize }
+
+func (d *sm3Digest) Write(p []byte) (n int, err error) {
+	n = len(p)
+	d.len += uint64(n)
+	if d.nx > 0 {
+		c := copy(d.x[d.nx:], p)
+		d.nx += c
+		if d.nx == sm3BlockSize {
+			sm3Block(d, d.x[:])
+			d.nx = 0
+		}
+		p = p[c:]
+	}
+	if len(p) >= sm3BlockSize {
+		m := len(p) &^ (sm3BlockSize - 1)
+		sm3Block(d, p[:m])
+		p = p[m:]
+	}
+	if len(p) > 0 {
+		d.nx = copy(d.x[:], p)
+	}
+	return
+}
+
+func (d *sm3Digest) Sum(in []byte) []byte {
+	d0 := *d
+	l := d0.len
+	// Padding. Add a 1 bit and 0 bits until 56 bytes mod 64.
+	var tmp [sm3BlockSize + 8]byte
+	tmp[0] = 0x80
+	var t uint64
+	if l%sm3BlockSize < 56 {
+		t = 56 - l%sm3BlockSize
+	} else {
+		t = sm3BlockSize + 56 - l%sm3BlockSize
+	}
+	binary.BigEndian.PutUint64(tmp[t:], l<<3)
+	d0.Write(tmp[:t+8])
+
+	var digest [sm3Size]byte
+	for i, s := range d0.h {
+		binary.BigEndian.PutUint32(digest[i*4:], s)
+	}
+	return append(in, digest[:]...)
+}
+
+func sm3P0(x uint32) uint32 { return x ^ rotl32(x, 9) ^ rotl32(x, 17) }
+func sm3P1(x uint32) uint32 { return x ^ rotl32(x, 15) ^ rotl32(x, 23) }
+
+func sm3Block(d *sm3Digest, p []byte) {
+	var w [68]uint32
+	for len(p) >= sm3BlockSize {
+		for i := 0; i < 16; i++ {
+			w[i] = binary.BigEndian.Uint32(p[4*i:])
+		}
+		for i := 16; i < 68; i++ {
+			w[i] = sm3P1(w[i-16]^w[i-9]^rotl32(w[i-3], 15)) ^ rotl32(w[i-13], 7) ^ w[i-6]
+		}
+
+		a, b, c, dd, e, f, g, h := d.h[0], d.h[1], d.h[2], d.h[3], d.h[4], d.h[5], d.h[6], d.h[7]
+		for j := 0; j < 64; j++ {
+			var t, ff, gg uint32
+			if j < 16 {
+				t = 0x79cc4519
+				ff = a ^ b ^ c
+				gg = e ^ f ^ g
+			} else {
+				t = 0x7a879d8a
+				ff = (a & b) | (a & c) | (b & c)
+				gg = (e & f) | (^e & g)
+			}
+			ss1 := rotl32(rotl32(a, 12)+e+rotl32(t, uint(j%32)), 7)
+			ss2 := ss1 ^ rotl32(a, 12)
+			tt1 := ff + dd + ss2 + (w[j] ^ w[j+4])
+			tt2 := gg + h + ss1 + w[j]
+			dd = c
+			c = rotl32(b, 9)
+			b = a
+			a = tt1
+			h = g
+			g = rotl32(f, 19)
+			f = e
+			e = sm3P0(tt2)
+		}
+
+		d.h[0] ^= a
+		d.h[1] ^= b
+		d.h[2] ^= c
+		d.h[3] ^= dd
+		d.h[4] ^= e
+		d.h[5] ^= f
+		d.h[6] ^= g
+		d.h[7] ^= h
+
+		p = p[sm3BlockSize:]
+	}
+}
@@ -0,0 +1,37 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// Test vectors from GB/T 32905-2016, appendix A.
+var sm3Tests = []struct {
+	in, out string
+}{
+	{"abc", "66c7f0f462eeedd9d1f2d46bdc10e4e24167c4875cf2f7a2297da02b8f4ba8e0"},
+	{strings.Repeat("abcd", 16), "debe9ff92275b8a138604889c18e5a4d6fdb70e5387e5765293dcba39c0c5732"},
+}
+
+func TestSM3Vectors(t *testing.T) {
+	for i, test := range sm3Tests {
+		expected := fromHex(test.out)
+		if got := sm3Sum([]byte(test.in)); !bytes.Equal(got, expected) {
+			t.Errorf("#%d: got %x, want %x", i, got, expected)
+		}
+
+		// Feed the input a byte at a time to exercise the buffering.
+		h := newSM3()
+		for j := 0; j < len(test.in); j++ {
+			h.Write([]byte{test.in[j]})
+		}
+		if got := h.Sum(nil); !bytes.Equal(got, expected) {
+			t.Errorf("#%d: got %x from byte-wise writes, want %x", i, got, expected)
+		}
+	}
+}
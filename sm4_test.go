@@ -0,0 +1,35 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestSM4Vectors(t *testing.T) {
+	// Test vector from GB/T 32907-2016, appendix A.1.
+	key := fromHex("0123456789abcdeffedcba9876543210")
+	plaintext := key
+	expected := fromHex("681edf34d206965e86b3e94f536e4246")
+
+	block, err := newSM4Cipher(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := make([]byte, sm4BlockSize)
+	block.Encrypt(out, plaintext)
+	if !bytes.Equal(out, expected) {
+		t.Errorf("got ciphertext %x, want %x", out, expected)
+	}
+	block.Decrypt(out, expected)
+	if !bytes.Equal(out, plaintext) {
+		t.Errorf("got plaintext %x, want %x", out, plaintext)
+	}
+
+	if _, err := newSM4Cipher(key[:15]); err == nil {
+		t.Error("newSM4Cipher accepted a 15 byte key")
+	}
+}
@@ -0,0 +1,261 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"math/big"
+	"strings"
+)
+
+// SRPGroup is a prime N and generator g used for SRP key agreement, as
+// specified in RFC 5054.
+type SRPGroup struct {
+	N *big.Int
+	G *big.Int
+}
+
+// SRPVerifier is the information a server stores for each SRP username: the
+// group, the salt and the password verifier v = g^x % N.
+type SRPVerifier struct {
+	Group    *SRPGroup
+	Salt     []byte
+	Verifier *big.Int
+}
+
+// NewSRPVerifier computes the SRP verifier for username and password with the
+// given group and salt, as specified in RFC 5054, section 2.4. The salt should
+// be random and at least 16 bytes long.
+func NewSRPVerifier(group *SRPGroup, username, password string, salt []byte) *SRPVerifier {
+	x := srpPrivateKey(salt, username, password)
+	return &SRPVerifier{
+		Group:    group,
+		Salt:     salt,
+		Verifier: new(big.Int).Exp(group.G, x, group.N),
+	}
+}
+
+// dummySRPVerifier returns the verifier that a server with SRPDummySecret
+// makes up for an unknown username. As in RFC 5054, section 2.5.1.3, the salt
+// is HMAC-SHA1(SRPDummySecret, "salt" | username), so that it is the same
+// each time the username is tried, and the verifier is random.
+func (c *Config) dummySRPVerifier(username string) (*SRPVerifier, error) {
+	h := hmac.New(sha1.New, c.SRPDummySecret)
+	h.Write([]byte("salt"))
+	h.Write([]byte(username))
+	v, err := rand.Int(c.rand(), SRPGroup2048.N)
+	if err != nil {
+		return nil, err
+	}
+	return &SRPVerifier{
+		Group:    SRPGroup2048,
+		Salt:     h.Sum(nil)[:16],
+		Verifier: v,
+	}, nil
+}
+
+var (
+	// SRPGroup1024 is the 1024-bit group from RFC 5054, appendix A.
+	SRPGroup1024 = &SRPGroup{
+		N: bigFromHex(`
+			EEAF0AB9ADB38DD69C33F80AFA8FC5E86072618775FF3C0B9EA2314C9C256576
+			D674DF7496EA81D3383B4813D692C6E0E0D5D8E250B98BE48E495C1D6089DAD1
+			5DC7D7B46154D6B6CE8EF4AD69B15D4982559B297BCF1885C529F566660E57EC
+			68EDBC3C05726CC02FD4CBF4976EAA9AFD5138FE8376435B9FC61D2FC0EB06E3`),
+		G: big.NewInt(2),
+	}
+
+	// SRPGroup1536 is the 1536-bit group from RFC 5054, appendix A.
+	SRPGroup1536 = &SRPGroup{
+		N: bigFromHex(`
+			9DEF3CAFB939277AB1F12A8617A47BBBDBA51DF499AC4C80BEEEA9614B19CC4D
+			5F4F5F556E27CBDE51C6A94BE4607A291558903BA0D0F84380B655BB9A22E8DC
+			DF028A7CEC67F0D08134B1C8B97989149B609E0BE3BAB63D47548381DBC5B1FC
+			764E3F4B53DD9DA1158BFD3E2B9C8CF56EDF019539349627DB2FD53D24B7C486
+			65772E437D6C7F8CE442734AF7CCB7AE837C264AE3A9BEB87F8A2FE9B8B5292E
+			5A021FFF5E91479E8CE7A28C2442C6F315180F93499A234DCF76E3FED135F9BB`),
+		G: big.NewInt(2),
+	}
+
+	// SRPGroup2048 is the 2048-bit group from RFC 5054, appendix A.
+	SRPGroup2048 = &SRPGroup{
+		N: bigFromHex(`
+			AC6BDB41324A9A9BF166DE5E1389582FAF72B6651987EE07FC3192943DB56050
+			A37329CBB4A099ED8193E0757767A13DD52312AB4B03310DCD7F48A9DA04FD50
+			E8083969EDB767B0CF6095179A163AB3661A05FBD5FAAAE82918A9962F0B93B8
+			55F97993EC975EEAA80D740ADBF4FF747359D041D5C33EA71D281E446B14773B
+			CA97B43A23FB801676BD207A436C6481F1D2B9078717461A5B9D32E688F87748
+			544523B524B0D57D5EA77A2775D2ECFA032CFBDBF52FB3786160279004E57AE6
+			AF874E7303CE53299CCC041C7BC308D82A5698F3A8D0C38271AE35F8E9DBFBB6
+			94B5C803D89F7AE435DE236D525F54759B65E372FCD68EF20FA7111F9E4AFF73`),
+		G: big.NewInt(2),
+	}
+
+	// SRPGroup3072 is the 3072-bit group from RFC 5054, appendix A.
+	SRPGroup3072 = &SRPGroup{
+		N: bigFromHex(`
+			FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74
+			020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437
+			4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED
+			EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05
+			98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB
+			9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B
+			E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718
+			3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33
+			A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7
+			ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864
+			D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2
+			08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A93AD2CAFFFFFFFFFFFFFFFF`),
+		G: big.NewInt(5),
+	}
+
+	// SRPGroup4096 is the 4096-bit group from RFC 5054, appendix A.
+	SRPGroup4096 = &SRPGroup{
+		N: bigFromHex(`
+			FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74
+			020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437
+			4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED
+			EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05
+			98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB
+			9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B
+			E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718
+			3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33
+			A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7
+			ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864
+			D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2
+			08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A92108011A723C12A787E6D7
+			88719A10BDBA5B2699C327186AF4E23C1A946834B6150BDA2583E9CA2AD44CE8
+			DBBBC2DB04DE8EF92E8EFC141FBECAA6287C59474E6BC05D99B2964FA090C3A2
+			233BA186515BE7ED1F612970CEE2D7AFB81BDD762170481CD0069127D5B05AA9
+			93B4EA988D8FDDC186FFB7DC90A6C08F4DF435C934063199FFFFFFFFFFFFFFFF`),
+		G: big.NewInt(5),
+	}
+
+	// SRPGroup6144 is the 6144-bit group from RFC 5054, appendix A.
+	SRPGroup6144 = &SRPGroup{
+		N: bigFromHex(`
+			FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74
+			020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437
+			4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED
+			EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05
+			98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB
+			9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B
+			E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718
+			3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33
+			A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7
+			ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864
+			D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2
+			08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A92108011A723C12A787E6D7
+			88719A10BDBA5B2699C327186AF4E23C1A946834B6150BDA2583E9CA2AD44CE8
+			DBBBC2DB04DE8EF92E8EFC141FBECAA6287C59474E6BC05D99B2964FA090C3A2
+			233BA186515BE7ED1F612970CEE2D7AFB81BDD762170481CD0069127D5B05AA9
+			93B4EA988D8FDDC186FFB7DC90A6C08F4DF435C93402849236C3FAB4D27C7026
+			C1D4DCB2602646DEC9751E763DBA37BDF8FF9406AD9E530EE5DB382F413001AE
+			B06A53ED9027D831179727B0865A8918DA3EDBEBCF9B14ED44CE6CBACED4BB1B
+			DB7F1447E6CC254B332051512BD7AF426FB8F401378CD2BF5983CA01C64B92EC
+			F032EA15D1721D03F482D7CE6E74FEF6D55E702F46980C82B5A84031900B1C9E
+			59E7C97FBEC7E8F323A97A7E36CC88BE0F1D45B7FF585AC54BD407B22B4154AA
+			CC8F6D7EBF48E1D814CC5ED20F8037E0A79715EEF29BE32806A1D58BB7C5DA76
+			F550AA3D8A1FBFF0EB19CCB1A313D55CDA56C9EC2EF29632387FE8D76E3C0468
+			043E8F663F4860EE12BF2D5B0B7474D6E694F91E6DCC4024FFFFFFFFFFFFFFFF`),
+		G: big.NewInt(5),
+	}
+
+	// SRPGroup8192 is the 8192-bit group from RFC 5054, appendix A.
+	SRPGroup8192 = &SRPGroup{
+		N: bigFromHex(`
+			FFFFFFFFFFFFFFFFC90FDAA22168C234C4C6628B80DC1CD129024E088A67CC74
+			020BBEA63B139B22514A08798E3404DDEF9519B3CD3A431B302B0A6DF25F1437
+			4FE1356D6D51C245E485B576625E7EC6F44C42E9A637ED6B0BFF5CB6F406B7ED
+			EE386BFB5A899FA5AE9F24117C4B1FE649286651ECE45B3DC2007CB8A163BF05
+			98DA48361C55D39A69163FA8FD24CF5F83655D23DCA3AD961C62F356208552BB
+			9ED529077096966D670C354E4ABC9804F1746C08CA18217C32905E462E36CE3B
+			E39E772C180E86039B2783A2EC07A28FB5C55DF06F4C52C9DE2BCBF695581718
+			3995497CEA956AE515D2261898FA051015728E5A8AAAC42DAD33170D04507A33
+			A85521ABDF1CBA64ECFB850458DBEF0A8AEA71575D060C7DB3970F85A6E1E4C7
+			ABF5AE8CDB0933D71E8C94E04A25619DCEE3D2261AD2EE6BF12FFA06D98A0864
+			D87602733EC86A64521F2B18177B200CBBE117577A615D6C770988C0BAD946E2
+			08E24FA074E5AB3143DB5BFCE0FD108E4B82D120A92108011A723C12A787E6D7
+			88719A10BDBA5B2699C327186AF4E23C1A946834B6150BDA2583E9CA2AD44CE8
+			DBBBC2DB04DE8EF92E8EFC141FBECAA6287C59474E6BC05D99B2964FA090C3A2
+			233BA186515BE7ED1F612970CEE2D7AFB81BDD762170481CD0069127D5B05AA9
+			93B4EA988D8FDDC186FFB7DC90A6C08F4DF435C93402849236C3FAB4D27C7026
+			C1D4DCB2602646DEC9751E763DBA37BDF8FF9406AD9E530EE5DB382F413001AE
+			B06A53ED9027D831179727B0865A8918DA3EDBEBCF9B14ED44CE6CBACED4BB1B
+			DB7F1447E6CC254B332051512BD7AF426FB8F401378CD2BF5983CA01C64B92EC
+			F032EA15D1721D03F482D7CE6E74FEF6D55E702F46980C82B5A84031900B1C9E
+			59E7C97FBEC7E8F323A97A7E36CC88BE0F1D45B7FF585AC54BD407B22B4154AA
+			CC8F6D7EBF48E1D814CC5ED20F8037E0A79715EEF29BE32806A1D58BB7C5DA76
+			F550AA3D8A1FBFF0EB19CCB1A313D55CDA56C9EC2EF29632387FE8D76E3C0468
+			043E8F663F4860EE12BF2D5B0B7474D6E694F91E6DBE115974A3926F12FEE5E4
+			38777CB6A932DF8CD8BEC4D073B931BA3BC832B68D9DD300741FA7BF8AFC47ED
+			2576F6936BA424663AAB639C5AE4F5683423B4742BF1C978238F16CBE39D652D
+			E3FDB8BEFC848AD922222E04A4037C0713EB57A81A23F0C73473FC646CEA306B
+			4BCBC8862F8385DDFA9D4B7FA2C087E879683303ED5BDD3A062B3CF5B3A278A6
+			6D2A13F83F44F82DDF310EE074AB6A364597E899A0255DC164F31CC50846851D
+			F9AB48195DED7EA1B1D510BD7EE74D73FAF36BC31ECFA268359046F4EB879F92
+			4009438B481C6CD7889A002ED5EE382BC9190DA6FC026E479558E4475677E9AA
+			9E3050E2765694DFC81F56E880B96E7160C980DD98EDD3DFFFFFFFFFFFFFFFFF`),
+		G: big.NewInt(19),
+	}
+)
+
+// srpGroups are the groups that a client accepts from a server. RFC 5054,
+// section 2.5.3 requires the client to reject parameters that it does not
+// know to be safe.
+var srpGroups = []*SRPGroup{SRPGroup1024, SRPGroup1536, SRPGroup2048, SRPGroup3072, SRPGroup4096, SRPGroup6144, SRPGroup8192}
+
+func srpKnownGroup(n, g *big.Int) *SRPGroup {
+	for _, group := range srpGroups {
+		if group.N.Cmp(n) == 0 && group.G.Cmp(g) == 0 {
+			return group
+		}
+	}
+	return nil
+}
+
+// bigFromHex parses a hex constant, ignoring any whitespace used to lay it out.
+func bigFromHex(s string) *big.Int {
+	n, ok := new(big.Int).SetString(strings.Join(strings.Fields(s), ""), 16)
+	if !ok {
+		panic("tls: bad hex constant")
+	}
+	return n
+}
+
+// srpPad returns the big-endian encoding of n, left padded with zeros to the
+// length of the group prime, as the PAD() function of RFC 5054, section 2.6.
+func srpPad(group *SRPGroup, n *big.Int) []byte {
+	b := n.Bytes()
+	ret := make([]byte, (group.N.BitLen()+7)/8)
+	copy(ret[len(ret)-len(b):], b)
+	return ret
+}
+
+func srpHash(slices ...[]byte) *big.Int {
+	h := sha1.New()
+	for _, s := range slices {
+		h.Write(s)
+	}
+	return new(big.Int).SetBytes(h.Sum(nil))
+}
+
+// srpPrivateKey returns x = SHA1(s | SHA1(I | ":" | P)).
+func srpPrivateKey(salt []byte, username, password string) *big.Int {
+	inner := sha1.Sum([]byte(username + ":" + password))
+	return srpHash(salt, inner[:])
+}
+
+// srpMultiplier returns k = SHA1(N | PAD(g)).
+func srpMultiplier(group *SRPGroup) *big.Int {
+	return srpHash(group.N.Bytes(), srpPad(group, group.G))
+}
+
+// srpScrambler returns u = SHA1(PAD(A) | PAD(B)).
+func srpScrambler(group *SRPGroup, A, B *big.Int) *big.Int {
+	return srpHash(srpPad(group, A), srpPad(group, B))
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+// fromSpacedHex is like fromHex but ignores the whitespace used to lay out
+// the RFC test vectors.
+func fromSpacedHex(s string) []byte {
+	return fromHex(strings.Join(strings.Fields(s), ""))
+}
+
+// Test vectors from RFC 5054, appendix B.
+var (
+	srpTestSalt     = fromSpacedHex("BEB25379 D1A8581E B5A72767 3A2441EE")
+	srpTestVerifier = fromSpacedHex(`
+		7E273DE8 696FFC4F 4E337D05 B4B375BE B0DDE156 9E8FA00A 9886D812
+		9BADA1F1 822223CA 1A605B53 0E379BA4 729FDC59 F105B478 7E5186F5
+		C671085A 1447B52A 48CF1970 B4FB6F84 00BBF4CE BFBB1681 52E08AB5
+		EA53D15C 1AFF87B2 B9DA6E04 E058AD51 CC72BFC9 033B564E 26480D78
+		E955A5E2 9E7AB245 DB2BE315 E2099AFB`)
+	srpTestClientPrivate = fromSpacedHex("60975527 035CF2AD 1989806F 0407210B C81EDC04 E2762A56 AFD529DD DA2D4393")
+	srpTestServerPrivate = fromSpacedHex("E487CB59 D31AC550 471E81F0 0F6928E0 1DDA08E9 74A004F4 9E61F5D1 05284D20")
+	srpTestPremaster     = fromSpacedHex(`
+		B0DC82BA BCF30674 AE450C02 87745E79 90A3381F 63B387AA F271A10D
+		233861E3 59B48220 F7C4693C 9AE12B0A 6F67809F 0876E2D0 13800D6C
+		41BB59B6 D5979B5C 00A172B4 A2A5903A 0BDCAF8A 709585EB 2AFAFA8F
+		3499B200 210DCC1F 10EB3394 3CD67FC8 8A2F39A4 BE5BEC4E C0A3212D
+		C346D7E4 74B29EDE 8A469FFE CA686E5A`)
+)
+
+func TestSRPVerifier(t *testing.T) {
+	v := NewSRPVerifier(SRPGroup1024, "alice", "password123", srpTestSalt)
+	if !bytes.Equal(v.Verifier.Bytes(), srpTestVerifier) {
+		t.Errorf("got verifier %x, want %x", v.Verifier.Bytes(), srpTestVerifier)
+	}
+	k := srpMultiplier(SRPGroup1024)
+	if want := fromSpacedHex("7556AA04 5AEF2CDD 07ABAF0F 665C3E81 8913186F"); !bytes.Equal(k.Bytes(), want) {
+		t.Errorf("got k %x, want %x", k.Bytes(), want)
+	}
+}
+
+func TestSRPKeyAgreement(t *testing.T) {
+	serverConfig := &Config{
+		Rand: bytes.NewReader(srpTestServerPrivate),
+		GetSRPVerifier: func(username string) (*SRPVerifier, error) {
+			return NewSRPVerifier(SRPGroup1024, "alice", "password123", srpTestSalt), nil
+		},
+	}
+	clientConfig := &Config{
+		Rand:           bytes.NewReader(srpTestClientPrivate),
+		GetSRPPassword: func(username string) (string, error) { return "password123", nil },
+	}
+	clientHello := &clientHelloMsg{srpUsername: "alice"}
+
+	server := srpKA(VersionTLS12)
+	skx, err := server.generateServerKeyExchange(serverConfig, nil, clientHello, &serverHelloMsg{})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	client := srpKA(VersionTLS12)
+	if err := client.processServerKeyExchange(clientConfig, clientHello, &serverHelloMsg{}, nil, skx); err != nil {
+		t.Fatal(err)
+	}
+	clientPremaster, ckx, err := client.generateClientKeyExchange(clientConfig, clientHello, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(clientPremaster, srpTestPremaster) {
+		t.Errorf("client premaster secret is %x, want %x", clientPremaster, srpTestPremaster)
+	}
+
+	serverPremaster, err := server.processClientKeyExchange(serverConfig, nil, ckx, VersionTLS12)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(serverPremaster, srpTestPremaster) {
+		t.Errorf("server premaster secret is %x, want %x", serverPremaster, srpTestPremaster)
+	}
+}
@@ -22,6 +22,28 @@ type sessionState struct {
 	cipherSuite  uint16
 	masterSecret []byte
 	certificates [][]byte
+	// encryptThenMAC is true if the session negotiated encrypt-then-MAC,
+	// which resumptions of it keep.
+	encryptThenMAC bool
+	// extendedMasterSecret is true if the master secret is an extended
+	// master secret, see RFC 7627.
+	extendedMasterSecret bool
+	// clientRawPublicKey is true if certificates holds the raw public key
+	// of the client instead of a chain, and serverRawPublicKey is true if
+	// the server authenticated with a raw public key, see RFC 7250.
+	clientRawPublicKey bool
+	serverRawPublicKey bool
+	// pskIdentity is the identity of the pre-shared key that authenticated
+	// the session, or nil if none did, and curveID is the group of its key
+	// agreement. curveID is only recorded for PSK sessions, so that other
+	// tickets stay readable by servers that predate it.
+	pskIdentity []byte
+	curveID     CurveID
+	// createdAt is when the ticket was issued, in seconds since the Unix
+	// epoch, or zero if that isn't known. It is recorded for TLS 1.3
+	// sessions, whose tickets have a limited lifetime, see
+	// maxSessionTicketLifetime, and for sessions in a ServerSessionCache.
+	createdAt uint64
 	// usedOldKey is true if the ticket from which this session came from
 	// was encrypted with an older key and thus should be refreshed.
 	usedOldKey bool
@@ -35,7 +57,15 @@ func (s *sessionState) equal(i interface{}) bool {
 
 	if s.vers != s1.vers ||
 		s.cipherSuite != s1.cipherSuite ||
-		!bytes.Equal(s.masterSecret, s1.masterSecret) {
+		!bytes.Equal(s.masterSecret, s1.masterSecret) ||
+		s.encryptThenMAC != s1.encryptThenMAC ||
+		s.extendedMasterSecret != s1.extendedMasterSecret ||
+		s.clientRawPublicKey != s1.clientRawPublicKey ||
+		s.serverRawPublicKey != s1.serverRawPublicKey ||
+		(s.pskIdentity == nil) != (s1.pskIdentity == nil) ||
+		!bytes.Equal(s.pskIdentity, s1.pskIdentity) ||
+		s.curveID != s1.curveID ||
+		s.createdAt != s1.createdAt {
 		return false
 	}
 
@@ -52,11 +82,61 @@ func (s *sessionState) equal(i interface{}) bool {
 	return true
 }
 
+// The certificate count of a serialized sessionState has sessionStateHasFlags
+// set if a byte of sessionState* flags follows it. Sessions without any flags
+// are serialized as they were before the flags were added. The flags byte is
+// followed by the PSK identity, with a two-byte length, if
+// sessionStatePSKIdentity is set, then by the two-byte curve ID if
+// sessionStateCurveID is set, and then by the eight-byte creation time if
+// sessionStateCreatedAt is set.
+const (
+	sessionStateHasFlags = 0x8000
+
+	sessionStateEncryptThenMAC       = 1 << 0
+	sessionStateExtendedMasterSecret = 1 << 1
+	sessionStateClientRawPublicKey   = 1 << 2
+	sessionStateServerRawPublicKey   = 1 << 3
+	sessionStatePSKIdentity          = 1 << 4
+	sessionStateCurveID              = 1 << 5
+	sessionStateCreatedAt            = 1 << 6
+)
+
 func (s *sessionState) marshal() []byte {
+	var flags byte
+	if s.encryptThenMAC {
+		flags |= sessionStateEncryptThenMAC
+	}
+	if s.extendedMasterSecret {
+		flags |= sessionStateExtendedMasterSecret
+	}
+	if s.clientRawPublicKey {
+		flags |= sessionStateClientRawPublicKey
+	}
+	if s.serverRawPublicKey {
+		flags |= sessionStateServerRawPublicKey
+	}
+
 	length := 2 + 2 + 2 + len(s.masterSecret) + 2
 	for _, cert := range s.certificates {
 		length += 4 + len(cert)
 	}
+	if s.pskIdentity != nil {
+		flags |= sessionStatePSKIdentity
+		length += 2 + len(s.pskIdentity)
+	}
+	if s.curveID != 0 {
+		flags |= sessionStateCurveID
+		length += 2
+	}
+	if s.createdAt != 0 {
+		flags |= sessionStateCreatedAt
+		length += 8
+	}
+	numCerts := len(s.certificates)
+	if flags != 0 {
+		length++
+		numCerts |= sessionStateHasFlags
+	}
 
 	ret := make([]byte, length)
 	x := ret
@@ -70,9 +150,30 @@ func (s *sessionState) marshal() []byte {
 	copy(x, s.masterSecret)
 	x = x[len(s.masterSecret):]
 
-	x[0] = byte(len(s.certificates) >> 8)
-	x[1] = byte(len(s.certificates))
+	x[0] = byte(numCerts >> 8)
+	x[1] = byte(numCerts)
 	x = x[2:]
+	if flags != 0 {
+		x[0] = flags
+		x = x[1:]
+	}
+	if s.pskIdentity != nil {
+		x[0] = byte(len(s.pskIdentity) >> 8)
+		x[1] = byte(len(s.pskIdentity))
+		copy(x[2:], s.pskIdentity)
+		x = x[2+len(s.pskIdentity):]
+	}
+	if s.curveID != 0 {
+		x[0] = byte(s.curveID >> 8)
+		x[1] = byte(s.curveID)
+		x = x[2:]
+	}
+	if s.createdAt != 0 {
+		for i := 0; i < 8; i++ {
+			x[i] = byte(s.createdAt >> uint(56-8*i))
+		}
+		x = x[8:]
+	}
 
 	for _, cert := range s.certificates {
 		x[0] = byte(len(cert) >> 24)
@@ -109,6 +210,60 @@ func (s *sessionState) unmarshal(data []byte) bool {
 	numCerts := int(data[0])<<8 | int(data[1])
 	data = data[2:]
 
+	s.encryptThenMAC = false
+	s.extendedMasterSecret = false
+	s.clientRawPublicKey = false
+	s.serverRawPublicKey = false
+	s.pskIdentity = nil
+	s.curveID = 0
+	s.createdAt = 0
+	if numCerts&sessionStateHasFlags != 0 {
+		numCerts &^= sessionStateHasFlags
+		const knownFlags = sessionStateEncryptThenMAC | sessionStateExtendedMasterSecret |
+			sessionStateClientRawPublicKey | sessionStateServerRawPublicKey |
+			sessionStatePSKIdentity | sessionStateCurveID | sessionStateCreatedAt
+		if len(data) < 1 || data[0] == 0 || data[0]&^knownFlags != 0 {
+			return false
+		}
+		flags := data[0]
+		s.encryptThenMAC = flags&sessionStateEncryptThenMAC != 0
+		s.extendedMasterSecret = flags&sessionStateExtendedMasterSecret != 0
+		s.clientRawPublicKey = flags&sessionStateClientRawPublicKey != 0
+		s.serverRawPublicKey = flags&sessionStateServerRawPublicKey != 0
+		data = data[1:]
+
+		if flags&sessionStatePSKIdentity != 0 {
+			identity, rest, ok := parseUint16Chunk(data)
+			if !ok {
+				return false
+			}
+			s.pskIdentity = identity
+			data = rest
+		}
+		if flags&sessionStateCurveID != 0 {
+			if len(data) < 2 {
+				return false
+			}
+			s.curveID = CurveID(data[0])<<8 | CurveID(data[1])
+			if s.curveID == 0 {
+				return false
+			}
+			data = data[2:]
+		}
+		if flags&sessionStateCreatedAt != 0 {
+			if len(data) < 8 {
+				return false
+			}
+			for _, b := range data[:8] {
+				s.createdAt = s.createdAt<<8 | uint64(b)
+			}
+			if s.createdAt == 0 {
+				return false
+			}
+			data = data[8:]
+		}
+	}
+
 	s.certificates = make([][]byte, numCerts)
 	for i := range s.certificates {
 		if len(data) < 4 {
@@ -154,6 +309,17 @@ func (c *Conn) encryptTicket(state *sessionState) ([]byte, error) {
 	return encrypted, nil
 }
 
+// mayBeTicket reports whether label could be a session ticket of this
+// server, even one that decryptTicket rejects because its key was rotated
+// away or tickets were disabled since. Only the key name counts, so that
+// long PSK identities are not taken for tickets.
+func (c *Conn) mayBeTicket(label []byte) bool {
+	if len(label) < ticketKeyNameLen+aes.BlockSize+sha256.Size {
+		return false
+	}
+	return c.config.isTicketKeyName(label[:ticketKeyNameLen])
+}
+
 func (c *Conn) decryptTicket(encrypted []byte) (*sessionState, bool) {
 	if c.config.SessionTicketsDisabled ||
 		len(encrypted) < ticketKeyNameLen+aes.BlockSize+sha256.Size {
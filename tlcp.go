@@ -0,0 +1,129 @@
+// Copyright 2017 The Go Authors. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package tls
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// This file contains the parts of TLCP, the protocol of GB/T 38636 (formerly
+// GM/T 0024), that differ from TLS 1.2. TLCP is modelled on TLS 1.1 but
+// shares the record layer, explicit CBC IVs, PRF construction and Finished
+// messages of TLS 1.2, hashing with SM3. So a TLCP Conn runs the TLS 1.2
+// handshake with c.vers set to VersionTLS12 and c.tlcp set, and differs
+// only in that:
+//
+//   - records and hellos carry VersionTLCP, and hellos have no extensions;
+//   - only the TLCP cipher suites are negotiated, and never outside TLCP;
+//   - the server sends two certificates, the one it signs with followed by
+//     the one whose key the client encrypts the pre-master secret to, and
+//     signs the latter in its ServerKeyExchange, see sm2KeyAgreement;
+//   - sessions aren't resumed, connections aren't renegotiated and clients
+//     don't authenticate.
+//
+// Only the ECC cipher suites are implemented. The ECDHE ones of TLCP use the
+// SM2 key exchange protocol rather than ECDH, which needs the client to
+// have an encryption certificate too.
+
+// tlcpClientHandshake performs a TLCP handshake as a client.
+func (c *Conn) tlcpClientHandshake() error {
+	if c.dtls != nil {
+		return errors.New("tls: TLCP can't be used over DTLS")
+	}
+	c.tlcp = true
+
+	hello := &clientHelloMsg{
+		vers:               VersionTLCP,
+		random:             make([]byte, 32),
+		cipherSuites:       c.config.cipherSuitesTLCP(),
+		compressionMethods: []uint8{compressionNone},
+	}
+	if _, err := io.ReadFull(c.config.rand(), hello.random); err != nil {
+		c.sendAlert(alertInternalError)
+		return errors.New("tls: short read from Rand: " + err.Error())
+	}
+
+	if _, err := c.writeRecord(recordTypeHandshake, hello.marshal()); err != nil {
+		return err
+	}
+
+	msg, err := c.readHandshake()
+	if err != nil {
+		return err
+	}
+	serverHello, ok := msg.(*serverHelloMsg)
+	if !ok {
+		c.sendAlert(alertUnexpectedMessage)
+		return unexpectedMessageError(serverHello, msg)
+	}
+	if serverHello.vers != VersionTLCP {
+		c.sendAlert(alertProtocolVersion)
+		return fmt.Errorf("tls: server selected unsupported protocol version %x", serverHello.vers)
+	}
+	c.vers = VersionTLS12
+	c.haveVers = true
+
+	suite := mutualCipherSuite(hello.cipherSuites, serverHello.cipherSuite)
+	if suite == nil {
+		c.sendAlert(alertHandshakeFailure)
+		return errors.New("tls: server chose an unconfigured cipher suite")
+	}
+
+	hs := &clientHandshakeState{
+		c:            c,
+		serverHello:  serverHello,
+		hello:        hello,
+		suite:        suite,
+		finishedHash: newFinishedHash(c.vers, suite),
+	}
+	// The client never signs the handshake.
+	hs.finishedHash.discardHandshakeBuffer()
+
+	// With no session offered, this is never a resumption.
+	if _, err := hs.processServerHello(); err != nil {
+		return err
+	}
+
+	hs.finishedHash.Write(hs.hello.marshal())
+	hs.finishedHash.Write(hs.serverHello.marshal())
+
+	c.buffering = true
+	if err := hs.doFullHandshake(); err != nil {
+		return err
+	}
+	if err := hs.establishKeys(); err != nil {
+		return err
+	}
+	if err := hs.sendFinished(c.clientFinished[:]); err != nil {
+		return err
+	}
+	if _, err := c.flush(); err != nil {
+		return err
+	}
+	c.clientFinishedIsFirst = true
+	if err := hs.readFinished(c.serverFinished[:]); err != nil {
+		return err
+	}
+
+	c.handshakeComplete = true
+	c.cipherSuite = suite.id
+	return nil
+}
+
+// tlcpClientHello returns a ClientHello that asks for TLCP as it is
+// processed by a server: without the extensions that TLCP doesn't have. The
+// original encoding is kept for the handshake hash.
+func tlcpClientHello(m *clientHelloMsg) *clientHelloMsg {
+	return &clientHelloMsg{
+		raw:                m.raw,
+		vers:               m.vers,
+		random:             m.random,
+		sessionId:          m.sessionId,
+		cipherSuites:       m.cipherSuites,
+		compressionMethods: m.compressionMethods,
+	}
+}
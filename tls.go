@@ -11,19 +11,25 @@ package tls
 // https://www.imperialviolet.org/2013/02/04/luckythirteen.html.
 
 import (
+	"bytes"
 	"crypto"
+	"crypto/dsa"
 	"crypto/ecdsa"
 	"crypto/rsa"
 	"crypto/x509"
+	"crypto/x509/pkix"
 	"encoding/asn1"
 	"encoding/pem"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"math/big"
 	"net"
 	"strings"
 	"time"
+
+	"golang_org/x/crypto/ed25519"
 )
 
 // Server returns a new TLS server side connection
@@ -83,6 +89,50 @@ func Listen(network, laddr string, config *Config) (net.Listener, error) {
 	return NewListener(l, config), nil
 }
 
+// DTLSServer returns a new DTLS server side connection using conn as the
+// underlying transport. Like a connected UDP socket, conn must keep the
+// boundaries of the datagrams written and read. The configuration config
+// must be non-nil and must include at least one certificate or else set
+// GetCertificate.
+func DTLSServer(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config, dtls: newDTLSState()}
+}
+
+// DTLSClient returns a new DTLS client side connection using conn as the
+// underlying transport. Like a connected UDP socket, conn must keep the
+// boundaries of the datagrams written and read. The config cannot be nil:
+// users must set either ServerName or InsecureSkipVerify in the config.
+func DTLSClient(conn net.Conn, config *Config) *Conn {
+	return &Conn{conn: conn, config: config, isClient: true, dtls: newDTLSState()}
+}
+
+// NewDTLSListener creates a Listener which accepts DTLS connections from the
+// datagrams arriving on conn. Clients have to echo a cookie before a Conn
+// is created for their address with DTLSServer. A new ClientHello from the
+// address of a connection replaces it once its handshake completes, and
+// connections from whose address nothing arrives for five minutes are
+// closed.
+// The configuration config must be non-nil and must include
+// at least one certificate or else set GetCertificate.
+func NewDTLSListener(conn net.PacketConn, config *Config) net.Listener {
+	return newDTLSListener(conn, config)
+}
+
+// ListenUDP creates a DTLS listener accepting connections on the given
+// network address using net.ListenPacket. The network must be "udp", "udp4"
+// or "udp6".
+// The configuration config must be non-nil.
+func ListenUDP(network, laddr string, config *Config) (net.Listener, error) {
+	if config == nil {
+		return nil, errors.New("tls: missing Config")
+	}
+	conn, err := net.ListenPacket(network, laddr)
+	if err != nil {
+		return nil, err
+	}
+	return NewDTLSListener(conn, config), nil
+}
+
 type timeoutError struct{}
 
 func (timeoutError) Error() string   { return "tls: DialWithDialer timed out" }
@@ -97,6 +147,10 @@ func (timeoutError) Temporary() bool { return true }
 // DialWithDialer interprets a nil configuration as equivalent to the zero
 // configuration; see the documentation of Config for the defaults.
 func DialWithDialer(dialer *net.Dialer, network, addr string, config *Config) (*Conn, error) {
+	return dial(dialer, network, addr, config, Client)
+}
+
+func dial(dialer *net.Dialer, network, addr string, config *Config, newConn func(net.Conn, *Config) *Conn) (*Conn, error) {
 	// We want the Timeout and Deadline values from dialer to cover the
 	// whole process: TCP connection and TLS handshake. This means that we
 	// also need to start our own timers now.
@@ -141,7 +195,7 @@ func DialWithDialer(dialer *net.Dialer, network, addr string, config *Config) (*
 		config = c
 	}
 
-	conn := Client(rawConn, config)
+	conn := newConn(rawConn, config)
 
 	if timeout == 0 {
 		err = conn.Handshake()
@@ -171,6 +225,16 @@ func Dial(network, addr string, config *Config) (*Conn, error) {
 	return DialWithDialer(new(net.Dialer), network, addr, config)
 }
 
+// DialUDP connects to the given network address using net.Dial and then
+// initiates a DTLS handshake, returning the resulting DTLS connection. The
+// network must be "udp", "udp4" or "udp6".
+// DialUDP interprets a nil configuration as equivalent to
+// the zero configuration; see the documentation of Config
+// for the defaults.
+func DialUDP(network, addr string, config *Config) (*Conn, error) {
+	return dial(new(net.Dialer), network, addr, config, DTLSClient)
+}
+
 // LoadX509KeyPair reads and parses a public/private key pair from a pair
 // of files. The files must contain PEM encoded data. The certificate file
 // may contain intermediate certificates following the leaf certificate to
@@ -246,7 +310,7 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 
 	// We don't need to parse the public key for TLS, but we so do anyway
 	// to check that it looks sane and matches the private key.
-	x509Cert, err := x509.ParseCertificate(cert.Certificate[0])
+	x509Cert, err := parseCertificate(cert.Certificate[0])
 	if err != nil {
 		return fail(err)
 	}
@@ -260,6 +324,16 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 		if pub.N.Cmp(priv.N) != 0 {
 			return fail(errors.New("tls: private key does not match public key"))
 		}
+	case *rsaPSSPublicKey:
+		priv, ok := cert.PrivateKey.(*rsa.PrivateKey)
+		if !ok {
+			return fail(errors.New("tls: private key type does not match public key type"))
+		}
+		if pub.N.Cmp(priv.N) != 0 {
+			return fail(errors.New("tls: private key does not match public key"))
+		}
+		// The certificate restricts the key to RSA-PSS.
+		cert.PrivateKey = &rsaPSSPrivateKey{key: priv, pub: pub}
 	case *ecdsa.PublicKey:
 		priv, ok := cert.PrivateKey.(*ecdsa.PrivateKey)
 		if !ok {
@@ -268,6 +342,30 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
 			return fail(errors.New("tls: private key does not match public key"))
 		}
+	case *dsa.PublicKey:
+		priv, ok := cert.PrivateKey.(*dsa.PrivateKey)
+		if !ok {
+			return fail(errors.New("tls: private key type does not match public key type"))
+		}
+		if pub.Y.Cmp(priv.Y) != 0 {
+			return fail(errors.New("tls: private key does not match public key"))
+		}
+	case ed25519.PublicKey:
+		priv, ok := cert.PrivateKey.(ed25519.PrivateKey)
+		if !ok {
+			return fail(errors.New("tls: private key type does not match public key type"))
+		}
+		if !bytes.Equal(priv.Public().(ed25519.PublicKey), pub) {
+			return fail(errors.New("tls: private key does not match public key"))
+		}
+	case *sm2PublicKey:
+		priv, ok := cert.PrivateKey.(*sm2PrivateKey)
+		if !ok {
+			return fail(errors.New("tls: private key type does not match public key type"))
+		}
+		if pub.X.Cmp(priv.X) != 0 || pub.Y.Cmp(priv.Y) != 0 {
+			return fail(errors.New("tls: private key does not match public key"))
+		}
 	default:
 		return fail(errors.New("tls: unknown public key algorithm"))
 	}
@@ -277,11 +375,20 @@ func X509KeyPair(certPEMBlock, keyPEMBlock []byte) (Certificate, error) {
 
 // Attempt to parse the given private key DER block. OpenSSL 0.9.8 generates
 // PKCS#1 private keys by default, while OpenSSL 1.0.0 generates PKCS#8 keys.
-// OpenSSL ecparam generates SEC1 EC private keys for ECDSA. We try all three.
+// OpenSSL ecparam generates SEC1 EC private keys for ECDSA. DSA keys come
+// either in OpenSSL's own format or as PKCS#8, which crypto/x509 can't parse,
+// and so do EC keys on the SM2 curve, RSASSA-PSS keys and Ed25519 keys. We
+// try all of them.
+//
+// RSASSA-PSS keys are returned as ordinary RSA keys. What they may sign with
+// is set by the certificate, see X509KeyPair.
 func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
 	if key, err := x509.ParsePKCS1PrivateKey(der); err == nil {
 		return key, nil
 	}
+	if key, err := parsePKCS8Ed25519PrivateKey(der); err == nil {
+		return key, nil
+	}
 	if key, err := x509.ParsePKCS8PrivateKey(der); err == nil {
 		switch key := key.(type) {
 		case *rsa.PrivateKey, *ecdsa.PrivateKey:
@@ -293,10 +400,312 @@ func parsePrivateKey(der []byte) (crypto.PrivateKey, error) {
 	if key, err := x509.ParseECPrivateKey(der); err == nil {
 		return key, nil
 	}
+	if key, err := parseDSAPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := parsePKCS8DSAPrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := parseSM2PrivateKey(der); err == nil {
+		return key, nil
+	}
+	if key, err := parsePKCS8RSAPSSPrivateKey(der); err == nil {
+		return key, nil
+	}
 
 	return nil, errors.New("tls: failed to parse private key")
 }
 
+// dsaOpenSSLPrivateKey is the ASN.1 structure of an OpenSSL "DSA PRIVATE
+// KEY".
+type dsaOpenSSLPrivateKey struct {
+	Version       int
+	P, Q, G, Y, X *big.Int
+}
+
+// dsaPKCS8PrivateKey is the ASN.1 structure of a PKCS#8 private key, see
+// RFC 5208. For DSA the parameters are in the algorithm identifier and the
+// private key is just an INTEGER.
+type dsaPKCS8PrivateKey struct {
+	Version    int
+	Algo       pkix.AlgorithmIdentifier
+	PrivateKey []byte
+}
+
+var oidPublicKeyDSA = asn1.ObjectIdentifier{1, 2, 840, 10040, 4, 1}
+
+// parseDSAPrivateKey parses a DSA private key in OpenSSL's format.
+func parseDSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k dsaOpenSSLPrivateKey
+	rest, err := asn1.Unmarshal(der, &k)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if k.Version != 0 {
+		return nil, errors.New("tls: unknown DSA private key version")
+	}
+	return newDSAPrivateKey(k.P, k.Q, k.G, k.Y, k.X)
+}
+
+// parsePKCS8DSAPrivateKey parses a PKCS#8 wrapped DSA private key.
+func parsePKCS8DSAPrivateKey(der []byte) (*dsa.PrivateKey, error) {
+	var k dsaPKCS8PrivateKey
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, err
+	}
+	if !k.Algo.Algorithm.Equal(oidPublicKeyDSA) {
+		return nil, errors.New("tls: PKCS#8 private key is not a DSA key")
+	}
+	var params struct{ P, Q, G *big.Int }
+	if _, err := asn1.Unmarshal(k.Algo.Parameters.FullBytes, &params); err != nil {
+		return nil, err
+	}
+	x := new(big.Int)
+	if _, err := asn1.Unmarshal(k.PrivateKey, &x); err != nil {
+		return nil, err
+	}
+	return newDSAPrivateKey(params.P, params.Q, params.G, nil, x)
+}
+
+// newDSAPrivateKey checks the DSA key values and assembles them into a key,
+// deriving the public value y if it's nil.
+func newDSAPrivateKey(p, q, g, y, x *big.Int) (*dsa.PrivateKey, error) {
+	if p.Sign() <= 0 || q.Sign() <= 0 || g.Sign() <= 0 || x.Sign() <= 0 || x.Cmp(q) >= 0 {
+		return nil, errors.New("tls: invalid DSA private key")
+	}
+	if y == nil {
+		y = new(big.Int).Exp(g, x, p)
+	}
+	key := &dsa.PrivateKey{X: x}
+	key.P, key.Q, key.G, key.Y = p, q, g, y
+	return key, nil
+}
+
+var oidPublicKeyRSAPSS = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+
+// parsePKCS8RSAPSSPrivateKey parses a PKCS#8 wrapped RSASSA-PSS private key,
+// see RFC 4055, section 1.2. It has the layout of dsaPKCS8PrivateKey and the
+// private key is a PKCS#1 RSA key.
+func parsePKCS8RSAPSSPrivateKey(der []byte) (*rsa.PrivateKey, error) {
+	var k dsaPKCS8PrivateKey
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, err
+	}
+	if !k.Algo.Algorithm.Equal(oidPublicKeyRSAPSS) {
+		return nil, errors.New("tls: PKCS#8 private key is not a RSASSA-PSS key")
+	}
+	return x509.ParsePKCS1PrivateKey(k.PrivateKey)
+}
+
+// parseRSAPSSPublicKey parses an RSASSA-PSS SubjectPublicKeyInfo, see RFC
+// 4055, section 1.2, which crypto/x509 leaves unparsed.
+func parseRSAPSSPublicKey(spki []byte) (*rsaPSSPublicKey, error) {
+	var info struct {
+		Algorithm pkix.AlgorithmIdentifier
+		PublicKey asn1.BitString
+	}
+	if rest, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPublicKeyRSAPSS) {
+		return nil, errors.New("tls: public key is not a RSASSA-PSS key")
+	}
+	hashFunc, saltLength, err := parseRSAPSSParameters(info.Algorithm.Parameters.FullBytes)
+	if err != nil {
+		return nil, err
+	}
+	var pub struct {
+		N *big.Int
+		E int
+	}
+	if rest, err := asn1.Unmarshal(info.PublicKey.RightAlign(), &pub); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if pub.N.Sign() <= 0 || pub.E <= 0 {
+		return nil, errors.New("tls: invalid RSASSA-PSS public key")
+	}
+	return &rsaPSSPublicKey{
+		PublicKey:  &rsa.PublicKey{N: pub.N, E: pub.E},
+		hashFunc:   hashFunc,
+		saltLength: saltLength,
+		raw:        spki,
+	}, nil
+}
+
+// rsaPSSParameters is the ASN.1 structure of RSASSA-PSS-params, see RFC
+// 4055, section 3.1. Absent algorithms default to SHA-1 and MGF1 with SHA-1.
+type rsaPSSParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"optional,explicit,tag:1"`
+	SaltLength   int                      `asn1:"optional,explicit,tag:2,default:20"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+var (
+	oidMGF1   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+)
+
+// hashForOID returns the hash function of an absent or known hash
+// algorithm identifier.
+func hashForOID(oid asn1.ObjectIdentifier) (crypto.Hash, error) {
+	switch {
+	case oid == nil, oid.Equal(oidSHA1):
+		return crypto.SHA1, nil
+	case oid.Equal(oidSHA256):
+		return crypto.SHA256, nil
+	case oid.Equal(oidSHA384):
+		return crypto.SHA384, nil
+	case oid.Equal(oidSHA512):
+		return crypto.SHA512, nil
+	}
+	return 0, errors.New("tls: unknown RSASSA-PSS hash algorithm")
+}
+
+// parseRSAPSSParameters returns the hash function and the minimum salt
+// length that the parameters of an RSASSA-PSS key restrict it to. A key
+// without parameters may use any of them, and gets a zero hash function.
+func parseRSAPSSParameters(der []byte) (hashFunc crypto.Hash, saltLength int, err error) {
+	if len(der) == 0 {
+		return 0, 0, nil
+	}
+	var params rsaPSSParameters
+	if rest, err := asn1.Unmarshal(der, &params); err != nil {
+		return 0, 0, err
+	} else if len(rest) > 0 {
+		return 0, 0, asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if hashFunc, err = hashForOID(params.Hash.Algorithm); err != nil {
+		return 0, 0, err
+	}
+	mgfHash := crypto.SHA1
+	if params.MGF.Algorithm != nil {
+		if !params.MGF.Algorithm.Equal(oidMGF1) {
+			return 0, 0, errors.New("tls: unknown RSASSA-PSS mask generation function")
+		}
+		var mgfHashAlgorithm pkix.AlgorithmIdentifier
+		if _, err := asn1.Unmarshal(params.MGF.Parameters.FullBytes, &mgfHashAlgorithm); err != nil {
+			return 0, 0, err
+		}
+		if mgfHash, err = hashForOID(mgfHashAlgorithm.Algorithm); err != nil {
+			return 0, 0, err
+		}
+	}
+	// crypto/rsa masks with the hash function that it signs with.
+	if mgfHash != hashFunc {
+		return 0, 0, errors.New("tls: unsupported RSASSA-PSS mask generation hash")
+	}
+	if params.SaltLength < 0 || params.TrailerField != 1 {
+		return 0, 0, errors.New("tls: invalid RSASSA-PSS parameters")
+	}
+	return hashFunc, params.SaltLength, nil
+}
+
+// An rsaPSSPublicKey is the public key of an RSASSA-PSS certificate, an RSA
+// key that only signs with RSA-PSS. TLS signs with it using the rsa_pss_pss
+// algorithms, and never the rsa_pss_rsae ones of rsaEncryption keys, see RFC
+// 8446, section 4.2.3.
+type rsaPSSPublicKey struct {
+	*rsa.PublicKey
+	hashFunc   crypto.Hash // the only one the key signs with, or zero for any
+	saltLength int         // the minimum salt length
+	raw        []byte      // the SubjectPublicKeyInfo
+}
+
+// allowsHash reports whether the parameters of k allow it to sign with
+// hashFunc and, as TLS does, a salt as long as the hash.
+func (k *rsaPSSPublicKey) allowsHash(hashFunc crypto.Hash) bool {
+	return (k.hashFunc == 0 || k.hashFunc == hashFunc) && k.saltLength <= hashFunc.Size()
+}
+
+// An rsaPSSPrivateKey is the private key of an rsaPSSPublicKey. It refuses
+// to sign other than as its parameters allow, and it doesn't decrypt.
+type rsaPSSPrivateKey struct {
+	key *rsa.PrivateKey
+	pub *rsaPSSPublicKey
+}
+
+func (k *rsaPSSPrivateKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+func (k *rsaPSSPrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	pssOpts, ok := opts.(*rsa.PSSOptions)
+	if !ok || pssOpts.SaltLength != rsa.PSSSaltLengthEqualsHash || !k.pub.allowsHash(opts.HashFunc()) {
+		return nil, errors.New("tls: RSASSA-PSS key can't sign with these parameters")
+	}
+	return k.key.Sign(rand, digest, opts)
+}
+
+var oidPublicKeyEd25519 = asn1.ObjectIdentifier{1, 3, 101, 112}
+
+// parsePKCS8Ed25519PrivateKey parses a PKCS#8 wrapped Ed25519 private key,
+// see RFC 8410, section 7. It has the layout of dsaPKCS8PrivateKey and the
+// private key is an OCTET STRING holding the seed.
+func parsePKCS8Ed25519PrivateKey(der []byte) (ed25519.PrivateKey, error) {
+	var k dsaPKCS8PrivateKey
+	if _, err := asn1.Unmarshal(der, &k); err != nil {
+		return nil, err
+	}
+	if !k.Algo.Algorithm.Equal(oidPublicKeyEd25519) {
+		return nil, errors.New("tls: PKCS#8 private key is not an Ed25519 key")
+	}
+	var seed []byte
+	if _, err := asn1.Unmarshal(k.PrivateKey, &seed); err != nil {
+		return nil, err
+	}
+	if len(seed) != ed25519.SeedSize {
+		return nil, errors.New("tls: invalid Ed25519 private key length")
+	}
+	return ed25519.NewKeyFromSeed(seed), nil
+}
+
+// ed25519PublicKeyInfo is the ASN.1 structure of an Ed25519
+// SubjectPublicKeyInfo, see RFC 8410, section 4. The algorithm identifier
+// has no parameters.
+type ed25519PublicKeyInfo struct {
+	Algorithm struct {
+		Algorithm asn1.ObjectIdentifier
+	}
+	PublicKey asn1.BitString
+}
+
+// parseEd25519PublicKey parses an Ed25519 SubjectPublicKeyInfo, which
+// crypto/x509 leaves unparsed.
+func parseEd25519PublicKey(spki []byte) (ed25519.PublicKey, error) {
+	var info ed25519PublicKeyInfo
+	if rest, err := asn1.Unmarshal(spki, &info); err != nil {
+		return nil, err
+	} else if len(rest) > 0 {
+		return nil, asn1.SyntaxError{Msg: "trailing data"}
+	}
+	if !info.Algorithm.Algorithm.Equal(oidPublicKeyEd25519) {
+		return nil, errors.New("tls: public key is not an Ed25519 key")
+	}
+	if info.PublicKey.BitLength != 8*ed25519.PublicKeySize {
+		return nil, errors.New("tls: invalid Ed25519 public key length")
+	}
+	return ed25519.PublicKey(info.PublicKey.Bytes), nil
+}
+
+// marshalEd25519PublicKey returns the SubjectPublicKeyInfo of pub.
+func marshalEd25519PublicKey(pub ed25519.PublicKey) ([]byte, error) {
+	var info ed25519PublicKeyInfo
+	info.Algorithm.Algorithm = oidPublicKeyEd25519
+	info.PublicKey = asn1.BitString{Bytes: pub, BitLength: 8 * len(pub)}
+	return asn1.Marshal(info)
+}
+
 // Validate DH Parameters. Confirms that:
 // p is odd
 // 1 < g < p - 1